@@ -29,6 +29,7 @@ require (
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
 	go.uber.org/zap v1.21.0
+	golang.org/x/oauth2 v0.0.0-20211028175245-ba495a64dcb5
 	sigs.k8s.io/yaml v1.1.0 // indirect
 )
 
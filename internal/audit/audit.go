@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"go.uber.org/zap"
+)
+
+const (
+	serverAdmins = "server_admins"
+	// How many entries !audit shows
+	listLimit = 50
+	// How far back !audit looks if it isn't told
+	defaultSince = 7 * 24 * time.Hour
+)
+
+// List shows the latest audit log entries. args can narrow it to a user (changes they made or that were made to
+// them) or a role, sig, filter or permission, and to how far back to look, e.g. 24h or 30d.
+func List(ctx context.Context, args []string, channelID, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.Strings("args", args),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	query := payloads.AuditQuery{
+		Since: time.Now().Add(-defaultSince),
+		Limit: listLimit,
+	}
+
+	for _, arg := range args {
+		if since, ok := parseSince(arg); ok {
+			query.Since = time.Now().Add(-since)
+			continue
+		}
+
+		if common.IsDiscordUser(arg) {
+			query.UserID = common.ExtractUserId(arg)
+			continue
+		}
+
+		query.Target = arg
+	}
+
+	entries, err := deps.Storage.GetAuditLog(ctx, query)
+	if err != nil {
+		sp.Error("Error getting audit log", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting audit log: %s", err)
+	}
+
+	if len(entries) == 0 {
+		return common.SendSuccess(nil, "No changes found")
+	}
+
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = fmt.Sprintf("**%s** %s", entry.CreatedAt.UTC().Format("2006-01-02 15:04 MST"),
+			common.FormatAuditEntry(entry))
+	}
+
+	err = common.SendChunkedMessage(ctx, channelID, "Audit log", lines, deps)
+	if err != nil {
+		sp.Error("Error sending chunked message", zap.Error(err))
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// parseSince reads a duration like 12h, also taking days, e.g. 7d.
+func parseSince(arg string) (time.Duration, bool) {
+	if strings.HasSuffix(arg, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+		if err != nil || days <= 0 {
+			return 0, false
+		}
+
+		return time.Duration(days) * 24 * time.Hour, true
+	}
+
+	since, err := time.ParseDuration(arg)
+	if err != nil || since <= 0 {
+		return 0, false
+	}
+
+	return since, true
+}
@@ -27,6 +27,27 @@ func Create(ctx context.Context, request *payloads.CreateRequest, deps common.De
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// ===========================================================================================
+	// Check the access policy
+
+	var allianceID int32
+	if request.Alliance != nil {
+		allianceID = request.Alliance.ID
+	}
+
+	access, err := common.CheckAccess(ctx, request.Character.ID, request.Corporation.ID, allianceID, deps)
+	if err != nil {
+		sp.Error("Error checking access policy", zap.Error(err))
+		return nil, err
+	}
+
+	sp.With(zap.Stringer("access", access))
+
+	if access == common.AccessDenied {
+		sp.Info("Character denied by the access policy")
+		return nil, common.ErrAccessDenied
+	}
+
 	// ===========================================================================================
 	// Get alliance
 
@@ -55,7 +76,10 @@ func Create(ctx context.Context, request *payloads.CreateRequest, deps common.De
 			return nil, err
 		}
 
-		roles.Add(ctx, false, false, request.Alliance.Ticker, request.Alliance.Name, "discord", deps)
+		// Blues only get the blue filter, not roles for their corp or alliance
+		if access == common.AccessMember {
+			roles.Add(ctx, false, false, request.Alliance.Ticker, request.Alliance.Name, "discord", deps)
+		}
 	}
 
 	// ===========================================================================================
@@ -81,7 +105,9 @@ func Create(ctx context.Context, request *payloads.CreateRequest, deps common.De
 		return nil, err
 	}
 
-	roles.Add(ctx, false, false, request.Corporation.Ticker, request.Corporation.Name, "discord", deps)
+	if access == common.AccessMember {
+		roles.Add(ctx, false, false, request.Corporation.Ticker, request.Corporation.Name, "discord", deps)
+	}
 
 	// ===========================================================================================
 	// Get character
@@ -115,6 +141,21 @@ func Create(ctx context.Context, request *payloads.CreateRequest, deps common.De
 		return nil, err
 	}
 
+	err = common.Audit(ctx, common.Change{
+		Action:     "create",
+		TargetType: "character",
+		Target:     request.Character.Name,
+		After: map[string]interface{}{
+			"character_id":   request.Character.ID,
+			"corporation_id": request.Corporation.ID,
+			"alliance_id":    allianceID,
+			"access":         access.String(),
+		},
+	}, deps)
+	if err != nil {
+		return nil, err
+	}
+
 	return &authCode, nil
 }
 
@@ -162,22 +203,6 @@ func Confirm(ctx context.Context, authCode, sender string, deps common.Dependenc
 		zap.Int32("corporation_id", character.CorporationID),
 	)
 
-	err = deps.Storage.UpdateAuthCode(ctx, authCode)
-	if err != nil {
-		sp.Error("Error updating auth code", zap.Error(err))
-		return common.SendErrorf(&sender, "Error updating auth code: %s", err)
-	}
-
-	err = deps.Storage.InsertUserCharacterMap(ctx, sender, characterID)
-	if err != nil {
-		if err == storage.ErrUserMapped {
-			return common.SendError(&sender, "User already mapped to character")
-		}
-
-		sp.Error("Error inserting user character map", zap.Error(err))
-		return common.SendErrorf(&sender, "Error inserting user character map: %s", err)
-	}
-
 	// get corp ticker
 	corporation, err := deps.Storage.GetCorporation(ctx, character.CorporationID)
 	if err != nil {
@@ -194,11 +219,10 @@ func Confirm(ctx context.Context, authCode, sender string, deps common.Dependenc
 		zap.Any("alliance_id", corporation.AllianceID),
 	)
 
-	filters.AddMember(ctx, sender, corporation.Ticker, deps)
-
+	var alliance payloads.Alliance
 	if corporation.AllianceID.Valid {
 		// get alliance ticker if there is an alliance
-		alliance, err := deps.Storage.GetAlliance(ctx, corporation.AllianceID.Int32)
+		alliance, err = deps.Storage.GetAlliance(ctx, corporation.AllianceID.Int32)
 		if err != nil {
 			if errors.Is(err, storage.ErrNoAlliance) {
 				return common.SendErrorf(&sender, "No such alliance: %d", corporation.AllianceID.Int32)
@@ -208,8 +232,72 @@ func Confirm(ctx context.Context, authCode, sender string, deps common.Dependenc
 			return common.SendErrorf(&sender, "Error getting alliance: %s", err)
 		}
 		sp.With(zap.String("alliance_ticker", alliance.Ticker))
+	}
+
+	// Check again, the character might have moved or the policy changed since the auth code was made
+	access, err := common.CheckAccess(ctx, character.ID, corporation.ID, alliance.ID, deps)
+	if err != nil {
+		sp.Error("Error checking access policy", zap.Error(err))
+		return common.SendErrorf(&sender, "Error checking access policy: %s", err)
+	}
+
+	sp.With(zap.Stringer("access", access))
+
+	if access == common.AccessDenied {
+		sp.Info("Character denied by the access policy")
+		return common.SendErrorf(&sender, "%s isn't allowed to authenticate here", character.Name)
+	}
+
+	err = deps.Storage.UpdateAuthCode(ctx, authCode)
+	if err != nil {
+		sp.Error("Error updating auth code", zap.Error(err))
+		return common.SendErrorf(&sender, "Error updating auth code: %s", err)
+	}
+
+	err = deps.Storage.InsertUserCharacterMap(ctx, sender, characterID)
+	if err != nil {
+		if err == storage.ErrUserMapped {
+			return common.SendError(&sender, "User already mapped to character")
+		}
 
-		filters.AddMember(ctx, sender, alliance.Ticker, deps)
+		sp.Error("Error inserting user character map", zap.Error(err))
+		return common.SendErrorf(&sender, "Error inserting user character map: %s", err)
+	}
+
+	err = common.Audit(ctx, common.Change{
+		Action:     "confirm",
+		TargetType: "character",
+		Target:     character.Name,
+		MemberID:   sender,
+		After: map[string]interface{}{
+			"character_id":   character.ID,
+			"corporation_id": corporation.ID,
+			"alliance_id":    alliance.ID,
+			"access":         access.String(),
+		},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&sender, "Error recording character confirmation: %s", err)
+	}
+
+	if access == common.AccessBlue {
+		if common.BlueFilter() != "" {
+			filters.AddMember(ctx, sender, common.BlueFilter(), deps)
+		}
+	} else {
+		filters.AddMember(ctx, sender, corporation.Ticker, deps)
+
+		if corporation.AllianceID.Valid {
+			filters.AddMember(ctx, sender, alliance.Ticker, deps)
+		}
+	}
+
+	// Start the member policy's clock again in every guild, the next poll lifts any quarantine
+	for _, guild := range common.Guilds() {
+		err = deps.ForGuild(guild.ID).Storage.UpdateMemberAuthed(ctx, sender)
+		if err != nil {
+			sp.Error("Error updating member state", zap.String("guild_id", guild.ID), zap.Error(err))
+		}
 	}
 
 	sp.Info("authed user")
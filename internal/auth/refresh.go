@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	esiPoller "github.com/chremoas/chremoas-ng/internal/esi-poller"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"go.uber.org/zap"
+)
+
+// Refresher is the part of the esi poller that updates one user's characters.
+type Refresher interface {
+	RefreshUser(ctx context.Context, chatID string) (int, int, error)
+}
+
+// Refresh updates the user's characters from ESI now instead of waiting for the poller and shows how their roles
+// changed. Anyone can refresh themselves, refreshing someone else needs server_admins. An empty user is the author.
+func Refresh(ctx context.Context, refresher Refresher, user, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("user", user),
+		zap.String("author", author),
+	)
+
+	userID := author
+	if user != "" {
+		if !common.IsDiscordUser(user) {
+			return common.SendError(&author, "argument must be a discord user")
+		}
+
+		userID = common.ExtractUserId(user)
+	}
+
+	if userID != author {
+		if err := perms.CanPerform(ctx, author, "server_admins", deps); err != nil {
+			sp.Warn("user doesn't have permission to this command", zap.Error(err))
+			return common.SendError(&author, "User doesn't have permission to this command")
+		}
+	}
+
+	before, err := common.GetMembership(ctx, userID, deps)
+	if err != nil {
+		sp.Error("Error getting membership", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting membership: %s", err)
+	}
+
+	count, errorCount, err := refresher.RefreshUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, esiPoller.ErrRefreshTooSoon) {
+			return common.SendErrorf(&author, "<@%s> was %s", userID, err)
+		}
+
+		sp.Error("Error refreshing user", zap.Error(err))
+		return common.SendErrorf(&author, "Error refreshing user: %s", err)
+	}
+
+	if count == 0 && errorCount == 0 {
+		return common.SendErrorf(&author, "<@%s> has no authed characters", userID)
+	}
+
+	after, err := common.GetMembership(ctx, userID, deps)
+	if err != nil {
+		sp.Error("Error getting membership", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting membership: %s", err)
+	}
+
+	added := after.Difference(before).ToSlice()
+	removed := before.Difference(after).ToSlice()
+
+	sp.Info("refreshed user",
+		zap.Int("count", count),
+		zap.Int("errorCount", errorCount),
+		zap.Strings("added", added),
+		zap.Strings("removed", removed),
+	)
+
+	embed := common.NewEmbed()
+	embed.SetTitle(fmt.Sprintf("Refreshed %d characters, %d failed", count, errorCount))
+	embed.SetDescription(fmt.Sprintf("<@%s>", userID))
+
+	if len(added) == 0 && len(removed) == 0 {
+		embed.AddField("Roles", "No changes")
+	}
+
+	if len(added) > 0 {
+		embed.AddField("Added", roleMentions(added))
+	}
+
+	if len(removed) > 0 {
+		embed.AddField("Removed", roleMentions(removed))
+	}
+
+	return []*discordgo.MessageSend{{Embed: embed.GetMessageEmbed()}}
+}
+
+func roleMentions(roleIDs []string) string {
+	var mentions string
+
+	for _, roleID := range roleIDs {
+		mentions += fmt.Sprintf("<@&%s>\n", roleID)
+	}
+
+	return mentions
+}
@@ -6,6 +6,7 @@ import (
 	"embed"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -16,9 +17,9 @@ import (
 	sl "github.com/bhechinger/spiffylogger"
 	"github.com/chremoas/chremoas-ng/internal/auth"
 	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/esicache"
 	"github.com/chremoas/chremoas-ng/internal/payloads"
 	"github.com/dimfeld/httptreemux"
-	"github.com/gregjones/httpcache"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -49,7 +50,7 @@ type Web struct {
 	ctx          context.Context
 }
 
-func New(ctx context.Context, deps common.Dependencies) (*Web, error) {
+func New(ctx context.Context, cache *esicache.Cache, deps common.Dependencies) (*Web, error) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
@@ -57,7 +58,7 @@ func New(ctx context.Context, deps common.Dependencies) (*Web, error) {
 	globalSessions, _ = session.NewManager("memory", &session.ManagerConfig{CookieName: "gosessionid", EnableSetCookie: true, Gclifetime: 600})
 	go globalSessions.GC()
 
-	httpClient := httpcache.NewMemoryCacheTransport().Client()
+	httpClient := cache.Client()
 
 	// Get the ESI API Client
 	apiClient = goesi.NewAPIClient(httpClient, "aba-auth-web maurer.it@gmail.com https://github.com/chremoas/auth-web")
@@ -65,8 +66,9 @@ func New(ctx context.Context, deps common.Dependencies) (*Web, error) {
 	fullCallbackUrl := viper.GetString("oauth.callBackProtocol") + "://" + viper.GetString("oauth.callBackHost") + viper.GetString("oauth.callBackUrl")
 
 	// Allocate an SSO Authenticator
+	// SSO calls are per character and never cached
 	authenticator = goesi.NewSSOAuthenticator(
-		httpClient,
+		http.DefaultClient,
 		viper.GetString("oauth.clientId"),
 		viper.GetString("oauth.clientSecret"),
 		fullCallbackUrl,
@@ -175,8 +177,20 @@ func (web Web) handleEveCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	internalAuthCode, err := web.doAuth(w, r, sess)
+	internalAuthCode, characterName, err := web.doAuth(w, r, sess)
 	if err != nil {
+		if errors.Is(err, common.ErrAccessDenied) {
+			sp.Info("character denied by the access policy", zap.String("character_name", characterName))
+			w.WriteHeader(http.StatusForbidden)
+
+			err = web.templates.ExecuteTemplate(w, "denied.html", &ResultModel{Title: "Access Denied", Name: characterName})
+			if err != nil {
+				sp.Error("Error executing denied template", zap.Error(err))
+			}
+
+			return
+		}
+
 		// TODO: Make another template for errors specifically for this endpoint
 		sp.Error("received an error from doAuth", zap.Error(err))
 		http.Error(w, "Unknown auth error", http.StatusInternalServerError)
@@ -197,10 +211,14 @@ func (web Web) handleEveCallback(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (web Web) doAuth(w http.ResponseWriter, r *http.Request, sess session.Store) (*string, error) {
+// doAuth returns the bot's auth code and the character's name, the name is there so a rejection can say who was
+// rejected.
+func (web Web) doAuth(w http.ResponseWriter, r *http.Request, sess session.Store) (*string, string, error) {
 	ctx, sp := sl.OpenSpan(r.Context())
 	defer sp.Close()
 
+	ctx = common.WithWeb(ctx)
+
 	state := r.FormValue("state")
 	code := r.FormValue("code")
 	stateValidate := sess.Get("state")
@@ -215,14 +233,14 @@ func (web Web) doAuth(w http.ResponseWriter, r *http.Request, sess session.Store
 	if state != stateValidate {
 		sp.Error("Invalid oauth state", zap.Any("expected_state", stateValidate), zap.String("actual_state", state))
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return nil, fmt.Errorf("invalid oauth state")
+		return nil, "", fmt.Errorf("invalid oauth state")
 	}
 
 	token, err := ssoauth.TokenExchange(code)
 	if err != nil {
 		sp.Error("Code exchange failed", zap.Error(err))
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return nil, err
+		return nil, "", err
 	}
 
 	tokenSource := ssoauth.TokenSource(token)
@@ -235,19 +253,19 @@ func (web Web) doAuth(w http.ResponseWriter, r *http.Request, sess session.Store
 	verifyReponse, err := ssoauth.Verify(tokenSource)
 	if err != nil {
 		sp.Error("Error getting verify response", zap.Error(err))
-		return nil, err
+		return nil, "", err
 	}
 
 	character, _, err := api.ESI.CharacterApi.GetCharactersCharacterId(r.Context(), int32(verifyReponse.CharacterID), nil)
 	if err != nil {
 		sp.Error("error getting character", zap.Error(err))
-		return nil, err
+		return nil, "", err
 	}
 
 	corporation, _, err := api.ESI.CorporationApi.GetCorporationsCorporationId(r.Context(), character.CorporationId, nil)
 	if err != nil {
 		sp.Error("error getting corporation", zap.Error(err))
-		return nil, err
+		return nil, character.Name, err
 	}
 
 	var alliance esi.GetAlliancesAllianceIdOk
@@ -255,7 +273,7 @@ func (web Web) doAuth(w http.ResponseWriter, r *http.Request, sess session.Store
 		alliance, _, err = api.ESI.AllianceApi.GetAlliancesAllianceId(r.Context(), corporation.AllianceId, nil)
 		if err != nil {
 			sp.Error("error getting alliance", zap.Error(err))
-			return nil, err
+			return nil, character.Name, err
 		}
 	}
 
@@ -289,8 +307,8 @@ func (web Web) doAuth(w http.ResponseWriter, r *http.Request, sess session.Store
 
 	if err != nil {
 		sp.Error("Had an issue authing internally", zap.Error(err))
-		return nil, err
+		return nil, character.Name, err
 	}
 
-	return authCode, nil
+	return authCode, character.Name, nil
 }
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/audit"
+	"go.uber.org/zap"
+)
+
+const (
+	auditUsage       = `!audit [user|role] [since]`
+	auditSubcommands = `
+    user: Only changes made by or to a user, e.g. @someone
+    role: Only changes to a role, sig, filter or permission, e.g. TEST
+    since: How far back to look, e.g. 24h or 30d (default 7d)
+`
+)
+
+// Audit will be called (due to AddHandler above) every time a new
+// message is created on any channel that the authenticated bot has access to.
+func (c Command) Audit(s *discordgo.Session, m *discordgo.Message, _ *mux.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
+	defer sp.Close()
+
+	sp.With(zap.String("command", "audit"))
+
+	for _, message := range c.forGuild(m).doAudit(ctx, m) {
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
+
+		if err != nil {
+			sp.Error("Error sending command", zap.Error(err))
+		}
+	}
+}
+
+func (c Command) doAudit(ctx context.Context, m *discordgo.Message) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("Received chat command", zap.String("content", m.Content))
+
+	cmdStr := strings.Fields(m.Content)
+
+	if len(cmdStr) > 1 && cmdStr[1] == "help" {
+		return getHelp("!audit help", auditUsage, auditSubcommands)
+	}
+
+	return audit.List(ctx, cmdStr[1:], m.ChannelID, m.Author.ID, c.dependencies)
+}
@@ -8,10 +8,16 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/bwmarrin/disgord/x/mux"
 	"github.com/chremoas/chremoas-ng/internal/auth"
+	"github.com/chremoas/chremoas-ng/internal/common"
 	"go.uber.org/zap"
 )
 
-const authUsage = `!auth <token>`
+const (
+	authUsage       = `!auth <token>`
+	authSubcommands = `
+    refresh: Update your characters and roles from ESI now, admins can refresh another user
+`
+)
 
 // Auth will be called (due to AddHandler above) every time a new
 // message is created on any channel that the autenticated bot has access to.
@@ -19,9 +25,11 @@ func (c Command) Auth(s *discordgo.Session, m *discordgo.Message, _ *mux.Context
 	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
 	defer sp.Close()
 
+	ctx = common.WithUser(ctx, m.Author.ID)
+
 	sp.With(zap.String("command", "auth"))
 
-	for _, message := range c.doAuth(ctx, m) {
+	for _, message := range c.forGuild(m).doAuth(ctx, m) {
 		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
 
 		if err != nil {
@@ -39,12 +47,19 @@ func (c Command) doAuth(ctx context.Context, m *discordgo.Message) []*discordgo.
 	cmdStr := strings.Split(m.Content, " ")
 
 	if len(cmdStr) < 2 {
-		return getHelp("!auth help", authUsage, "")
+		return getHelp("!auth help", authUsage, authSubcommands)
 	}
 
 	switch cmdStr[1] {
 	case "help":
-		return getHelp("!auth help", authUsage, "")
+		return getHelp("!auth help", authUsage, authSubcommands)
+
+	case "refresh":
+		var user string
+		if len(cmdStr) > 2 {
+			user = cmdStr[2]
+		}
+		return auth.Refresh(ctx, c.poller, user, m.Author.ID, c.dependencies)
 
 	default:
 		return auth.Confirm(ctx, cmdStr[1], m.Author.ID, c.dependencies)
@@ -5,20 +5,46 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/chremoas/chremoas-ng/internal/common"
+	esiPoller "github.com/chremoas/chremoas-ng/internal/esi-poller"
+	"github.com/chremoas/chremoas-ng/internal/esicache"
 )
 
 type Command struct {
 	dependencies common.Dependencies
+	poller       esiPoller.AuthEsiPoller
+	esiCache     *esicache.Cache
 	ctx          context.Context
 }
 
-func New(ctx context.Context, deps common.Dependencies) *Command {
+func New(ctx context.Context, poller esiPoller.AuthEsiPoller, esiCache *esicache.Cache, deps common.Dependencies) *Command {
 	return &Command{
 		dependencies: deps,
+		poller:       poller,
+		esiCache:     esiCache,
 		ctx:          ctx,
 	}
 }
 
+// forGuild points the command at the guild the message came from. Direct messages stay on the main guild.
+func (c Command) forGuild(m *discordgo.Message) Command {
+	if _, ok := common.GetGuild(m.GuildID); ok {
+		c.dependencies = c.dependencies.ForGuild(m.GuildID)
+	}
+
+	return c
+}
+
+// hasFlag is true if the command has the flag anywhere after the subcommand.
+func hasFlag(cmdStr []string, flag string) bool {
+	for _, s := range cmdStr {
+		if s == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
 func getHelp(title, usage, subCommands string) []*discordgo.MessageSend {
 	var embeds []*discordgo.MessageSend
 
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"go.uber.org/zap"
+)
+
+const (
+	esiUsage       = `!esi <subcommand> <parameters>`
+	esiSubcommands = `
+    cache stats: Show how big the ESI cache is and its hit rate
+    cache flush: Empty the ESI cache
+`
+)
+
+// ESI will be called (due to AddHandler above) every time a new
+// message is created on any channel that the authenticated bot has access to.
+func (c Command) ESI(s *discordgo.Session, m *discordgo.Message, _ *mux.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
+	defer sp.Close()
+
+	ctx = common.WithUser(ctx, m.Author.ID)
+
+	sp.With(zap.String("command", "esi"))
+
+	for _, message := range c.doESI(ctx, m) {
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
+
+		if err != nil {
+			sp.Error("Error sending command", zap.Error(err))
+		}
+	}
+}
+
+func (c Command) doESI(ctx context.Context, m *discordgo.Message) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("Received chat command", zap.String("content", m.Content))
+
+	cmdStr := strings.Split(m.Content, " ")
+
+	if len(cmdStr) < 3 || cmdStr[1] != "cache" {
+		return getHelp("!esi help", esiUsage, esiSubcommands)
+	}
+
+	switch cmdStr[2] {
+	case "stats":
+		return c.esiCache.Stats(ctx, m.Author.ID, c.dependencies)
+
+	case "flush":
+		return c.esiCache.Flush(ctx, m.Author.ID, c.dependencies)
+	}
+
+	return getHelp("!esi help", esiUsage, esiSubcommands)
+}
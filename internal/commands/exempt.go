@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/exemptions"
+	"go.uber.org/zap"
+)
+
+const (
+	exemptUsage       = `!exempt <subcommand> <arguments>`
+	exemptSubcommands = `
+    list: List members the member policy skips
+    add: Exempt a user from the member policy
+    remove: Remove a user's exemption
+`
+)
+
+// Exempt will be called (due to AddHandler above) every time a new
+// message is created on any channel that the authenticated bot has access to.
+func (c Command) Exempt(s *discordgo.Session, m *discordgo.Message, _ *mux.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
+	defer sp.Close()
+
+	ctx = common.WithUser(ctx, m.Author.ID)
+
+	sp.With(zap.String("command", "exempt"))
+
+	for _, message := range c.doExempt(ctx, m) {
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
+
+		if err != nil {
+			sp.Error("Error sending command", zap.Error(err))
+		}
+	}
+}
+
+func (c Command) doExempt(ctx context.Context, m *discordgo.Message) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("Received chat command", zap.String("content", m.Content))
+
+	cmdStr := strings.Split(m.Content, " ")
+
+	if len(cmdStr) < 2 {
+		return getHelp("!exempt help", exemptUsage, exemptSubcommands)
+	}
+
+	switch cmdStr[1] {
+	case "list":
+		return exemptions.List(ctx, m.ChannelID, c.dependencies)
+
+	case "add":
+		if len(cmdStr) < 3 {
+			return getHelp("!exempt add help", "!exempt add <user> [reason]", "")
+		}
+		return exemptions.Add(ctx, cmdStr[2], strings.Join(cmdStr[3:], " "), m.Author.ID, c.dependencies)
+
+	case "remove":
+		if len(cmdStr) < 3 {
+			return getHelp("!exempt remove help", "!exempt remove <user>", "")
+		}
+		return exemptions.Remove(ctx, cmdStr[2], m.Author.ID, c.dependencies)
+	}
+
+	return getHelp("!exempt help", exemptUsage, exemptSubcommands)
+}
@@ -7,6 +7,7 @@ import (
 	sl "github.com/bhechinger/spiffylogger"
 	"github.com/bwmarrin/discordgo"
 	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/common"
 	"github.com/chremoas/chremoas-ng/internal/filters"
 	"go.uber.org/zap"
 )
@@ -29,9 +30,11 @@ func (c Command) Filter(s *discordgo.Session, m *discordgo.Message, _ *mux.Conte
 	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
 	defer sp.Close()
 
+	ctx = common.WithUser(ctx, m.Author.ID)
+
 	sp.With(zap.String("command", "filter"))
 
-	for _, message := range c.doFilter(ctx, m) {
+	for _, message := range c.forGuild(m).doFilter(ctx, m) {
 		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
 
 		if err != nil {
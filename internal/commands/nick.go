@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/nicknames"
+	"go.uber.org/zap"
+)
+
+const (
+	nickUsage       = `!nick <subcommand> <parameters>`
+	nickSubcommands = `
+    show: Show the nickname a user should have
+    set: Override a user's nickname
+    clear: Remove a user's nickname override
+`
+)
+
+// Nick will be called (due to AddHandler above) every time a new
+// message is created on any channel that the authenticated bot has access to.
+func (c Command) Nick(s *discordgo.Session, m *discordgo.Message, _ *mux.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
+	defer sp.Close()
+
+	ctx = common.WithUser(ctx, m.Author.ID)
+
+	sp.With(zap.String("command", "nick"))
+
+	for _, message := range c.doNick(ctx, m) {
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
+
+		if err != nil {
+			sp.Error("Error sending command", zap.Error(err))
+		}
+	}
+}
+
+func (c Command) doNick(ctx context.Context, m *discordgo.Message) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("Received chat command", zap.String("content", m.Content))
+
+	cmdStr := strings.Split(m.Content, " ")
+
+	if len(cmdStr) < 2 {
+		return getHelp("!nick help", nickUsage, nickSubcommands)
+	}
+
+	switch cmdStr[1] {
+	case "show":
+		if len(cmdStr) < 3 {
+			return getHelp("!nick show help", "!nick show <user>", "")
+		}
+		return nicknames.Show(ctx, cmdStr[2], c.dependencies)
+
+	case "set":
+		if len(cmdStr) < 4 {
+			return getHelp("!nick set help", "!nick set <user> <nickname>", "")
+		}
+		return nicknames.Set(ctx, cmdStr[2], strings.Join(cmdStr[3:], " "), m.Author.ID, c.dependencies)
+
+	case "clear":
+		if len(cmdStr) < 3 {
+			return getHelp("!nick clear help", "!nick clear <user>", "")
+		}
+		return nicknames.Clear(ctx, cmdStr[2], m.Author.ID, c.dependencies)
+	}
+
+	return getHelp("!nick help", nickUsage, nickSubcommands)
+}
@@ -7,6 +7,7 @@ import (
 	sl "github.com/bhechinger/spiffylogger"
 	"github.com/bwmarrin/discordgo"
 	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/common"
 	"github.com/chremoas/chremoas-ng/internal/perms"
 	"go.uber.org/zap"
 )
@@ -30,6 +31,8 @@ func (c Command) Perms(s *discordgo.Session, m *discordgo.Message, _ *mux.Contex
 	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
 	defer sp.Close()
 
+	ctx = common.WithUser(ctx, m.Author.ID)
+
 	sp.With(zap.String("command", "perms"))
 
 	for _, message := range c.doPerms(ctx, m) {
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/pollruns"
+	"go.uber.org/zap"
+)
+
+const (
+	pollerUsage       = `!poller <subcommand> <parameters>`
+	pollerSubcommands = `
+    status: Show the latest poller runs
+    run: Run the poller now, optionally only one stage
+`
+)
+
+// Poller will be called (due to AddHandler above) every time a new
+// message is created on any channel that the authenticated bot has access to.
+func (c Command) Poller(s *discordgo.Session, m *discordgo.Message, _ *mux.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
+	defer sp.Close()
+
+	ctx = common.WithUser(ctx, m.Author.ID)
+
+	sp.With(zap.String("command", "poller"))
+
+	for _, message := range c.doPoller(ctx, m) {
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
+
+		if err != nil {
+			sp.Error("Error sending command", zap.Error(err))
+		}
+	}
+}
+
+func (c Command) doPoller(ctx context.Context, m *discordgo.Message) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("Received chat command", zap.String("content", m.Content))
+
+	cmdStr := strings.Split(m.Content, " ")
+
+	if len(cmdStr) < 2 {
+		return getHelp("!poller help", pollerUsage, pollerSubcommands)
+	}
+
+	switch cmdStr[1] {
+	case "status":
+		return pollruns.Status(ctx, m.ChannelID, c.dependencies)
+
+	case "run":
+		var stage string
+		if len(cmdStr) > 2 {
+			stage = cmdStr[2]
+		}
+		return pollruns.Run(ctx, c.poller, stage, m.Author.ID, c.dependencies)
+	}
+
+	return getHelp("!poller help", pollerUsage, pollerSubcommands)
+}
@@ -25,6 +25,10 @@ const (
     set: Set role key
     list members: List Role members
     list membership: List user Roles
+    doctor: Check the bot can manage the synced roles
+    diff: Compare a Role's members with discord
+    sync preview: Show what the next role sync would change and its plan ID
+    sync confirm: Apply a previewed role sync that's over the delete limit or a dry run
 `
 )
 
@@ -34,9 +38,11 @@ func (c Command) Role(s *discordgo.Session, m *discordgo.Message, _ *mux.Context
 	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
 	defer sp.Close()
 
+	ctx = common.WithUser(ctx, m.Author.ID)
+
 	sp.With(zap.String("command", "role"))
 
-	for _, message := range c.doRole(ctx, m) {
+	for _, message := range c.forGuild(m).doRole(ctx, m) {
 		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
 
 		if err != nil {
@@ -112,6 +118,34 @@ func (c Command) doRole(ctx context.Context, m *discordgo.Message) []*discordgo.
 		}
 		return roles.Info(ctx, roles.Role, cmdStr[2], c.dependencies)
 
+	case "doctor":
+		return roles.Doctor(ctx, m.Author.ID, c.dependencies)
+
+	case "diff":
+		if len(cmdStr) < 3 {
+			return getHelp("!role diff help", "!role diff <role_name> [--fix]", "")
+		}
+		return roles.Diff(ctx, roles.Role, cmdStr[2], hasFlag(cmdStr, "--fix"), m.ChannelID, m.Author.ID, c.dependencies)
+
+	case "sync":
+		if len(cmdStr) < 3 {
+			return getHelp("!role sync help", "!role sync <preview|confirm <plan_id>>", "")
+		}
+
+		switch cmdStr[2] {
+		case "preview":
+			return roles.SyncPreview(ctx, c.poller, m.ChannelID, m.Author.ID, c.dependencies)
+
+		case "confirm":
+			if len(cmdStr) < 4 {
+				return getHelp("!role sync help", "!role sync confirm <plan_id>", "")
+			}
+			return roles.SyncConfirm(ctx, c.poller, cmdStr[3], m.Author.ID, c.dependencies)
+
+		default:
+			return getHelp("!role sync help", "!role sync <preview|confirm <plan_id>>", "")
+		}
+
 	case "keys":
 		return roles.Keys()
 
@@ -24,6 +24,7 @@ const (
     create: Add SIGs
     destroy: Delete SIGs
     info: Get SIG info
+    diff: Compare a SIG's members with discord
     set: Set sig key
     add: Add user to SIG
     remove: Remove user from SIG
@@ -41,9 +42,11 @@ func (c Command) Sig(s *discordgo.Session, m *discordgo.Message, _ *mux.Context)
 	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
 	defer sp.Close()
 
+	ctx = common.WithUser(ctx, m.Author.ID)
+
 	sp.With(zap.String("command", "sig"))
 
-	for _, message := range c.doSig(ctx, m) {
+	for _, message := range c.forGuild(m).doSig(ctx, m) {
 		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
 
 		if err != nil {
@@ -118,6 +121,12 @@ func (c Command) doSig(ctx context.Context, m *discordgo.Message) []*discordgo.M
 		}
 		return roles.Info(ctx, roles.Sig, cmdStr[2], c.dependencies)
 
+	case "diff":
+		if len(cmdStr) < 3 {
+			return getHelp("!sig diff help", "!sig diff <sig_name> [--fix]", "")
+		}
+		return roles.Diff(ctx, roles.Sig, cmdStr[2], hasFlag(cmdStr, "--fix"), m.ChannelID, m.Author.ID, c.dependencies)
+
 	case "set":
 		if len(cmdStr) < 5 {
 			return getHelp("!sig set help", "!sig set <sig_name> <key> <value>", "")
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/stats"
+	"go.uber.org/zap"
+)
+
+const (
+	statsUsage       = `!stats [subcommand] [arguments] [days]`
+	statsSubcommands = `
+    (none): Member counts now against days ago for corps, alliances and roles, and the top sigs (default 30 days)
+    show: Daily member counts of a corp, alliance, sig or role, e.g. !stats show TEST 60
+    history: When a user joined and left filters, e.g. !stats history @someone (default 90 days)
+    csv: Export the daily counts or the history as CSV, e.g. !stats csv history 30 (default snapshots, 90 days)
+`
+)
+
+// Stats will be called (due to AddHandler above) every time a new
+// message is created on any channel that the authenticated bot has access to.
+func (c Command) Stats(s *discordgo.Session, m *discordgo.Message, _ *mux.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
+	defer sp.Close()
+
+	sp.With(zap.String("command", "stats"))
+
+	for _, message := range c.forGuild(m).doStats(ctx, m) {
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
+
+		if err != nil {
+			sp.Error("Error sending command", zap.Error(err))
+		}
+	}
+}
+
+func (c Command) doStats(ctx context.Context, m *discordgo.Message) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("Received chat command", zap.String("content", m.Content))
+
+	cmdStr := strings.Fields(m.Content)
+
+	if len(cmdStr) < 2 {
+		return stats.Trends(ctx, stats.DefaultTrendDays, m.ChannelID, m.Author.ID, c.dependencies)
+	}
+
+	if days, ok := stats.ParseDays(cmdStr[1]); ok && len(cmdStr) == 2 {
+		return stats.Trends(ctx, days, m.ChannelID, m.Author.ID, c.dependencies)
+	}
+
+	switch cmdStr[1] {
+	case "show":
+		if len(cmdStr) < 3 || len(cmdStr) > 4 {
+			return getHelp("!stats show help", "!stats show <name> [days]", "")
+		}
+
+		days := stats.DefaultTrendDays
+		if len(cmdStr) == 4 {
+			var ok bool
+			if days, ok = stats.ParseDays(cmdStr[3]); !ok {
+				return getHelp("!stats show help", "!stats show <name> [days]", "")
+			}
+		}
+
+		return stats.Series(ctx, cmdStr[2], days, m.ChannelID, m.Author.ID, c.dependencies)
+
+	case "history":
+		if len(cmdStr) < 3 || len(cmdStr) > 4 {
+			return getHelp("!stats history help", "!stats history <user> [days]", "")
+		}
+
+		days := stats.DefaultHistoryDays
+		if len(cmdStr) == 4 {
+			var ok bool
+			if days, ok = stats.ParseDays(cmdStr[3]); !ok {
+				return getHelp("!stats history help", "!stats history <user> [days]", "")
+			}
+		}
+
+		return stats.History(ctx, cmdStr[2], days, m.ChannelID, m.Author.ID, c.dependencies)
+
+	case "csv":
+		what := "snapshots"
+		days := stats.DefaultHistoryDays
+
+		for _, arg := range cmdStr[2:] {
+			if d, ok := stats.ParseDays(arg); ok {
+				days = d
+				continue
+			}
+
+			what = arg
+		}
+
+		return stats.Export(ctx, what, days, m.Author.ID, c.dependencies)
+
+	default:
+		return getHelp("!stats help", statsUsage, statsSubcommands)
+	}
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/bwmarrin/disgord/x/mux"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"go.uber.org/zap"
+
+	"github.com/chremoas/chremoas-ng/internal/roles"
+)
+
+const (
+	syncUsage       = `!sync <subcommand> <parameters>`
+	syncSubcommands = `
+    check: Compare a user's roles with their filters, --fix queues a sync
+`
+)
+
+// Sync will be called (due to AddHandler above) every time a new
+// message is created on any channel that the authenticated bot has access to.
+func (c Command) Sync(s *discordgo.Session, m *discordgo.Message, _ *mux.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(c.ctx, sl.NewID())
+	defer sp.Close()
+
+	ctx = common.WithUser(ctx, m.Author.ID)
+
+	sp.With(zap.String("command", "sync"))
+
+	for _, message := range c.forGuild(m).doSync(ctx, m) {
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, message)
+
+		if err != nil {
+			sp.Error("Error sending command", zap.Error(err))
+		}
+	}
+}
+
+func (c Command) doSync(ctx context.Context, m *discordgo.Message) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("Received chat command", zap.String("content", m.Content))
+
+	cmdStr := strings.Split(m.Content, " ")
+
+	if len(cmdStr) < 2 {
+		return getHelp("!sync help", syncUsage, syncSubcommands)
+	}
+
+	switch cmdStr[1] {
+	case "check":
+		if len(cmdStr) < 3 {
+			return getHelp("!sync check help", "!sync check <user> [--fix]", "")
+		}
+		return roles.CheckUser(ctx, cmdStr[2], hasFlag(cmdStr, "--fix"), m.Author.ID, c.dependencies)
+	}
+
+	return getHelp("!sync help", syncUsage, syncSubcommands)
+}
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"context"
+	"errors"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Access is what the access policy in bot.accessPolicy lets a character do.
+type Access int
+
+const (
+	// AccessDenied characters can't authenticate and get nothing
+	AccessDenied Access = iota
+	// AccessBlue characters are only allowed because of standings, they get the blue filter but no corp or alliance
+	// roles
+	AccessBlue
+	// AccessMember characters are in an allowed corp or alliance, or the policy is off
+	AccessMember
+)
+
+var ErrAccessDenied = errors.New("character isn't allowed to authenticate")
+
+func (a Access) String() string {
+	switch a {
+	case AccessMember:
+		return "member"
+	case AccessBlue:
+		return "blue"
+	default:
+		return "denied"
+	}
+}
+
+func contains(ids []int, id int32) bool {
+	if id == 0 {
+		return false
+	}
+
+	for _, i := range ids {
+		if int32(i) == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func denied(corporationID, allianceID int32) bool {
+	return contains(viper.GetIntSlice("bot.accessPolicy.denyCorporations"), corporationID) ||
+		contains(viper.GetIntSlice("bot.accessPolicy.denyAlliances"), allianceID)
+}
+
+// StandingsEnabled is true if bot.accessPolicy.standings points at a contact list.
+func StandingsEnabled() bool {
+	return viper.GetInt("bot.accessPolicy.standings.id") != 0
+}
+
+// BlueFilter is the filter characters allowed by standings are put in, empty if there isn't one.
+func BlueFilter() string {
+	return viper.GetString("bot.accessPolicy.standings.filter")
+}
+
+// AccessPolicyEnabled is true if anything other than the deny lists is set. With no allow lists and no standings
+// everyone that isn't denied is treated as a member.
+func AccessPolicyEnabled() bool {
+	return len(viper.GetIntSlice("bot.accessPolicy.allowCorporations")) > 0 ||
+		len(viper.GetIntSlice("bot.accessPolicy.allowAlliances")) > 0 ||
+		StandingsEnabled()
+}
+
+// OrganizationAccess checks a corp or alliance against the allow and deny lists, standings aren't used. This decides
+// whether roles get created for it.
+func OrganizationAccess(corporationID, allianceID int32) Access {
+	if denied(corporationID, allianceID) {
+		return AccessDenied
+	}
+
+	if !AccessPolicyEnabled() {
+		return AccessMember
+	}
+
+	if contains(viper.GetIntSlice("bot.accessPolicy.allowCorporations"), corporationID) ||
+		contains(viper.GetIntSlice("bot.accessPolicy.allowAlliances"), allianceID) {
+		return AccessMember
+	}
+
+	return AccessDenied
+}
+
+// CheckAccess works out what the access policy lets a character do. Deny lists win, then the allow lists, then
+// standings from the contact list. Any of the IDs can be 0 if they aren't known.
+func CheckAccess(ctx context.Context, characterID, corporationID, allianceID int32, deps Dependencies) (Access, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.Int32("character_id", characterID),
+		zap.Int32("corporation_id", corporationID),
+		zap.Int32("alliance_id", allianceID),
+	)
+
+	access := OrganizationAccess(corporationID, allianceID)
+	if access == AccessMember || !StandingsEnabled() {
+		return access, nil
+	}
+
+	// Standings don't override the deny lists
+	if denied(corporationID, allianceID) {
+		return AccessDenied, nil
+	}
+
+	standings, err := deps.Storage.GetStandings(ctx)
+	if err != nil {
+		sp.Error("Error getting standings", zap.Error(err))
+		return AccessDenied, err
+	}
+
+	minStanding := float32(viper.GetFloat64("bot.accessPolicy.standings.minStanding"))
+
+	for _, id := range []int32{characterID, corporationID, allianceID} {
+		if id == 0 {
+			continue
+		}
+
+		if standing, ok := standings[id]; ok && standing >= minStanding {
+			sp.Debug("Allowed by standings", zap.Int32("contact_id", id), zap.Float32("standing", standing))
+			return AccessBlue, nil
+		}
+	}
+
+	return AccessDenied, nil
+}
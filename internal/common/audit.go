@@ -0,0 +1,182 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Who made a change, see WithUser, WithPoller and WithWeb. Changes with no actor in their context are recorded as
+// made by the system.
+const (
+	ActorUser   = "user"
+	ActorPoller = "poller"
+	ActorWeb    = "web"
+	ActorSystem = "system"
+)
+
+type (
+	actorKey      struct{}
+	targetTypeKey struct{}
+)
+
+type actor struct {
+	actorType string
+	id        string
+}
+
+// WithUser records changes made with the context as made by the discord user, in the audit log and the membership
+// history.
+func WithUser(ctx context.Context, userID string) context.Context {
+	ctx = storage.WithMembershipCause(ctx, ActorUser, userID)
+	return context.WithValue(ctx, actorKey{}, actor{actorType: ActorUser, id: userID})
+}
+
+// WithPoller records changes made with the context as made by the ESI poller.
+func WithPoller(ctx context.Context) context.Context {
+	ctx = storage.WithMembershipCause(ctx, ActorPoller, "")
+	return context.WithValue(ctx, actorKey{}, actor{actorType: ActorPoller})
+}
+
+// WithWeb records changes made with the context as made by the auth web site.
+func WithWeb(ctx context.Context) context.Context {
+	ctx = storage.WithMembershipCause(ctx, ActorWeb, "")
+	return context.WithValue(ctx, actorKey{}, actor{actorType: ActorWeb})
+}
+
+// WithTargetType records changes made with the context against a different kind of target. Sigs are joined through
+// their filter, the change is recorded as made to the sig.
+func WithTargetType(ctx context.Context, targetType string) context.Context {
+	return context.WithValue(ctx, targetTypeKey{}, targetType)
+}
+
+func actorFrom(ctx context.Context) actor {
+	if a, ok := ctx.Value(actorKey{}).(actor); ok {
+		return a
+	}
+
+	return actor{actorType: ActorSystem}
+}
+
+// Change is one administrative change for the audit log. Before and After are stored as JSON, either can be nil.
+type Change struct {
+	Action     string
+	TargetType string
+	Target     string
+	// The discord user the change was made to, if any
+	MemberID string
+	Before   interface{}
+	After    interface{}
+}
+
+// Audit records the change in the audit log with the actor from the context and mirrors it to bot.audit.channel if
+// that's set. Failing to record a change is logged but doesn't fail the change, except in a transaction: the failed
+// insert has aborted it so the error is returned for the change to fail too. Callers check the error since they can't
+// always know whether they run in a transaction. In a transaction the change is only mirrored once it commits.
+func Audit(ctx context.Context, change Change, deps Dependencies) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	a := actorFrom(ctx)
+
+	if targetType, ok := ctx.Value(targetTypeKey{}).(string); ok {
+		change.TargetType = targetType
+	}
+
+	sp.With(
+		zap.String("action", change.Action),
+		zap.String("target", change.Target),
+		zap.String("actor_type", a.actorType),
+		zap.String("actor_id", a.id),
+	)
+
+	entry := payloads.AuditEntry{
+		ActorType:     a.actorType,
+		ActorID:       a.id,
+		Action:        change.Action,
+		TargetType:    change.TargetType,
+		Target:        change.Target,
+		MemberID:      change.MemberID,
+		CorrelationID: sp.GetCorrelationID(),
+	}
+
+	var err error
+
+	entry.Before, err = auditValue(change.Before)
+	if err != nil {
+		sp.Error("Error encoding audit before value", zap.Error(err))
+	}
+
+	entry.After, err = auditValue(change.After)
+	if err != nil {
+		sp.Error("Error encoding audit after value", zap.Error(err))
+	}
+
+	err = deps.Storage.InsertAuditEntry(ctx, entry)
+	if err != nil {
+		sp.Error("Error recording audit entry", zap.Error(err))
+		if deps.Storage.InTx() {
+			return err
+		}
+	}
+
+	channelID := viper.GetString("bot.audit.channel")
+	if channelID == "" || deps.Session == nil {
+		return nil
+	}
+
+	deps.Storage.OnCommit(func() {
+		_, sp := sl.OpenSpan(ctx)
+		defer sp.Close()
+
+		// Mentions show who's involved without pinging them
+		_, err := deps.Session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+			Content:         FormatAuditEntry(entry),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			sp.Error("Error mirroring audit entry", zap.Error(err))
+		}
+	})
+
+	return nil
+}
+
+func auditValue(value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(value)
+}
+
+// FormatAuditEntry is a one line description of the entry for discord.
+func FormatAuditEntry(entry payloads.AuditEntry) string {
+	who := entry.ActorType
+	if entry.ActorID != "" {
+		who = fmt.Sprintf("<@%s>", entry.ActorID)
+	}
+
+	line := fmt.Sprintf("%s `%s` %s `%s`", who, entry.Action, entry.TargetType, entry.Target)
+
+	if entry.MemberID != "" {
+		line += fmt.Sprintf(" member <@%s>", entry.MemberID)
+	}
+
+	if len(entry.Before) > 0 {
+		line += fmt.Sprintf(" before `%s`", entry.Before)
+	}
+
+	if len(entry.After) > 0 {
+		line += fmt.Sprintf(" after `%s`", entry.After)
+	}
+
+	return line
+}
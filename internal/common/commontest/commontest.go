@@ -0,0 +1,137 @@
+// Package commontest builds dependencies for tests. Everything is kept in memory and the discord session can't reach
+// discord, every request to it fails with a 404.
+package commontest
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/chremoas/chremoas-ng/internal/queue"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
+)
+
+// GuildID is the guild the dependencies act on, it's also set as bot.discordServerId.
+const GuildID = "1000"
+
+// Context returns a context with a logger that only logs fatal errors.
+func Context() context.Context {
+	return sl.NewCtxWithLogger(zapcore.FatalLevel)
+}
+
+// Dependencies returns dependencies on a fresh in-memory store whose producers write to its outbox.
+func Dependencies(t *testing.T) common.Dependencies {
+	t.Helper()
+
+	viper.Set("bot.discordServerId", GuildID)
+	t.Cleanup(func() { viper.Set("bot.discordServerId", nil) })
+
+	session, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("creating discord session: %s", err)
+	}
+
+	session.MaxRestRetries = 0
+	session.Client = &http.Client{Transport: notFound{}}
+
+	store := storage.NewMemory().ForGuild(GuildID)
+
+	return common.Dependencies{
+		Storage:         store,
+		MembersProducer: store.OutboxPublisher(queue.Members),
+		RolesProducer:   store.OutboxPublisher(queue.Roles),
+		Session:         session,
+		BotID:           "1",
+		GuildID:         GuildID,
+	}
+}
+
+// RoleMessages returns the role messages waiting in the outbox, in the order they were sent.
+func RoleMessages(t *testing.T, deps common.Dependencies) []payloads.RolePayload {
+	t.Helper()
+
+	var out []payloads.RolePayload
+	for _, body := range outbox(t, deps, queue.Roles) {
+		var payload payloads.RolePayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("decoding role message: %s", err)
+		}
+
+		out = append(out, payload)
+	}
+
+	return out
+}
+
+// MemberMessages returns the member messages waiting in the outbox, in the order they were sent.
+func MemberMessages(t *testing.T, deps common.Dependencies) []payloads.MemberPayload {
+	t.Helper()
+
+	var out []payloads.MemberPayload
+	for _, body := range outbox(t, deps, queue.Members) {
+		var payload payloads.MemberPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("decoding member message: %s", err)
+		}
+
+		out = append(out, payload)
+	}
+
+	return out
+}
+
+func outbox(t *testing.T, deps common.Dependencies, q string) [][]byte {
+	t.Helper()
+
+	ctx := Context()
+
+	var bodies [][]byte
+
+	err := deps.Storage.WithTx(ctx, func(tx storage.Store) error {
+		messages, err := tx.GetOutboxMessages(ctx, []string{q}, 1000)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			bodies = append(bodies, message.Body)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reading outbox: %s", err)
+	}
+
+	return bodies
+}
+
+// Reply returns the text of the first message of a command's reply, or "" if there isn't one.
+func Reply(messages []*discordgo.MessageSend) string {
+	if len(messages) == 0 {
+		return ""
+	}
+
+	return messages[0].Content
+}
+
+type notFound struct{}
+
+func (notFound) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     "404 Not Found",
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"message": "Unknown", "code": 0}`)),
+		Request:    req,
+	}, nil
+}
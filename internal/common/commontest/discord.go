@@ -0,0 +1,99 @@
+package commontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+)
+
+// Guild is a fake of the discord guild GuildID. It answers reads of its roles and members and accepts every change
+// without doing anything but recording it. Everything else fails with a 404 like the session from Dependencies.
+type Guild struct {
+	Roles []*discordgo.Role
+	// Members in the order discord lists them, by ID
+	Members []*discordgo.Member
+
+	mutex   sync.Mutex
+	changes []string
+}
+
+// Serve makes the session in deps talk to the guild.
+func (g *Guild) Serve(deps common.Dependencies) {
+	deps.Session.Client = &http.Client{Transport: g}
+}
+
+// Changes returns what was sent to the guild, as the method and the path under the guild, e.g.
+// "DELETE /members/123".
+func (g *Guild) Changes() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return append([]string(nil), g.changes...)
+}
+
+func (g *Guild) RoundTrip(req *http.Request) (*http.Response, error) {
+	prefix := "/api/v" + discordgo.APIVersion + "/guilds/" + GuildID
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		return notFound{}.RoundTrip(req)
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, prefix)
+
+	if req.Method != http.MethodGet {
+		g.mutex.Lock()
+		g.changes = append(g.changes, req.Method+" "+path)
+		g.mutex.Unlock()
+
+		return respond(req, http.StatusNoContent, nil)
+	}
+
+	switch {
+	case path == "/roles":
+		return respond(req, http.StatusOK, g.Roles)
+
+	case path == "/members":
+		members := g.Members
+		if after := req.URL.Query().Get("after"); after != "" {
+			for i, member := range g.Members {
+				if member.User.ID == after {
+					members = g.Members[i+1:]
+				}
+			}
+		}
+
+		return respond(req, http.StatusOK, members)
+
+	case strings.HasPrefix(path, "/members/"):
+		for _, member := range g.Members {
+			if member.User.ID == strings.TrimPrefix(path, "/members/") {
+				return respond(req, http.StatusOK, member)
+			}
+		}
+	}
+
+	return notFound{}.RoundTrip(req)
+}
+
+func respond(req *http.Request, status int, body interface{}) (*http.Response, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Response{
+		Status:     http.StatusText(status),
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(b)),
+		Request:    req,
+	}, nil
+}
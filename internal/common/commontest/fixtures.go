@@ -0,0 +1,94 @@
+package commontest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/roles"
+)
+
+// AddPermission creates the permission with the users as its members.
+func AddPermission(t *testing.T, deps common.Dependencies, permission string, userIDs ...string) {
+	t.Helper()
+
+	if err := deps.Storage.InsertPermission(Context(), permission, permission); err != nil {
+		t.Fatalf("InsertPermission: %s", err)
+	}
+
+	for _, userID := range userIDs {
+		AddPermissionMember(t, deps, permission, userID)
+	}
+}
+
+func AddPermissionMember(t *testing.T, deps common.Dependencies, permission, userID string) {
+	t.Helper()
+
+	ctx := Context()
+
+	perm, err := deps.Storage.GetPermission(ctx, permission)
+	if err != nil {
+		t.Fatalf("GetPermission: %s", err)
+	}
+
+	if err = deps.Storage.InsertPermissionMembership(ctx, perm.ID, userID); err != nil {
+		t.Fatalf("InsertPermissionMembership: %s", err)
+	}
+}
+
+// IsPermissionMember is true if the user is a member of the permission.
+func IsPermissionMember(t *testing.T, deps common.Dependencies, permission, userID string) bool {
+	t.Helper()
+
+	members, err := deps.Storage.ListPermissionMembers(Context(), permission)
+	if err != nil {
+		t.Fatalf("ListPermissionMembers: %s", err)
+	}
+
+	for _, member := range members {
+		if strconv.Itoa(member) == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddRole creates a role and its filter, both called ticker, the way !role create does and gives it the discord ID
+// roleID.
+func AddRole(t *testing.T, deps common.Dependencies, ticker, name, roleID string) {
+	t.Helper()
+
+	ctx := Context()
+
+	roles.Add(ctx, roles.Role, false, ticker, name, "discord", deps)
+	if err := deps.Storage.UpdateRole(ctx, roleID, name, ""); err != nil {
+		t.Fatalf("UpdateRole: %s", err)
+	}
+}
+
+func AddFilterMember(t *testing.T, deps common.Dependencies, filter, userID string) {
+	t.Helper()
+
+	ctx := Context()
+
+	f, err := deps.Storage.GetFilter(ctx, filter)
+	if err != nil {
+		t.Fatalf("GetFilter: %s", err)
+	}
+
+	if err = deps.Storage.AddFilterMembership(ctx, f.ID, userID); err != nil {
+		t.Fatalf("AddFilterMembership: %s", err)
+	}
+}
+
+func FilterMembers(t *testing.T, deps common.Dependencies, filter string) []int64 {
+	t.Helper()
+
+	members, err := deps.Storage.ListFilterMembers(Context(), filter)
+	if err != nil {
+		t.Fatalf("ListFilterMembers: %s", err)
+	}
+
+	return members
+}
@@ -1,15 +1,44 @@
 package common
 
 import (
+	"context"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/chremoas/chremoas-ng/internal/queue"
 	"github.com/chremoas/chremoas-ng/internal/storage"
 )
 
 type Dependencies struct {
-	Storage         *storage.Storage
-	MembersProducer *queue.Producer
-	RolesProducer   *queue.Producer
+	Storage         storage.Store
+	MembersProducer queue.Publisher
+	RolesProducer   queue.Publisher
 	Session         *discordgo.Session
-	GuildID         string
+	// BotID is the bot's discord user ID. It's looked up at startup so it's there before the gateway is, or without it.
+	BotID   string
+	GuildID string
+	// Leader is nil if the process doesn't run the bot and poller
+	Leader Leader
+}
+
+// ForGuild returns a copy of the dependencies that acts on the given guild, including only seeing that guild's roles.
+func (d Dependencies) ForGuild(guildID string) Dependencies {
+	d.GuildID = guildID
+	if d.Storage != nil {
+		d.Storage = d.Storage.ForGuild(guildID)
+	}
+
+	return d
+}
+
+// WithTx runs fn with a copy of the dependencies whose storage runs in one transaction and whose producers write to
+// the outbox in it, so the changes fn makes and the queue messages it sends are kept or dropped together. The
+// messages are published by the outbox relay once the transaction commits.
+func (d Dependencies) WithTx(ctx context.Context, fn func(deps Dependencies) error) error {
+	return d.Storage.WithTx(ctx, func(tx storage.Store) error {
+		d.Storage = tx
+		d.MembersProducer = tx.OutboxPublisher(queue.Members)
+		d.RolesProducer = tx.OutboxPublisher(queue.Roles)
+
+		return fn(d)
+	})
 }
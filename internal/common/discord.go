@@ -38,9 +38,33 @@ func GetUsername(userID interface{}, discord *discordgo.Session) string {
 	}
 }
 
-func IgnoreRole(role string) bool {
-	ignoredRoles := viper.GetStringSlice("bot.ignoredRoles")
-	ignoredRoles = append(ignoredRoles, "@everyone")
+// AuthURL is where members go to sign in with their EVE character.
+func AuthURL() string {
+	return fmt.Sprintf("%s://%s/",
+		viper.GetString("oauth.callBackProtocol"),
+		viper.GetString("oauth.callBackHost"),
+	)
+}
+
+// DirectMessage sends a message to the user in a DM.
+func DirectMessage(userID, message string, deps Dependencies) error {
+	channel, err := deps.Session.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = deps.Session.ChannelMessageSend(channel.ID, message)
+	return err
+}
+
+func IgnoreRole(guildID, role string) bool {
+	guild, _ := GetGuild(guildID)
+	ignoredRoles := append(guild.IgnoredRoles, "@everyone")
+
+	// The member policy's quarantine role is made by hand, role syncs mustn't delete it
+	if quarantineRole := viper.GetString("bot.memberPolicy.quarantineRole"); quarantineRole != "" {
+		ignoredRoles = append(ignoredRoles, quarantineRole)
+	}
 
 	for _, r := range ignoredRoles {
 		if role == r {
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Guild is the per guild configuration from bot.guilds.
+type Guild struct {
+	ID           string   `mapstructure:"id"`
+	IgnoredRoles []string `mapstructure:"ignoredRoles"`
+}
+
+// Guilds returns every guild the bot manages. Auth and filters are shared between them but each guild has its own
+// roles. If bot.guilds isn't set we fall back to the single guild in bot.discordServerId.
+func Guilds() []Guild {
+	var guilds []Guild
+
+	if err := viper.UnmarshalKey("bot.guilds", &guilds); err != nil || len(guilds) == 0 {
+		return []Guild{{
+			ID:           viper.GetString("bot.discordServerId"),
+			IgnoredRoles: viper.GetStringSlice("bot.ignoredRoles"),
+		}}
+	}
+
+	return guilds
+}
+
+// GetGuild returns the configuration for a guild and whether the bot manages it at all.
+func GetGuild(guildID string) (Guild, bool) {
+	for _, guild := range Guilds() {
+		if guild.ID == guildID {
+			return guild, true
+		}
+	}
+
+	return Guild{}, false
+}
+
+// MainGuildID is the guild people auth into. Leaving it means leaving the coalition, leaving any other guild doesn't.
+func MainGuildID() string {
+	return viper.GetString("bot.discordServerId")
+}
@@ -0,0 +1,214 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var ErrNoBotID = errors.New("the bot's user ID isn't known")
+
+// HierarchyReport is what the bot can and can't do to the synced roles in a guild.
+type HierarchyReport struct {
+	GuildID        string
+	CanManageRoles bool
+	TopPosition    int
+	// Unmanageable are the synced roles at or above the bot's highest role
+	Unmanageable []payloads.Role
+	// NewlyUnmanageable are the roles that weren't marked unmanageable before this check
+	NewlyUnmanageable []payloads.Role
+}
+
+// OK is true if the bot can manage every synced role.
+func (h HierarchyReport) OK() bool {
+	return h.CanManageRoles && len(h.Unmanageable) == 0
+}
+
+func (h HierarchyReport) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(fmt.Sprintf("Guild: %s\n", h.GuildID))
+	buffer.WriteString(fmt.Sprintf("Manage Roles permission: %t\n", h.CanManageRoles))
+	buffer.WriteString(fmt.Sprintf("Bot's highest role position: %d\n", h.TopPosition))
+
+	if len(h.Unmanageable) == 0 {
+		buffer.WriteString("All synced roles are below the bot's highest role\n")
+	} else {
+		buffer.WriteString("Synced roles the bot can't manage (move them below the bot's role):\n")
+		for _, role := range h.Unmanageable {
+			buffer.WriteString(fmt.Sprintf("\t%s (position %d)\n", role.Name, role.Position))
+		}
+	}
+
+	return buffer.String()
+}
+
+// botStanding returns the bot's effective guild permissions and the position of its highest role.
+func botStanding(guildID string, deps Dependencies) (int64, int, error) {
+	if deps.BotID == "" {
+		return 0, -1, ErrNoBotID
+	}
+
+	member, err := deps.Session.GuildMember(guildID, deps.BotID)
+	if err != nil {
+		return 0, -1, err
+	}
+
+	roles, err := deps.Session.GuildRoles(guildID)
+	if err != nil {
+		return 0, -1, err
+	}
+
+	var (
+		permissions int64
+		top         int
+	)
+
+	for _, role := range roles {
+		// The @everyone role has the same ID as the guild and applies to everybody
+		if role.ID == guildID {
+			permissions |= role.Permissions
+			continue
+		}
+
+		for _, memberRole := range member.Roles {
+			if role.ID == memberRole {
+				permissions |= role.Permissions
+				if role.Position > top {
+					top = role.Position
+				}
+			}
+		}
+	}
+
+	return permissions, top, nil
+}
+
+// CheckHierarchy works out which synced roles the bot can manage in deps.GuildID and records it in the database so the
+// consumers can skip the ones it can't instead of retrying forever.
+func CheckHierarchy(ctx context.Context, deps Dependencies) (*HierarchyReport, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("guild_id", deps.GuildID))
+
+	permissions, top, err := botStanding(deps.GuildID, deps)
+	if err != nil {
+		sp.Error("Error getting the bot's permissions", zap.Error(err))
+		return nil, err
+	}
+
+	report := &HierarchyReport{
+		GuildID:        deps.GuildID,
+		CanManageRoles: permissions&(discordgo.PermissionManageRoles|discordgo.PermissionAdministrator) != 0,
+		TopPosition:    top,
+	}
+
+	discordRoles, err := deps.Session.GuildRoles(deps.GuildID)
+	if err != nil {
+		sp.Error("Error getting discord roles", zap.Error(err))
+		return nil, err
+	}
+
+	positions := make(map[string]int)
+	for _, role := range discordRoles {
+		positions[role.ID] = role.Position
+	}
+
+	dbRoles, err := deps.Storage.GetRolesBySync(ctx, true)
+	if err != nil {
+		sp.Error("Error getting synced roles", zap.Error(err))
+		return nil, err
+	}
+
+	for _, role := range dbRoles {
+		position, ok := positions[role.ID]
+		if !ok {
+			// Not in discord yet, nothing to check
+			continue
+		}
+
+		role.Position = position
+		manageable := position < top
+
+		if !manageable {
+			report.Unmanageable = append(report.Unmanageable, role)
+			if role.Manageable {
+				report.NewlyUnmanageable = append(report.NewlyUnmanageable, role)
+			}
+		}
+
+		if manageable != role.Manageable {
+			sp.Info("Role manageability changed", zap.String("role", role.Name), zap.Bool("manageable", manageable))
+
+			err = deps.Storage.UpdateRoleManageable(ctx, role.ID, manageable)
+			if err != nil {
+				sp.Error("Error updating role", zap.Error(err))
+				return nil, err
+			}
+		}
+	}
+
+	sp.With(
+		zap.Bool("can_manage_roles", report.CanManageRoles),
+		zap.Int("top_position", report.TopPosition),
+		zap.Int("unmanageable", len(report.Unmanageable)),
+	)
+	sp.Debug("Checked role hierarchy")
+
+	return report, nil
+}
+
+// BotTopPosition returns the position of the bot's highest role in the guild. Discord won't let the bot edit, move or
+// assign any role at or above that position.
+func BotTopPosition(guildID string, deps Dependencies) (int, error) {
+	_, top, err := botStanding(guildID, deps)
+	return top, err
+}
+
+// Alert sends a message to the admin alert channel in bot.alertChannel, if there is one.
+func Alert(ctx context.Context, message string, deps Dependencies) {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	channelID := viper.GetString("bot.alertChannel")
+	if channelID == "" {
+		sp.Warn("No alert channel configured", zap.String("alert", message))
+		return
+	}
+
+	embed := NewEmbed()
+	embed.SetTitle("Chremoas alert")
+	embed.SetDescription(message)
+
+	_, err := deps.Session.ChannelMessageSendEmbed(channelID, embed.GetMessageEmbed())
+	if err != nil {
+		sp.Error("Error sending alert", zap.Error(err), zap.String("alert", message))
+	}
+}
+
+// IsForbidden is true if discord refused the request, retrying won't help until an admin fixes something.
+func IsForbidden(err error) bool {
+	return isStatus(err, http.StatusForbidden)
+}
+
+// IsNotFound is true if the discord object doesn't exist.
+func IsNotFound(err error) bool {
+	return isStatus(err, http.StatusNotFound)
+}
+
+func isStatus(err error, status int) bool {
+	if restError, ok := err.(*discordgo.RESTError); ok {
+		return restError.Response != nil && restError.Response.StatusCode == status
+	}
+
+	return false
+}
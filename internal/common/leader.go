@@ -0,0 +1,7 @@
+package common
+
+// Leader tells whether this replica is the elected leader. Only the leader does work that mustn't run twice, like
+// polls, role syncs and handling discord commands and events.
+type Leader interface {
+	IsLeader() bool
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"text/template"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Discord won't take a nickname longer than this.
+const NicknameMaxLength = 32
+
+// NicknameData is what bot.nickname.template can use, e.g. "[{{.CorpTicker}}] {{.Name}}".
+type NicknameData struct {
+	// Name is the name of the member's main character, the first one they authed
+	Name           string
+	CorpTicker     string
+	AllianceTicker string
+}
+
+// nicknameTemplate returns the parsed bot.nickname.template, or nil if nicknames aren't enforced.
+func nicknameTemplate() (*template.Template, error) {
+	text := viper.GetString("bot.nickname.template")
+	if text == "" {
+		return nil, nil
+	}
+
+	return template.New("nickname").Parse(text)
+}
+
+// Nickname works out the nickname a member should have. It's empty if nicknames aren't enforced or the member has no
+// characters.
+func Nickname(ctx context.Context, userID string, deps Dependencies) (string, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("user_id", userID))
+
+	tmpl, err := nicknameTemplate()
+	if err != nil {
+		sp.Error("Error parsing nickname template", zap.Error(err))
+		return "", err
+	}
+
+	if tmpl == nil {
+		return "", nil
+	}
+
+	nickname, err := deps.Storage.GetNicknameOverride(ctx, userID)
+	if err == nil {
+		return nickname, nil
+	}
+
+	if !errors.Is(err, storage.ErrNoNicknameOverride) {
+		sp.Error("Error getting nickname override", zap.Error(err))
+		return "", err
+	}
+
+	character, err := deps.Storage.GetMainCharacter(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoCharacter) {
+			return "", nil
+		}
+
+		sp.Error("Error getting main character", zap.Error(err))
+		return "", err
+	}
+
+	data := NicknameData{Name: character.Name}
+
+	corporation, err := deps.Storage.GetCorporation(ctx, character.CorporationID)
+	if err != nil {
+		sp.Error("Error getting corporation", zap.Error(err))
+		return "", err
+	}
+
+	data.CorpTicker = corporation.Ticker
+
+	if corporation.AllianceID.Valid {
+		alliance, err := deps.Storage.GetAlliance(ctx, corporation.AllianceID.Int32)
+		if err != nil {
+			sp.Error("Error getting alliance", zap.Error(err))
+			return "", err
+		}
+
+		data.AllianceTicker = alliance.Ticker
+	}
+
+	var buffer bytes.Buffer
+
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		sp.Error("Error executing nickname template", zap.Error(err))
+		return "", err
+	}
+
+	nickname = strings.TrimSpace(buffer.String())
+	if len([]rune(nickname)) > NicknameMaxLength {
+		nickname = string([]rune(nickname)[:NicknameMaxLength])
+	}
+
+	return nickname, nil
+}
+
+// EnforceNickname sets the member's nickname in deps.GuildID if it doesn't match bot.nickname.template. Members with
+// a role in bot.nickname.exemptRoles are left alone.
+func EnforceNickname(ctx context.Context, member *discordgo.Member, deps Dependencies) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("guild_id", deps.GuildID),
+		zap.String("member_id", member.User.ID),
+		zap.String("current_nickname", member.Nick),
+	)
+
+	nickname, err := Nickname(ctx, member.User.ID, deps)
+	if err != nil {
+		sp.Error("Error getting nickname", zap.Error(err))
+		return err
+	}
+
+	if nickname == "" || nickname == member.Nick {
+		return nil
+	}
+
+	exempt, err := nicknameExempt(member, deps)
+	if err != nil {
+		sp.Error("Error checking exempt roles", zap.Error(err))
+		return err
+	}
+
+	if exempt {
+		sp.Debug("Member is exempt from the nickname policy")
+		return nil
+	}
+
+	err = deps.Session.GuildMemberNickname(deps.GuildID, member.User.ID, nickname)
+	if err != nil {
+		// Discord never lets bots rename the guild owner or anyone above the bot, no point retrying
+		if IsForbidden(err) {
+			sp.Warn("Not allowed to change member's nickname", zap.Error(err))
+			return nil
+		}
+
+		sp.Error("Error setting nickname", zap.Error(err))
+		return err
+	}
+
+	sp.Info("Updated member nickname", zap.String("nickname", nickname))
+
+	return nil
+}
+
+func nicknameExempt(member *discordgo.Member, deps Dependencies) (bool, error) {
+	exemptRoles := viper.GetStringSlice("bot.nickname.exemptRoles")
+	if len(exemptRoles) == 0 {
+		return false, nil
+	}
+
+	roles, err := deps.Session.GuildRoles(deps.GuildID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		for _, memberRole := range member.Roles {
+			if role.ID != memberRole {
+				continue
+			}
+
+			for _, exempt := range exemptRoles {
+				if role.Name == exempt {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
+)
+
+// Used when bot.syncMaxDeletes isn't set.
+const defaultSyncMaxDeletes = 5
+
+var (
+	// ErrNoRolePlanPreview is returned when a role sync is confirmed with an ID that wasn't the guild's last preview.
+	ErrNoRolePlanPreview = errors.New("no role sync preview with that ID")
+	// ErrRolePlanChanged is returned when a role sync is confirmed but discord or the database changed since the
+	// preview, so the sync would no longer do what the admin saw.
+	ErrRolePlanChanged = errors.New("role sync changed since the preview")
+)
+
+// RolePlan is what a role sync would change in discord.
+type RolePlan struct {
+	GuildID string
+	Delete  []payloads.Role
+	Add     []payloads.Role
+	Update  []payloads.Role
+}
+
+// Empty is true if discord already matches the database.
+func (p RolePlan) Empty() bool {
+	return len(p.Delete) == 0 && len(p.Add) == 0 && len(p.Update) == 0
+}
+
+// TooManyDeletes is true if the plan deletes more roles than bot.syncMaxDeletes allows without an admin confirming.
+func (p RolePlan) TooManyDeletes() bool {
+	max := SyncMaxDeletes()
+	return max >= 0 && len(p.Delete) > max
+}
+
+// ID identifies what the plan changes, two plans with the same ID make the same changes. It doesn't depend on the
+// order roles were planned in or on their positions, those are left to the reorder.
+func (p RolePlan) ID() string {
+	normalize := func(roles []payloads.Role) []payloads.Role {
+		normalized := make([]payloads.Role, len(roles))
+		copy(normalized, roles)
+
+		for i := range normalized {
+			normalized[i].Position = 0
+		}
+
+		sort.Slice(normalized, func(i, j int) bool {
+			if normalized[i].Name != normalized[j].Name {
+				return normalized[i].Name < normalized[j].Name
+			}
+
+			return normalized[i].ID < normalized[j].ID
+		})
+
+		return normalized
+	}
+
+	// Marshalling structs of plain fields can't fail
+	b, _ := json.Marshal([][]payloads.Role{normalize(p.Delete), normalize(p.Add), normalize(p.Update)})
+	sum := sha256.Sum256(append([]byte(p.GuildID+"\n"), b...))
+
+	return hex.EncodeToString(sum[:4])
+}
+
+// Lines returns the plan one change per line, for SendChunkedMessage.
+func (p RolePlan) Lines() []string {
+	var lines []string
+
+	for _, role := range p.Delete {
+		lines = append(lines, fmt.Sprintf("delete: %s", role.Name))
+	}
+
+	for _, role := range p.Add {
+		lines = append(lines, fmt.Sprintf("add: %s", role.Name))
+	}
+
+	for _, role := range p.Update {
+		lines = append(lines, fmt.Sprintf("update: %s", role.Name))
+	}
+
+	return lines
+}
+
+// SyncDryRun is true if bot.syncDryRun is set, role syncs only log what they would do.
+func SyncDryRun() bool {
+	return viper.GetBool("bot.syncDryRun")
+}
+
+// SyncMaxDeletes is how many roles a sync can delete before it needs confirming. Negative means no limit.
+func SyncMaxDeletes() int {
+	if !viper.IsSet("bot.syncMaxDeletes") {
+		return defaultSyncMaxDeletes
+	}
+
+	return viper.GetInt("bot.syncMaxDeletes")
+}
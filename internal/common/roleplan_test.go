@@ -0,0 +1,112 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
+)
+
+func TestRolePlanTooManyDeletes(t *testing.T) {
+	deletes := func(n int) common.RolePlan {
+		var plan common.RolePlan
+		for i := 0; i < n; i++ {
+			plan.Delete = append(plan.Delete, payloads.Role{Name: "Test Corp"})
+		}
+
+		return plan
+	}
+
+	cases := []struct {
+		name string
+		// max is bot.syncMaxDeletes, nil leaves it unset
+		max  interface{}
+		plan common.RolePlan
+		want bool
+	}{
+		{name: "default limit", plan: deletes(5)},
+		{name: "over the default limit", plan: deletes(6), want: true},
+		{name: "at the limit", max: 2, plan: deletes(2)},
+		{name: "over", max: 2, plan: deletes(3), want: true},
+		{name: "nothing allowed", max: 0, plan: deletes(1), want: true},
+		{name: "nothing to delete", max: 0, plan: deletes(0)},
+		{name: "no limit", max: -1, plan: deletes(100)},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			viper.Set("bot.syncMaxDeletes", c.max)
+			defer viper.Set("bot.syncMaxDeletes", nil)
+
+			if got := c.plan.TooManyDeletes(); got != c.want {
+				t.Errorf("got %t, want %t", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRolePlanID(t *testing.T) {
+	plan := common.RolePlan{
+		GuildID: "1000",
+		Delete:  []payloads.Role{{ID: "1", Name: "Old Corp", Position: 3}, {ID: "2", Name: "Gone Corp", Position: 4}},
+		Update:  []payloads.Role{{ID: "3", Name: "Test Corp", Color: 0xff0000}},
+	}
+
+	cases := []struct {
+		name string
+		edit func(plan *common.RolePlan)
+		same bool
+	}{
+		{
+			name: "same plan",
+			edit: func(plan *common.RolePlan) {},
+			same: true,
+		},
+		{
+			name: "planned in another order",
+			edit: func(plan *common.RolePlan) {
+				plan.Delete = []payloads.Role{plan.Delete[1], plan.Delete[0]}
+			},
+			same: true,
+		},
+		{
+			name: "moved in discord",
+			edit: func(plan *common.RolePlan) { plan.Delete[0].Position = 9 },
+			same: true,
+		},
+		{
+			name: "another guild",
+			edit: func(plan *common.RolePlan) { plan.GuildID = "2000" },
+		},
+		{
+			name: "another delete",
+			edit: func(plan *common.RolePlan) {
+				plan.Delete = append(plan.Delete, payloads.Role{ID: "4", Name: "New Corp"})
+			},
+		},
+		{
+			name: "updated differently",
+			edit: func(plan *common.RolePlan) { plan.Update[0].Color = 0x00ff00 },
+		},
+		{
+			name: "add instead of update",
+			edit: func(plan *common.RolePlan) { plan.Add, plan.Update = plan.Update, nil },
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			edited := plan
+			edited.Delete = append([]payloads.Role(nil), plan.Delete...)
+			edited.Update = append([]payloads.Role(nil), plan.Update...)
+			c.edit(&edited)
+
+			if same := edited.ID() == plan.ID(); same != c.same {
+				t.Errorf("same ID: got %t, want %t", same, c.same)
+			}
+		})
+	}
+}
@@ -75,3 +75,78 @@ func RoleToSet(roles []payloads.Role) *sets.StringSet {
 
 	return newSet
 }
+
+// DesiredRoles works out the complete set of discord role IDs a member should have. Roles chremoas manages (synced and
+// not ignored) come from the member's filter membership, everything else the member already has is left alone.
+func DesiredRoles(ctx context.Context, userID string, current []string, deps Dependencies) (*sets.StringSet, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("user_id", userID),
+		zap.Strings("current", current),
+	)
+
+	membership, err := GetMembership(ctx, userID, deps)
+	if err != nil {
+		sp.Error("Error getting membership", zap.Error(err))
+		return nil, err
+	}
+
+	managed, err := ManagedRoles(ctx, deps)
+	if err != nil {
+		sp.Error("Error getting managed roles", zap.Error(err))
+		return nil, err
+	}
+
+	desired := sets.NewStringSet()
+
+	for _, roleID := range current {
+		if !managed.Contains(roleID) {
+			desired.Add(roleID)
+		}
+	}
+
+	for _, roleID := range membership.ToSlice() {
+		if managed.Contains(roleID) {
+			desired.Add(roleID)
+		}
+	}
+
+	return desired, nil
+}
+
+// ManagedRoles returns the discord role IDs chremoas is responsible for, which is every synced role that isn't ignored
+// and that the bot is able to manage.
+func ManagedRoles(ctx context.Context, deps Dependencies) (*sets.StringSet, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	roles, err := deps.Storage.GetRolesBySync(ctx, true)
+	if err != nil {
+		sp.Error("Error getting synced roles", zap.Error(err))
+		return nil, err
+	}
+
+	managed := sets.NewStringSet()
+
+	for _, role := range roles {
+		if role.ID == "0" || !role.Manageable || IgnoreRole(deps.GuildID, role.Name) {
+			continue
+		}
+
+		managed.Add(role.ID)
+	}
+
+	return managed, nil
+}
+
+// RoleMatches is true if a discord role has everything a role sync sets the way chremoas wants it. Positions are left
+// to the reorder.
+func RoleMatches(want, have payloads.Role) bool {
+	return want.Name == have.Name &&
+		want.Mentionable == have.Mentionable &&
+		want.Hoist == have.Hoist &&
+		want.Color == have.Color &&
+		want.Permissions == have.Permissions
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Component is a part of chremoas that can be run in a process of its own.
+type Component string
+
+const (
+	// Bot is the Discord gateway: commands and gateway events. Every replica connects but only the elected leader
+	// handles them, so it runs with the poller.
+	Bot Component = "bot"
+	// Web is the auth web site
+	Web Component = "web"
+	// Poller is the ESI poller and role sync, it only runs on the elected leader. It runs with the bot.
+	Poller Component = "poller"
+	// MembersWorker consumes the members queue
+	MembersWorker Component = "members-worker"
+	// RolesWorker consumes the roles queue
+	RolesWorker Component = "roles-worker"
+)
+
+// Components are all the components, in the order they're started.
+var Components = []Component{MembersWorker, RolesWorker, Poller, Web, Bot}
+
+// Mode is the set of components a process runs.
+type Mode map[Component]bool
+
+// ParseMode reads a comma separated list of components, e.g. "bot,poller,web". Empty or "all" is every
+// component.
+func ParseMode(mode string) (Mode, error) {
+	m := make(Mode)
+
+	mode = strings.TrimSpace(mode)
+	if mode == "" || mode == "all" {
+		for _, c := range Components {
+			m[c] = true
+		}
+
+		return m, nil
+	}
+
+	for _, name := range strings.Split(mode, ",") {
+		c := Component(strings.TrimSpace(name))
+		if !c.valid() {
+			return nil, fmt.Errorf("unknown component %q, expected all or some of %s", c, componentNames(Components))
+		}
+
+		m[c] = true
+	}
+
+	// Commands and events are handled by the leader alone, a leader without the bot would leave them unanswered and
+	// a bot without the poller would never be the leader
+	if m[Bot] != m[Poller] {
+		return nil, fmt.Errorf("%s and %s run together, the elected leader handles both", Bot, Poller)
+	}
+
+	return m, nil
+}
+
+func (c Component) valid() bool {
+	for _, component := range Components {
+		if c == component {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Has is true if the mode runs any of the components.
+func (m Mode) Has(components ...Component) bool {
+	for _, c := range components {
+		if m[c] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m Mode) String() string {
+	var running []Component
+	for _, c := range Components {
+		if m[c] {
+			running = append(running, c)
+		}
+	}
+
+	return componentNames(running)
+}
+
+func componentNames(components []Component) string {
+	names := make([]string, len(components))
+	for i, c := range components {
+		names[i] = string(c)
+	}
+
+	return strings.Join(names, ",")
+}
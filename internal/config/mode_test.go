@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	cases := []struct {
+		mode string
+		want string
+		err  bool
+	}{
+		{mode: "", want: "members-worker,roles-worker,poller,web,bot"},
+		{mode: "all", want: "members-worker,roles-worker,poller,web,bot"},
+		{mode: "web", want: "web"},
+		{mode: " bot , poller ", want: "poller,bot"},
+		{mode: "members-worker,roles-worker", want: "members-worker,roles-worker"},
+		{mode: "bot", err: true},
+		{mode: "poller,web", err: true},
+		{mode: "bot,poller,bogus", err: true},
+	}
+
+	for _, c := range cases {
+		mode, err := ParseMode(c.mode)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParseMode(%q): got %s, want an error", c.mode, mode)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseMode(%q): %s", c.mode, err)
+			continue
+		}
+
+		if mode.String() != c.want {
+			t.Errorf("ParseMode(%q): got %s, want %s", c.mode, mode, c.want)
+		}
+	}
+}
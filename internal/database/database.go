@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io/fs"
 
 	sq "github.com/Masterminds/squirrel"
 	sl "github.com/bhechinger/spiffylogger"
@@ -12,14 +13,11 @@ import (
 	"go.uber.org/zap"
 )
 
-func New(ctx context.Context) (*sq.StatementBuilderType, error) {
+// Open connects to the database without touching the schema, for the migrate command.
+func Open(ctx context.Context) (*sqlx.DB, error) {
 	_, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
-	var err error
-
-	// ignoredRoles = viper.GetStringSlice("bot.ignoredRoles")
-
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
 		viper.GetString("database.host"),
 		viper.GetInt("database.port"),
@@ -40,6 +38,52 @@ func New(ctx context.Context) (*sq.StatementBuilderType, error) {
 		return nil, err
 	}
 
+	return ldb, nil
+}
+
+// New connects to the database and brings the schema up to date with the migrations in fsys, or only checks it is
+// if database.migrate is "check". It won't start against a schema newer than the binary. The *sql.DB is the same
+// pool the statement builder uses, for things that need a connection of their own.
+func New(ctx context.Context, fsys fs.FS) (*sq.StatementBuilderType, *sql.DB, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	// ignoredRoles = viper.GetStringSlice("bot.ignoredRoles")
+
+	ldb, err := Open(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	migrator, err := NewMigrator(ldb.DB, fsys)
+	if err != nil {
+		sp.Error("Error reading migrations", zap.Error(err))
+		return nil, nil, err
+	}
+
+	switch viper.GetString("database.migrate") {
+	case "", "auto":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			sp.Error("Error migrating the schema", zap.Error(err))
+			return nil, nil, err
+		}
+
+		sp.Info("Schema up to date", zap.Int("applied", applied))
+
+	case "check":
+		err = migrator.Check(ctx)
+		if err != nil {
+			sp.Error("Schema doesn't match this binary, run migrate up", zap.Error(err))
+			return nil, nil, err
+		}
+
+	default:
+		err = fmt.Errorf("unknown database.migrate %q, expected auto or check", viper.GetString("database.migrate"))
+		sp.Error("Error migrating the schema", zap.Error(err))
+		return nil, nil, err
+	}
+
 	dbCache := sq.NewStmtCache(ldb)
 	db := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(dbCache)
 
@@ -71,13 +115,26 @@ func New(ctx context.Context) (*sq.StatementBuilderType, error) {
 				Scan(&id)
 			if err != nil {
 				sp.Error("Error inserting permissions", zap.Error(err))
-				return nil, err
+				return nil, nil, err
 			}
 		default:
 			sp.Error("Error checking permissions", zap.Error(err))
-			return nil, err
+			return nil, nil, err
+		}
+	}
+
+	// Roles created before guilds were tracked belong to the main guild
+	guildID := viper.GetString("bot.discordServerId")
+	if guildID != "" {
+		_, err = db.Update("roles").
+			Set("guild_id", guildID).
+			Where(sq.Eq{"guild_id": 0}).
+			Exec()
+		if err != nil {
+			sp.Error("Error setting guild on existing roles", zap.Error(err))
+			return nil, nil, err
 		}
 	}
 
-	return &db, nil
+	return &db, ldb.DB, nil
 }
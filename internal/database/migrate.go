@@ -0,0 +1,338 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"go.uber.org/zap"
+)
+
+// Only one replica migrates at a time, the others wait for it and then find nothing to do
+const migrationLockKey = 0x6d696772
+
+var (
+	ErrSchemaNewer = errors.New("database schema is newer than this binary")
+	ErrSchemaOlder = errors.New("database schema is older than this binary")
+	ErrSchemaDirty = errors.New("database schema is dirty")
+)
+
+// Migration files are named like 001_initialize_schema.up.sql and 001_initialize_schema.down.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is one numbered change to the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is where the database schema is against the migrations the binary has.
+type MigrationStatus struct {
+	// Version is the last migration applied, 0 if none are
+	Version int
+	// Dirty is set if a migration failed part way, the schema needs fixing by hand
+	Dirty bool
+	// Latest is the binary's last migration
+	Latest  int
+	Pending []Migration
+}
+
+// Migrator applies the migrations in sql/ and records where the schema is in schema_migrations, the same table the
+// migrate CLI uses, so databases it set up carry on from where they are.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator reads the migrations from fsys, which holds the files in sql/.
+func NewMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		match := migrationFile.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version %s: %w", file.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Status reports where the schema is.
+func (m *Migrator) Status(ctx context.Context) (MigrationStatus, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	status := MigrationStatus{}
+	if len(m.migrations) > 0 {
+		status.Latest = m.migrations[len(m.migrations)-1].Version
+	}
+
+	err := m.ensureTable(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	status.Version, status.Dirty, err = m.version(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > status.Version {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+
+	return status, nil
+}
+
+// Check returns an error unless the schema is exactly what the binary expects.
+func (m *Migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	return status.check()
+}
+
+func (status MigrationStatus) check() error {
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("%w at version %d, fix it by hand and clear schema_migrations.dirty", ErrSchemaDirty,
+			status.Version)
+	case status.Version > status.Latest:
+		return fmt.Errorf("%w, the database is at version %d and this binary only knows up to %d", ErrSchemaNewer,
+			status.Version, status.Latest)
+	case len(status.Pending) > 0:
+		return fmt.Errorf("%w, the database is at version %d and this binary needs %d", ErrSchemaOlder,
+			status.Version, status.Latest)
+	}
+
+	return nil
+}
+
+// Up applies the pending migrations and returns how many it applied. It refuses to touch a schema that's newer than
+// the binary or dirty.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var applied int
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = status.check()
+		if err != nil && !errors.Is(err, ErrSchemaOlder) {
+			return err
+		}
+
+		for _, migration := range status.Pending {
+			sp.Info("Applying migration", zap.Int("version", migration.Version), zap.String("name", migration.Name))
+
+			err = m.apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			applied += 1
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last steps migrations, all of them if steps is 0, and returns how many it rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var rolledBack int
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = status.check()
+		if err != nil && !errors.Is(err, ErrSchemaOlder) {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version > status.Version {
+				continue
+			}
+
+			if steps > 0 && rolledBack == steps {
+				break
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down", migration.Version, migration.Name)
+			}
+
+			previous := 0
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+
+			sp.Info("Rolling back migration", zap.Int("version", migration.Version),
+				zap.String("name", migration.Name))
+
+			err = m.apply(ctx, conn, migration.Down, previous)
+			if err != nil {
+				return fmt.Errorf("error rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			rolledBack += 1
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// locked runs fn holding the migration lock on a connection of its own, waiting for another replica to finish first.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			sp.Warn("Error closing migration connection", zap.Error(err))
+		}
+	}()
+
+	sp.Debug("Taking the migration lock")
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+	if err != nil {
+		return fmt.Errorf("error taking the migration lock: %w", err)
+	}
+	defer func() {
+		// The session lock has to be released on the same connection
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		if err != nil {
+			sp.Warn("Error releasing the migration lock", zap.Error(err))
+		}
+	}()
+
+	return fn(conn)
+}
+
+// apply runs a migration and records the new version in one transaction, so a failed migration leaves nothing
+// behind.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, body string, version int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, body)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "TRUNCATE schema_migrations")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// No row is version 0, the same as the migrate CLI
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", version)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)")
+	return err
+}
+
+func (m *Migrator) version(ctx context.Context) (int, bool, error) {
+	var (
+		version int
+		dirty   bool
+	)
+
+	err := m.db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestNewMigrator(t *testing.T) {
+	cases := []struct {
+		name  string
+		files fstest.MapFS
+		// want is the migrations in version order, unused if wantErr is set
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"010_second.up.sql":  file("up 10"),
+				"002_first.up.sql":   file("up 2"),
+				"002_first.down.sql": file("down 2"),
+			},
+			want: []Migration{
+				{Version: 2, Name: "first", Up: "up 2", Down: "down 2"},
+				{Version: 10, Name: "second", Up: "up 10"},
+			},
+		},
+		{
+			name: "other files ignored",
+			files: fstest.MapFS{
+				"001_first.up.sql": file("up 1"),
+				"README.md":        file("readme"),
+				"001_first.sql":    file("not a migration"),
+				"first.up.sql":     file("no version"),
+			},
+			want: []Migration{
+				{Version: 1, Name: "first", Up: "up 1"},
+			},
+		},
+		{
+			name:  "empty",
+			files: fstest.MapFS{},
+			want:  []Migration{},
+		},
+		{
+			name: "no up",
+			files: fstest.MapFS{
+				"001_first.down.sql": file("down 1"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "two names",
+			files: fstest.MapFS{
+				"001_first.up.sql":   file("up 1"),
+				"001_other.down.sql": file("down 1"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			m, err := NewMigrator(nil, c.files)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", m.migrations)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("NewMigrator: %s", err)
+			}
+
+			if len(m.migrations) != len(c.want) {
+				t.Fatalf("got %v, want %v", m.migrations, c.want)
+			}
+
+			for i := range c.want {
+				if m.migrations[i] != c.want[i] {
+					t.Errorf("migration %d: got %+v, want %+v", i, m.migrations[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+// The migrations shipped in sql/ must all parse
+func TestNewMigratorSQL(t *testing.T) {
+	m, err := NewMigrator(nil, os.DirFS("../../sql"))
+	if err != nil {
+		t.Fatalf("NewMigrator: %s", err)
+	}
+
+	if len(m.migrations) == 0 {
+		t.Fatal("no migrations found")
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Down == "" {
+			t.Errorf("migration %d_%s has no down", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestMigrationStatusCheck(t *testing.T) {
+	pending := []Migration{{Version: 3, Name: "third"}}
+
+	cases := []struct {
+		name   string
+		status MigrationStatus
+		want   error
+	}{
+		{
+			name:   "up to date",
+			status: MigrationStatus{Version: 3, Latest: 3},
+		},
+		{
+			name:   "no migrations",
+			status: MigrationStatus{},
+		},
+		{
+			name:   "behind",
+			status: MigrationStatus{Version: 2, Latest: 3, Pending: pending},
+			want:   ErrSchemaOlder,
+		},
+		{
+			name:   "newer",
+			status: MigrationStatus{Version: 4, Latest: 3},
+			want:   ErrSchemaNewer,
+		},
+		{
+			name:   "dirty",
+			status: MigrationStatus{Version: 3, Latest: 3, Dirty: true},
+			want:   ErrSchemaDirty,
+		},
+		{
+			// Someone has to fix it by hand before anything else happens
+			name:   "dirty and behind",
+			status: MigrationStatus{Version: 2, Latest: 3, Dirty: true, Pending: pending},
+			want:   ErrSchemaDirty,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			err := c.status.check()
+			if c.want == nil {
+				if err != nil {
+					t.Errorf("got %s, want no error", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, c.want) {
+				t.Errorf("got %v, want %s", err, c.want)
+			}
+		})
+	}
+}
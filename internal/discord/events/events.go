@@ -0,0 +1,247 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	esiPoller "github.com/chremoas/chremoas-ng/internal/esi-poller"
+	"github.com/chremoas/chremoas-ng/internal/filters"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"go.uber.org/zap"
+)
+
+// Role changes tend to come in bursts so wait for things to settle before syncing.
+const roleSyncDelay = time.Second * 5
+
+// Events handles discord gateway events other than messages so we don't have to wait for the next poll to react.
+// Every replica gets the events but only the leader's handlers run, see handlerGate in main.
+type Events struct {
+	dependencies common.Dependencies
+	ctx          context.Context
+	poller       esiPoller.AuthEsiPoller
+
+	mutex         sync.Mutex
+	roleSyncTimer *time.Timer
+}
+
+func New(ctx context.Context, poller esiPoller.AuthEsiPoller, deps common.Dependencies) *Events {
+	return &Events{
+		dependencies: deps,
+		ctx:          ctx,
+		poller:       poller,
+	}
+}
+
+// GuildMemberAdd gives returning members their roles straight away and tells everyone else how to authenticate.
+func (e *Events) GuildMemberAdd(_ *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	ctx, sp := sl.OpenCorrelatedSpan(e.ctx, sl.NewID())
+	defer sp.Close()
+
+	if _, ok := common.GetGuild(m.GuildID); !ok || m.User == nil || m.User.Bot {
+		return
+	}
+
+	sp.With(
+		zap.String("event", "GuildMemberAdd"),
+		zap.String("member_id", m.User.ID),
+	)
+
+	joinedAt, err := m.JoinedAt.Parse()
+	if err != nil {
+		joinedAt = time.Now()
+	}
+
+	// Start the clock for the member policy
+	err = e.dependencies.ForGuild(m.GuildID).Storage.InsertMemberState(ctx, m.User.ID, joinedAt)
+	if err != nil {
+		sp.Error("Error inserting member state", zap.Error(err))
+	}
+
+	characters, err := e.dependencies.Storage.GetDiscordCharacters(ctx, m.User.ID)
+	if err != nil {
+		sp.Error("Error getting discord characters", zap.Error(err))
+		return
+	}
+
+	if len(characters) > 0 {
+		sp.Info("Member is already authed, syncing roles")
+		filters.QueueSync(ctx, m.User.ID, e.dependencies)
+		return
+	}
+
+	err = common.DirectMessage(m.User.ID, fmt.Sprintf(
+		"Welcome! To get your roles sign in with your EVE Online character at %s and then paste the `!auth` command it gives you into any channel on the server.",
+		common.AuthURL(),
+	), e.dependencies)
+	if err != nil {
+		sp.Error("Error sending auth link", zap.Error(err))
+		return
+	}
+
+	sp.Info("Sent auth link to new member")
+}
+
+// GuildMemberRemove stops tracking members that leave and cleans up members that leave the main guild, the same as
+// when we find out they're gone from a 404. Leaving any other guild doesn't affect their auth.
+func (e *Events) GuildMemberRemove(_ *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	ctx, sp := sl.OpenCorrelatedSpan(e.ctx, sl.NewID())
+	defer sp.Close()
+
+	if _, ok := common.GetGuild(m.GuildID); !ok || m.User == nil || m.User.Bot {
+		return
+	}
+
+	sp.With(
+		zap.String("event", "GuildMemberRemove"),
+		zap.String("guild_id", m.GuildID),
+		zap.String("member_id", m.User.ID),
+	)
+
+	err := e.dependencies.ForGuild(m.GuildID).Storage.DeleteMemberState(ctx, m.User.ID)
+	if err != nil {
+		sp.Error("Error deleting member state", zap.Error(err))
+	}
+
+	if m.GuildID != e.dependencies.GuildID {
+		return
+	}
+
+	err = e.dependencies.Storage.DeleteDiscordUser(ctx, m.User.ID)
+	if err != nil {
+		sp.Error("Error deleting discord user", zap.Error(err))
+		return
+	}
+
+	sp.Info("Cleaned up member that left")
+}
+
+// GuildRoleUpdate puts a synced role back the way we want it if someone edited it by hand. Our own role changes and
+// reorders come back as updates too, a role that already looks the way we want it is left alone so they don't set off
+// another sync. Roles moved by hand are put back by the next poll.
+func (e *Events) GuildRoleUpdate(_ *discordgo.Session, r *discordgo.GuildRoleUpdate) {
+	if _, ok := common.GetGuild(r.GuildID); !ok || r.Role == nil {
+		return
+	}
+
+	e.roleChanged("GuildRoleUpdate", r.GuildID, r.Role.ID, r.Role)
+}
+
+// GuildRoleDelete recreates a synced role if someone deleted it by hand.
+func (e *Events) GuildRoleDelete(_ *discordgo.Session, r *discordgo.GuildRoleDelete) {
+	if _, ok := common.GetGuild(r.GuildID); !ok {
+		return
+	}
+
+	e.roleChanged("GuildRoleDelete", r.GuildID, r.RoleID, nil)
+}
+
+// roleChanged schedules a role sync if a synced role changed. updated is the role after the change, nil if it was
+// deleted.
+func (e *Events) roleChanged(event, guildID, roleID string, updated *discordgo.Role) {
+	ctx, sp := sl.OpenCorrelatedSpan(e.ctx, sl.NewID())
+	defer sp.Close()
+
+	sp.With(
+		zap.String("event", event),
+		zap.String("guild_id", guildID),
+		zap.String("role_id", roleID),
+	)
+
+	role, err := e.dependencies.ForGuild(guildID).Storage.GetRoleByChatID(ctx, roleID)
+	if err != nil {
+		// Most likely a role we don't know about
+		sp.Debug("Error getting role", zap.Error(err))
+		return
+	}
+
+	if !role.Sync {
+		return
+	}
+
+	if updated != nil {
+		matches, err := e.roleMatches(ctx, guildID, updated)
+		if err != nil {
+			sp.Error("Error comparing role", zap.Error(err))
+		} else if matches {
+			sp.Debug("Role already the way we want it, not syncing")
+			return
+		}
+	}
+
+	sp.Info("Synced role changed in discord, scheduling role sync")
+	e.scheduleRoleSync()
+}
+
+// roleMatches is true if the discord role has everything a role sync would set.
+func (e *Events) roleMatches(ctx context.Context, guildID string, updated *discordgo.Role) (bool, error) {
+	roles, err := e.dependencies.ForGuild(guildID).Storage.GetRolesBySync(ctx, true)
+	if err != nil {
+		return false, err
+	}
+
+	have := payloads.Role{
+		Name:        updated.Name,
+		Mentionable: updated.Mentionable,
+		Hoist:       updated.Hoist,
+		Color:       updated.Color,
+		Permissions: updated.Permissions,
+	}
+
+	for _, want := range roles {
+		if want.ID == updated.ID {
+			return common.RoleMatches(want, have), nil
+		}
+	}
+
+	return false, nil
+}
+
+// scheduleRoleSync runs a single role sync once role events stop arriving for a little while.
+func (e *Events) scheduleRoleSync() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.roleSyncTimer != nil {
+		e.roleSyncTimer.Reset(roleSyncDelay)
+		return
+	}
+
+	e.roleSyncTimer = time.AfterFunc(roleSyncDelay, func() {
+		e.mutex.Lock()
+		e.roleSyncTimer = nil
+		e.mutex.Unlock()
+
+		ctx, sp := sl.OpenSpan(common.WithPoller(e.ctx))
+		defer sp.Close()
+
+		// Leadership may have moved on while we waited
+		if !e.dependencies.Leader.IsLeader() {
+			sp.Debug("No longer the leader, not syncing roles")
+			return
+		}
+
+		count, errorCount, err := e.poller.SyncRoles(ctx)
+		if err != nil {
+			sp.Error("error synchronizing discord roles", zap.Error(err))
+			return
+		}
+
+		sp.Info("SyncRoles() completed", zap.Int("count", count), zap.Int("errorCount", errorCount))
+	})
+}
+
+// Stop drops a role sync that's still waiting to run, for shutdown.
+func (e *Events) Stop() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.roleSyncTimer != nil {
+		e.roleSyncTimer.Stop()
+		e.roleSyncTimer = nil
+	}
+}
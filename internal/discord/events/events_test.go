@@ -0,0 +1,99 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/roles"
+)
+
+func TestRoleEvents(t *testing.T) {
+	// What the synced role looks like in the database
+	synced := discordgo.Role{ID: "42", Name: "Test Corp", Color: 0xff0000, Hoist: true, Mentionable: true}
+
+	edited := func(edit func(role *discordgo.Role)) *discordgo.Role {
+		role := synced
+		edit(&role)
+		return &role
+	}
+
+	cases := []struct {
+		name   string
+		update *discordgo.Role
+		delete string
+		// scheduled is whether a role sync is scheduled
+		scheduled bool
+	}{
+		{
+			name:   "our own update",
+			update: edited(func(role *discordgo.Role) {}),
+		},
+		{
+			name:   "moved",
+			update: edited(func(role *discordgo.Role) { role.Position = 7 }),
+		},
+		{
+			name:      "recoloured by hand",
+			update:    edited(func(role *discordgo.Role) { role.Color = 0x00ff00 }),
+			scheduled: true,
+		},
+		{
+			name:      "renamed by hand",
+			update:    edited(func(role *discordgo.Role) { role.Name = "Someone Else" }),
+			scheduled: true,
+		},
+		{
+			name:   "unknown role",
+			update: &discordgo.Role{ID: "43", Name: "Not ours"},
+		},
+		{
+			name:      "deleted by hand",
+			delete:    "42",
+			scheduled: true,
+		},
+		{
+			name:   "unknown role deleted",
+			delete: "43",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := commontest.Context()
+			deps := commontest.Dependencies(t)
+
+			commontest.AddRole(t, deps, "TEST", synced.Name, synced.ID)
+
+			err := deps.Storage.UpdateRoleValues(ctx, roles.Role, synced.Name, map[string]string{
+				"sync":        "true",
+				"color":       "#ff0000",
+				"hoist":       "true",
+				"mentionable": "true",
+			})
+			if err != nil {
+				t.Fatalf("UpdateRoleValues: %s", err)
+			}
+
+			e := New(ctx, nil, deps)
+			defer e.Stop()
+
+			if c.update != nil {
+				e.GuildRoleUpdate(nil, &discordgo.GuildRoleUpdate{
+					GuildRole: &discordgo.GuildRole{GuildID: commontest.GuildID, Role: c.update},
+				})
+			} else {
+				e.GuildRoleDelete(nil, &discordgo.GuildRoleDelete{GuildID: commontest.GuildID, RoleID: c.delete})
+			}
+
+			e.mutex.Lock()
+			scheduled := e.roleSyncTimer != nil
+			e.mutex.Unlock()
+
+			if scheduled != c.scheduled {
+				t.Errorf("role sync scheduled: got %t, want %t", scheduled, c.scheduled)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package members
+
+import (
+	"sync"
+	"time"
+)
+
+// How long we remember a member's last reconcile. Anything queued before that is covered by the reconcile.
+const coalesceWindow = time.Minute * 30
+
+// coalescer tracks the sync count each member's last reconcile started at. Publishers bump the member's count in the
+// database in the same transaction as the change and put the new count in the message. A reconcile reads the count
+// before it reads the member's complete desired state, so any message with a count no higher than that has already
+// been applied and can be dropped. A burst of updates for the same member therefore costs a single discord call.
+// Counts come from the database, not the clocks of the processes publishing and consuming.
+type coalescer struct {
+	mutex  sync.Mutex
+	synced map[string]coalesced
+}
+
+type coalesced struct {
+	seq int64
+	// at is only used to forget old reconciles
+	at time.Time
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{synced: make(map[string]coalesced)}
+}
+
+// covered returns true if a reconcile that started at or after the message's count already applied it. Messages
+// without a count are never covered.
+func (c *coalescer) covered(key string, seq int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	last, ok := c.synced[key]
+
+	return ok && seq > 0 && seq <= last.seq
+}
+
+// start records that a reconcile for the member is starting at the given sync count.
+func (c *coalescer) start(key string, seq int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	if last, ok := c.synced[key]; !ok || seq > last.seq {
+		c.synced[key] = coalesced{seq: seq, at: now}
+	}
+
+	// Don't let the map grow forever
+	for k, v := range c.synced {
+		if now.Sub(v.at) > coalesceWindow {
+			delete(c.synced, k)
+		}
+	}
+}
+
+// fail forgets the reconcile for the member so the retried message isn't dropped.
+func (c *coalescer) fail(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.synced, key)
+}
@@ -0,0 +1,113 @@
+package members
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	type step struct {
+		// op is start, fail or covered
+		op  string
+		key string
+		seq int64
+		// want is what covered should return
+		want bool
+	}
+
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "nothing synced",
+			steps: []step{
+				{op: "covered", key: "1", seq: 1, want: false},
+			},
+		},
+		{
+			name: "older and equal counts are covered",
+			steps: []step{
+				{op: "start", key: "1", seq: 5},
+				{op: "covered", key: "1", seq: 4, want: true},
+				{op: "covered", key: "1", seq: 5, want: true},
+				{op: "covered", key: "1", seq: 6, want: false},
+			},
+		},
+		{
+			name: "no count is never covered",
+			steps: []step{
+				{op: "start", key: "1", seq: 5},
+				{op: "covered", key: "1", seq: 0, want: false},
+			},
+		},
+		{
+			name: "other members aren't covered",
+			steps: []step{
+				{op: "start", key: "1", seq: 5},
+				{op: "covered", key: "2", seq: 1, want: false},
+			},
+		},
+		{
+			name: "a stale start doesn't go backwards",
+			steps: []step{
+				{op: "start", key: "1", seq: 5},
+				{op: "start", key: "1", seq: 3},
+				{op: "covered", key: "1", seq: 5, want: true},
+			},
+		},
+		{
+			name: "a newer start moves forward",
+			steps: []step{
+				{op: "start", key: "1", seq: 5},
+				{op: "start", key: "1", seq: 8},
+				{op: "covered", key: "1", seq: 7, want: true},
+			},
+		},
+		{
+			name: "a failed reconcile covers nothing",
+			steps: []step{
+				{op: "start", key: "1", seq: 5},
+				{op: "fail", key: "1"},
+				{op: "covered", key: "1", seq: 4, want: false},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			coalescer := newCoalescer()
+
+			for i, s := range c.steps {
+				switch s.op {
+				case "start":
+					coalescer.start(s.key, s.seq)
+				case "fail":
+					coalescer.fail(s.key)
+				case "covered":
+					if got := coalescer.covered(s.key, s.seq); got != s.want {
+						t.Errorf("step %d: covered(%q, %d): got %t, want %t", i, s.key, s.seq, got, s.want)
+					}
+				default:
+					t.Fatalf("step %d: unknown op %q", i, s.op)
+				}
+			}
+		})
+	}
+}
+
+func TestCoalescerForgets(t *testing.T) {
+	c := newCoalescer()
+	c.synced["old"] = coalesced{seq: 5, at: time.Now().Add(-coalesceWindow - time.Minute)}
+
+	c.start("new", 1)
+
+	if _, ok := c.synced["old"]; ok {
+		t.Error("reconcile older than the window not forgotten")
+	}
+
+	if !c.covered("new", 1) {
+		t.Error("new reconcile forgotten")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 
+	"github.com/bhechinger/go-sets"
 	sl "github.com/bhechinger/spiffylogger"
 	"github.com/chremoas/chremoas-ng/internal/common"
 	"github.com/chremoas/chremoas-ng/internal/payloads"
@@ -15,6 +16,7 @@ type Member struct {
 	dependencies common.Dependencies
 	ctx          context.Context
 	cad          common.CheckAndDelete
+	coalescer    *coalescer
 }
 
 func New(ctx context.Context, deps common.Dependencies) *Member {
@@ -22,6 +24,7 @@ func New(ctx context.Context, deps common.Dependencies) *Member {
 		dependencies: deps,
 		ctx:          ctx,
 		cad:          common.NewCheckAndDelete(deps),
+		coalescer:    newCoalescer(),
 	}
 }
 
@@ -75,112 +78,83 @@ func (m Member) HandleMessage(deliveries <-chan amqp.Delivery, done chan error,
 
 			sp.Debug("Handling message")
 
-			m.dependencies.Session.Lock()
-			defer func() {
-				m.dependencies.Session.Unlock()
-			}()
+			// Whatever the action, the member ends up with the roles the database says they should have. Older messages
+			// that only carry a single role are handled the same way.
+			key := body.GuildID + ":" + body.MemberID
+			if m.coalescer.covered(key, body.Seq) {
+				sp.Debug("Already reconciled since this was queued, skipping")
 
-			if body.RoleID == "0" {
-				err = d.Reject(false)
+				err = d.Ack(false)
 				if err != nil {
-					sp.Error("Error rejecting invalid (RoleID==0) Role Add message: %s", zap.Error(err))
+					sp.Error("Error ACKing message", zap.Error(err))
 				}
 
 				return
 			}
 
-			// We have the role's ID but the ignore list is the role names so let's look it up
-			roles, err := m.dependencies.Session.GuildRoles(m.dependencies.GuildID)
+			// Read before the desired roles are, everything queued up to this count is in them
+			seq, err := m.dependencies.ForGuild(body.GuildID).Storage.GetMemberSync(ctx, body.MemberID)
 			if err != nil {
-				sp.Error("Error fetching discord roles", zap.Error(err))
-				return
-			}
-
-			var roleName string
-			for _, role := range roles {
-				if body.RoleID == role.ID {
-					roleName = role.Name
-					break
-				}
-			}
+				sp.Error("Error getting member sync count", zap.Error(err))
 
-			sp.With(zap.String("role_name", roleName))
-
-			if common.IgnoreRole(roleName) {
-				err = d.Reject(false)
+				err = d.Reject(true)
 				if err != nil {
-					sp.Error("Error rejecting invalid (ignored role) Role Add message", zap.Error(err))
+					sp.Error("Error rejecting member sync message", zap.Error(err))
 				}
 
 				return
 			}
+			m.coalescer.start(key, seq)
 
-			switch body.Action {
-			case payloads.Add, payloads.Upsert:
-				role, err := m.dependencies.Storage.GetRoleByChatID(ctx, body.RoleID)
-				if err != nil {
-					sp.Error("Error getting role", zap.Error(err))
-					err = d.Reject(false)
-					if err != nil {
-						sp.Error("Error rejecting invalid (ignored role) Role Add message", zap.Error(err))
-					}
-					return
-				}
+			err = m.reconcile(ctx, body.GuildID, body.MemberID)
+			if err != nil {
+				m.coalescer.fail(key)
 
-				sp.With(zap.Bool("sync", role.Sync))
+				// Not everyone is in every guild, only leaving the main guild means they're gone
+				if body.GuildID != m.dependencies.GuildID && common.IsNotFound(err) {
+					sp.Debug("Member isn't in this guild")
 
-				if !role.Sync {
-					err = d.Reject(false)
+					err = d.Ack(false)
 					if err != nil {
-						sp.Error("Rejecting role not set to sync", zap.Error(err))
+						sp.Error("Error ACKing message", zap.Error(err))
 					}
 
 					return
 				}
 
-				err = m.dependencies.Session.GuildMemberRoleAdd(body.GuildID, body.MemberID, body.RoleID)
-				if err != nil {
-					handled, hErr := m.cad.CheckAndDelete(ctx, body.MemberID, err)
-					if hErr != nil {
-						sp.Error("Additional errors from checkAndDelete", zap.Error(hErr))
-					}
-					if handled {
-						return
-					}
+				// Retrying won't help until an admin fixes the bot's permissions, !role doctor will show what's wrong
+				if common.IsForbidden(err) {
+					sp.Warn("Not allowed to update member roles, dropping message", zap.Error(err))
 
-					sp.Error("Error adding role to user", zap.Error(err), zap.NamedError("hErr", hErr))
-
-					err = d.Reject(true)
+					err = d.Ack(false)
 					if err != nil {
-						sp.Error("Error rejecting Role Add message: %s", zap.Error(err))
+						sp.Error("Error ACKing message", zap.Error(err))
 					}
 
 					return
 				}
 
-			case payloads.Delete:
-				err = m.dependencies.Session.GuildMemberRoleRemove(body.GuildID, body.MemberID, body.RoleID)
-				if err != nil {
-					handled, hErr := m.cad.CheckAndDelete(ctx, body.MemberID, err)
-					if hErr != nil {
-						sp.Error("Additional errors from checkAndDelete", zap.Error(hErr))
-					}
-					if handled {
-						return
-					}
-
-					sp.Error("Error removing role from user", zap.Error(err), zap.NamedError("hErr", hErr))
-
-					err = d.Reject(true)
+				handled, hErr := m.cad.CheckAndDelete(ctx, body.MemberID, err)
+				if hErr != nil {
+					sp.Error("Additional errors from checkAndDelete", zap.Error(hErr))
+				}
+				if handled {
+					err = d.Ack(false)
 					if err != nil {
-						sp.Error("Error rejecting Role Remove message", zap.Error(err))
+						sp.Error("Error ACKing message", zap.Error(err))
 					}
 
 					return
 				}
 
-			default:
-				sp.Error("Unknown action")
+				sp.Error("Error reconciling member roles", zap.Error(err), zap.NamedError("hErr", hErr))
+
+				err = d.Reject(true)
+				if err != nil {
+					sp.Error("Error rejecting member sync message", zap.Error(err))
+				}
+
+				return
 			}
 
 			err = d.Ack(false)
@@ -192,3 +166,61 @@ func (m Member) HandleMessage(deliveries <-chan amqp.Delivery, done chan error,
 
 	done <- nil
 }
+
+// reconcile brings the member's discord roles in line with their desired roles using a single discord call.
+func (m Member) reconcile(ctx context.Context, guildID, memberID string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("guild_id", guildID),
+		zap.String("member_id", memberID),
+	)
+
+	member, err := m.dependencies.Session.GuildMember(guildID, memberID)
+	if err != nil {
+		sp.Error("Error getting guild member", zap.Error(err))
+		return err
+	}
+
+	deps := m.dependencies.ForGuild(guildID)
+
+	// The poller, auth and corp changes all end up here so this is where the nickname policy is enforced. A nickname
+	// that can't be set mustn't hold up the roles, the next sync tries again.
+	err = common.EnforceNickname(ctx, member, deps)
+	if err != nil {
+		sp.Error("Error enforcing nickname, carrying on with the roles", zap.Error(err))
+	}
+
+	desired, err := common.DesiredRoles(ctx, memberID, member.Roles, deps)
+	if err != nil {
+		sp.Error("Error getting desired roles", zap.Error(err))
+		return err
+	}
+
+	current := sets.NewStringSet()
+	current.FromSlice(member.Roles)
+
+	added := desired.Difference(current)
+	removed := current.Difference(desired)
+
+	sp.With(
+		zap.Strings("added", added.ToSlice()),
+		zap.Strings("removed", removed.ToSlice()),
+	)
+
+	if added.Len() == 0 && removed.Len() == 0 {
+		sp.Debug("Member roles already up to date")
+		return nil
+	}
+
+	err = m.dependencies.Session.GuildMemberEdit(guildID, memberID, desired.ToSlice())
+	if err != nil {
+		sp.Error("Error updating member roles", zap.Error(err))
+		return err
+	}
+
+	sp.Info("Updated member roles")
+
+	return nil
+}
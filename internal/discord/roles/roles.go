@@ -71,7 +71,7 @@ func (r Role) HandleMessage(deliveries <-chan amqp.Delivery, done chan error, th
 			sp.With(zap.Any("payload", body))
 			sp.Debug("Handling message")
 
-			if common.IgnoreRole(body.Role.Name) {
+			if common.IgnoreRole(body.GuildID, body.Role.Name) {
 				sp.Info("Ignoring request for role")
 
 				err = d.Reject(false)
@@ -89,6 +89,8 @@ func (r Role) HandleMessage(deliveries <-chan amqp.Delivery, done chan error, th
 				err = r.upsert(ctx, body)
 			case payloads.Delete:
 				err = r.delete(ctx, body)
+			case payloads.Reorder:
+				err = r.reorder(ctx, body)
 			default:
 				sp.Error("Unknown action")
 			}
@@ -134,7 +136,7 @@ func (r Role) upsert(ctx context.Context, role payloads.RolePayload) error {
 		r.dependencies.Session.Unlock()
 	}()
 
-	roleData, err := r.dependencies.Storage.GetRole(ctx, role.Role.Name, role.Role.ShortName, nil)
+	roleData, err := r.dependencies.ForGuild(role.GuildID).Storage.GetRole(ctx, role.Role.Name, role.Role.ShortName, nil)
 	if err != nil {
 		sp.Error("Error getting role", zap.Error(err))
 		return err
@@ -147,6 +149,12 @@ func (r Role) upsert(ctx context.Context, role payloads.RolePayload) error {
 		return nil
 	}
 
+	// The bot can't touch this role, !role doctor has the details
+	if !roleData.Manageable {
+		sp.Warn("Role isn't manageable by the bot, skipping")
+		return nil
+	}
+
 	// Check and see if this role has been created in discord or not
 	if r.exists(ctx, role.Role.Name, role.GuildID) {
 		// Update an existing role
@@ -160,6 +168,10 @@ func (r Role) upsert(ctx context.Context, role payloads.RolePayload) error {
 		// Create a new role
 		newRole, err := r.dependencies.Session.GuildRoleCreate(role.GuildID)
 		if err != nil {
+			if common.IsForbidden(err) {
+				sp.Warn("Not allowed to create role, dropping message", zap.Error(err))
+				return nil
+			}
 			sp.Error("Error creating role", zap.Error(err))
 			return err
 		}
@@ -168,7 +180,7 @@ func (r Role) upsert(ctx context.Context, role payloads.RolePayload) error {
 
 		sp.Info("Create role")
 
-		err = r.dependencies.Storage.UpdateRole(ctx, newRole.ID, role.Role.Name, "")
+		err = r.dependencies.ForGuild(role.GuildID).Storage.UpdateRole(ctx, newRole.ID, role.Role.Name, "")
 		if err != nil {
 			sp.Error("Error updating role", zap.Error(err))
 		}
@@ -179,6 +191,10 @@ func (r Role) upsert(ctx context.Context, role payloads.RolePayload) error {
 	st, err := r.dependencies.Session.GuildRoleEdit(role.GuildID, role.Role.ID, role.Role.Name, role.Role.Color, role.Role.Hoist,
 		role.Role.Permissions, role.Role.Mentionable)
 	if err != nil {
+		if common.IsForbidden(err) {
+			sp.Warn("Not allowed to edit role, dropping message", zap.Error(err))
+			return nil
+		}
 		sp.Error("Error editing role", zap.Error(err))
 		return err
 	}
@@ -208,10 +224,14 @@ func (r Role) delete(ctx context.Context, role payloads.RolePayload) error {
 
 	err := r.dependencies.Session.GuildRoleDelete(role.GuildID, role.Role.ID)
 	if err != nil {
-		if err.(*discordgo.RESTError).Response.StatusCode == 404 {
+		if common.IsNotFound(err) {
 			sp.Warn("Role doesn't exist in discord", zap.String("role", role.Role.ID))
 			return nil
 		}
+		if common.IsForbidden(err) {
+			sp.Warn("Not allowed to delete role, dropping message", zap.Error(err))
+			return nil
+		}
 		sp.Error("Error deleting role", zap.Error(err))
 		return err
 	}
@@ -221,6 +241,43 @@ func (r Role) delete(ctx context.Context, role payloads.RolePayload) error {
 	return nil
 }
 
+// Only return an error if we want to keep the message and try again.
+func (r Role) reorder(ctx context.Context, role payloads.RolePayload) error {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("queue", "role"),
+		zap.Any("roles", role.Roles),
+	)
+
+	// Only one thing should write to discord at a time
+	r.dependencies.Session.Lock()
+	defer func() {
+		r.dependencies.Session.Unlock()
+	}()
+
+	var roles []*discordgo.Role
+	for _, rl := range role.Roles {
+		roles = append(roles, &discordgo.Role{ID: rl.ID, Position: rl.Position})
+	}
+
+	// One call moves every role, rather than one edit per role
+	_, err := r.dependencies.Session.GuildRoleReorder(role.GuildID, roles)
+	if err != nil {
+		if common.IsForbidden(err) {
+			sp.Warn("Not allowed to reorder roles, dropping message", zap.Error(err))
+			return nil
+		}
+		sp.Error("Error reordering roles", zap.Error(err))
+		return err
+	}
+
+	sp.Info("Reordered roles in discord")
+
+	return nil
+}
+
 // Maybe ditch this in favor of just trying to create and if that fails update, maybe.
 func (r Role) exists(ctx context.Context, name, guildID string) bool {
 	_, sp := sl.OpenSpan(ctx)
@@ -0,0 +1,100 @@
+package esi_poller
+
+import (
+	"context"
+
+	"github.com/bhechinger/go-sets"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/filters"
+	"go.uber.org/zap"
+)
+
+// enforceAccess puts the member in the corp and alliance filters of the characters the access policy allows and in
+// the blue filter if any of their characters is only allowed by standings. It takes them out of the corp, alliance
+// and blue filters they shouldn't be in. Only filters that need to change are touched.
+func (aep *authEsiPoller) enforceAccess(ctx context.Context, chatID string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("chat_id", chatID))
+
+	characters, err := aep.dependencies.Storage.GetDiscordCharacters(ctx, chatID)
+	if err != nil {
+		sp.Error("error getting characters", zap.Error(err))
+		return err
+	}
+
+	var (
+		managed = sets.NewStringSet()
+		want    = sets.NewStringSet()
+	)
+
+	blueFilter := common.BlueFilter()
+	if blueFilter != "" {
+		managed.Add(blueFilter)
+	}
+
+	for _, character := range characters {
+		corporation, err := aep.dependencies.Storage.GetCorporation(ctx, character.CorporationID)
+		if err != nil {
+			sp.Error("error getting corporation", zap.Int32("corporation_id", character.CorporationID), zap.Error(err))
+			return err
+		}
+
+		managed.Add(corporation.Ticker)
+
+		var allianceTicker string
+		if corporation.AllianceID.Valid {
+			alliance, err := aep.dependencies.Storage.GetAlliance(ctx, corporation.AllianceID.Int32)
+			if err != nil {
+				sp.Error("error getting alliance", zap.Int32("alliance_id", corporation.AllianceID.Int32), zap.Error(err))
+				return err
+			}
+
+			allianceTicker = alliance.Ticker
+			managed.Add(allianceTicker)
+		}
+
+		access, err := common.CheckAccess(ctx, character.ID, corporation.ID, corporation.AllianceID.Int32, aep.dependencies)
+		if err != nil {
+			return err
+		}
+
+		switch access {
+		case common.AccessMember:
+			want.Add(corporation.Ticker)
+			if allianceTicker != "" {
+				want.Add(allianceTicker)
+			}
+		case common.AccessBlue:
+			if blueFilter != "" {
+				want.Add(blueFilter)
+			}
+		}
+	}
+
+	userFilters, err := aep.dependencies.Storage.GetUserFilters(ctx, chatID)
+	if err != nil {
+		sp.Error("error getting user filters", zap.Error(err))
+		return err
+	}
+
+	have := sets.NewStringSet()
+	for _, filter := range userFilters {
+		have.Add(filter.Name)
+	}
+
+	for _, filter := range managed.ToSlice() {
+		switch {
+		case want.Contains(filter) && !have.Contains(filter):
+			sp.Info("access policy adding member to filter", zap.String("filter", filter))
+			filters.AddMember(ctx, chatID, filter, aep.dependencies)
+		case !want.Contains(filter) && have.Contains(filter):
+			sp.Info("access policy removing member from filter", zap.String("filter", filter))
+			filters.RemoveMember(ctx, chatID, filter, aep.dependencies)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package esi_poller
+
+import (
+	"context"
+
+	"github.com/antihax/goesi/esi"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/roles"
+	"go.uber.org/zap"
+)
+
+// ESI's bulk endpoints take at most this many IDs per call.
+const esiBulkLimit = 1000
+
+// batches splits ids into chunks ESI's bulk endpoints will take.
+func batches(ids []int32) [][]int32 {
+	var chunks [][]int32
+
+	for len(ids) > esiBulkLimit {
+		chunks = append(chunks, ids[:esiBulkLimit])
+		ids = ids[esiBulkLimit:]
+	}
+
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+
+	return chunks
+}
+
+// getAffiliations looks up the corporation and alliance of every character.
+func (aep *authEsiPoller) getAffiliations(ctx context.Context) (map[int32]esi.PostCharactersAffiliation200Ok, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("sub-component", "affiliation"))
+
+	characters, err := aep.dependencies.Storage.GetCharacters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int32, 0, len(characters))
+	for _, character := range characters {
+		ids = append(ids, character.ID)
+	}
+
+	return aep.lookupAffiliations(ctx, ids), nil
+}
+
+// lookupAffiliations uses POST /characters/affiliation/. ESI rejects the whole batch if any ID in it is bad, so
+// characters in a failed batch are left out and the stages that use this fall back to looking them up one at a time.
+func (aep *authEsiPoller) lookupAffiliations(ctx context.Context, ids []int32) map[int32]esi.PostCharactersAffiliation200Ok {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	affiliations := make(map[int32]esi.PostCharactersAffiliation200Ok, len(ids))
+
+	for _, batch := range batches(ids) {
+		response, _, err := aep.esiClient.ESI.CharacterApi.PostCharactersAffiliation(ctx, batch, nil)
+		if err != nil {
+			sp.Warn("Error calling PostCharactersAffiliation", zap.Int("batch_size", len(batch)), zap.Error(err))
+			continue
+		}
+
+		for _, affiliation := range response {
+			affiliations[affiliation.CharacterId] = affiliation
+		}
+	}
+
+	return affiliations
+}
+
+// getNames looks up the current names of corporations or alliances with POST /universe/names/. Like getAffiliations
+// IDs in a failed batch are left out.
+func (aep *authEsiPoller) getNames(ctx context.Context, ids []int32) map[int32]string {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	names := make(map[int32]string, len(ids))
+
+	for _, batch := range batches(ids) {
+		response, _, err := aep.esiClient.ESI.UniverseApi.PostUniverseNames(ctx, batch, nil)
+		if err != nil {
+			sp.Warn("Error calling PostUniverseNames", zap.Int("batch_size", len(batch)), zap.Error(err))
+			continue
+		}
+
+		for _, name := range response {
+			names[name.Id] = name.Name
+		}
+	}
+
+	return names
+}
+
+// roleMissing is true if the corp or alliance should have a role but doesn't, e.g. after the access policy changed.
+func (aep *authEsiPoller) roleMissing(ctx context.Context, ticker string, allowed bool) (bool, error) {
+	if !allowed {
+		return false, nil
+	}
+
+	count, err := aep.dependencies.Storage.GetRoleCount(ctx, roles.Role, ticker)
+	if err != nil {
+		return false, err
+	}
+
+	return count == 0, nil
+}
@@ -5,40 +5,53 @@ import (
 	"fmt"
 
 	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/common"
 	"github.com/chremoas/chremoas-ng/internal/payloads"
 	"github.com/chremoas/chremoas-ng/internal/roles"
 	"go.uber.org/zap"
 )
 
+// updateAlliances only gets the full details of alliances whose name changed.
 func (aep *authEsiPoller) updateAlliances(ctx context.Context) (int, int, error) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
 	sp.With(zap.String("sub-component", "alliance"))
 
-	var (
-		count      int
-		errorCount int
-		err        error
-	)
-
 	alliances, err := aep.dependencies.Storage.GetAlliances(ctx)
 	if err != nil {
 		return -1, -1, err
 	}
 
-	for a := range alliances {
-		sp.With(zap.Any("alliance", alliances[a]))
+	ids := make([]int32, 0, len(alliances))
+	for _, alliance := range alliances {
+		ids = append(ids, alliance.ID)
+	}
+
+	names := aep.getNames(ctx, ids)
+
+	count, errorCount := forEach(ctx, len(alliances), func(ctx context.Context, a int) error {
+		// Only get the full details when something changed, or the alliance wasn't in the bulk lookup
+		name, ok := names[alliances[a].ID]
+		if ok && name == alliances[a].Name {
+			missing, err := aep.roleMissing(ctx, alliances[a].Ticker, common.OrganizationAccess(0, alliances[a].ID) == common.AccessMember)
+			if err != nil {
+				sp.Error("error checking alliance role", zap.Any("alliance", alliances[a]), zap.Error(err))
+				return err
+			}
+
+			if !missing {
+				return nil
+			}
+		}
 
-		err = aep.updateAlliance(ctx, alliances[a])
+		err := aep.updateAlliance(ctx, alliances[a])
 		if err != nil {
-			sp.Error("error updating alliance", zap.Error(err))
-			errorCount += 1
-			continue
+			sp.Error("error updating alliance", zap.Any("alliance", alliances[a]), zap.Error(err))
 		}
 
-		count += 1
-	}
+		return err
+	})
 
 	return count, errorCount, nil
 }
@@ -85,6 +98,12 @@ func (aep *authEsiPoller) updateAlliance(ctx context.Context, alliance payloads.
 	sp.With(zap.Int("count", count))
 
 	if count == 0 {
+		// Only make roles for the corps and alliances the access policy lets in
+		if common.OrganizationAccess(0, alliance.ID) != common.AccessMember {
+			sp.Debug("Alliance not allowed by the access policy, not adding role")
+			return nil
+		}
+
 		sp.Debug("Adding Alliance")
 		roles.Add(ctx, roles.Role, false, response.Ticker, response.Name, "discord", aep.dependencies)
 	} else {
@@ -5,77 +5,90 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/bhechinger/go-sets"
+	"github.com/antihax/goesi/esi"
 	sl "github.com/bhechinger/spiffylogger"
-	"github.com/chremoas/chremoas-ng/internal/common"
 	"github.com/chremoas/chremoas-ng/internal/filters"
 	"github.com/chremoas/chremoas-ng/internal/payloads"
 	"github.com/chremoas/chremoas-ng/internal/storage"
 	"go.uber.org/zap"
 )
 
-func (aep *authEsiPoller) updateCharacters(ctx context.Context) (int, int, error) {
+// updateCharacters only gets the full details of characters whose corporation changed. Characters missing from
+// affiliations are looked up one at a time.
+func (aep *authEsiPoller) updateCharacters(ctx context.Context, affiliations map[int32]esi.PostCharactersAffiliation200Ok) (int, int, error) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
 	sp.With(zap.String("sub-component", "character"))
 
-	var (
-		count      int
-		errorCount int
-		err        error
-	)
-
 	characters, err := aep.dependencies.Storage.GetCharacters(ctx)
 	if err != nil {
 		return -1, -1, err
 	}
 
-	for c := range characters {
-		sp.With(zap.Any("character", characters[c]))
+	count, errorCount := forEach(ctx, len(characters), func(ctx context.Context, c int) error {
+		return aep.pollCharacter(ctx, characters[c], affiliations)
+	})
 
-		err = aep.updateCharacter(ctx, characters[c])
-		if err != nil {
-			discordID, err := aep.dependencies.Storage.GetDiscordUser(ctx, characters[c].ID)
-			if err != nil {
-				if errors.Is(err, storage.ErrNoDiscordUser) {
-					// character is no longer associated with a discord user so we're going do delete it.
-					sp.Warn("Deleting character as they have no associated discord user")
-					err = aep.dependencies.Storage.DeleteCharacter(ctx, characters[c].ID)
-					if err != nil {
-						sp.Error("Error deleting character", zap.Error(err))
-					}
-				}
-				sp.Error("Error getting discord user", zap.Error(err))
-			}
+	return count, errorCount, nil
+}
 
-			handled, hErr := aep.cad.CheckAndDelete(ctx, discordID, err)
-			if hErr != nil {
-				sp.Error("Additional errors from checkAndDelete", zap.Error(hErr))
-			}
-			if handled {
-				continue
-			}
+func (aep *authEsiPoller) pollCharacter(ctx context.Context, character payloads.Character, affiliations map[int32]esi.PostCharactersAffiliation200Ok) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
 
-			sp.Error(
-				"error updating character",
-				zap.Error(err),
-				zap.NamedError("hErr", hErr),
-				zap.Int32("id", characters[c].ID),
-				zap.String("name", characters[c].Name),
-			)
+	sp.With(zap.Any("character", character))
+
+	var updateErr error
+
+	affiliation, ok := affiliations[character.ID]
+	switch {
+	case !ok:
+		updateErr = aep.updateCharacter(ctx, character, nil)
+	case affiliation.CorporationId == character.CorporationID:
+		updateErr = aep.syncCharacter(ctx, character)
+	default:
+		updateErr = aep.updateCharacter(ctx, character, &affiliation)
+	}
+	if updateErr == nil {
+		return nil
+	}
 
-			errorCount += 1
-			continue
+	discordID, err := aep.dependencies.Storage.GetDiscordUser(ctx, character.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoDiscordUser) {
+			// character is no longer associated with a discord user so we're going do delete it.
+			sp.Warn("Deleting character as they have no associated discord user")
+			err = aep.dependencies.Storage.DeleteCharacter(ctx, character.ID)
+			if err != nil {
+				sp.Error("Error deleting character", zap.Error(err))
+			}
 		}
+		sp.Error("Error getting discord user", zap.Error(err))
+	}
 
-		count += 1
+	handled, hErr := aep.cad.CheckAndDelete(ctx, discordID, err)
+	if hErr != nil {
+		sp.Error("Additional errors from checkAndDelete", zap.Error(hErr))
+	}
+	if handled {
+		return nil
 	}
 
-	return count, errorCount, nil
+	sp.Error(
+		"error updating character",
+		zap.Error(updateErr),
+		zap.NamedError("hErr", hErr),
+		zap.Int32("id", character.ID),
+		zap.String("name", character.Name),
+	)
+
+	return updateErr
 }
 
-func (aep *authEsiPoller) updateCharacter(ctx context.Context, character payloads.Character) error {
+// updateCharacter gets the character from ESI and moves them between corps and alliances if they've changed. The
+// character endpoint is cached for a long time so a corporation from the affiliation lookup wins, if there is one.
+func (aep *authEsiPoller) updateCharacter(ctx context.Context, character payloads.Character, affiliation *esi.PostCharactersAffiliation200Ok) error {
 	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
 	defer sp.Close()
 
@@ -90,6 +103,10 @@ func (aep *authEsiPoller) updateCharacter(ctx context.Context, character payload
 		return err
 	}
 
+	if affiliation != nil {
+		response.CorporationId = affiliation.CorporationId
+	}
+
 	if response.CorporationId == 0 {
 		sp.Error("CorpID is 0: ESI Error most likely, probably transient")
 		return fmt.Errorf("CorpID is 0: ESI error most likely, probably transient")
@@ -185,6 +202,16 @@ func (aep *authEsiPoller) updateCharacter(ctx context.Context, character payload
 		}
 	}
 
+	return aep.syncCharacter(ctx, character)
+}
+
+// syncCharacter applies the access policy to the character's member and queues a sync of their roles.
+func (aep *authEsiPoller) syncCharacter(ctx context.Context, character payloads.Character) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.Int32("character_id", character.ID))
+
 	// We need the chatID of the user, so let's get that.
 	chatID, err := aep.dependencies.Storage.GetDiscordUser(ctx, character.ID)
 	if err != nil {
@@ -193,44 +220,14 @@ func (aep *authEsiPoller) updateCharacter(ctx context.Context, character payload
 
 	sp.With(zap.String("chat_id", chatID))
 
-	member, err := aep.dependencies.Session.GuildMember(aep.dependencies.GuildID, chatID)
-	if err != nil {
-		discordID, dErr := aep.dependencies.Storage.GetDiscordUser(ctx, character.ID)
-		if dErr != nil {
-			return dErr
-		}
-
-		handled, hErr := aep.cad.CheckAndDelete(ctx, discordID, err)
-		if hErr != nil {
-			sp.Error("Additional errors from checkAndDelete", zap.Error(hErr))
-		}
-		if handled {
-			return nil
-		}
-
-		sp.Error("error getting guild member", zap.Error(err), zap.NamedError("hErr", hErr))
-		return err
-	}
-
-	dRoles := sets.NewStringSet()
-	dRoles.FromSlice(member.Roles)
-
-	roles, err := common.GetMembership(ctx, chatID, aep.dependencies)
+	err = aep.enforceAccess(ctx, chatID)
 	if err != nil {
-		sp.Error("error getting membership", zap.Error(err))
+		sp.Error("error enforcing access policy", zap.Error(err))
 		return err
 	}
 
-	addRoles := roles.Difference(dRoles)
-	removeRoles := dRoles.Difference(roles)
-
-	for _, r := range addRoles.ToSlice() {
-		filters.QueueUpdate(ctx, payloads.Add, chatID, r, aep.dependencies)
-	}
-
-	for _, r := range removeRoles.ToSlice() {
-		filters.QueueUpdate(ctx, payloads.Delete, chatID, r, aep.dependencies)
-	}
+	// The members consumer works out what needs to change and handles members who have left
+	filters.QueueSync(ctx, chatID, aep.dependencies)
 
 	return nil
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/antihax/goesi/esi"
 	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/common"
 	"github.com/chremoas/chremoas-ng/internal/filters"
 	"github.com/chremoas/chremoas-ng/internal/payloads"
 	"github.com/chremoas/chremoas-ng/internal/roles"
@@ -70,35 +72,54 @@ func (aep *authEsiPoller) removeCorpMembers(ctx context.Context, corpTicker stri
 	}
 }
 
-func (aep *authEsiPoller) updateCorporations(ctx context.Context) (int, int, error) {
+// updateCorporations only gets the full details of corporations whose name or alliance changed. Alliances come from
+// the characters' affiliations, corporations nobody in affiliations is in are only checked by name.
+func (aep *authEsiPoller) updateCorporations(ctx context.Context, affiliations map[int32]esi.PostCharactersAffiliation200Ok) (int, int, error) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
 	sp.With(zap.String("sub-component", "corporation"))
 
-	var (
-		count      int
-		errorCount int
-		err        error
-	)
-
 	corporations, err := aep.dependencies.Storage.GetCorporations(ctx)
 	if err != nil {
 		return -1, -1, err
 	}
 
-	for c := range corporations {
-		sp.With(zap.Any("corporation", corporations[c]))
+	alliances := make(map[int32]int32)
+	for _, affiliation := range affiliations {
+		alliances[affiliation.CorporationId] = affiliation.AllianceId
+	}
+
+	ids := make([]int32, 0, len(corporations))
+	for _, corporation := range corporations {
+		ids = append(ids, corporation.ID)
+	}
+
+	names := aep.getNames(ctx, ids)
+
+	count, errorCount := forEach(ctx, len(corporations), func(ctx context.Context, c int) error {
+		name, ok := names[corporations[c].ID]
+		allianceID, seen := alliances[corporations[c].ID]
+		if ok && name == corporations[c].Name && (!seen || allianceID == corporations[c].AllianceID.Int32) {
+			allowed := common.OrganizationAccess(corporations[c].ID, corporations[c].AllianceID.Int32) == common.AccessMember
+			missing, err := aep.roleMissing(ctx, corporations[c].Ticker, allowed)
+			if err != nil {
+				sp.Error("error checking corporation role", zap.Any("corporation", corporations[c]), zap.Error(err))
+				return err
+			}
+
+			if !missing {
+				return nil
+			}
+		}
 
 		err := aep.updateCorporation(ctx, corporations[c])
 		if err != nil {
-			sp.Error("error updating corporation", zap.Error(err))
-			errorCount += 1
-			continue
+			sp.Error("error updating corporation", zap.Any("corporation", corporations[c]), zap.Error(err))
 		}
 
-		count += 1
-	}
+		return err
+	})
 
 	return count, errorCount, nil
 }
@@ -180,6 +201,12 @@ func (aep *authEsiPoller) updateCorporation(ctx context.Context, corporation pay
 	sp.With(zap.Int("count", count))
 
 	if count == 0 {
+		// Only make roles for the corps and alliances the access policy lets in
+		if common.OrganizationAccess(corporation.ID, response.AllianceId) != common.AccessMember {
+			sp.Debug("Corporation not allowed by the access policy, not adding role")
+			return nil
+		}
+
 		sp.Debug("Adding Corporation")
 		roles.Add(ctx, roles.Role, false, response.Ticker, response.Name, "discord", aep.dependencies)
 	} else {
@@ -3,111 +3,368 @@ package esi_poller
 import (
 	"context"
 	"errors"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/antihax/goesi"
 	"github.com/antihax/goesi/esi"
 	sl "github.com/bhechinger/spiffylogger"
 	"github.com/chremoas/chremoas-ng/internal/common"
-	"github.com/gregjones/httpcache"
+	"github.com/chremoas/chremoas-ng/internal/esicache"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 type AuthEsiPoller interface {
 	Start(ctx context.Context)
 	Poll(ctx context.Context)
+	SyncRoles(ctx context.Context) (int, int, error)
+	PreviewRoles(ctx context.Context, guildID string) (*common.RolePlan, error)
+	ConfirmRoles(ctx context.Context, guildID, planID string) (int, int, error)
+	RefreshUser(ctx context.Context, chatID string) (int, int, error)
 	Stop(ctx context.Context)
 }
 
 type authEsiPoller struct {
 	dependencies common.Dependencies
-	tickTime     time.Duration
 	ticker       *time.Ticker
 	esiClient    *goesi.APIClient
+	ssoAuth      *goesi.SSOAuthenticator
+	limiter      *esiLimiter
 	cad          common.CheckAndDelete
+
+	// cancel stops the polling loop and any poll in flight, done is closed once it has
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// pollMutex stops polls overlapping, lastRun is when each stage last ran. Pointers because some methods take
+	// copies of the poller to change the guild.
+	pollMutex *sync.Mutex
+	lastRun   map[string]time.Time
+	polls     *pollGroup
+
+	refreshes     *refreshCooldown
+	memberReports *memberReports
+	rolePreviews  *rolePreviews
 }
 
-func New(ctx context.Context, userAgent string, deps common.Dependencies) AuthEsiPoller {
+func New(ctx context.Context, userAgent string, cache *esicache.Cache, deps common.Dependencies) AuthEsiPoller {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
 	sp.Info("Setting up Auth ESI Poller", zap.String("component", "esi-poller"))
-	httpClient := httpcache.NewMemoryCacheTransport().Client()
+
+	// The limiter sits under the cache so cached responses don't wait
+	limiter := newESILimiter(http.DefaultTransport)
+	httpClient := &http.Client{Transport: cache.Transport(limiter)}
 
 	return &authEsiPoller{
 		dependencies: deps,
-		tickTime:     time.Minute * 60,
 		esiClient:    goesi.NewAPIClient(httpClient, userAgent),
-		cad:          common.NewCheckAndDelete(deps),
+		// SSO calls are per character and never cached
+		ssoAuth: goesi.NewSSOAuthenticator(
+			&http.Client{Transport: limiter},
+			viper.GetString("oauth.clientId"),
+			viper.GetString("oauth.clientSecret"),
+			"",
+			nil,
+		),
+		limiter:       limiter,
+		cad:           common.NewCheckAndDelete(deps),
+		pollMutex:     &sync.Mutex{},
+		lastRun:       make(map[string]time.Time),
+		polls:         &pollGroup{cancels: make(map[int]context.CancelFunc)},
+		refreshes:     &refreshCooldown{last: make(map[string]time.Time)},
+		memberReports: &memberReports{last: make(map[string]string)},
+		rolePreviews:  &rolePreviews{ids: make(map[string]string)},
 	}
 }
 
+// Start begins polling. The poller can be started again after Stop, e.g. when this replica becomes the leader again.
+// If a Stop gave up waiting for the old loop, Start waits for it to finish first so two loops never run at once.
 func (aep *authEsiPoller) Start(ctx context.Context) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
-	aep.ticker = time.NewTicker(aep.tickTime)
+	if aep.cancel != nil {
+		return
+	}
+
+	if aep.done != nil {
+		sp.Warn("Waiting for the last polling loop to stop")
+		select {
+		case <-aep.done:
+			aep.done = nil
+		case <-ctx.Done():
+			sp.Warn("Not starting, the last polling loop is still running", zap.Error(ctx.Err()))
+			return
+		}
+	}
+
+	ctx, aep.cancel = context.WithCancel(ctx)
+	aep.done = make(chan struct{})
+	aep.ticker = time.NewTicker(pollTick())
 
 	sp.Info("Starting polling loop")
-	go func() {
+	go func(done chan struct{}) {
+		defer close(done)
+
 		aep.Poll(ctx)
-		for range aep.ticker.C {
-			aep.Poll(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-aep.ticker.C:
+				aep.Poll(ctx)
+			}
 		}
-	}()
+	}(aep.done)
 }
 
+// Stop cancels the polling loop and every poll in flight, including ones started with !poller run, and waits for
+// them until ctx is done. It's safe to call on a poller that was never started. If ctx is done first the loop is left
+// to finish on its own and the next Start waits for it.
 func (aep *authEsiPoller) Stop(ctx context.Context) {
-	_, sp := sl.OpenSpan(ctx)
+	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
 	sp.Info("Stopping poller")
-	aep.ticker.Stop()
+
+	if aep.cancel != nil {
+		aep.ticker.Stop()
+		aep.cancel()
+		aep.cancel = nil
+	}
+
+	running := aep.polls.cancel()
+	if !aep.polls.wait(ctx) {
+		sp.Warn("Abandoning polls still running", zap.Int("polls", running))
+		return
+	}
+
+	if aep.done != nil {
+		select {
+		case <-aep.done:
+			aep.done = nil
+		case <-ctx.Done():
+			sp.Warn("Abandoning polling loop still running")
+			return
+		}
+	}
+
+	sp.Info("Poller stopped")
 }
 
 // Poll currently starts at alliances and works it's way down to characters.  It then walks back up at the corporation
-// level and character level if alliance/corporation membership has changed from the last poll.
+// level and character level if alliance/corporation membership has changed from the last poll. Changes are found with
+// ESI's bulk affiliation and names lookups, full details are only fetched for what changed.
+//
+// Each stage runs on its own schedule from bot.esiPoller.schedule, Poll only runs the stages that are due unless the
+// context says otherwise, see WithStages. Stages that call ESI are skipped during ESI's daily downtime. Every poll that
+// runs a stage is recorded in poll_runs.
 func (aep *authEsiPoller) Poll(ctx context.Context) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
+	ctx, finish := aep.polls.add(ctx)
+	defer finish()
+
+	ctx = common.WithPoller(ctx)
+
+	aep.pollMutex.Lock()
+	defer aep.pollMutex.Unlock()
+
+	run := payloads.PollRun{Trigger: pollTrigger(ctx), StartedAt: time.Now()}
+
+	downtime, err := inDowntime(time.Now())
+	if err != nil {
+		sp.Error("error checking ESI downtime", zap.Error(err))
+	}
+
+	if downtime {
+		sp.Info("ESI downtime, skipping ESI stages")
+	}
+
+	runStage := func(s stage, fn func(ctx context.Context) (int, int, error)) {
+		result := aep.runStage(ctx, s, downtime, fn)
+		if result != nil {
+			run.Stages = append(run.Stages, *result)
+		}
+	}
+
+	runStage(stage{name: "standings", esi: true}, aep.updateStandings)
+	runStage(stage{name: "roles"}, aep.SyncRoles)
+
+	var affiliations map[int32]esi.PostCharactersAffiliation200Ok
+	if !downtime && (aep.due(ctx, "corporations") || aep.due(ctx, "characters")) {
+		sp.Info("Calling getAffiliations()")
+		affiliations, err = aep.getAffiliations(ctx)
+		if err != nil {
+			sp.Error("error getting affiliations", zap.Error(err))
+		} else {
+			sp.Info("getAffiliations() completed", zap.Int("count", len(affiliations)))
+		}
+	}
+
+	runStage(stage{name: "alliances", esi: true}, aep.updateAlliances)
+	runStage(stage{name: "corporations", esi: true}, func(ctx context.Context) (int, int, error) {
+		return aep.updateCorporations(ctx, affiliations)
+	})
+	runStage(stage{name: "characters", esi: true}, func(ctx context.Context) (int, int, error) {
+		return aep.updateCharacters(ctx, affiliations)
+	})
+	runStage(stage{name: "members"}, aep.reconcileMembers)
+	runStage(stage{name: "policy"}, aep.applyMemberPolicy)
+	runStage(stage{name: "stats"}, aep.snapshotMembership)
+
+	remain, reset := aep.limiter.budget()
+	sp.Debug("ESI error budget", zap.Int("remain", remain), zap.Time("reset", reset))
+
+	if len(run.Stages) == 0 {
+		return
+	}
+
+	run.FinishedAt = time.Now()
+	aep.recordRun(ctx, run)
+}
+
+// due is true if the stage hasn't run within its interval or the context forces it.
+func (aep *authEsiPoller) due(ctx context.Context, name string) bool {
+	if forced(ctx, name) {
+		return true
+	}
+
+	return time.Since(aep.lastRun[name]) >= stageInterval(name)
+}
+
+// runStage runs the stage if it's due, logs how it went and returns the result for poll_runs. It returns nil if the
+// stage didn't run.
+func (aep *authEsiPoller) runStage(ctx context.Context, s stage, downtime bool, run func(ctx context.Context) (int, int, error)) *payloads.PollStage {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("stage", s.name))
+
+	if ctx.Err() != nil || !aep.due(ctx, s.name) || (s.esi && downtime) {
+		return nil
+	}
+
+	result := &payloads.PollStage{Stage: s.name, StartedAt: time.Now()}
+	samples := &errorSample{}
+
+	sp.Info("Running stage")
+	count, errorCount, err := run(withErrorSample(ctx, samples))
+	if err != nil {
+		samples.add(err)
+	}
+
+	result.Count = count
+	result.ErrorCount = errorCount
+	result.FinishedAt = time.Now()
+
+	// A cancelled stage runs again on the next start
+	if ctx.Err() != nil {
+		sp.Info("Stage cancelled", zap.Int("count", count), zap.Int("errorCount", errorCount))
+		samples.add(ctx.Err())
+		result.Errors = samples.errors
+		return result
+	}
+
+	aep.lastRun[s.name] = time.Now()
+	result.Errors = samples.errors
+
+	if err != nil {
+		sp.Error("error running stage", zap.Error(err))
+		return result
+	}
+
+	sp.Info("Stage completed", zap.Int("count", count), zap.Int("errorCount", errorCount))
+
+	return result
+}
+
+func (aep *authEsiPoller) recordRun(ctx context.Context, run payloads.PollRun) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	// The poll might have been cancelled, the record should still be written
+	ctx = detached{ctx}
+
+	_, err := aep.dependencies.Storage.InsertPollRun(ctx, run)
+	if err != nil {
+		sp.Error("error recording poll run", zap.Error(err))
+		return
+	}
+
+	err = aep.dependencies.Storage.DeleteOldPollRuns(ctx, pollRunsKept)
+	if err != nil {
+		sp.Error("error deleting old poll runs", zap.Error(err))
+	}
+}
+
+// SyncRoles makes discord's roles match the database in every guild. Each guild is synced independently so one
+// failing doesn't stop the rest, the first error is returned.
+func (aep *authEsiPoller) SyncRoles(ctx context.Context) (int, int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
 	var (
 		count      int
 		errorCount int
-		err        error
+		firstErr   error
 	)
 
-	sp.Info("calling syncRoles()")
-	count, errorCount, err = aep.syncRoles(ctx)
-	if err == nil {
-		sp.Info("syncRoles() completed", zap.Int("count", count), zap.Int("errorCount", errorCount))
-	} else {
-		sp.Error("error synchronizing discord roles", zap.Error(err))
-	}
+	for _, guild := range common.Guilds() {
+		c, e, err := aep.syncRoles(ctx, guild.ID, "")
+		if err != nil {
+			sp.Error("error synchronizing guild roles", zap.String("guild_id", guild.ID), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
 
-	sp.Info("Calling updateAlliances()")
-	count, errorCount, err = aep.updateAlliances(ctx)
-	if err != nil {
-		sp.Error("error updating alliances", zap.Error(err))
-	} else {
-		sp.Info("updateAlliances() completed", zap.Int("count", count), zap.Int("errorCount", errorCount))
+		count += c
+		errorCount += e
 	}
 
-	sp.Info("Calling updateCorporations()")
-	count, errorCount, err = aep.updateCorporations(ctx)
+	return count, errorCount, firstErr
+}
+
+// PreviewRoles returns what a role sync would change in the guild without changing anything. The plan's ID is
+// remembered so it can be confirmed.
+func (aep *authEsiPoller) PreviewRoles(ctx context.Context, guildID string) (*common.RolePlan, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("guild_id", guildID))
+
+	// Copy so the guild only changes for this preview
+	preview := *aep
+	preview.dependencies = preview.dependencies.ForGuild(guildID)
+
+	plan, err := preview.planRoles(ctx)
 	if err != nil {
-		sp.Error("error updating corporations", zap.Error(err))
-	} else {
-		sp.Info("updateCorporations() completed.", zap.Int("count", count), zap.Int("errorCount", errorCount))
+		sp.Error("error planning role sync", zap.Error(err))
+		return nil, err
 	}
 
-	sp.Info("Calling updateCharacters()")
-	count, errorCount, err = aep.updateCharacters(ctx)
-	if err != nil {
-		sp.Error("error updating characters", zap.Error(err))
-	} else {
-		sp.Info("updateCharacters() completed", zap.Int("count", count), zap.Int("errorCount", errorCount))
+	aep.rolePreviews.set(guildID, plan.ID())
+
+	return &plan.RolePlan, nil
+}
+
+// ConfirmRoles applies the guild's last previewed role plan even if it's a dry run or more roles would be deleted than
+// bot.syncMaxDeletes allows. It refuses if planID isn't that preview or a fresh plan no longer matches it, either way
+// a new preview is needed.
+func (aep *authEsiPoller) ConfirmRoles(ctx context.Context, guildID, planID string) (int, int, error) {
+	if !aep.rolePreviews.take(guildID, planID) {
+		return -1, -1, common.ErrNoRolePlanPreview
 	}
+
+	return aep.syncRoles(ctx, guildID, planID)
 }
 
 func (aep *authEsiPoller) notFound(ctx context.Context, err error) error {
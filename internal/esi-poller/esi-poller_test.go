@@ -0,0 +1,37 @@
+package esi_poller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+)
+
+func TestRestartWithOldLoopRunning(t *testing.T) {
+	// A loop left behind by a Stop that gave up waiting
+	old := make(chan struct{})
+	aep := &authEsiPoller{
+		polls: &pollGroup{cancels: make(map[int]context.CancelFunc)},
+		done:  old,
+	}
+
+	ctx, cancel := context.WithCancel(commontest.Context())
+	cancel()
+
+	aep.Stop(ctx)
+	if aep.done != old {
+		t.Fatal("Stop forgot the polling loop it didn't wait for")
+	}
+
+	aep.Start(ctx)
+	if aep.cancel != nil || aep.done != old {
+		t.Fatal("Start ran a second polling loop")
+	}
+
+	close(old)
+
+	aep.Stop(commontest.Context())
+	if aep.done != nil {
+		t.Error("Stop didn't notice the polling loop finished")
+	}
+}
@@ -0,0 +1,120 @@
+package esi_poller
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// Used when bot.esiPoller.requestsPerSecond isn't set
+	defaultRequestsPerSecond = 20
+	// Below this many errors left in ESI's budget every request is slowed down, more the lower it gets
+	errorLimitSlow = 50
+	// At or below this many errors left nothing is sent until the budget resets
+	errorLimitStop = 10
+)
+
+// esiLimiter is the http.RoundTripper every poller ESI call goes through. It spaces requests out to
+// bot.esiPoller.requestsPerSecond and watches X-Esi-Error-Limit-Remain and X-Esi-Error-Limit-Reset so the poller
+// slows down as the error budget shrinks and waits for the reset before ESI starts blocking us.
+type esiLimiter struct {
+	transport http.RoundTripper
+	interval  time.Duration
+
+	mutex    sync.Mutex
+	nextSlot time.Time
+	remain   int
+	reset    time.Time
+}
+
+func newESILimiter(transport http.RoundTripper) *esiLimiter {
+	requestsPerSecond := viper.GetInt("bot.esiPoller.requestsPerSecond")
+	if requestsPerSecond <= 0 {
+		requestsPerSecond = defaultRequestsPerSecond
+	}
+
+	return &esiLimiter{
+		transport: transport,
+		interval:  time.Second / time.Duration(requestsPerSecond),
+		remain:    errorLimitSlow,
+	}
+}
+
+func (l *esiLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	timer := time.NewTimer(l.delay())
+	defer timer.Stop()
+
+	select {
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-timer.C:
+	}
+
+	resp, err := l.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	l.update(resp.Header)
+
+	return resp, nil
+}
+
+// delay reserves the next request slot and returns how long to wait for it.
+func (l *esiLimiter) delay() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	// The budget is full again once it resets
+	if l.reset.Before(now) {
+		l.remain = errorLimitSlow
+	}
+
+	slot := l.nextSlot
+	if slot.Before(now) {
+		slot = now
+	}
+
+	switch {
+	case l.remain <= errorLimitStop && l.reset.After(slot):
+		slot = l.reset
+	case l.remain < errorLimitSlow:
+		slot = slot.Add(l.interval * time.Duration(errorLimitSlow-l.remain))
+	}
+
+	l.nextSlot = slot.Add(l.interval)
+
+	return slot.Sub(now)
+}
+
+func (l *esiLimiter) update(header http.Header) {
+	remain, err := strconv.Atoi(header.Get("X-Esi-Error-Limit-Remain"))
+	if err != nil {
+		return
+	}
+
+	reset, err := strconv.Atoi(header.Get("X-Esi-Error-Limit-Reset"))
+	if err != nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.remain = remain
+	l.reset = time.Now().Add(time.Duration(reset) * time.Second)
+}
+
+// budget returns the errors ESI said we have left and when that resets, for logging.
+func (l *esiLimiter) budget() (int, time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.remain, l.reset
+}
@@ -0,0 +1,207 @@
+package esi_poller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bhechinger/go-sets"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/filters"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// How many members discord hands back per page, 1000 is the most it allows.
+	memberPageSize = 1000
+	// How many members to name in the report alert before just counting them.
+	memberReportLimit = 20
+
+	memberReconcileOff     = "off"
+	memberReconcileReport  = "report"
+	memberReconcileEnforce = "enforce"
+)
+
+// memberReports remembers which members each guild's last report alert named, so a mismatch that's still there on
+// the next poll isn't reported again.
+type memberReports struct {
+	mutex sync.Mutex
+	last  map[string]string
+}
+
+// changed records the guild's mismatches and returns true if they're not the ones last reported.
+func (r *memberReports) changed(guildID string, mismatches []string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sort.Strings(mismatches)
+	key := strings.Join(mismatches, "\n")
+
+	if r.last[guildID] == key {
+		return false
+	}
+
+	r.last[guildID] = key
+
+	return true
+}
+
+// reconcileMembers walks every member of every guild, not just the ones we have characters for, and finds managed
+// roles they have that their filter membership doesn't justify. What happens next depends on bot.memberReconcile:
+// "off" skips the stage, "report" (the default) only logs and alerts when the members and roles that don't match change, "enforce" queues a sync for each member.
+func (aep *authEsiPoller) reconcileMembers(ctx context.Context) (int, int, error) {
+	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
+	defer sp.Close()
+
+	mode := viper.GetString("bot.memberReconcile")
+	if mode == "" {
+		mode = memberReconcileReport
+	}
+
+	sp.With(
+		zap.String("sub-component", "members"),
+		zap.String("mode", mode),
+	)
+
+	switch mode {
+	case memberReconcileOff:
+		sp.Debug("member reconciliation is off")
+		return 0, 0, nil
+	case memberReconcileReport, memberReconcileEnforce:
+	default:
+		return -1, -1, fmt.Errorf("unknown bot.memberReconcile mode: %s", mode)
+	}
+
+	var (
+		count      int
+		errorCount int
+	)
+
+	for _, guild := range common.Guilds() {
+		c, e, err := aep.reconcileGuildMembers(ctx, guild.ID, mode == memberReconcileEnforce)
+		if err != nil {
+			sp.Error("error reconciling guild members", zap.String("guild_id", guild.ID), zap.Error(err))
+			errorCount += 1
+			continue
+		}
+
+		count += c
+		errorCount += e
+	}
+
+	return count, errorCount, nil
+}
+
+// reconcileGuildMembers returns how many members have roles they shouldn't.
+func (aep authEsiPoller) reconcileGuildMembers(ctx context.Context, guildID string, enforce bool) (int, int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("guild_id", guildID),
+		zap.Bool("enforce", enforce),
+	)
+
+	// aep is a copy so this only changes the guild for this stage
+	aep.dependencies = aep.dependencies.ForGuild(guildID)
+
+	var (
+		count      int
+		errorCount int
+		after      string
+		report     []string
+		mismatches []string
+	)
+
+	managed, err := common.ManagedRoles(ctx, aep.dependencies)
+	if err != nil {
+		sp.Error("error getting managed roles", zap.Error(err))
+		return -1, -1, err
+	}
+
+	for {
+		members, err := aep.dependencies.Session.GuildMembers(guildID, after, memberPageSize)
+		if err != nil {
+			sp.Error("error getting guild members", zap.String("after", after), zap.Error(err))
+			return count, errorCount, err
+		}
+
+		for _, member := range members {
+			if member.User == nil || member.User.Bot {
+				continue
+			}
+
+			membership, err := common.GetMembership(ctx, member.User.ID, aep.dependencies)
+			if err != nil {
+				sp.Error("error getting membership", zap.String("member_id", member.User.ID), zap.Error(err))
+				errorCount += 1
+				continue
+			}
+
+			current := sets.NewStringSet()
+			current.FromSlice(member.Roles)
+
+			extra := current.Intersection(managed).Difference(membership)
+			if extra.Len() == 0 {
+				continue
+			}
+
+			count += 1
+
+			sp.Info("member has managed roles they shouldn't",
+				zap.String("member_id", member.User.ID),
+				zap.Strings("roles", extra.ToSlice()),
+			)
+
+			if enforce {
+				filters.QueueGuildSync(ctx, guildID, member.User.ID, aep.dependencies)
+				continue
+			}
+
+			mismatches = append(mismatches, member.User.ID+":"+strings.Join(sortedRoles(extra), ","))
+
+			if len(report) < memberReportLimit {
+				report = append(report, fmt.Sprintf("<@%s>: %s", member.User.ID, roleMentions(extra)))
+			}
+		}
+
+		if len(members) < memberPageSize {
+			break
+		}
+
+		after = members[len(members)-1].User.ID
+	}
+
+	if !enforce && aep.memberReports.changed(guildID, mismatches) && count > 0 {
+		if count > len(report) {
+			report = append(report, fmt.Sprintf("... and %d more", count-len(report)))
+		}
+
+		common.Alert(ctx, fmt.Sprintf(
+			"%d members in guild %s have synced roles their filters don't give them. Set bot.memberReconcile to enforce to remove them.\n%s",
+			count, guildID, strings.Join(report, "\n"),
+		), aep.dependencies)
+	}
+
+	return count, errorCount, nil
+}
+
+func sortedRoles(roles *sets.StringSet) []string {
+	sorted := roles.ToSlice()
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+func roleMentions(roles *sets.StringSet) string {
+	var mentions []string
+	for _, role := range roles.ToSlice() {
+		mentions = append(mentions, fmt.Sprintf("<@&%s>", role))
+	}
+
+	return strings.Join(mentions, ", ")
+}
@@ -0,0 +1,225 @@
+package esi_poller
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	orderAlliance    = "alliance"
+	orderCorporation = "corporation"
+	orderSig         = "sig"
+	orderRole        = "role"
+)
+
+// Used when bot.roleOrder isn't set, highest first.
+var defaultRoleOrder = []string{orderAlliance, orderCorporation, orderSig, orderRole}
+
+// orderRoles makes the synced roles in discord follow bot.roleOrder. Anything out of order is sent as a single
+// reorder message. Returns true if a reorder was queued.
+func (aep authEsiPoller) orderRoles(ctx context.Context, discordRoles []*discordgo.Role, dbRoles []payloads.Role) (bool, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("sub-component", "order"),
+		zap.String("guild_id", aep.dependencies.GuildID),
+	)
+
+	top, err := common.BotTopPosition(aep.dependencies.GuildID, aep.dependencies)
+	if err != nil {
+		sp.Error("error getting bot's top role", zap.Error(err))
+		return false, err
+	}
+
+	sp.With(zap.Int("bot_top_position", top))
+
+	categories, err := aep.roleCategories(ctx)
+	if err != nil {
+		sp.Error("error getting role categories", zap.Error(err))
+		return false, err
+	}
+
+	reorder := planRoleOrder(ctx, discordRoles, dbRoles, top, categories)
+	if len(reorder) == 0 {
+		sp.Debug("roles already in order")
+		return false, nil
+	}
+
+	sp.Info("roles out of order", zap.Int("count", len(reorder)))
+
+	payload := payloads.RolePayload{
+		Action:  payloads.Reorder,
+		GuildID: aep.dependencies.GuildID,
+		Roles:   reorder,
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		sp.Error("error marshalling json for queue", zap.Error(err))
+		return false, err
+	}
+
+	err = aep.dependencies.RolesProducer.Publish(ctx, b)
+	if err != nil {
+		sp.Error("error publishing message", zap.Error(err))
+		return false, err
+	}
+
+	return true, nil
+}
+
+// planRoleOrder sorts the synced roles the bot can move by category and then by the position stored in the database
+// (highest first). If discord doesn't already have them in that order, with no two sharing a position, every one of
+// them is given a position in the contiguous range right below the bot's top role. Returns nothing if they're already
+// in order.
+func planRoleOrder(ctx context.Context, discordRoles []*discordgo.Role, dbRoles []payloads.Role, top int,
+	categories map[string]string) []payloads.Role {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	rank := make(map[string]int)
+	for i, category := range roleOrder() {
+		rank[category] = i
+	}
+
+	current := make(map[string]*discordgo.Role)
+	for _, role := range discordRoles {
+		current[role.ID] = role
+	}
+
+	var managed []payloads.Role
+	for _, role := range dbRoles {
+		discordRole, ok := current[role.ID]
+		if !ok {
+			// Not created in discord yet, it'll get ordered next time.
+			continue
+		}
+
+		if !role.Manageable || discordRole.Position >= top {
+			sp.Warn("role is above the bot's highest role, can't move it",
+				zap.String("role", role.Name),
+				zap.Int("position", discordRole.Position),
+			)
+			continue
+		}
+
+		managed = append(managed, role)
+	}
+
+	if len(managed) == 0 {
+		return nil
+	}
+
+	category := func(role payloads.Role) int {
+		if role.Sig {
+			return rank[orderSig]
+		}
+
+		if c, ok := categories[role.ShortName]; ok {
+			return rank[c]
+		}
+
+		return rank[orderRole]
+	}
+
+	sort.SliceStable(managed, func(i, j int) bool {
+		ci, cj := category(managed[i]), category(managed[j])
+		if ci != cj {
+			return ci < cj
+		}
+
+		if managed[i].Position != managed[j].Position {
+			return managed[i].Position > managed[j].Position
+		}
+
+		return managed[i].Name < managed[j].Name
+	})
+
+	inOrder := true
+	for i := 1; i < len(managed); i++ {
+		if current[managed[i-1].ID].Position <= current[managed[i].ID].Position {
+			inOrder = false
+			break
+		}
+	}
+
+	if inOrder {
+		return nil
+	}
+
+	// Position 0 is @everyone, which can't move
+	if top-len(managed) < 1 {
+		sp.Warn("not enough room below the bot's top role to order roles", zap.Int("roles", len(managed)))
+		return nil
+	}
+
+	reorder := make([]payloads.Role, 0, len(managed))
+	for i, role := range managed {
+		reorder = append(reorder, payloads.Role{ID: role.ID, Name: role.Name, Position: top - 1 - i})
+	}
+
+	return reorder
+}
+
+// roleCategories maps role tickers to whether they belong to an alliance or a corporation.
+func (aep authEsiPoller) roleCategories(ctx context.Context) (map[string]string, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	categories := make(map[string]string)
+
+	corporations, err := aep.dependencies.Storage.GetCorporations(ctx)
+	if err != nil {
+		sp.Error("error getting corporations", zap.Error(err))
+		return nil, err
+	}
+
+	for _, corporation := range corporations {
+		categories[corporation.Ticker] = orderCorporation
+	}
+
+	alliances, err := aep.dependencies.Storage.GetAlliances(ctx)
+	if err != nil {
+		sp.Error("error getting alliances", zap.Error(err))
+		return nil, err
+	}
+
+	for _, alliance := range alliances {
+		categories[alliance.Ticker] = orderAlliance
+	}
+
+	return categories, nil
+}
+
+func roleOrder() []string {
+	order := viper.GetStringSlice("bot.roleOrder")
+	if len(order) == 0 {
+		return defaultRoleOrder
+	}
+
+	// Anything left out goes below everything that was listed
+	for _, category := range defaultRoleOrder {
+		found := false
+		for _, o := range order {
+			if o == category {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			order = append(order, category)
+		}
+	}
+
+	return order
+}
@@ -0,0 +1,139 @@
+package esi_poller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+func TestPlanRoleOrder(t *testing.T) {
+	// The bot's top role is at 10
+	const top = 10
+
+	categories := map[string]string{"ALLY": orderAlliance, "CORP": orderCorporation}
+	dbRoles := []payloads.Role{
+		{ID: "1", Name: "Corp", ShortName: "CORP", Manageable: true},
+		{ID: "2", Name: "Alliance", ShortName: "ALLY", Manageable: true},
+		{ID: "3", Name: "Sig", ShortName: "SIG", Sig: true, Manageable: true},
+	}
+
+	// at places the roles in discord, by ID
+	at := func(positions map[string]int) []*discordgo.Role {
+		var roles []*discordgo.Role
+		for id, position := range positions {
+			roles = append(roles, &discordgo.Role{ID: id, Position: position})
+		}
+
+		return roles
+	}
+
+	below := []payloads.Role{
+		{ID: "2", Name: "Alliance", Position: 9},
+		{ID: "1", Name: "Corp", Position: 8},
+		{ID: "3", Name: "Sig", Position: 7},
+	}
+
+	cases := []struct {
+		name    string
+		discord []*discordgo.Role
+		db      []payloads.Role
+		want    []payloads.Role
+	}{
+		{
+			name:    "in order",
+			discord: at(map[string]int{"2": 9, "1": 8, "3": 7}),
+		},
+		{
+			name:    "in order with unmanaged roles in between",
+			discord: at(map[string]int{"2": 8, "1": 5, "3": 1}),
+		},
+		{
+			name:    "out of order",
+			discord: at(map[string]int{"1": 9, "2": 8, "3": 7}),
+			want:    below,
+		},
+		{
+			name:    "sharing a position",
+			discord: at(map[string]int{"2": 5, "1": 5, "3": 4}),
+			want:    below,
+		},
+		{
+			name:    "already below the top role but out of order",
+			discord: at(map[string]int{"2": 3, "1": 1, "3": 2}),
+			want:    below,
+		},
+		{
+			name:    "not in discord yet",
+			discord: at(map[string]int{"2": 5, "1": 4}),
+		},
+		{
+			name:    "above the bot's top role",
+			discord: at(map[string]int{"2": 11, "1": 4, "3": 5}),
+			want: []payloads.Role{
+				{ID: "1", Name: "Corp", Position: 9},
+				{ID: "3", Name: "Sig", Position: 8},
+			},
+		},
+		{
+			name:    "not manageable",
+			discord: at(map[string]int{"1": 4, "2": 3, "3": 5}),
+			db: []payloads.Role{
+				{ID: "1", Name: "Corp", ShortName: "CORP", Manageable: true},
+				{ID: "2", Name: "Alliance", ShortName: "ALLY"},
+				{ID: "3", Name: "Sig", ShortName: "SIG", Sig: true, Manageable: true},
+			},
+			want: []payloads.Role{
+				{ID: "1", Name: "Corp", Position: 9},
+				{ID: "3", Name: "Sig", Position: 8},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			db := c.db
+			if db == nil {
+				db = dbRoles
+			}
+
+			got := planRoleOrder(commontest.Context(), c.discord, db, top, categories)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPlanRoleOrderConverges(t *testing.T) {
+	categories := map[string]string{"ALLY": orderAlliance}
+	dbRoles := []payloads.Role{
+		{ID: "1", Name: "B", Manageable: true},
+		{ID: "2", Name: "A", Manageable: true},
+		{ID: "3", Name: "Alliance", ShortName: "ALLY", Manageable: true},
+	}
+
+	// Every role at the same position, the way discord can leave them
+	discord := []*discordgo.Role{{ID: "1", Position: 2}, {ID: "2", Position: 2}, {ID: "3", Position: 2}}
+
+	reorder := planRoleOrder(commontest.Context(), discord, dbRoles, 10, categories)
+	if len(reorder) != 3 {
+		t.Fatalf("first plan: got %+v, want every role moved", reorder)
+	}
+
+	moved := make(map[string]int)
+	for _, role := range reorder {
+		moved[role.ID] = role.Position
+	}
+
+	for _, role := range discord {
+		role.Position = moved[role.ID]
+	}
+
+	if again := planRoleOrder(commontest.Context(), discord, dbRoles, 10, categories); len(again) != 0 {
+		t.Errorf("second plan: got %+v, want nothing", again)
+	}
+}
@@ -0,0 +1,468 @@
+package esi_poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bhechinger/go-sets"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	policyOff        = "off"
+	policyRemind     = "remind"
+	policyQuarantine = "quarantine"
+	policyKick       = "kick"
+	// policyRelease takes the quarantine role away from a member that's allowed again, it's never configured
+	policyRelease = "release"
+)
+
+// Used when bot.memberPolicy.maxActions isn't set.
+const defaultPolicyMaxActions = 10
+
+// ErrTooManyPolicyActions is returned when the member policy would quarantine or kick more than
+// bot.memberPolicy.maxActions members in a guild in one poll. Nothing is done to anyone until an admin has checked.
+var ErrTooManyPolicyActions = errors.New("member policy would act on too many members")
+
+// memberRule is what to do with members in one situation, from bot.memberPolicy.unauthed or .departed.
+type memberRule struct {
+	name   string
+	action string
+	grace  time.Duration
+}
+
+func getMemberRule(name string) (memberRule, error) {
+	rule := memberRule{
+		name:   name,
+		action: viper.GetString(fmt.Sprintf("bot.memberPolicy.%s.action", name)),
+		grace:  time.Duration(viper.GetInt(fmt.Sprintf("bot.memberPolicy.%s.days", name))) * time.Hour * 24,
+	}
+
+	switch rule.action {
+	case "":
+		rule.action = policyOff
+	case policyOff, policyRemind, policyQuarantine, policyKick:
+	default:
+		return rule, fmt.Errorf("unknown bot.memberPolicy.%s.action: %s", name, rule.action)
+	}
+
+	return rule, nil
+}
+
+// policyDryRun is true if bot.memberPolicy.dryRun is set, the policy only logs what it would do.
+func policyDryRun() bool {
+	return viper.GetBool("bot.memberPolicy.dryRun")
+}
+
+// policyMaxActions is how many members the policy can quarantine or kick in a guild in one poll. Negative means no
+// limit.
+func policyMaxActions() int {
+	if !viper.IsSet("bot.memberPolicy.maxActions") {
+		return defaultPolicyMaxActions
+	}
+
+	return viper.GetInt("bot.memberPolicy.maxActions")
+}
+
+// memberAction is what the policy is going to do to one member.
+type memberAction struct {
+	member *discordgo.Member
+	rule   memberRule
+	action string
+	since  time.Time
+}
+
+func (a memberAction) String() string {
+	if a.action == policyRelease {
+		return fmt.Sprintf("%s: <@%s>", a.action, a.member.User.ID)
+	}
+
+	return fmt.Sprintf("%s: <@%s> (%s for %s)", a.action, a.member.User.ID, a.rule.name, time.Since(a.since).Round(time.Hour))
+}
+
+// memberPolicyPlan is everything the policy is going to do in a guild.
+type memberPolicyPlan []memberAction
+
+// removals is how many members the plan quarantines or kicks.
+func (p memberPolicyPlan) removals() int {
+	var n int
+	for _, a := range p {
+		if a.action == policyQuarantine || a.action == policyKick {
+			n++
+		}
+	}
+
+	return n
+}
+
+// tooManyRemovals is true if the plan quarantines or kicks more members than bot.memberPolicy.maxActions allows.
+// A poll after an ESI or database outage that left characters unresolved would otherwise throw everyone out.
+func (p memberPolicyPlan) tooManyRemovals() bool {
+	max := policyMaxActions()
+	return max >= 0 && p.removals() > max
+}
+
+func (p memberPolicyPlan) lines() []string {
+	lines := make([]string, len(p))
+	for i, a := range p {
+		lines[i] = a.String()
+	}
+
+	return lines
+}
+
+// applyMemberPolicy deals with members that never authed (bot.memberPolicy.unauthed) and members whose characters
+// are all outside the access policy in bot.accessPolicy (bot.memberPolicy.departed). Once a member has been in that
+// state for the rule's number of days they're reminded, quarantined or kicked. Quarantined members get the role taken
+// away again once they're allowed. Members in the exemption list are never touched. Each guild is planned before
+// anything is done: with bot.memberPolicy.dryRun set the plan is only logged, and a plan that quarantines or kicks
+// more than bot.memberPolicy.maxActions members isn't carried out at all.
+func (aep *authEsiPoller) applyMemberPolicy(ctx context.Context) (int, int, error) {
+	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
+	defer sp.Close()
+
+	sp.With(zap.String("sub-component", "policy"))
+
+	unauthed, err := getMemberRule("unauthed")
+	if err != nil {
+		return -1, -1, err
+	}
+
+	departed, err := getMemberRule("departed")
+	if err != nil {
+		return -1, -1, err
+	}
+
+	if unauthed.action == policyOff && departed.action == policyOff {
+		sp.Debug("member policy is off")
+		return 0, 0, nil
+	}
+
+	exemptions, err := aep.dependencies.Storage.GetMemberPolicyExemptions(ctx)
+	if err != nil {
+		sp.Error("error getting member policy exemptions", zap.Error(err))
+		return -1, -1, err
+	}
+
+	exempt := sets.NewStringSet()
+	for _, exemption := range exemptions {
+		exempt.Add(exemption.ChatID)
+	}
+
+	var (
+		count      int
+		errorCount int
+	)
+
+	for _, guild := range common.Guilds() {
+		c, e, err := aep.applyGuildMemberPolicy(ctx, guild.ID, unauthed, departed, exempt)
+		if err != nil {
+			sp.Error("error applying member policy", zap.String("guild_id", guild.ID), zap.Error(err))
+			errorCount += 1
+			continue
+		}
+
+		count += c
+		errorCount += e
+	}
+
+	return count, errorCount, nil
+}
+
+func (aep authEsiPoller) applyGuildMemberPolicy(ctx context.Context, guildID string, unauthed, departed memberRule, exempt *sets.StringSet) (int, int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("guild_id", guildID))
+
+	// aep is a copy so this only changes the guild for this stage
+	aep.dependencies = aep.dependencies.ForGuild(guildID)
+
+	plan, errorCount, err := aep.planGuildMemberPolicy(ctx, guildID, unauthed, departed, exempt)
+	if err != nil {
+		return 0, errorCount, err
+	}
+
+	if len(plan) == 0 {
+		return 0, errorCount, nil
+	}
+
+	if policyDryRun() {
+		sp.Info("dry run, not applying the member policy", zap.Strings("plan", plan.lines()))
+		return 0, errorCount, nil
+	}
+
+	if plan.tooManyRemovals() {
+		sp.Warn("member policy would act on too many members",
+			zap.Int("removals", plan.removals()),
+			zap.Int("max_actions", policyMaxActions()),
+			zap.Strings("plan", plan.lines()),
+		)
+
+		common.Alert(ctx, fmt.Sprintf(
+			"The member policy for guild %s would quarantine or kick %d members, the limit is %d. Nothing was done. Check the log for who, then exempt them with `!exempt` or raise bot.memberPolicy.maxActions if it's right.",
+			guildID, plan.removals(), policyMaxActions(),
+		), aep.dependencies)
+
+		return 0, errorCount, ErrTooManyPolicyActions
+	}
+
+	var count int
+
+	for _, action := range plan {
+		err = aep.applyMemberAction(ctx, action)
+		if err != nil {
+			sp.Error("error applying member policy", zap.String("member_id", action.member.User.ID), zap.Error(err))
+			errorCount += 1
+			continue
+		}
+
+		count += 1
+	}
+
+	return count, errorCount, nil
+}
+
+// planGuildMemberPolicy works out what to do to every member of the guild. Only the member's state in the database
+// is kept up to date, nothing is done to the members themselves.
+func (aep authEsiPoller) planGuildMemberPolicy(ctx context.Context, guildID string, unauthed, departed memberRule, exempt *sets.StringSet) (memberPolicyPlan, int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var (
+		plan       memberPolicyPlan
+		errorCount int
+		after      string
+	)
+
+	for {
+		members, err := aep.dependencies.Session.GuildMembers(guildID, after, memberPageSize)
+		if err != nil {
+			sp.Error("error getting guild members", zap.String("after", after), zap.Error(err))
+			return nil, errorCount, err
+		}
+
+		for _, member := range members {
+			if member.User == nil || member.User.Bot || exempt.Contains(member.User.ID) {
+				continue
+			}
+
+			action, err := aep.planMemberPolicy(ctx, member, unauthed, departed)
+			if err != nil {
+				sp.Error("error planning member policy", zap.String("member_id", member.User.ID), zap.Error(err))
+				errorCount += 1
+				continue
+			}
+
+			if action != nil {
+				plan = append(plan, *action)
+			}
+		}
+
+		if len(members) < memberPageSize {
+			break
+		}
+
+		after = members[len(members)-1].User.ID
+	}
+
+	return plan, errorCount, nil
+}
+
+// planMemberPolicy returns what to do to the member, nil if nothing.
+func (aep authEsiPoller) planMemberPolicy(ctx context.Context, member *discordgo.Member, unauthed, departed memberRule) (*memberAction, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("member_id", member.User.ID))
+
+	joinedAt, err := member.JoinedAt.Parse()
+	if err != nil {
+		joinedAt = time.Now()
+	}
+
+	// Members that joined before we started tracking them
+	err = aep.dependencies.Storage.InsertMemberState(ctx, member.User.ID, joinedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := aep.dependencies.Storage.GetMemberState(ctx, member.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	characters, err := aep.dependencies.Storage.GetDiscordCharacters(ctx, member.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := aep.allowedMember(ctx, characters)
+	if err != nil {
+		return nil, err
+	}
+
+	if allowed {
+		err = aep.dependencies.Storage.UpdateMemberAuthed(ctx, member.User.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if state.QuarantinedAt == nil {
+			return nil, nil
+		}
+
+		return &memberAction{member: member, action: policyRelease}, nil
+	}
+
+	rule := departed
+	if len(characters) == 0 {
+		rule = unauthed
+	}
+
+	return ruleAction(member, rule, state), nil
+}
+
+// ruleAction returns what the rule does to a member that isn't allowed in, nil if nothing yet.
+func ruleAction(member *discordgo.Member, rule memberRule, state payloads.MemberState) *memberAction {
+	since := state.JoinedAt
+	if state.LastAuthed != nil && state.LastAuthed.After(since) {
+		since = *state.LastAuthed
+	}
+
+	if rule.action == policyOff || time.Since(since) < rule.grace {
+		return nil
+	}
+
+	switch rule.action {
+	case policyRemind:
+		if state.RemindedAt != nil && time.Since(*state.RemindedAt) < rule.grace {
+			return nil
+		}
+
+	case policyQuarantine:
+		if state.QuarantinedAt != nil {
+			return nil
+		}
+	}
+
+	return &memberAction{member: member, rule: rule, action: rule.action, since: since}
+}
+
+// applyMemberAction does what the plan says to the member.
+func (aep authEsiPoller) applyMemberAction(ctx context.Context, a memberAction) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("member_id", a.member.User.ID),
+		zap.String("rule", a.rule.name),
+		zap.String("action", a.action),
+		zap.Time("since", a.since),
+	)
+
+	switch a.action {
+	case policyRelease:
+		sp.Info("releasing member from quarantine")
+		return aep.quarantine(ctx, a.member.User.ID, false)
+
+	case policyRemind:
+		sp.Info("reminding member to auth")
+		err := common.DirectMessage(a.member.User.ID, fmt.Sprintf(
+			"You don't have a character that gives you access to this server. Sign in with your EVE Online character at %s and then paste the `!auth` command it gives you into any channel on the server.",
+			common.AuthURL(),
+		), aep.dependencies)
+		if err != nil {
+			// People with DMs turned off can't be reminded, don't keep trying every poll
+			sp.Warn("error sending reminder", zap.Error(err))
+		}
+
+		return aep.dependencies.Storage.UpdateMemberReminded(ctx, a.member.User.ID)
+
+	case policyQuarantine:
+		sp.Info("quarantining member")
+		return aep.quarantine(ctx, a.member.User.ID, true)
+
+	case policyKick:
+		sp.Info("kicking member")
+		err := aep.dependencies.Session.GuildMemberDeleteWithReason(
+			aep.dependencies.GuildID,
+			a.member.User.ID,
+			fmt.Sprintf("member policy: %s for %s", a.rule.name, time.Since(a.since).Round(time.Hour)),
+		)
+		if err != nil {
+			return err
+		}
+
+		return aep.dependencies.Storage.DeleteMemberState(ctx, a.member.User.ID)
+	}
+
+	return nil
+}
+
+// quarantine adds or removes bot.memberPolicy.quarantineRole.
+func (aep authEsiPoller) quarantine(ctx context.Context, memberID string, quarantined bool) error {
+	roleID, err := quarantineRoleID(aep.dependencies)
+	if err != nil {
+		return err
+	}
+
+	if quarantined {
+		err = aep.dependencies.Session.GuildMemberRoleAdd(aep.dependencies.GuildID, memberID, roleID)
+	} else {
+		err = aep.dependencies.Session.GuildMemberRoleRemove(aep.dependencies.GuildID, memberID, roleID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return aep.dependencies.Storage.UpdateMemberQuarantined(ctx, memberID, quarantined)
+}
+
+func quarantineRoleID(deps common.Dependencies) (string, error) {
+	name := viper.GetString("bot.memberPolicy.quarantineRole")
+	if name == "" {
+		return "", errors.New("bot.memberPolicy.quarantineRole isn't set")
+	}
+
+	roles, err := deps.Session.GuildRoles(deps.GuildID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, role := range roles {
+		if role.Name == name {
+			return role.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("quarantine role %s doesn't exist in guild %s", name, deps.GuildID)
+}
+
+// allowedMember is true if the access policy lets in any of the member's characters, as a member or a blue.
+func (aep authEsiPoller) allowedMember(ctx context.Context, characters []payloads.Character) (bool, error) {
+	for _, character := range characters {
+		corporation, err := aep.dependencies.Storage.GetCorporation(ctx, character.CorporationID)
+		if err != nil {
+			return false, err
+		}
+
+		access, err := common.CheckAccess(ctx, character.ID, corporation.ID, corporation.AllianceID.Int32, aep.dependencies)
+		if err != nil {
+			return false, err
+		}
+
+		if access != common.AccessDenied {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
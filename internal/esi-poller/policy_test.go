@@ -0,0 +1,252 @@
+package esi_poller
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bhechinger/go-sets"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
+)
+
+func TestRuleAction(t *testing.T) {
+	member := &discordgo.Member{User: &discordgo.User{ID: "123"}}
+	week := 7 * 24 * time.Hour
+	ago := func(d time.Duration) *time.Time {
+		at := time.Now().Add(-d)
+		return &at
+	}
+
+	cases := []struct {
+		name   string
+		action string
+		state  payloads.MemberState
+		want   string
+	}{
+		{
+			name:   "off",
+			action: policyOff,
+			state:  payloads.MemberState{JoinedAt: *ago(2 * week)},
+		},
+		{
+			name:   "in grace",
+			action: policyKick,
+			state:  payloads.MemberState{JoinedAt: *ago(week / 2)},
+		},
+		{
+			name:   "authed recently",
+			action: policyKick,
+			state:  payloads.MemberState{JoinedAt: *ago(2 * week), LastAuthed: ago(week / 2)},
+		},
+		{
+			name:   "kick",
+			action: policyKick,
+			state:  payloads.MemberState{JoinedAt: *ago(2 * week)},
+			want:   policyKick,
+		},
+		{
+			name:   "remind",
+			action: policyRemind,
+			state:  payloads.MemberState{JoinedAt: *ago(2 * week)},
+			want:   policyRemind,
+		},
+		{
+			name:   "reminded recently",
+			action: policyRemind,
+			state:  payloads.MemberState{JoinedAt: *ago(2 * week), RemindedAt: ago(week / 2)},
+		},
+		{
+			name:   "reminded a while ago",
+			action: policyRemind,
+			state:  payloads.MemberState{JoinedAt: *ago(3 * week), RemindedAt: ago(2 * week)},
+			want:   policyRemind,
+		},
+		{
+			name:   "quarantine",
+			action: policyQuarantine,
+			state:  payloads.MemberState{JoinedAt: *ago(2 * week)},
+			want:   policyQuarantine,
+		},
+		{
+			name:   "already quarantined",
+			action: policyQuarantine,
+			state:  payloads.MemberState{JoinedAt: *ago(2 * week), QuarantinedAt: ago(week)},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			rule := memberRule{name: "unauthed", action: c.action, grace: week}
+
+			var got string
+			if a := ruleAction(member, rule, c.state); a != nil {
+				got = a.action
+			}
+
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMemberPolicyPlanTooManyRemovals(t *testing.T) {
+	plan := func(actions ...string) memberPolicyPlan {
+		var p memberPolicyPlan
+		for _, action := range actions {
+			p = append(p, memberAction{member: &discordgo.Member{User: &discordgo.User{ID: "123"}}, action: action})
+		}
+
+		return p
+	}
+
+	cases := []struct {
+		name string
+		// max is bot.memberPolicy.maxActions, nil leaves it unset
+		max  interface{}
+		plan memberPolicyPlan
+		want bool
+	}{
+		{
+			name: "default limit",
+			plan: plan(policyKick, policyKick, policyKick, policyKick, policyKick, policyQuarantine, policyQuarantine,
+				policyQuarantine, policyQuarantine, policyQuarantine),
+		},
+		{
+			name: "over the default limit",
+			plan: plan(policyKick, policyKick, policyKick, policyKick, policyKick, policyQuarantine, policyQuarantine,
+				policyQuarantine, policyQuarantine, policyQuarantine, policyKick),
+			want: true,
+		},
+		{
+			name: "reminders and releases don't count",
+			max:  1,
+			plan: plan(policyKick, policyRemind, policyRemind, policyRelease),
+		},
+		{
+			name: "over",
+			max:  1,
+			plan: plan(policyKick, policyQuarantine),
+			want: true,
+		},
+		{
+			name: "no limit",
+			max:  -1,
+			plan: plan(policyKick, policyKick, policyKick),
+		},
+		{
+			name: "nothing allowed",
+			max:  0,
+			plan: plan(policyQuarantine),
+			want: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			viper.Set("bot.memberPolicy.maxActions", c.max)
+			defer viper.Set("bot.memberPolicy.maxActions", nil)
+
+			if got := c.plan.tooManyRemovals(); got != c.want {
+				t.Errorf("got %t, want %t", got, c.want)
+			}
+		})
+	}
+}
+
+func TestApplyGuildMemberPolicy(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	joined := func(ago time.Duration) discordgo.Timestamp {
+		return discordgo.Timestamp(time.Now().Add(-ago).Format(time.RFC3339))
+	}
+
+	cases := []struct {
+		name string
+		// max is bot.memberPolicy.maxActions, nil leaves it unset
+		max    interface{}
+		dryRun bool
+		exempt []string
+		// joined is how long ago 201 joined, 202 joined two weeks ago
+		joined  time.Duration
+		kicked  []string
+		count   int
+		tooMany bool
+	}{
+		{
+			name:   "kick",
+			joined: 2 * week,
+			kicked: []string{"DELETE /members/201", "DELETE /members/202"},
+			count:  2,
+		},
+		{
+			name:   "in grace",
+			joined: week / 2,
+			kicked: []string{"DELETE /members/202"},
+			count:  1,
+		},
+		{
+			name:   "exempt",
+			joined: 2 * week,
+			exempt: []string{"202"},
+			kicked: []string{"DELETE /members/201"},
+			count:  1,
+		},
+		{
+			name:   "dry run",
+			joined: 2 * week,
+			dryRun: true,
+		},
+		{
+			name:    "over the limit",
+			joined:  2 * week,
+			max:     1,
+			tooMany: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			viper.Set("bot.memberPolicy.maxActions", c.max)
+			viper.Set("bot.memberPolicy.dryRun", c.dryRun)
+			defer viper.Set("bot.memberPolicy.maxActions", nil)
+			defer viper.Set("bot.memberPolicy.dryRun", nil)
+
+			deps := commontest.Dependencies(t)
+			guild := &commontest.Guild{Members: []*discordgo.Member{
+				{User: &discordgo.User{ID: deps.BotID, Bot: true}, JoinedAt: joined(4 * week)},
+				{User: &discordgo.User{ID: "201"}, JoinedAt: joined(c.joined)},
+				{User: &discordgo.User{ID: "202"}, JoinedAt: joined(2 * week)},
+			}}
+			guild.Serve(deps)
+
+			exempt := sets.NewStringSet()
+			for _, id := range c.exempt {
+				exempt.Add(id)
+			}
+
+			unauthed := memberRule{name: "unauthed", action: policyKick, grace: week}
+			departed := memberRule{name: "departed", action: policyOff}
+
+			count, errorCount, err := authEsiPoller{dependencies: deps}.applyGuildMemberPolicy(
+				commontest.Context(), commontest.GuildID, unauthed, departed, exempt)
+			if errors.Is(err, ErrTooManyPolicyActions) != c.tooMany || (err != nil && !c.tooMany) {
+				t.Fatalf("got error %v, want too many %t", err, c.tooMany)
+			}
+
+			if count != c.count || errorCount != 0 {
+				t.Errorf("got %d done and %d errors, want %d done", count, errorCount, c.count)
+			}
+
+			if changes := guild.Changes(); !reflect.DeepEqual(changes, c.kicked) {
+				t.Errorf("changes to the guild: got %v, want %v", changes, c.kicked)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package esi_poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Used when bot.authRefreshCooldown isn't set
+const defaultRefreshCooldown = 5 * time.Minute
+
+var ErrRefreshTooSoon = errors.New("refreshed too recently")
+
+// refreshCooldown remembers when each user was last refreshed so !auth refresh can't be used to hammer ESI.
+type refreshCooldown struct {
+	mutex sync.Mutex
+	last  map[string]time.Time
+}
+
+// remaining returns how long the user still has to wait, 0 if they don't.
+func (r *refreshCooldown) remaining(chatID string) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.remainingLocked(chatID)
+}
+
+// take returns how long the user still has to wait, or 0 and starts a new cooldown.
+func (r *refreshCooldown) take(chatID string) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if remaining := r.remainingLocked(chatID); remaining > 0 {
+		return remaining
+	}
+
+	r.last[chatID] = time.Now()
+
+	return 0
+}
+
+func (r *refreshCooldown) remainingLocked(chatID string) time.Duration {
+	cooldown := defaultRefreshCooldown
+	if viper.IsSet("bot.authRefreshCooldown") {
+		cooldown = viper.GetDuration("bot.authRefreshCooldown")
+	}
+
+	if remaining := cooldown - time.Since(r.last[chatID]); remaining > 0 {
+		return remaining
+	}
+
+	return 0
+}
+
+// RefreshUser updates every character linked to the discord user from ESI right away, the same as the characters
+// stage does. Filter changes are applied and role changes queued before it returns.
+func (aep *authEsiPoller) RefreshUser(ctx context.Context, chatID string) (int, int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("chat_id", chatID))
+
+	if remaining := aep.refreshes.remaining(chatID); remaining > 0 {
+		return 0, 0, fmt.Errorf("%w, try again in %s", ErrRefreshTooSoon, remaining.Round(time.Second))
+	}
+
+	characters, err := aep.dependencies.Storage.GetDiscordCharacters(ctx, chatID)
+	if err != nil {
+		sp.Error("error getting characters", zap.Error(err))
+		return -1, -1, err
+	}
+
+	if len(characters) == 0 {
+		return 0, 0, nil
+	}
+
+	// The cooldown only starts once there's something to refresh, another refresh may have started meanwhile
+	if remaining := aep.refreshes.take(chatID); remaining > 0 {
+		return 0, 0, fmt.Errorf("%w, try again in %s", ErrRefreshTooSoon, remaining.Round(time.Second))
+	}
+
+	ids := make([]int32, 0, len(characters))
+	for _, character := range characters {
+		ids = append(ids, character.ID)
+	}
+
+	affiliations := aep.lookupAffiliations(ctx, ids)
+
+	count, errorCount := forEach(ctx, len(characters), func(ctx context.Context, c int) error {
+		var affiliation *esi.PostCharactersAffiliation200Ok
+		if a, ok := affiliations[characters[c].ID]; ok {
+			affiliation = &a
+		}
+
+		err := aep.updateCharacter(ctx, characters[c], affiliation)
+		if err != nil {
+			sp.Error("error updating character", zap.Int32("character_id", characters[c].ID), zap.Error(err))
+		}
+
+		return err
+	})
+
+	return count, errorCount, nil
+}
@@ -3,6 +3,9 @@ package esi_poller
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 
 	sl "github.com/bhechinger/spiffylogger"
 	"github.com/bwmarrin/discordgo"
@@ -11,32 +14,131 @@ import (
 	"go.uber.org/zap"
 )
 
-// Make sure the roles we have in the db match what's in discord
-func (aep authEsiPoller) syncRoles(ctx context.Context) (int, int, error) {
+// ErrTooManyDeletes is returned when a sync would delete more than bot.syncMaxDeletes roles. An admin has to look at
+// it with !role sync preview and then confirm that plan with !role sync confirm.
+var ErrTooManyDeletes = errors.New("role sync would delete too many roles")
+
+// rolePreviews remembers the ID of each guild's last previewed role plan, so only a plan an admin has seen can be
+// confirmed. Only the leader handles commands, a new leader needs a new preview.
+type rolePreviews struct {
+	mutex sync.Mutex
+	ids   map[string]string
+}
+
+func (r *rolePreviews) set(guildID, planID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.ids[guildID] = planID
+}
+
+// take forgets the guild's preview and returns true if it had the ID.
+func (r *rolePreviews) take(guildID, planID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if planID == "" || r.ids[guildID] != planID {
+		return false
+	}
+
+	delete(r.ids, guildID)
+
+	return true
+}
+
+// rolePlan is a common.RolePlan plus what's needed to apply it.
+type rolePlan struct {
+	common.RolePlan
+	// moved are roles discord has under a different ID than the database, probably recreated manually
+	moved        []payloads.Role
+	discordRoles []*discordgo.Role
+	dbRoles      []payloads.Role
+}
+
+// syncRoles makes sure the roles we have in the db match what's in discord. If confirm is the ID of a previewed plan
+// it's applied even if it's a dry run or over the delete limit, but only if nothing has changed since the preview.
+func (aep authEsiPoller) syncRoles(ctx context.Context, guildID, confirm string) (int, int, error) {
 	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
 	defer sp.Close()
 
-	sp.With(zap.String("sub-component", "roles"))
+	sp.With(
+		zap.String("sub-component", "roles"),
+		zap.String("guild_id", guildID),
+		zap.String("confirm", confirm),
+	)
+
+	// aep is a copy so this only changes the guild for this sync
+	aep.dependencies = aep.dependencies.ForGuild(guildID)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Find out which roles we can't touch before trying to change them
+	report, err := common.CheckHierarchy(ctx, aep.dependencies)
+	if err != nil {
+		sp.Error("error checking role hierarchy", zap.Error(err))
+		return -1, -1, err
+	}
+
+	if len(report.NewlyUnmanageable) > 0 {
+		common.Alert(ctx, report.String(), aep.dependencies)
+	}
+
+	plan, err := aep.planRoles(ctx)
+	if err != nil {
+		sp.Error("error planning role sync", zap.Error(err))
+		return -1, -1, err
+	}
+
+	if confirm != "" {
+		if id := plan.ID(); id != confirm {
+			sp.Warn("role sync changed since the preview", zap.String("plan_id", id))
+			return -1, -1, common.ErrRolePlanChanged
+		}
+	} else {
+		if common.SyncDryRun() {
+			sp.Info("dry run, not changing any roles", zap.Strings("plan", plan.Lines()))
+			return 0, 0, nil
+		}
+
+		if plan.TooManyDeletes() {
+			sp.Warn("role sync would delete too many roles",
+				zap.Int("deletes", len(plan.Delete)),
+				zap.Int("max_deletes", common.SyncMaxDeletes()),
+			)
+
+			common.Alert(ctx, fmt.Sprintf(
+				"Role sync for guild %s would delete %d roles, the limit is %d. Nothing was changed. Check it with `!role sync preview` and run the `!role sync confirm` it shows if it's right.",
+				guildID, len(plan.Delete), common.SyncMaxDeletes(),
+			), aep.dependencies)
+
+			return -1, -1, ErrTooManyDeletes
+		}
+	}
+
+	return aep.applyRoles(ctx, plan)
+}
+
+// planRoles works out what needs to change in discord without changing anything.
+func (aep authEsiPoller) planRoles(ctx context.Context) (*rolePlan, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
 
 	var (
-		count         int
-		errorCount    int
-		roles         []*discordgo.Role
+		err           error
 		discordRoles  = make(map[string]payloads.Role)
 		chremoasRoles = make(map[string]payloads.Role)
+		plan          = &rolePlan{RolePlan: common.RolePlan{GuildID: aep.dependencies.GuildID}}
 	)
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	roles, err := aep.dependencies.Session.GuildRoles(aep.dependencies.GuildID)
+	plan.discordRoles, err = aep.dependencies.Session.GuildRoles(aep.dependencies.GuildID)
 	if err != nil {
 		sp.Error("error getting discord roles", zap.Error(err))
-		return -1, -1, err
+		return nil, err
 	}
 
-	for _, role := range roles {
-		if !common.IgnoreRole(role.Name) {
+	for _, role := range plan.discordRoles {
+		if !common.IgnoreRole(aep.dependencies.GuildID, role.Name) {
 			var dr payloads.Role
 
 			dr.ID = role.ID
@@ -52,65 +154,117 @@ func (aep authEsiPoller) syncRoles(ctx context.Context) (int, int, error) {
 		}
 	}
 
-	dbRoles, err := aep.dependencies.Storage.GetRolesBySync(ctx, true)
+	plan.dbRoles, err = aep.dependencies.Storage.GetRolesBySync(ctx, true)
 	if err != nil {
 		sp.Error("error getting roles by sync", zap.Error(err))
-		return -1, -1, err
+		return nil, err
 	}
 
-	for r := range dbRoles {
+	for r := range plan.dbRoles {
 		// Check if we need to update the role ID in the database
-		if val, ok := discordRoles[dbRoles[r].Name]; ok {
-			if val.ID != dbRoles[r].ID {
+		if val, ok := discordRoles[plan.dbRoles[r].Name]; ok {
+			if val.ID != plan.dbRoles[r].ID {
 				sp.Info("Discord role ID doesn't match what we have", zap.String("discord_id", val.ID))
-				err = aep.dependencies.Storage.UpdateRole(ctx, val.ID, dbRoles[r].Name, "")
-				if err != nil {
-					sp.Error("error updating role", zap.Error(err))
-					return -1, -1, err
-				}
-
-				dbRoles[r].ID = val.ID
+				plan.dbRoles[r].ID = val.ID
+				plan.moved = append(plan.moved, plan.dbRoles[r])
 			}
 		}
 
-		chremoasRoles[dbRoles[r].Name] = dbRoles[r]
+		chremoasRoles[plan.dbRoles[r].Name] = plan.dbRoles[r]
 	}
 
 	sp.Debug("current roles", zap.Any("chremoas", chremoasRoles))
 	sp.Debug("current roles", zap.Any("discord", discordRoles))
 
 	// Delete roles from discord that aren't in the bot
-	rolesToDelete := difference(discordRoles, chremoasRoles)
-	for _, role := range rolesToDelete {
+	plan.Delete = difference(discordRoles, chremoasRoles)
+
+	// Add roles to discord that are in the bot
+	for _, role := range difference(chremoasRoles, discordRoles) {
+		if role.Manageable {
+			plan.Add = append(plan.Add, role)
+		}
+	}
+
+	for _, role := range interDiff(ctx, chremoasRoles, discordRoles) {
+		if !role.Manageable {
+			sp.Debug("skipping unmanageable role", zap.String("role", role.Name))
+			continue
+		}
+
+		plan.Update = append(plan.Update, role)
+	}
+
+	return plan, nil
+}
+
+// applyRoles queues everything in the plan.
+func (aep authEsiPoller) applyRoles(ctx context.Context, plan *rolePlan) (int, int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var (
+		count      int
+		errorCount int
+	)
+
+	for _, role := range plan.moved {
+		err := aep.dependencies.Storage.UpdateRole(ctx, role.ID, role.Name, "")
+		if err != nil {
+			sp.Error("error updating role", zap.Error(err))
+			return -1, -1, err
+		}
+	}
+
+	for _, role := range plan.Delete {
 		sp.With(zap.Any("role", role), zap.Any("action", payloads.Delete))
-		err = aep.queueUpdate(ctx, role, payloads.Delete)
+		err := aep.queueUpdate(ctx, role, payloads.Delete)
 		if err != nil {
 			sp.Error("error updating role", zap.Error(err))
 			errorCount += 1
 			continue
 		}
 
+		err = common.Audit(ctx, common.Change{
+			Action:     "sync_delete",
+			TargetType: "discord_role",
+			Target:     role.Name,
+			Before:     role,
+		}, aep.dependencies)
+		if err != nil {
+			errorCount += 1
+			continue
+		}
+
 		count += 1
 	}
 
-	// Add roles to discord that are in the bot
-	rolesToAdd := difference(chremoasRoles, discordRoles)
-	for _, role := range rolesToAdd {
+	for _, role := range plan.Add {
 		sp.With(zap.Any("role", role), zap.Any("action", payloads.Upsert))
-		err = aep.queueUpdate(ctx, role, payloads.Upsert)
+		err := aep.queueUpdate(ctx, role, payloads.Upsert)
 		if err != nil {
 			sp.Error("error updating role", zap.Error(err))
 			errorCount += 1
 			continue
 		}
 
+		err = common.Audit(ctx, common.Change{
+			Action:     "sync_create",
+			TargetType: "discord_role",
+			Target:     role.Name,
+			After:      role,
+		}, aep.dependencies)
+		if err != nil {
+			errorCount += 1
+			continue
+		}
+
 		count += 1
 	}
 
-	rolesToUpdate := interDiff(ctx, chremoasRoles, discordRoles, aep.dependencies)
-	for _, role := range rolesToUpdate {
+	for _, role := range plan.Update {
 		sp.With(zap.Any("role", role), zap.Any("action", payloads.Upsert))
-		err = aep.queueUpdate(ctx, role, payloads.Upsert)
+		err := aep.queueUpdate(ctx, role, payloads.Upsert)
 		if err != nil {
 			sp.Error(
 				"error updating role",
@@ -123,6 +277,25 @@ func (aep authEsiPoller) syncRoles(ctx context.Context) (int, int, error) {
 			continue
 		}
 
+		err = common.Audit(ctx, common.Change{
+			Action:     "sync_update",
+			TargetType: "discord_role",
+			Target:     role.Name,
+			After:      role,
+		}, aep.dependencies)
+		if err != nil {
+			errorCount += 1
+			continue
+		}
+
+		count += 1
+	}
+
+	reordered, err := aep.orderRoles(ctx, plan.discordRoles, plan.dbRoles)
+	if err != nil {
+		sp.Error("error ordering roles", zap.Error(err))
+		errorCount += 1
+	} else if reordered {
 		count += 1
 	}
 
@@ -132,7 +305,7 @@ func (aep authEsiPoller) syncRoles(ctx context.Context) (int, int, error) {
 // interDiff finds the intersection of the two maps of roles and then checks if there are any
 // differences between what we (chremoas) thinks the roles should be and what they actually are
 // in discord.
-func interDiff(ctx context.Context, chremoasMap, discordMap map[string]payloads.Role, deps common.Dependencies) []payloads.Role {
+func interDiff(ctx context.Context, chremoasMap, discordMap map[string]payloads.Role) []payloads.Role {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
@@ -143,9 +316,6 @@ func interDiff(ctx context.Context, chremoasMap, discordMap map[string]payloads.
 		output   []payloads.Role
 	)
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
 	// find the intersection and make as list
 	for m1 := range chremoasMap {
 		for m2 := range discordMap {
@@ -156,19 +326,7 @@ func interDiff(ctx context.Context, chremoasMap, discordMap map[string]payloads.
 	}
 
 	for _, r := range roleList {
-		if chremoasMap[r].ID != discordMap[r].ID {
-			// The role was probably recreated manually.
-			err := deps.Storage.UpdateRole(ctx, discordMap[r].ID, r, "")
-			if err != nil {
-				sp.Error("error updating role", zap.Error(err))
-				continue
-			}
-		}
-
-		if chremoasMap[r].Mentionable != discordMap[r].Mentionable ||
-			chremoasMap[r].Hoist != discordMap[r].Hoist ||
-			chremoasMap[r].Color != discordMap[r].Color ||
-			chremoasMap[r].Permissions != discordMap[r].Permissions {
+		if !common.RoleMatches(chremoasMap[r], discordMap[r]) {
 			sp.Info("roles differ", zap.String("name", r))
 
 			output = append(output, chremoasMap[r])
@@ -0,0 +1,265 @@
+package esi_poller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/chremoas/chremoas-ng/internal/roles"
+)
+
+// newRoleDependencies has the synced roles Test Corp (42) and New Corp (43).
+func newRoleDependencies(t *testing.T) common.Dependencies {
+	t.Helper()
+
+	deps := commontest.Dependencies(t)
+
+	for _, role := range []struct{ ticker, name, id string }{{"TEST", "Test Corp", "42"}, {"NEW", "New Corp", "43"}} {
+		commontest.AddRole(t, deps, role.ticker, role.name, role.id)
+
+		err := deps.Storage.UpdateRoleValues(commontest.Context(), roles.Role, role.name, map[string]string{"sync": "true"})
+		if err != nil {
+			t.Fatalf("UpdateRoleValues: %s", err)
+		}
+	}
+
+	return deps
+}
+
+// syncedRoles returns the synced roles from the database the way discord would have them, by name.
+func syncedRoles(t *testing.T, deps common.Dependencies) map[string]*discordgo.Role {
+	t.Helper()
+
+	dbRoles, err := deps.Storage.GetRolesBySync(commontest.Context(), true)
+	if err != nil {
+		t.Fatalf("GetRolesBySync: %s", err)
+	}
+
+	synced := make(map[string]*discordgo.Role)
+	for _, role := range dbRoles {
+		synced[role.Name] = &discordgo.Role{
+			ID:          role.ID,
+			Name:        role.Name,
+			Managed:     role.Managed,
+			Mentionable: role.Mentionable,
+			Hoist:       role.Hoist,
+			Color:       role.Color,
+			Permissions: role.Permissions,
+		}
+	}
+
+	return synced
+}
+
+func names(roles []payloads.Role) []string {
+	var out []string
+	for _, role := range roles {
+		out = append(out, role.Name)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+func TestPlanRoles(t *testing.T) {
+	everyone := &discordgo.Role{ID: commontest.GuildID, Name: "@everyone"}
+
+	cases := []struct {
+		name string
+		// guild builds discord's roles from the synced roles
+		guild        func(synced map[string]*discordgo.Role) []*discordgo.Role
+		unmanageable string
+		delete       []string
+		add          []string
+		update       []string
+		moved        []string
+	}{
+		{
+			name: "in sync",
+			guild: func(synced map[string]*discordgo.Role) []*discordgo.Role {
+				return []*discordgo.Role{everyone, synced["Test Corp"], synced["New Corp"]}
+			},
+		},
+		{
+			name: "missing from discord",
+			guild: func(synced map[string]*discordgo.Role) []*discordgo.Role {
+				return []*discordgo.Role{everyone, synced["Test Corp"]}
+			},
+			add: []string{"New Corp"},
+		},
+		{
+			name: "missing but out of reach",
+			guild: func(synced map[string]*discordgo.Role) []*discordgo.Role {
+				return []*discordgo.Role{everyone, synced["Test Corp"]}
+			},
+			unmanageable: "43",
+		},
+		{
+			name: "not ours",
+			guild: func(synced map[string]*discordgo.Role) []*discordgo.Role {
+				return []*discordgo.Role{everyone, synced["Test Corp"], synced["New Corp"], {ID: "99", Name: "Someone's"}}
+			},
+			delete: []string{"Someone's"},
+		},
+		{
+			name: "changed by hand",
+			guild: func(synced map[string]*discordgo.Role) []*discordgo.Role {
+				synced["Test Corp"].Color = 0x00ff00
+				return []*discordgo.Role{everyone, synced["Test Corp"], synced["New Corp"]}
+			},
+			update: []string{"Test Corp"},
+		},
+		{
+			name: "moved only",
+			guild: func(synced map[string]*discordgo.Role) []*discordgo.Role {
+				synced["Test Corp"].Position = 5
+				return []*discordgo.Role{everyone, synced["Test Corp"], synced["New Corp"]}
+			},
+		},
+		{
+			name: "recreated by hand",
+			guild: func(synced map[string]*discordgo.Role) []*discordgo.Role {
+				synced["New Corp"].ID = "99"
+				return []*discordgo.Role{everyone, synced["Test Corp"], synced["New Corp"]}
+			},
+			moved: []string{"New Corp"},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			deps := newRoleDependencies(t)
+
+			if c.unmanageable != "" {
+				if err := deps.Storage.UpdateRoleManageable(commontest.Context(), c.unmanageable, false); err != nil {
+					t.Fatalf("UpdateRoleManageable: %s", err)
+				}
+			}
+
+			guild := &commontest.Guild{Roles: c.guild(syncedRoles(t, deps))}
+			guild.Serve(deps)
+
+			plan, err := authEsiPoller{dependencies: deps}.planRoles(commontest.Context())
+			if err != nil {
+				t.Fatalf("planRoles: %s", err)
+			}
+
+			for _, check := range []struct {
+				what      string
+				got, want []string
+			}{
+				{"delete", names(plan.Delete), c.delete},
+				{"add", names(plan.Add), c.add},
+				{"update", names(plan.Update), c.update},
+				{"moved", names(plan.moved), c.moved},
+			} {
+				if !reflect.DeepEqual(check.got, check.want) {
+					t.Errorf("%s: got %v, want %v", check.what, check.got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderRoles(t *testing.T) {
+	cases := []struct {
+		name string
+		// positions are where Test Corp (42) and New Corp (43) are in discord
+		positions map[string]int
+		want      []payloads.Role
+	}{
+		{
+			name:      "in order",
+			positions: map[string]int{"43": 5, "42": 4},
+		},
+		{
+			name:      "out of order",
+			positions: map[string]int{"42": 5, "43": 4},
+			want:      []payloads.Role{{ID: "43", Name: "New Corp", Position: 9}, {ID: "42", Name: "Test Corp", Position: 8}},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := commontest.Context()
+			deps := newRoleDependencies(t)
+
+			// The bot's top role is at 10
+			guild := &commontest.Guild{
+				Roles:   []*discordgo.Role{{ID: commontest.GuildID, Name: "@everyone"}, {ID: "10", Name: "Chremoas", Position: 10}},
+				Members: []*discordgo.Member{{User: &discordgo.User{ID: deps.BotID}, Roles: []string{"10"}}},
+			}
+
+			for _, role := range syncedRoles(t, deps) {
+				role.Position = c.positions[role.ID]
+				guild.Roles = append(guild.Roles, role)
+			}
+
+			guild.Serve(deps)
+
+			dbRoles, err := deps.Storage.GetRolesBySync(ctx, true)
+			if err != nil {
+				t.Fatalf("GetRolesBySync: %s", err)
+			}
+
+			// Skip the upserts from creating the roles
+			before := len(commontest.RoleMessages(t, deps))
+
+			reordered, err := authEsiPoller{dependencies: deps}.orderRoles(ctx, guild.Roles, dbRoles)
+			if err != nil {
+				t.Fatalf("orderRoles: %s", err)
+			}
+
+			messages := commontest.RoleMessages(t, deps)[before:]
+
+			if reordered != (c.want != nil) {
+				t.Errorf("reordered: got %t, want %t", reordered, c.want != nil)
+			}
+
+			if c.want == nil {
+				if len(messages) != 0 {
+					t.Errorf("role messages: got %+v, want none", messages)
+				}
+				return
+			}
+
+			if len(messages) != 1 || messages[0].Action != payloads.Reorder || !reflect.DeepEqual(messages[0].Roles, c.want) {
+				t.Errorf("role messages: got %+v, want a reorder to %+v", messages, c.want)
+			}
+		})
+	}
+}
+
+func TestRolePreviews(t *testing.T) {
+	previews := &rolePreviews{ids: make(map[string]string)}
+	previews.set("1000", "abcd1234")
+	previews.set("2000", "ffff0000")
+
+	if previews.take("1000", "") {
+		t.Error("took an empty plan ID")
+	}
+
+	if previews.take("1000", "ffff0000") {
+		t.Error("took another guild's plan ID")
+	}
+
+	if !previews.take("1000", "abcd1234") {
+		t.Error("didn't take the previewed plan ID")
+	}
+
+	if previews.take("1000", "abcd1234") {
+		t.Error("took the same preview twice")
+	}
+
+	previews.set("2000", "eeee0000")
+	if previews.take("2000", "ffff0000") {
+		t.Error("took a plan ID a newer preview replaced")
+	}
+}
@@ -0,0 +1,259 @@
+package esi_poller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// Used when bot.esiPoller.tick isn't set, how often the poller checks which stages are due
+	defaultTick = time.Minute
+	// Used when a stage has no bot.esiPoller.schedule entry
+	defaultStageInterval = time.Hour
+	// Used when bot.esiPoller.workers isn't set
+	defaultWorkers = 8
+	// ESI's daily downtime, used when bot.esiPoller.downtime isn't set. Times are UTC.
+	defaultDowntimeStart    = "11:00"
+	defaultDowntimeDuration = 15 * time.Minute
+	// How many runs poll_runs keeps
+	pollRunsKept = 100
+	// How many errors each stage keeps in poll_runs
+	maxSampledErrors = 5
+)
+
+// Stages are the poll's stages in the order they run.
+var Stages = []string{"standings", "roles", "alliances", "corporations", "characters", "members", "policy", "stats"}
+
+type contextKey int
+
+const (
+	forcedStagesKey contextKey = iota
+	errorSampleKey
+)
+
+// stage is one step of a poll. Stages that call ESI are skipped during downtime.
+type stage struct {
+	name string
+	esi  bool
+}
+
+// stageInterval is how often the stage runs, from bot.esiPoller.schedule.<name>, e.g. "30m".
+func stageInterval(name string) time.Duration {
+	interval := viper.GetDuration(fmt.Sprintf("bot.esiPoller.schedule.%s", name))
+	if interval <= 0 {
+		return defaultStageInterval
+	}
+
+	return interval
+}
+
+func pollTick() time.Duration {
+	tick := viper.GetDuration("bot.esiPoller.tick")
+	if tick <= 0 {
+		return defaultTick
+	}
+
+	return tick
+}
+
+func pollWorkers() int {
+	workers := viper.GetInt("bot.esiPoller.workers")
+	if workers <= 0 {
+		return defaultWorkers
+	}
+
+	return workers
+}
+
+// inDowntime is true during ESI's daily downtime, bot.esiPoller.downtime.start (UTC) for
+// bot.esiPoller.downtime.duration.
+func inDowntime(now time.Time) (bool, error) {
+	start := viper.GetString("bot.esiPoller.downtime.start")
+	if start == "" {
+		start = defaultDowntimeStart
+	}
+
+	duration := defaultDowntimeDuration
+	if viper.IsSet("bot.esiPoller.downtime.duration") {
+		duration = viper.GetDuration("bot.esiPoller.downtime.duration")
+	}
+
+	startTime, err := time.Parse("15:04", start)
+	if err != nil {
+		return false, fmt.Errorf("bad bot.esiPoller.downtime.start: %w", err)
+	}
+
+	now = now.UTC()
+	downtime := time.Date(now.Year(), now.Month(), now.Day(), startTime.Hour(), startTime.Minute(), 0, 0, time.UTC)
+
+	return !now.Before(downtime) && now.Before(downtime.Add(duration)), nil
+}
+
+// WithStages makes Poll run the stages now whether they're due or not, every stage if none are given. Polls run this
+// way are recorded as manual.
+func WithStages(ctx context.Context, stages ...string) context.Context {
+	if len(stages) == 0 {
+		stages = Stages
+	}
+
+	forced := make(map[string]bool, len(stages))
+	for _, s := range stages {
+		forced[s] = true
+	}
+
+	return context.WithValue(ctx, forcedStagesKey, forced)
+}
+
+func forced(ctx context.Context, name string) bool {
+	stages, ok := ctx.Value(forcedStagesKey).(map[string]bool)
+	return ok && stages[name]
+}
+
+func pollTrigger(ctx context.Context) string {
+	if _, ok := ctx.Value(forcedStagesKey).(map[string]bool); ok {
+		return "manual"
+	}
+
+	return "schedule"
+}
+
+// errorSample keeps the first few errors a stage hits for poll_runs.
+type errorSample struct {
+	mutex  sync.Mutex
+	errors []string
+}
+
+func (e *errorSample) add(err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.errors) < maxSampledErrors {
+		e.errors = append(e.errors, err.Error())
+	}
+}
+
+func withErrorSample(ctx context.Context, sample *errorSample) context.Context {
+	return context.WithValue(ctx, errorSampleKey, sample)
+}
+
+// sampleError adds the error to the running stage's sample, if there is one.
+func sampleError(ctx context.Context, err error) {
+	if sample, ok := ctx.Value(errorSampleKey).(*errorSample); ok {
+		sample.add(err)
+	}
+}
+
+// detached keeps the values of a context, like the logger, but not its cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// pollGroup tracks the polls in flight, scheduled or started with !poller run, so Stop can cancel them and wait.
+type pollGroup struct {
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+	next    int
+	cancels map[int]context.CancelFunc
+}
+
+// add registers a poll. The returned context is cancelled by cancel, finish must be called when the poll returns.
+func (g *pollGroup) add(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	id := g.next
+	g.next += 1
+	g.cancels[id] = cancel
+	g.wg.Add(1)
+
+	return ctx, func() {
+		g.mutex.Lock()
+		delete(g.cancels, id)
+		g.mutex.Unlock()
+
+		cancel()
+		g.wg.Done()
+	}
+}
+
+func (g *pollGroup) cancel() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for _, cancel := range g.cancels {
+		cancel()
+	}
+
+	return len(g.cancels)
+}
+
+// wait waits for the polls to finish until ctx is done, false if some are still running.
+func (g *pollGroup) wait(ctx context.Context) bool {
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// forEach calls fn for 0 to n-1 on at most bot.esiPoller.workers goroutines and returns how many succeeded and
+// failed. It stops handing out work once ctx is done.
+func forEach(ctx context.Context, n int, fn func(ctx context.Context, i int) error) (int, int) {
+	var (
+		count      int
+		errorCount int
+		mutex      sync.Mutex
+		wg         sync.WaitGroup
+		workers    = make(chan struct{}, pollWorkers())
+	)
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return count, errorCount
+		case workers <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			err := fn(ctx, i)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				sampleError(ctx, err)
+				errorCount += 1
+			} else {
+				count += 1
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	return count, errorCount
+}
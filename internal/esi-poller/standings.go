@@ -0,0 +1,140 @@
+package esi_poller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+	"github.com/antihax/goesi/optional"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// updateStandings reloads the contact list the access policy uses for standings, from the alliance or corporation in
+// bot.accessPolicy.standings. The refresh token needs esi-alliances.read_contacts.v1 or
+// esi-corporations.read_contacts.v1 from a character in it.
+func (aep *authEsiPoller) updateStandings(ctx context.Context) (int, int, error) {
+	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
+	defer sp.Close()
+
+	sp.With(zap.String("sub-component", "standings"))
+
+	if !common.StandingsEnabled() {
+		sp.Debug("standings aren't configured")
+		return 0, 0, nil
+	}
+
+	var (
+		contactType  = viper.GetString("bot.accessPolicy.standings.type")
+		id           = int32(viper.GetInt("bot.accessPolicy.standings.id"))
+		refreshToken = viper.GetString("bot.accessPolicy.standings.refreshToken")
+		standings    []payloads.Standing
+	)
+
+	sp.With(
+		zap.String("type", contactType),
+		zap.Int32("id", id),
+	)
+
+	if refreshToken == "" {
+		return -1, -1, fmt.Errorf("bot.accessPolicy.standings.refreshToken isn't set")
+	}
+
+	tokenSource := aep.ssoAuth.TokenSource(&oauth2.Token{RefreshToken: refreshToken})
+	authCtx := context.WithValue(ctx, goesi.ContextOAuth2, tokenSource)
+
+	// Contacts come a page at a time, the whole list is needed before it replaces the stored one
+	var getPage func(page int32) ([]payloads.Standing, *http.Response, error)
+
+	switch contactType {
+	case "alliance":
+		getPage = func(page int32) ([]payloads.Standing, *http.Response, error) {
+			contacts, response, err := aep.esiClient.ESI.ContactsApi.GetAlliancesAllianceIdContacts(authCtx, id,
+				&esi.GetAlliancesAllianceIdContactsOpts{Page: optional.NewInt32(page)})
+			if err != nil {
+				sp.Error("Error calling GetAlliancesAllianceIdContacts", zap.Int32("page", page), zap.Error(err))
+				return nil, response, err
+			}
+
+			var standings []payloads.Standing
+			for _, contact := range contacts {
+				standings = append(standings, payloads.Standing{
+					ContactID:   contact.ContactId,
+					ContactType: contact.ContactType,
+					Standing:    contact.Standing,
+				})
+			}
+
+			return standings, response, nil
+		}
+
+	case "corporation":
+		getPage = func(page int32) ([]payloads.Standing, *http.Response, error) {
+			contacts, response, err := aep.esiClient.ESI.ContactsApi.GetCorporationsCorporationIdContacts(authCtx, id,
+				&esi.GetCorporationsCorporationIdContactsOpts{Page: optional.NewInt32(page)})
+			if err != nil {
+				sp.Error("Error calling GetCorporationsCorporationIdContacts", zap.Int32("page", page), zap.Error(err))
+				return nil, response, err
+			}
+
+			var standings []payloads.Standing
+			for _, contact := range contacts {
+				standings = append(standings, payloads.Standing{
+					ContactID:   contact.ContactId,
+					ContactType: contact.ContactType,
+					Standing:    contact.Standing,
+				})
+			}
+
+			return standings, response, nil
+		}
+
+	default:
+		return -1, -1, fmt.Errorf("unknown bot.accessPolicy.standings.type: %s", contactType)
+	}
+
+	for page, pages := int32(1), int32(1); page <= pages; page++ {
+		pageStandings, response, err := getPage(page)
+		if err != nil {
+			return -1, -1, err
+		}
+
+		standings = append(standings, pageStandings...)
+
+		pages, err = esiPages(response)
+		if err != nil {
+			sp.Error("Error reading X-Pages", zap.Error(err))
+			return -1, -1, err
+		}
+	}
+
+	err := aep.dependencies.Storage.ReplaceStandings(ctx, standings)
+	if err != nil {
+		sp.Error("Error replacing standings", zap.Error(err))
+		return -1, -1, err
+	}
+
+	return len(standings), 0, nil
+}
+
+// esiPages returns how many pages a paginated ESI response has, 1 if it doesn't say.
+func esiPages(response *http.Response) (int32, error) {
+	header := response.Header.Get("X-Pages")
+	if header == "" {
+		return 1, nil
+	}
+
+	pages, err := strconv.ParseInt(header, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad X-Pages header %q: %w", header, err)
+	}
+
+	return int32(pages), nil
+}
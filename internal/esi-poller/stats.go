@@ -0,0 +1,39 @@
+package esi_poller
+
+import (
+	"context"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"go.uber.org/zap"
+)
+
+// snapshotMembership stores today's member counts for every guild for !stats. Each run replaces the counts already
+// taken today, so the day ends up with the last run's.
+func (aep *authEsiPoller) snapshotMembership(ctx context.Context) (int, int, error) {
+	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
+	defer sp.Close()
+
+	sp.With(zap.String("sub-component", "stats"))
+
+	var (
+		count      int
+		errorCount int
+		today      = time.Now().UTC()
+	)
+
+	for _, guild := range common.Guilds() {
+		c, err := aep.dependencies.ForGuild(guild.ID).Storage.SnapshotMembership(ctx, today)
+		if err != nil {
+			sp.Error("error taking membership snapshot", zap.String("guild_id", guild.ID), zap.Error(err))
+			sampleError(ctx, err)
+			errorCount += 1
+			continue
+		}
+
+		count += c
+	}
+
+	return count, errorCount, nil
+}
@@ -0,0 +1,211 @@
+package esicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"github.com/gregjones/httpcache"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	serverAdmins = "server_admins"
+	// Used when bot.esiCache.maxSize (in MB) isn't set
+	defaultMaxSize = 256
+	// Used when bot.esiCache.maxAge isn't set, entries nobody has used for this long are evicted
+	defaultMaxAge = 7 * 24 * time.Hour
+	// Eviction runs once every this many writes
+	evictEvery = 100
+)
+
+// Cache is an httpcache.Cache kept in the database so ESI responses and their ETags survive restarts. httpcache does
+// the Expires and ETag handling, this only stores what it gives us.
+type Cache struct {
+	dependencies common.Dependencies
+	ctx          context.Context
+
+	hits   uint64
+	misses uint64
+	writes uint64
+}
+
+func New(ctx context.Context, deps common.Dependencies) *Cache {
+	return &Cache{
+		dependencies: deps,
+		ctx:          ctx,
+	}
+}
+
+// Transport caches responses from next, usually the transport that talks to ESI. Requests with an Authorization
+// header go straight to next, their responses belong to one character and the cache is keyed by URL only and shared
+// by every replica.
+func (c *Cache) Transport(next http.RoundTripper) http.RoundTripper {
+	transport := httpcache.NewTransport(c)
+	transport.Transport = next
+
+	return &authBypass{cached: transport, next: next}
+}
+
+// Client is a cached http.Client using the default transport.
+func (c *Cache) Client() *http.Client {
+	return &http.Client{Transport: c.Transport(http.DefaultTransport)}
+}
+
+type authBypass struct {
+	cached http.RoundTripper
+	next   http.RoundTripper
+}
+
+func (a *authBypass) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return a.next.RoundTrip(req)
+	}
+
+	return a.cached.RoundTrip(req)
+}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	ctx, sp := sl.OpenSpan(c.ctx)
+	defer sp.Close()
+
+	value, err := c.dependencies.Storage.GetCacheEntry(ctx, key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNoCacheEntry) {
+			sp.Error("Error getting cache entry", zap.String("key", key), zap.Error(err))
+		}
+
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	return value, true
+}
+
+func (c *Cache) Set(key string, value []byte) {
+	ctx, sp := sl.OpenSpan(c.ctx)
+	defer sp.Close()
+
+	err := c.dependencies.Storage.UpsertCacheEntry(ctx, key, value)
+	if err != nil {
+		sp.Error("Error setting cache entry", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	if atomic.AddUint64(&c.writes, 1)%evictEvery == 0 {
+		c.evict(ctx)
+	}
+}
+
+func (c *Cache) Delete(key string) {
+	ctx, sp := sl.OpenSpan(c.ctx)
+	defer sp.Close()
+
+	err := c.dependencies.Storage.DeleteCacheEntry(ctx, key)
+	if err != nil {
+		sp.Error("Error deleting cache entry", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func (c *Cache) evict(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	evicted, err := c.dependencies.Storage.EvictCache(ctx, maxSize(), maxAge())
+	if err != nil {
+		sp.Error("Error evicting cache entries", zap.Error(err))
+		return
+	}
+
+	sp.Debug("Evicted cache entries", zap.Int64("evicted", evicted))
+}
+
+// maxSize is bot.esiCache.maxSize in bytes.
+func maxSize() int64 {
+	size := viper.GetInt64("bot.esiCache.maxSize")
+	if size <= 0 {
+		size = defaultMaxSize
+	}
+
+	return size * 1024 * 1024
+}
+
+func maxAge() time.Duration {
+	age := viper.GetDuration("bot.esiCache.maxAge")
+	if age <= 0 {
+		return defaultMaxAge
+	}
+
+	return age
+}
+
+// Stats tells an admin how big the cache is and how well it's doing since the bot started.
+func (c *Cache) Stats(ctx context.Context, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("author", author))
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	stats, err := deps.Storage.GetCacheStats(ctx)
+	if err != nil {
+		sp.Error("Error getting cache stats", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting cache stats: %s", err)
+	}
+
+	hits := atomic.LoadUint64(&c.hits)
+	misses := atomic.LoadUint64(&c.misses)
+
+	var hitRate float64
+	if hits+misses > 0 {
+		hitRate = float64(hits) * 100 / float64(hits+misses)
+	}
+
+	embed := common.NewEmbed()
+	embed.SetTitle("ESI Cache")
+	embed.AddField("Entries", fmt.Sprintf("%d", stats.Entries))
+	embed.AddField("Size", fmt.Sprintf("%.1f MB of %d MB", float64(stats.Size)/1024/1024, maxSize()/1024/1024))
+	embed.AddField("Hit rate", fmt.Sprintf("%.1f%% (%d hits, %d misses since start)", hitRate, hits, misses))
+
+	if stats.LastAccessed != nil {
+		embed.AddField("Last used", stats.LastAccessed.Format(time.RFC3339))
+	}
+
+	return []*discordgo.MessageSend{{Embed: embed.GetMessageEmbed()}}
+}
+
+// Flush empties the cache, the next calls go to ESI without ETags.
+func (c *Cache) Flush(ctx context.Context, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("author", author))
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	flushed, err := deps.Storage.FlushCache(ctx)
+	if err != nil {
+		sp.Error("Error flushing cache", zap.Error(err))
+		return common.SendErrorf(&author, "Error flushing cache: %s", err)
+	}
+
+	sp.Info("Flushed ESI cache", zap.Int64("entries", flushed))
+	return common.SendSuccessf(&author, "Flushed %d cache entries", flushed)
+}
@@ -0,0 +1,88 @@
+package esicache_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/esicache"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+)
+
+// countingTransport answers every request with a response ESI says can be cached for five minutes.
+type countingTransport struct {
+	calls int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.calls++
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control": []string{"max-age=300"},
+			"Date":          []string{time.Now().UTC().Format(http.TimeFormat)},
+		},
+		Body:    ioutil.NopCloser(strings.NewReader(`{"name":"Test Character"}`)),
+		Request: req,
+	}, nil
+}
+
+func TestTransport(t *testing.T) {
+	const url = "https://esi.evetech.net/latest/characters/123/"
+
+	cases := []struct {
+		name          string
+		authorization string
+		// calls is how many of the two requests reach ESI
+		calls int
+		// cached is whether the response is stored
+		cached bool
+	}{
+		{name: "public", calls: 1, cached: true},
+		{name: "authenticated", authorization: "Bearer token", calls: 2},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := commontest.Context()
+			deps := commontest.Dependencies(t)
+			next := &countingTransport{}
+			client := &http.Client{Transport: esicache.New(ctx, deps).Transport(next)}
+
+			for i := 0; i < 2; i++ {
+				req, err := http.NewRequest(http.MethodGet, url, nil)
+				if err != nil {
+					t.Fatalf("NewRequest: %s", err)
+				}
+
+				if c.authorization != "" {
+					req.Header.Set("Authorization", c.authorization)
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("Do: %s", err)
+				}
+
+				// httpcache stores the response once the body is read
+				_, _ = ioutil.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+			}
+
+			if next.calls != c.calls {
+				t.Errorf("calls to ESI: got %d, want %d", next.calls, c.calls)
+			}
+
+			_, err := deps.Storage.GetCacheEntry(ctx, url)
+			if cached := !errors.Is(err, storage.ErrNoCacheEntry); cached != c.cached {
+				t.Errorf("cached: got %t (%v), want %t", cached, err, c.cached)
+			}
+		})
+	}
+}
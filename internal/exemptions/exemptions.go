@@ -0,0 +1,170 @@
+package exemptions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"go.uber.org/zap"
+)
+
+const serverAdmins = "server_admins"
+
+// List shows the members the member policy skips.
+func List(ctx context.Context, channelID string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var exemptionList []string
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	exemptions, err := deps.Storage.GetMemberPolicyExemptions(ctx)
+	if err != nil {
+		sp.Error("Error getting exemptions", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting exemptions: %s", err)
+	}
+
+	for _, exemption := range exemptions {
+		if exemption.Reason == "" {
+			exemptionList = append(exemptionList, fmt.Sprintf("<@%s>", exemption.ChatID))
+			continue
+		}
+
+		exemptionList = append(exemptionList, fmt.Sprintf("<@%s>: %s", exemption.ChatID, exemption.Reason))
+	}
+
+	if len(exemptionList) == 0 {
+		return common.SendError(nil, "No exemptions")
+	}
+
+	err = common.SendChunkedMessage(ctx, channelID, "Member Policy Exemptions", exemptionList, deps)
+	if err != nil {
+		sp.Error("Error sending chunked message")
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// Add stops the member policy from touching a member.
+func Add(ctx context.Context, user, reason, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("required_permission", serverAdmins),
+		zap.String("user", user),
+		zap.String("reason", reason),
+		zap.String("author", author),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	if !common.IsDiscordUser(user) {
+		sp.Warn("second argument must be a discord user")
+		return common.SendError(&author, "second argument must be a discord user")
+	}
+
+	userID := common.ExtractUserId(user)
+
+	err := deps.Storage.InsertMemberPolicyExemption(ctx, userID, reason)
+	if err != nil {
+		if errors.Is(err, storage.ErrExemptionExists) {
+			return common.SendErrorf(&author, "<@%s> is already exempt", userID)
+		}
+
+		sp.Error("Error inserting exemption", zap.Error(err))
+		return common.SendErrorf(&author, "Error inserting exemption: %s", err)
+	}
+
+	sp.Info("exempted user from the member policy")
+	err = common.Audit(ctx, common.Change{
+		Action:     "add",
+		TargetType: "exemption",
+		Target:     userID,
+		MemberID:   userID,
+		After:      map[string]string{"reason": reason},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording exemption: %s", err)
+	}
+
+	return common.SendSuccessf(nil, "Exempted %s from the member policy", common.GetUsername(userID, deps.Session))
+}
+
+// Remove puts a member back under the member policy.
+func Remove(ctx context.Context, user, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("required_permission", serverAdmins),
+		zap.String("user", user),
+		zap.String("author", author),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	if !common.IsDiscordUser(user) {
+		sp.Warn("second argument must be a discord user")
+		return common.SendError(&author, "second argument must be a discord user")
+	}
+
+	userID := common.ExtractUserId(user)
+
+	exemptions, err := deps.Storage.GetMemberPolicyExemptions(ctx)
+	if err != nil {
+		sp.Error("Error getting exemptions", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting exemptions: %s", err)
+	}
+
+	var before interface{}
+	for _, exemption := range exemptions {
+		if exemption.ChatID == userID {
+			before = map[string]string{"reason": exemption.Reason}
+		}
+	}
+
+	err = deps.Storage.DeleteMemberPolicyExemption(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoExemption) {
+			return common.SendErrorf(&author, "<@%s> isn't exempt", userID)
+		}
+
+		sp.Error("Error deleting exemption", zap.Error(err))
+		return common.SendErrorf(&author, "Error deleting exemption: %s", err)
+	}
+
+	sp.Info("removed user's member policy exemption")
+	err = common.Audit(ctx, common.Change{
+		Action:     "remove",
+		TargetType: "exemption",
+		Target:     userID,
+		MemberID:   userID,
+		Before:     before,
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording exemption removal: %s", err)
+	}
+
+	return common.SendSuccessf(nil, "Removed %s's member policy exemption", common.GetUsername(userID, deps.Session))
+}
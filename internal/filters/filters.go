@@ -90,6 +90,16 @@ func Add(ctx context.Context, name, description string, deps common.Dependencies
 	sp.With(zap.Int("id", id))
 
 	sp.Info("created filter")
+	err = common.Audit(ctx, common.Change{
+		Action:     "create",
+		TargetType: "filter",
+		Target:     name,
+		After:      map[string]string{"description": description},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording filter creation: %s", err), -1
+	}
+
 	return common.SendSuccessf(nil, "Created filter `%s`", name), id
 }
 
@@ -133,6 +143,14 @@ func Delete(ctx context.Context, name string, deps common.Dependencies) []*disco
 	}
 
 	sp.Info("deleted filter", zap.Int("id", id))
+	err = common.Audit(ctx, common.Change{
+		Action:     "delete",
+		TargetType: "filter",
+		Target:     name,
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording filter deletion: %s", err)
+	}
 
 	return common.SendSuccessf(nil, "Deleted filter `%s`", name)
 }
@@ -248,6 +266,18 @@ func AddMember(ctx context.Context, userID, filter string, deps common.Dependenc
 		return common.SendFatalf(nil, "Error getting membership: %s", err)
 	}
 
+	err = common.Audit(ctx, common.Change{
+		Action:     "add_member",
+		TargetType: "filter",
+		Target:     filter,
+		MemberID:   userID,
+		Before:     map[string]interface{}{"roles": before.ToSlice()},
+		After:      map[string]interface{}{"roles": after.ToSlice()},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording filter membership: %s", err)
+	}
+
 	addSet := after.Difference(before)
 
 	if addSet.Len() == 0 {
@@ -262,9 +292,7 @@ func AddMember(ctx context.Context, userID, filter string, deps common.Dependenc
 		)
 	}
 
-	for _, role := range addSet.ToSlice() {
-		QueueUpdate(ctx, payloads.Upsert, userID, role, deps)
-	}
+	QueueSync(ctx, userID, deps)
 
 	sp.Info("added user to filter")
 	return common.SendSuccessf(nil, "Added <@%s> to `%s`", userID, filter)
@@ -319,11 +347,11 @@ func RemoveMember(ctx context.Context, userID, filterName string, deps common.De
 	filter, err := deps.Storage.GetFilter(ctx, filterName)
 	if err != nil {
 		if errors.Is(err, storage.ErrNoFilter) {
-			return common.SendErrorf(nil, "No such filter: %s", filter)
+			return common.SendErrorf(nil, "No such filter: %s", filterName)
 		}
 
 		sp.Error("error getting filter")
-		return common.SendErrorf(nil, "Error getting filter: %s", filter)
+		return common.SendErrorf(nil, "Error getting filter: %s", filterName)
 	}
 
 	sp.With(zap.Int("filter_id", filter.ID))
@@ -335,7 +363,7 @@ func RemoveMember(ctx context.Context, userID, filterName string, deps common.De
 			nil,
 			"Error removing %s from filter %s membership",
 			common.GetUsername(userID, deps.Session),
-			filter,
+			filter.Name,
 		)
 	}
 
@@ -345,15 +373,25 @@ func RemoveMember(ctx context.Context, userID, filterName string, deps common.De
 		return common.SendFatalf(nil, "Error getting membership: %s", err)
 	}
 
+	err = common.Audit(ctx, common.Change{
+		Action:     "remove_member",
+		TargetType: "filter",
+		Target:     filter.Name,
+		MemberID:   userID,
+		Before:     map[string]interface{}{"roles": before.ToSlice()},
+		After:      map[string]interface{}{"roles": after.ToSlice()},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording filter membership removal: %s", err)
+	}
+
 	removeSet := before.Difference(after)
 
 	if removeSet.Len() == 0 {
 		return common.SendErrorf(nil, "<@%s> not a member of `%s`", userID, filter.Name)
 	}
 
-	for _, role := range removeSet.ToSlice() {
-		QueueUpdate(ctx, payloads.Delete, userID, role, deps)
-	}
+	QueueSync(ctx, userID, deps)
 
 	sp.Info("removed user from filter")
 	return common.SendSuccessf(
@@ -364,21 +402,35 @@ func RemoveMember(ctx context.Context, userID, filterName string, deps common.De
 	)
 }
 
-func QueueUpdate(ctx context.Context, action payloads.Action, memberID, roleID string, deps common.Dependencies) {
+// QueueSync asks the members consumer to reconcile all of a member's roles. Only one message per guild is needed
+// however many roles changed, and messages for the same member that are still waiting when a sync runs are dropped by
+// the consumer. Filters are shared so every guild is synced.
+func QueueSync(ctx context.Context, memberID string, deps common.Dependencies) {
+	for _, guild := range common.Guilds() {
+		QueueGuildSync(ctx, guild.ID, memberID, deps)
+	}
+}
+
+// QueueGuildSync is QueueSync for a single guild.
+func QueueGuildSync(ctx context.Context, guildID, memberID string, deps common.Dependencies) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
+	// The count is bumped with the change when deps is in a transaction, a message without one is never skipped
+	seq, err := deps.ForGuild(guildID).Storage.BumpMemberSync(ctx, memberID)
+	if err != nil {
+		sp.Error("error bumping member sync count", zap.Error(err))
+	}
+
 	payload := payloads.MemberPayload{
-		Action:   action,
-		GuildID:  deps.GuildID,
+		Action:   payloads.Sync,
+		GuildID:  guildID,
 		MemberID: memberID,
-		RoleID:   roleID,
+		Seq:      seq,
 	}
 
 	sp.With(
-		zap.Any("action", action),
 		zap.String("member_id", memberID),
-		zap.String("role_id", roleID),
 		zap.Any("payload", payload),
 	)
 
@@ -388,11 +440,6 @@ func QueueUpdate(ctx context.Context, action payloads.Action, memberID, roleID s
 		return
 	}
 
-	if roleID == "0" {
-		// no point submitting a message as it'll be ignored anyway
-		return
-	}
-
 	sp.Debug("Submitting member queue message")
 	err = deps.MembersProducer.Publish(ctx, b)
 	if err != nil {
@@ -0,0 +1,190 @@
+package filters_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/filters"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+// newDependencies has a role TEST whose filter is TEST and a filter PLAIN that isn't any role's.
+func newDependencies(t *testing.T) common.Dependencies {
+	t.Helper()
+
+	deps := commontest.Dependencies(t)
+	commontest.AddRole(t, deps, "TEST", "Test Corp", "42")
+
+	if _, err := deps.Storage.InsertFilter(commontest.Context(), "PLAIN", "Not a role's filter"); err != nil {
+		t.Fatalf("InsertFilter: %s", err)
+	}
+
+	return deps
+}
+
+func TestAddMember(t *testing.T) {
+	cases := []struct {
+		name   string
+		setup  func(t *testing.T, deps common.Dependencies)
+		user   string
+		filter string
+		reply  string
+		// member is whether 123 is in the filter afterwards
+		member bool
+		// synced is whether a member sync is queued
+		synced bool
+	}{
+		{
+			name:   "not a user",
+			user:   "bob",
+			filter: "TEST",
+			reply:  "second argument must be a discord user",
+		},
+		{
+			name:   "no such filter",
+			user:   "123",
+			filter: "NOPE",
+			reply:  "No such filter: NOPE",
+		},
+		{
+			name:   "role filter",
+			user:   "123",
+			filter: "TEST",
+			reply:  "Added <@123> to `TEST`",
+			member: true,
+			synced: true,
+		},
+		{
+			name:   "mention",
+			user:   "<@123>",
+			filter: "TEST",
+			reply:  "Added <@123> to `TEST`",
+			member: true,
+			synced: true,
+		},
+		{
+			name:   "already a member",
+			setup:  func(t *testing.T, deps common.Dependencies) { commontest.AddFilterMember(t, deps, "TEST", "123") },
+			user:   "123",
+			filter: "TEST",
+			reply:  "123 Already member of TEST",
+			member: true,
+		},
+		{
+			name:   "filter without a role",
+			user:   "123",
+			filter: "PLAIN",
+			reply:  "123 already a member of PLAIN (maybe)",
+			member: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			deps := newDependencies(t)
+
+			if c.setup != nil {
+				c.setup(t, deps)
+			}
+
+			reply := commontest.Reply(filters.AddMember(commontest.Context(), c.user, c.filter, deps))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			if c.filter != "NOPE" {
+				m := commontest.FilterMembers(t, deps, c.filter)
+				if c.member != (len(m) == 1 && m[0] == 123) {
+					t.Errorf("members of %s: got %v, want member %t", c.filter, m, c.member)
+				}
+			}
+
+			checkSynced(t, deps, c.synced)
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	cases := []struct {
+		name   string
+		member bool
+		filter string
+		reply  string
+		synced bool
+	}{
+		{
+			name:   "no such filter",
+			filter: "NOPE",
+			reply:  "No such filter: NOPE",
+		},
+		{
+			name:   "member",
+			member: true,
+			filter: "TEST",
+			reply:  "Removed 123 from `TEST`",
+			synced: true,
+		},
+		{
+			name:   "not a member",
+			filter: "TEST",
+			reply:  "<@123> not a member of `TEST`",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			deps := newDependencies(t)
+
+			if c.member {
+				commontest.AddFilterMember(t, deps, c.filter, "123")
+			}
+
+			reply := commontest.Reply(filters.RemoveMember(commontest.Context(), "123", c.filter, deps))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			if c.filter != "NOPE" {
+				if m := commontest.FilterMembers(t, deps, c.filter); len(m) != 0 {
+					t.Errorf("members of %s: got %v, want none", c.filter, m)
+				}
+			}
+
+			checkSynced(t, deps, c.synced)
+		})
+	}
+}
+
+// checkSynced checks a sync of 123 is queued for the guild if synced is true and nothing is queued if it isn't.
+func checkSynced(t *testing.T, deps common.Dependencies, synced bool) {
+	t.Helper()
+
+	messages := commontest.MemberMessages(t, deps)
+	if !synced {
+		if len(messages) != 0 {
+			t.Errorf("member messages: got %+v, want none", messages)
+		}
+		return
+	}
+
+	want := payloads.MemberPayload{
+		Action:   payloads.Sync,
+		GuildID:  commontest.GuildID,
+		MemberID: "123",
+		Seq:      1,
+	}
+
+	if len(messages) != 1 {
+		t.Fatalf("member messages: got %+v, want one sync", messages)
+	}
+
+	got := messages[0]
+	got.CorrelationID = ""
+	if got != want {
+		t.Errorf("member message: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,256 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// Used when bot.leader.lockKey isn't set, every replica has to use the same key
+	defaultLockKey = 0x6368726d
+	// Used when bot.leader.interval isn't set, how often the leader checks it still has the lock and the others try
+	// to take it
+	defaultInterval = 15 * time.Second
+)
+
+// Elector makes sure only one replica does the work that mustn't run twice, like the poller and role sync. The
+// leader is whoever holds a Postgres session advisory lock on a connection of its own. Postgres drops the lock when
+// that session ends, so if the leader dies another replica takes over on its next try, within bot.leader.interval.
+// The leader checks the lock every interval and stands down as soon as the check fails.
+type Elector struct {
+	db       *sql.DB
+	key      int64
+	interval time.Duration
+
+	onElected func(ctx context.Context)
+	onDemoted func(ctx context.Context)
+
+	conn *sql.Conn
+	// leading is read by IsLeader from other goroutines
+	mutex   sync.Mutex
+	leading bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New sets up an elector. onElected is called when this replica becomes the leader and onDemoted when it stops
+// being the leader, including on Stop.
+func New(db *sql.DB, onElected, onDemoted func(ctx context.Context)) *Elector {
+	key := viper.GetInt64("bot.leader.lockKey")
+	if key == 0 {
+		key = defaultLockKey
+	}
+
+	interval := viper.GetDuration("bot.leader.interval")
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Elector{
+		db:        db,
+		key:       key,
+		interval:  interval,
+		onElected: onElected,
+		onDemoted: onDemoted,
+	}
+}
+
+// Start tries to take the lock now and then every interval until Stop is called.
+func (e *Elector) Start(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("component", "leader"), zap.Int64("lock_key", e.key))
+
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+
+	sp.Info("Starting leader election", zap.Duration("interval", e.interval))
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		e.check(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.check(ctx)
+			}
+		}
+	}()
+}
+
+// IsLeader returns true while this replica is the leader. The leader's work has been started by the time it does.
+func (e *Elector) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.leading
+}
+
+// Stop stands down if this replica is the leader and releases the lock so another replica can take over straight
+// away.
+func (e *Elector) Stop(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	if e.cancel == nil {
+		return
+	}
+
+	e.cancel()
+	<-e.done
+	e.cancel = nil
+
+	if e.IsLeader() {
+		e.demote(ctx)
+	}
+
+	sp.Info("Leader election stopped")
+}
+
+// check renews the lock if we're the leader and tries to take it if we aren't.
+func (e *Elector) check(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	// Don't let a hung connection hold up the check past the next one
+	checkCtx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+
+	if e.IsLeader() {
+		held, err := e.held(checkCtx)
+		if err != nil || !held {
+			sp.Warn("Lost the leader lock, standing down", zap.Bool("held", held), zap.Error(err))
+			e.demote(ctx)
+		}
+
+		return
+	}
+
+	acquired, err := e.acquire(checkCtx)
+	if err != nil {
+		sp.Error("Error trying to take the leader lock", zap.Error(err))
+		e.closeConn(ctx)
+		return
+	}
+
+	if !acquired {
+		sp.Debug("Another replica is the leader")
+		return
+	}
+
+	sp.Info("Elected leader")
+	e.onElected(ctx)
+	e.setLeading(true)
+}
+
+func (e *Elector) acquire(ctx context.Context) (bool, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	if e.conn == nil {
+		conn, err := e.db.Conn(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		e.conn = conn
+	}
+
+	var acquired bool
+
+	sp.Debug("acquire(): sql query", zap.String("query", "SELECT pg_try_advisory_lock($1)"), zap.Int64("key", e.key))
+	err := e.conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired)
+	if err != nil {
+		return false, err
+	}
+
+	return acquired, nil
+}
+
+// held checks our session still has the lock. A broken connection means the session, and the lock with it, is gone
+// or soon will be.
+func (e *Elector) held(ctx context.Context) (bool, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	// The 64 bit key is split across classid and objid
+	query := `SELECT COUNT(*) FROM pg_locks
+		WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid()
+		AND classid = ($1::bigint >> 32)::oid AND objid = ($1::bigint & 4294967295)::oid AND objsubid = 1`
+
+	var count int
+
+	sp.Debug("held(): sql query", zap.String("query", query), zap.Int64("key", e.key))
+	err := e.conn.QueryRowContext(ctx, query, e.key).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// demote stops the leader's work before letting the lock go so the next leader never overlaps with us.
+func (e *Elector) demote(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	// Nothing new is let in while the leader's work stops
+	e.setLeading(false)
+	e.onDemoted(ctx)
+
+	if e.conn != nil {
+		// The context may already be cancelled if we're shutting down
+		unlockCtx, cancel := context.WithTimeout(context.Background(), e.interval)
+		defer cancel()
+
+		_, err := e.conn.ExecContext(unlockCtx, "SELECT pg_advisory_unlock($1)", e.key)
+		if err != nil {
+			sp.Warn("Error releasing the leader lock", zap.Error(err))
+		}
+	}
+
+	e.closeConn(ctx)
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.leading = leading
+}
+
+// closeConn throws the connection away rather than putting it back in the pool.
+func (e *Elector) closeConn(ctx context.Context) {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	if e.conn == nil {
+		return
+	}
+
+	// Back in the pool a session that still has the lock would keep it, returning ErrBadConn makes database/sql
+	// close it instead
+	err := e.conn.Raw(func(driverConn interface{}) error {
+		return driver.ErrBadConn
+	})
+	if err != nil && err != driver.ErrBadConn {
+		sp.Warn("Error discarding the leader connection", zap.Error(err))
+	}
+
+	_ = e.conn.Close()
+	e.conn = nil
+}
@@ -0,0 +1,155 @@
+package nicknames
+
+import (
+	"context"
+	"errors"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/filters"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"go.uber.org/zap"
+)
+
+const serverAdmins = "server_admins"
+
+// Show tells the user what nickname the policy gives a member.
+func Show(ctx context.Context, user string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("user", user))
+
+	if !common.IsDiscordUser(user) {
+		return common.SendError(nil, "argument must be a discord user")
+	}
+
+	userID := common.ExtractUserId(user)
+
+	nickname, err := common.Nickname(ctx, userID, deps)
+	if err != nil {
+		sp.Error("Error getting nickname", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting nickname: %s", err)
+	}
+
+	if nickname == "" {
+		return common.SendSuccessf(nil, "No nickname is enforced for <@%s>", userID)
+	}
+
+	_, err = deps.Storage.GetNicknameOverride(ctx, userID)
+	if err == nil {
+		return common.SendSuccessf(nil, "<@%s> should be `%s` (override)", userID, nickname)
+	}
+
+	return common.SendSuccessf(nil, "<@%s> should be `%s`", userID, nickname)
+}
+
+// Set overrides the nickname the policy would give a member.
+func Set(ctx context.Context, user, nickname, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("user", user),
+		zap.String("nickname", nickname),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	if !common.IsDiscordUser(user) {
+		return common.SendError(&author, "argument must be a discord user")
+	}
+
+	if len([]rune(nickname)) > common.NicknameMaxLength {
+		return common.SendErrorf(&author, "Nickname can't be longer than %d characters", common.NicknameMaxLength)
+	}
+
+	userID := common.ExtractUserId(user)
+
+	var before interface{}
+	previous, err := deps.Storage.GetNicknameOverride(ctx, userID)
+	if err == nil {
+		before = map[string]string{"nickname": previous}
+	} else if !errors.Is(err, storage.ErrNoNicknameOverride) {
+		sp.Error("Error getting nickname override", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting nickname override: %s", err)
+	}
+
+	err = deps.Storage.UpsertNicknameOverride(ctx, userID, nickname)
+	if err != nil {
+		sp.Error("Error setting nickname override", zap.Error(err))
+		return common.SendErrorf(&author, "Error setting nickname override: %s", err)
+	}
+
+	err = common.Audit(ctx, common.Change{
+		Action:     "set",
+		TargetType: "nickname",
+		Target:     userID,
+		MemberID:   userID,
+		Before:     before,
+		After:      map[string]string{"nickname": nickname},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording nickname override: %s", err)
+	}
+
+	filters.QueueSync(ctx, userID, deps)
+
+	return common.SendSuccessf(&author, "Set <@%s>'s nickname to `%s`", userID, nickname)
+}
+
+// Clear removes a member's override so the policy applies again.
+func Clear(ctx context.Context, user, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("user", user),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	if !common.IsDiscordUser(user) {
+		return common.SendError(&author, "argument must be a discord user")
+	}
+
+	userID := common.ExtractUserId(user)
+
+	previous, err := deps.Storage.GetNicknameOverride(ctx, userID)
+	if err == nil {
+		err = deps.Storage.DeleteNicknameOverride(ctx, userID)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrNoNicknameOverride) {
+			return common.SendErrorf(&author, "<@%s> doesn't have a nickname override", userID)
+		}
+
+		sp.Error("Error deleting nickname override", zap.Error(err))
+		return common.SendErrorf(&author, "Error deleting nickname override: %s", err)
+	}
+
+	err = common.Audit(ctx, common.Change{
+		Action:     "clear",
+		TargetType: "nickname",
+		Target:     userID,
+		MemberID:   userID,
+		Before:     map[string]string{"nickname": previous},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording nickname override removal: %s", err)
+	}
+
+	filters.QueueSync(ctx, userID, deps)
+
+	return common.SendSuccessf(&author, "Cleared <@%s>'s nickname override", userID)
+}
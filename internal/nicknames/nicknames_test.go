@@ -0,0 +1,49 @@
+package nicknames_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/nicknames"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+func TestAudit(t *testing.T) {
+	ctx := commontest.Context()
+	deps := commontest.Dependencies(t)
+
+	commontest.AddPermission(t, deps, "server_admins", "1")
+
+	nicknames.Set(ctx, "<@123>", "Bob", "1", deps)
+	nicknames.Set(ctx, "<@123>", "Robert", "1", deps)
+	nicknames.Clear(ctx, "<@123>", "1", deps)
+
+	entries, err := deps.Storage.GetAuditLog(ctx, payloads.AuditQuery{Target: "123", Limit: 10})
+	if err != nil {
+		t.Fatalf("GetAuditLog: %s", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	want := []struct {
+		action, before, after string
+	}{
+		{"set", "", `{"nickname":"Bob"}`},
+		{"set", `{"nickname":"Bob"}`, `{"nickname":"Robert"}`},
+		{"clear", `{"nickname":"Robert"}`, ""},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("audit entries: got %+v, want %d", entries, len(want))
+	}
+
+	for i, w := range want {
+		got := entries[i]
+		if got.Action != w.action || got.TargetType != "nickname" || got.MemberID != "123" ||
+			string(got.Before) != w.before || string(got.After) != w.after {
+			t.Errorf("entry %d: got %s %s before %s after %s, want %s before %s after %s", i, got.Action,
+				got.TargetType, got.Before, got.After, w.action, w.before, w.after)
+		}
+	}
+}
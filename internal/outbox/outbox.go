@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/queue"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// Used when bot.outbox.interval isn't set, how often the relay looks for messages
+	defaultInterval = time.Second
+	// How many messages the relay publishes per transaction
+	batchSize = 100
+)
+
+// Relay publishes the messages written to the outbox. Changes write their queue messages to the outbox in the same
+// transaction, so a message is only published if its change commits and is published even if we stop right after.
+// Messages are deleted once published so a failure publishes them again on the next try, consumers may see a message
+// twice but never miss one. Every process runs a relay, they take turns with a transaction lock.
+type Relay struct {
+	storage    storage.Store
+	publishers map[string]queue.Publisher
+	// queues are the ones there's a publisher for
+	queues   []string
+	interval time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New sets up a relay that publishes each queue's messages with its publisher.
+func New(storage storage.Store, publishers map[string]queue.Publisher) *Relay {
+	interval := viper.GetDuration("bot.outbox.interval")
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	var queues []string
+	for name := range publishers {
+		queues = append(queues, name)
+	}
+	sort.Strings(queues)
+
+	return &Relay{
+		storage:    storage,
+		publishers: publishers,
+		queues:     queues,
+		interval:   interval,
+	}
+}
+
+// Start publishes the outbox every interval until Stop is called.
+func (r *Relay) Start(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("component", "outbox"))
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+
+	sp.Info("Starting outbox relay", zap.Duration("interval", r.interval))
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.publishAll(ctx)
+			}
+		}
+	}()
+}
+
+// Stop waits for the relay to finish what it's publishing and then publishes what's left, until ctx is done.
+func (r *Relay) Stop(ctx context.Context) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	if r.cancel == nil {
+		return nil
+	}
+
+	r.cancel()
+	r.cancel = nil
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.publishAll(ctx)
+
+	return ctx.Err()
+}
+
+// publishAll publishes batches until the outbox is empty, another relay has it or publishing fails.
+func (r *Relay) publishAll(ctx context.Context) {
+	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
+	defer sp.Close()
+
+	for ctx.Err() == nil {
+		count, err := r.publish(ctx)
+		if err != nil {
+			sp.Error("error publishing outbox", zap.Error(err))
+			return
+		}
+
+		if count < batchSize {
+			return
+		}
+	}
+}
+
+// publish publishes a batch and returns how many messages it published, fewer than batchSize if there's nothing more
+// it can publish now.
+func (r *Relay) publish(ctx context.Context) (int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var count int
+
+	err := r.storage.WithTx(ctx, func(tx storage.Store) error {
+		locked, err := tx.LockOutbox(ctx)
+		if err != nil || !locked {
+			return err
+		}
+
+		// Messages for a queue this process has no publisher for are left for one that does, they mustn't hold up
+		// the rest
+		messages, err := tx.GetOutboxMessages(ctx, r.queues, batchSize)
+		if err != nil {
+			return err
+		}
+
+		var published []int64
+		for _, message := range messages {
+			err = r.publishers[message.Queue].Publish(ctx, message.Body)
+			if err != nil {
+				// Later messages could depend on this one, try them all again next time
+				sp.Error("error publishing outbox message", zap.Int64("id", message.ID), zap.Error(err))
+				break
+			}
+
+			published = append(published, message.ID)
+		}
+
+		count = len(published)
+
+		if count == 0 {
+			return nil
+		}
+
+		sp.Debug("published outbox messages", zap.Int("count", len(published)))
+
+		return tx.DeleteOutboxMessages(ctx, published)
+	})
+
+	return count, err
+}
@@ -47,6 +47,116 @@ type UserCharacterMap struct {
 	CharacterID int32 `db:"character_id" json:"characterId"`
 }
 
+type MemberState struct {
+	GuildID       string     `db:"guild_id" json:"guildId"`
+	ChatID        string     `db:"chat_id" json:"chatId"`
+	JoinedAt      time.Time  `db:"joined_at" json:"joinedAt"`
+	LastAuthed    *time.Time `db:"last_authed" json:"lastAuthed"`
+	RemindedAt    *time.Time `db:"reminded_at" json:"remindedAt"`
+	QuarantinedAt *time.Time `db:"quarantined_at" json:"quarantinedAt"`
+}
+
+type MemberPolicyExemption struct {
+	ChatID     string     `db:"chat_id" json:"chatId"`
+	Reason     string     `db:"reason" json:"reason"`
+	InsertedAt *time.Time `db:"inserted_at" json:"insertedAt"`
+}
+
+type Standing struct {
+	ContactID   int32   `db:"contact_id" json:"contactId"`
+	ContactType string  `db:"contact_type" json:"contactType"`
+	Standing    float32 `db:"standing" json:"standing"`
+}
+
+type ESICacheStats struct {
+	Entries      int        `db:"entries" json:"entries"`
+	Size         int64      `db:"size" json:"size"`
+	LastAccessed *time.Time `db:"last_accessed" json:"lastAccessed"`
+}
+
+type PollRun struct {
+	ID         int         `db:"id" json:"id"`
+	Trigger    string      `db:"trigger" json:"trigger"`
+	StartedAt  time.Time   `db:"started_at" json:"startedAt"`
+	FinishedAt time.Time   `db:"finished_at" json:"finishedAt"`
+	Stages     []PollStage `json:"stages"`
+}
+
+type PollStage struct {
+	Stage      string    `db:"stage" json:"stage"`
+	Count      int       `db:"count" json:"count"`
+	ErrorCount int       `db:"error_count" json:"errorCount"`
+	Errors     []string  `db:"errors" json:"errors"`
+	StartedAt  time.Time `db:"started_at" json:"startedAt"`
+	FinishedAt time.Time `db:"finished_at" json:"finishedAt"`
+}
+
+type AuditEntry struct {
+	ID            int64     `db:"id" json:"id"`
+	GuildID       string    `db:"guild_id" json:"guildId"`
+	ActorType     string    `db:"actor_type" json:"actorType"`
+	ActorID       string    `db:"actor_id" json:"actorId"`
+	Action        string    `db:"action" json:"action"`
+	TargetType    string    `db:"target_type" json:"targetType"`
+	Target        string    `db:"target" json:"target"`
+	MemberID      string    `db:"member_id" json:"memberId"`
+	Before        []byte    `db:"before" json:"before"`
+	After         []byte    `db:"after" json:"after"`
+	CorrelationID string    `db:"correlation_id" json:"correlationId"`
+	CreatedAt     time.Time `db:"created_at" json:"createdAt"`
+}
+
+// AuditQuery picks audit log entries. Empty fields match everything.
+type AuditQuery struct {
+	// UserID matches entries the user made or that were made to them
+	UserID string
+	Target string
+	Since  time.Time
+	Limit  uint64
+}
+
+type MembershipEvent struct {
+	ID            int64     `db:"id" json:"id"`
+	FilterName    string    `db:"filter_name" json:"filterName"`
+	UserID        string    `db:"user_id" json:"userId"`
+	Event         string    `db:"event" json:"event"`
+	Cause         string    `db:"cause" json:"cause"`
+	ActorID       string    `db:"actor_id" json:"actorId"`
+	CorrelationID string    `db:"correlation_id" json:"correlationId"`
+	CreatedAt     time.Time `db:"created_at" json:"createdAt"`
+}
+
+// MembershipHistoryQuery picks membership events. Empty fields match everything.
+type MembershipHistoryQuery struct {
+	UserID string
+	Since  time.Time
+	Limit  uint64
+}
+
+type MembershipSnapshot struct {
+	GuildID string    `db:"guild_id" json:"guildId"`
+	Day     time.Time `db:"day" json:"day"`
+	Kind    string    `db:"kind" json:"kind"`
+	Name    string    `db:"name" json:"name"`
+	Members int       `db:"members" json:"members"`
+}
+
+// MembershipSnapshotQuery picks daily membership counts. Empty fields match everything.
+type MembershipSnapshotQuery struct {
+	Kind  string
+	Name  string
+	Since time.Time
+	// Until is exclusive
+	Until time.Time
+}
+
+type OutboxMessage struct {
+	ID        int64     `db:"id" json:"id"`
+	Queue     string    `db:"queue" json:"queue"`
+	Body      []byte    `db:"body" json:"body"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
 type CreateRequest struct {
 	Token       string       `json:"token,omitempty"`
 	Character   *Character   `json:"character,omitempty"`
@@ -6,20 +6,27 @@ const (
 	Add    Action = "add"
 	Upsert Action = "upsert"
 	Delete Action = "delete"
+	// Sync asks the members consumer to bring all of a member's roles in line with what chremoas thinks they should be
+	Sync Action = "sync"
+	// Reorder asks the roles consumer to move roles to new positions with a single call
+	Reorder Action = "reorder"
 )
 
 type RolePayload struct {
 	Action        Action `json:"action,omitempty"`
 	GuildID       string `json:"guildId"`
 	Role          Role   `json:"role,omitempty"`
+	Roles         []Role `json:"roles,omitempty"`
 	CorrelationID string `json:"correlation_id"`
 }
 
 type MemberPayload struct {
-	Action        Action `json:"action"`
-	GuildID       string `json:"guildId"`
-	MemberID      string `json:"memberId"`
-	RoleID        string `json:"roleId"`
+	Action   Action `json:"action"`
+	GuildID  string `json:"guildId"`
+	MemberID string `json:"memberId"`
+	RoleID   string `json:"roleId,omitempty"`
+	// Seq is the member's sync count when this was queued, 0 for messages that are never skipped
+	Seq           int64  `json:"seq,omitempty"`
 	CorrelationID string `json:"correlation_id"`
 }
 
@@ -65,4 +72,6 @@ type Role struct {
 	Sig       bool   `json:"sig,omitempty"`
 	Sync      bool   `json:"sync,omitempty"`
 	Type      string `json:"chat_type"`
+	// Manageable is false when the role is out of the bot's reach in discord
+	Manageable bool `json:"manageable,omitempty"`
 }
@@ -83,6 +83,16 @@ func Add(ctx context.Context, permission, description, author string, deps commo
 	}
 
 	sp.Info("created permission")
+	err = common.Audit(ctx, common.Change{
+		Action:     "create",
+		TargetType: "permission",
+		Target:     permission,
+		After:      map[string]string{"description": description},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording permission creation: %s", err)
+	}
+
 	return common.SendSuccessf(nil, "Created permission `%s`", permission)
 }
 
@@ -116,6 +126,15 @@ func Delete(ctx context.Context, permission, author string, deps common.Dependen
 	}
 
 	sp.Info("deleted permission")
+	err = common.Audit(ctx, common.Change{
+		Action:     "delete",
+		TargetType: "permission",
+		Target:     permission,
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording permission deletion: %s", err)
+	}
+
 	return common.SendSuccessf(nil, "Deleted permission `%s`", permission)
 }
 
@@ -165,8 +184,6 @@ func AddMember(ctx context.Context, user, permission, author string, deps common
 		zap.String("author", author),
 	)
 
-	var permissionID int
-
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -199,7 +216,7 @@ func AddMember(ctx context.Context, user, permission, author string, deps common
 
 	sp.With(zap.Int("permission_id", perm.ID))
 
-	err = deps.Storage.InsertPermissionMembership(ctx, permissionID, userID)
+	err = deps.Storage.InsertPermissionMembership(ctx, perm.ID, userID)
 	if err != nil {
 		if errors.Is(err, storage.ErrPermissionMember) {
 			return common.SendErrorf(&author, "Already a member of permission: %s", permission)
@@ -209,6 +226,16 @@ func AddMember(ctx context.Context, user, permission, author string, deps common
 	}
 
 	sp.Info("added user to permission")
+	err = common.Audit(ctx, common.Change{
+		Action:     "add_member",
+		TargetType: "permission",
+		Target:     permission,
+		MemberID:   userID,
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording permission membership: %s", err)
+	}
+
 	return common.SendSuccessf(
 		nil,
 		"Added %s to `%s`",
@@ -265,6 +292,16 @@ func RemoveMember(ctx context.Context, user, permission, author string, deps com
 	}
 
 	sp.Info("removed user from permission")
+	err = common.Audit(ctx, common.Change{
+		Action:     "remove_member",
+		TargetType: "permission",
+		Target:     permission,
+		MemberID:   userID,
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(&author, "Error recording permission membership removal: %s", err)
+	}
+
 	return common.SendSuccessf(
 		nil,
 		"Removed <@%s> from `%s`",
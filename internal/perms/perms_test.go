@@ -0,0 +1,156 @@
+package perms_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+)
+
+// admin is in server_admins
+const admin = "1"
+
+// newDependencies has the server_admins and role_admins permissions with admin in server_admins.
+func newDependencies(t *testing.T) common.Dependencies {
+	t.Helper()
+
+	deps := commontest.Dependencies(t)
+	commontest.AddPermission(t, deps, "server_admins", admin)
+	commontest.AddPermission(t, deps, "role_admins")
+
+	return deps
+}
+
+func TestAddMember(t *testing.T) {
+	cases := []struct {
+		name       string
+		member     bool
+		user       string
+		permission string
+		reply      string
+		// added is whether 123 is in role_admins afterwards
+		added bool
+	}{
+		{
+			name:       "server admins",
+			user:       "<@123>",
+			permission: "server_admins",
+			reply:      "User doesn't have rights to this permission",
+		},
+		{
+			name:       "not a user",
+			user:       "bob",
+			permission: "role_admins",
+			reply:      "second argument must be a discord user",
+		},
+		{
+			name:       "no such permission",
+			user:       "<@123>",
+			permission: "nope",
+			reply:      "No such permission: nope",
+		},
+		{
+			name:       "new member",
+			user:       "<@123>",
+			permission: "role_admins",
+			reply:      "Added 123 to `role_admins`",
+			added:      true,
+		},
+		{
+			name:       "already a member",
+			member:     true,
+			user:       "<@123>",
+			permission: "role_admins",
+			reply:      "Already a member of permission: role_admins",
+			added:      true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			deps := newDependencies(t)
+
+			if c.member {
+				commontest.AddPermissionMember(t, deps, c.permission, "123")
+			}
+
+			reply := commontest.Reply(perms.AddMember(commontest.Context(), c.user, c.permission, admin, deps))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			if got := commontest.IsPermissionMember(t, deps, "role_admins", "123"); got != c.added {
+				t.Errorf("member of role_admins: got %t, want %t", got, c.added)
+			}
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	cases := []struct {
+		name       string
+		permission string
+		reply      string
+		// removed is whether 123 is out of role_admins afterwards
+		removed bool
+	}{
+		{
+			name:       "server admins",
+			permission: "server_admins",
+			reply:      "User doesn't have rights to this permission",
+		},
+		{
+			name:       "member",
+			permission: "role_admins",
+			reply:      "Removed <@123> from `role_admins`",
+			removed:    true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			deps := newDependencies(t)
+			commontest.AddPermissionMember(t, deps, "role_admins", "123")
+
+			reply := commontest.Reply(perms.RemoveMember(commontest.Context(), "<@123>", c.permission, admin, deps))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			if got := !commontest.IsPermissionMember(t, deps, "role_admins", "123"); got != c.removed {
+				t.Errorf("removed from role_admins: got %t, want %t", got, c.removed)
+			}
+		})
+	}
+}
+
+func TestCanPerform(t *testing.T) {
+	cases := []struct {
+		name       string
+		author     string
+		permission string
+		err        error
+	}{
+		{"auth web", "auth-web", "nope", nil},
+		{"no such permission", admin, "nope", storage.ErrNoPermission},
+		{"member", admin, "server_admins", nil},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			deps := newDependencies(t)
+
+			err := perms.CanPerform(commontest.Context(), c.author, c.permission, deps)
+			if !errors.Is(err, c.err) {
+				t.Errorf("got error %v, want %v", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package pollruns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	esiPoller "github.com/chremoas/chremoas-ng/internal/esi-poller"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"go.uber.org/zap"
+)
+
+const (
+	serverAdmins = "server_admins"
+	// How many runs !poller status shows
+	statusRuns = 5
+)
+
+// Poller is the part of the esi poller that runs polls.
+type Poller interface {
+	Poll(ctx context.Context)
+}
+
+// Status shows the latest poll runs and how each stage went.
+func Status(ctx context.Context, channelID string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	runs, err := deps.Storage.GetPollRuns(ctx, statusRuns)
+	if err != nil {
+		sp.Error("Error getting poll runs", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting poll runs: %s", err)
+	}
+
+	if len(runs) == 0 {
+		return common.SendSuccess(nil, "The poller hasn't run yet")
+	}
+
+	var lines []string
+
+	for _, run := range runs {
+		lines = append(lines, fmt.Sprintf("**%s** %s, took %s",
+			run.StartedAt.UTC().Format("2006-01-02 15:04 MST"),
+			run.Trigger,
+			run.FinishedAt.Sub(run.StartedAt).Round(time.Second),
+		))
+
+		for _, stage := range run.Stages {
+			lines = append(lines, fmt.Sprintf("    %s: %d ok, %d errors", stage.Stage, stage.Count, stage.ErrorCount))
+
+			for _, e := range stage.Errors {
+				lines = append(lines, fmt.Sprintf("        `%s`", e))
+			}
+		}
+	}
+
+	err = common.SendChunkedMessage(ctx, channelID, "Recent poller runs", lines, deps)
+	if err != nil {
+		sp.Error("Error sending chunked message", zap.Error(err))
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// Run starts a poll now, of one stage or all of them. It runs in the background and shows up in !poller status once
+// it's done.
+func Run(ctx context.Context, poller Poller, stage, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("stage", stage),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	var stages []string
+	if stage != "" {
+		if !validStage(stage) {
+			return common.SendErrorf(&author, "No such stage: %s (stages are %s)", stage, strings.Join(esiPoller.Stages, ", "))
+		}
+
+		stages = append(stages, stage)
+	}
+
+	sp.Info("Starting manual poll")
+	go poller.Poll(esiPoller.WithStages(ctx, stages...))
+
+	if stage == "" {
+		return common.SendSuccess(&author, "Started a poll of every stage, it starts once any running poll finishes")
+	}
+
+	return common.SendSuccessf(&author, "Started a poll of %s, it starts once any running poll finishes", stage)
+}
+
+func validStage(stage string) bool {
+	for _, s := range esiPoller.Stages {
+		if s == stage {
+			return true
+		}
+	}
+
+	return false
+}
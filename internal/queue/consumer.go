@@ -11,16 +11,17 @@ import (
 
 type Handler func(deliveries <-chan amqp.Delivery, done chan error, threadID int)
 
-type Consumer struct {
+type AMQPConsumer struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
 	tag     string
+	threads int
 	done    chan error
 	handler Handler
 }
 
 func NewConsumer(ctx context.Context, amqpURI, exchange, exchangeType, queueName, key, ctag string, threads int,
-	handler Handler) (*Consumer, error) {
+	handler Handler) (*AMQPConsumer, error) {
 
 	_, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
@@ -34,11 +35,13 @@ func NewConsumer(ctx context.Context, amqpURI, exchange, exchangeType, queueName
 		zap.String("ctag", ctag),
 	)
 
-	c := &Consumer{
+	c := &AMQPConsumer{
 		conn:    nil,
 		channel: nil,
 		tag:     ctag,
-		done:    make(chan error),
+		threads: threads,
+		// Every handler thread sends on done when it exits, buffered so they don't block if Shutdown gave up waiting
+		done:    make(chan error, threads),
 		handler: handler,
 	}
 
@@ -129,27 +132,35 @@ func NewConsumer(ctx context.Context, amqpURI, exchange, exchangeType, queueName
 	return c, nil
 }
 
-func (c *Consumer) Shutdown(ctx context.Context) error {
-	_, sp := sl.OpenSpan(ctx)
+// Shutdown stops new deliveries and waits, until ctx is done, for the handler threads to finish and ack what they
+// already have. The connection is only closed after that so the acks get through.
+func (c *AMQPConsumer) Shutdown(ctx context.Context) error {
+	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
 	sp.With(
 		zap.String("ctag", c.tag),
 	)
 
-	// will close() the deliveries channel
-	if err := c.channel.Cancel(c.tag, true); err != nil {
+	// will close() the deliveries channel once the server has stopped sending
+	if err := c.channel.Cancel(c.tag, false); err != nil {
 		sp.Error("error canceling consumer", zap.Error(err))
 		return err
 	}
 
-	if err := c.conn.Close(); err != nil {
-		sp.Error("error closing connection", zap.Error(err))
+	// wait for handle() to exit
+	err := waitForHandlers(ctx, c.done, c.threads)
+
+	if cErr := c.conn.Close(); cErr != nil {
+		sp.Error("error closing connection", zap.Error(cErr))
+		return cErr
+	}
+
+	if err != nil {
 		return err
 	}
 
-	defer sp.Info("AMQP shutdown OK")
+	sp.Info("AMQP shutdown OK")
 
-	// wait for handle() to exit
-	return <-c.done
+	return nil
 }
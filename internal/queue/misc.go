@@ -1,7 +1,12 @@
 package queue
 
 import (
+	"context"
+	"fmt"
 	"regexp"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"go.uber.org/zap"
 )
 
 func sanitizeURI(uri string) string {
@@ -9,3 +14,25 @@ func sanitizeURI(uri string) string {
 
 	return re.ReplaceAllString(uri, "$1:REDACTED@$3")
 }
+
+// waitForHandlers waits for each of the handler threads to send on done, or for ctx to be done. Messages held by
+// handlers still running at the deadline aren't acked and get delivered again later.
+func waitForHandlers(ctx context.Context, done chan error, threads int) error {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var err error
+	for i := 0; i < threads; i++ {
+		select {
+		case hErr := <-done:
+			if hErr != nil {
+				err = hErr
+			}
+		case <-ctx.Done():
+			sp.Warn("Abandoning queue handlers still running", zap.Int("abandoned", threads-i))
+			return fmt.Errorf("%d of %d handlers still running: %w", threads-i, threads, ctx.Err())
+		}
+	}
+
+	return err
+}
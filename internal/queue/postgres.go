@@ -0,0 +1,315 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+const (
+	// How often an idle consumer checks the table for new messages. Publishers in the same process wake consumers up
+	// straight away so this only matters for messages published by another process.
+	postgresPollInterval = time.Second
+	// How long a claimed message is hidden from other consumers before it's handed out again.
+	postgresLease = time.Minute * 5
+	// How long a requeued message waits before it's retried, so a message that keeps failing doesn't spin.
+	postgresRetryDelay = time.Second * 5
+)
+
+// postgresBackend is a durable queue that keeps its messages in the queue_messages table. It's meant for installs
+// that don't want to run RabbitMQ.
+type postgresBackend struct {
+	db *sq.StatementBuilderType
+
+	mutex  sync.Mutex
+	wakeup map[string]chan struct{}
+}
+
+// NewPostgresBackend returns a Backend that stores messages in the database.
+func NewPostgresBackend(db *sq.StatementBuilderType) Backend {
+	return &postgresBackend{
+		db:     db,
+		wakeup: make(map[string]chan struct{}),
+	}
+}
+
+func (b *postgresBackend) wakeupChannel(name string) chan struct{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch, ok := b.wakeup[name]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		b.wakeup[name] = ch
+	}
+
+	return ch
+}
+
+func (b *postgresBackend) NewPublisher(ctx context.Context, name string) (Publisher, error) {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.Info("created postgres publisher", zap.String("queue", name))
+
+	return &postgresPublisher{backend: b, name: name}, nil
+}
+
+func (b *postgresBackend) NewConsumer(ctx context.Context, name string, threads int, handler Handler) (Consumer, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("queue", name),
+		zap.Int("threads", threads),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	c := &postgresConsumer{
+		backend:     b,
+		name:        name,
+		threads:     threads,
+		cancel:      cancel,
+		deliveries:  make(chan amqp.Delivery),
+		done:        make(chan error, threads),
+		fetcherDone: make(chan struct{}),
+	}
+
+	for i := 1; i <= threads; i++ {
+		go handler(c.deliveries, c.done, i)
+	}
+
+	go c.fetch(ctx)
+
+	sp.Info("started postgres consumer")
+
+	return c, nil
+}
+
+type postgresPublisher struct {
+	backend *postgresBackend
+	name    string
+}
+
+func (p postgresPublisher) Publish(ctx context.Context, body []byte) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.ByteString("payload", body),
+		zap.String("queue", p.name),
+	)
+
+	query := p.backend.db.Insert("queue_messages").
+		Columns("queue", "body").
+		Values(p.name, body)
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("Publish(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error publishing", zap.Error(err))
+		return err
+	}
+
+	// Let a consumer in this process know there is work waiting
+	select {
+	case p.backend.wakeupChannel(p.name) <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Shutdown is a no-op, the database connection belongs to storage.
+func (p postgresPublisher) Shutdown(_ context.Context) {}
+
+type postgresConsumer struct {
+	backend     *postgresBackend
+	name        string
+	threads     int
+	cancel      context.CancelFunc
+	deliveries  chan amqp.Delivery
+	done        chan error
+	fetcherDone chan struct{}
+}
+
+// fetch claims messages one at a time and hands them to the handler threads until the context is cancelled.
+func (c *postgresConsumer) fetch(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("queue", c.name))
+
+	defer close(c.fetcherDone)
+	defer close(c.deliveries)
+
+	wakeup := c.backend.wakeupChannel(c.name)
+
+	for {
+		d, err := c.claim(ctx)
+		if err == nil {
+			select {
+			case c.deliveries <- d:
+			case <-ctx.Done():
+				// Nobody is going to handle it, put it back for the next consumer.
+				if err = d.Reject(true); err != nil {
+					sp.Error("error releasing message", zap.Error(err))
+				}
+				return
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, context.Canceled) {
+			sp.Error("error claiming message", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wakeup:
+		case <-time.After(postgresPollInterval):
+		}
+	}
+}
+
+func (c *postgresConsumer) claim(ctx context.Context) (amqp.Delivery, error) {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var (
+		id       int64
+		body     []byte
+		attempts int
+	)
+
+	query := c.backend.db.Update("queue_messages").
+		Set("locked_until", sq.Expr("NOW() + ?::interval", interval(postgresLease))).
+		Set("attempts", sq.Expr("attempts + 1")).
+		Where(`id = (SELECT id FROM queue_messages
+			WHERE queue = ? AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED)`, c.name).
+		Suffix(`RETURNING "id", "body", "attempts"`)
+
+	err := query.ScanContext(ctx, &id, &body, &attempts)
+	if err != nil {
+		return amqp.Delivery{}, err
+	}
+
+	sp.Debug("claimed message", zap.Int64("id", id), zap.Int("attempts", attempts))
+
+	return amqp.Delivery{
+		Acknowledger: postgresAcknowledger{ctx: ctx, backend: c.backend},
+		ContentType:  "text/plain",
+		DeliveryTag:  uint64(id),
+		Redelivered:  attempts > 1,
+		Exchange:     c.name,
+		RoutingKey:   c.name,
+		Body:         body,
+	}, nil
+}
+
+// Shutdown stops claiming messages and waits, until ctx is done, for the handler threads to finish the messages they
+// have. Abandoned messages are handed out again once their lease runs out.
+func (c *postgresConsumer) Shutdown(ctx context.Context) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("queue", c.name))
+
+	// Stopping the fetcher closes the deliveries channel which lets the handlers return
+	c.cancel()
+	<-c.fetcherDone
+
+	err := waitForHandlers(ctx, c.done, c.threads)
+	if err != nil {
+		return err
+	}
+
+	sp.Info("postgres consumer shutdown OK")
+
+	return nil
+}
+
+// postgresAcknowledger lets the handlers keep calling Ack and Reject on the amqp.Delivery they are given.
+type postgresAcknowledger struct {
+	ctx     context.Context
+	backend *postgresBackend
+}
+
+func (a postgresAcknowledger) Ack(tag uint64, _ bool) error {
+	return a.remove(tag)
+}
+
+func (a postgresAcknowledger) Nack(tag uint64, _ bool, requeue bool) error {
+	return a.Reject(tag, requeue)
+}
+
+func (a postgresAcknowledger) Reject(tag uint64, requeue bool) error {
+	if !requeue {
+		return a.remove(tag)
+	}
+
+	// Ignore cancellation so a message can still be released during shutdown
+	ctx := context.Background()
+	_, sp := sl.OpenSpan(a.ctx)
+	defer sp.Close()
+
+	sp.With(zap.Uint64("id", tag))
+
+	query := a.backend.db.Update("queue_messages").
+		Set("locked_until", sq.Expr("NOW() + ?::interval", interval(postgresRetryDelay))).
+		Where(sq.Eq{"id": tag})
+
+	_, err := query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error requeueing message", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (a postgresAcknowledger) remove(tag uint64) error {
+	ctx := context.Background()
+	_, sp := sl.OpenSpan(a.ctx)
+	defer sp.Close()
+
+	sp.With(zap.Uint64("id", tag))
+
+	query := a.backend.db.Delete("queue_messages").
+		Where(sq.Eq{"id": tag})
+
+	_, err := query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error removing message", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func interval(d time.Duration) string {
+	return fmt.Sprintf("%d milliseconds", d.Milliseconds())
+}
@@ -0,0 +1,301 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	_ "github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/chremoas/chremoas-ng/internal/database"
+)
+
+// testDatabaseEnv names a postgres DSN to run the postgres backend tests against. The database is wiped on every
+// test.
+const testDatabaseEnv = "CHREMOAS_TEST_DATABASE"
+
+func testContext() context.Context {
+	return sl.NewCtxWithLogger(zapcore.FatalLevel)
+}
+
+func TestInterval(t *testing.T) {
+	cases := []struct {
+		d    time.Duration
+		want string
+	}{
+		{postgresLease, "300000 milliseconds"},
+		{postgresRetryDelay, "5000 milliseconds"},
+		{1500 * time.Microsecond, "1 milliseconds"},
+	}
+
+	for _, c := range cases {
+		if got := interval(c.d); got != c.want {
+			t.Errorf("interval(%s): got %q, want %q", c.d, got, c.want)
+		}
+	}
+}
+
+// newTestBackend returns a postgres backend on a freshly migrated database, and the database.
+func newTestBackend(t *testing.T) (*postgresBackend, *sql.DB) {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testDatabaseEnv)
+	}
+
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("opening database: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx := testContext()
+	if _, err = conn.ExecContext(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatalf("wiping database: %s", err)
+	}
+
+	migrator, err := database.NewMigrator(conn, os.DirFS("../../sql"))
+	if err != nil {
+		t.Fatalf("reading migrations: %s", err)
+	}
+
+	if _, err = migrator.Up(ctx); err != nil {
+		t.Fatalf("migrating database: %s", err)
+	}
+
+	db := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(sq.NewStmtCache(conn))
+
+	return NewPostgresBackend(&db).(*postgresBackend), conn
+}
+
+// handle returns a Handler that calls fn with every delivery until the deliveries channel closes.
+func handle(fn func(d amqp.Delivery)) Handler {
+	return func(deliveries <-chan amqp.Delivery, done chan error, _ int) {
+		for d := range deliveries {
+			fn(d)
+		}
+
+		done <- nil
+	}
+}
+
+func publish(t *testing.T, b *postgresBackend, bodies ...string) {
+	t.Helper()
+
+	p, err := b.NewPublisher(testContext(), Roles)
+	if err != nil {
+		t.Fatalf("NewPublisher: %s", err)
+	}
+
+	for _, body := range bodies {
+		if err = p.Publish(testContext(), []byte(body)); err != nil {
+			t.Fatalf("Publish: %s", err)
+		}
+	}
+}
+
+// consume starts a consumer and returns the first n deliveries it's given, handled with fn.
+func consume(t *testing.T, b *postgresBackend, n int, fn func(d amqp.Delivery)) []amqp.Delivery {
+	t.Helper()
+
+	got := make(chan amqp.Delivery, n)
+	c, err := b.NewConsumer(testContext(), Roles, 1, handle(func(d amqp.Delivery) {
+		fn(d)
+		got <- d
+	}))
+	if err != nil {
+		t.Fatalf("NewConsumer: %s", err)
+	}
+	defer func() { _ = c.Shutdown(testContext()) }()
+
+	var deliveries []amqp.Delivery
+	for i := 0; i < n; i++ {
+		select {
+		case d := <-got:
+			deliveries = append(deliveries, d)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d deliveries, want %d", len(deliveries), n)
+		}
+	}
+
+	return deliveries
+}
+
+func queued(t *testing.T, conn *sql.DB) int {
+	t.Helper()
+
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM queue_messages").Scan(&n); err != nil {
+		t.Fatalf("counting messages: %s", err)
+	}
+
+	return n
+}
+
+func TestPostgresAck(t *testing.T) {
+	b, conn := newTestBackend(t)
+	publish(t, b, "one", "two")
+
+	deliveries := consume(t, b, 2, func(d amqp.Delivery) {
+		if err := d.Ack(false); err != nil {
+			t.Errorf("Ack: %s", err)
+		}
+	})
+
+	if string(deliveries[0].Body) != "one" || string(deliveries[1].Body) != "two" {
+		t.Errorf("bodies: got %q and %q, want one and two in order", deliveries[0].Body, deliveries[1].Body)
+	}
+
+	if deliveries[0].Redelivered {
+		t.Error("first delivery marked as redelivered")
+	}
+
+	if n := queued(t, conn); n != 0 {
+		t.Errorf("messages left: got %d, want 0", n)
+	}
+}
+
+func TestPostgresReject(t *testing.T) {
+	cases := []struct {
+		name    string
+		requeue bool
+		// left is how many messages are still in the table
+		left int
+	}{
+		{name: "dropped", left: 0},
+		{name: "requeued", requeue: true, left: 1},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			b, conn := newTestBackend(t)
+			publish(t, b, "one")
+
+			consume(t, b, 1, func(d amqp.Delivery) {
+				if err := d.Reject(c.requeue); err != nil {
+					t.Errorf("Reject: %s", err)
+				}
+			})
+
+			if n := queued(t, conn); n != c.left {
+				t.Fatalf("messages left: got %d, want %d", n, c.left)
+			}
+
+			if !c.requeue {
+				return
+			}
+
+			// It waits out the retry delay instead of being handed straight back
+			var (
+				attempts int
+				hidden   bool
+			)
+			err := conn.QueryRow("SELECT attempts, locked_until > NOW() FROM queue_messages").Scan(&attempts, &hidden)
+			if err != nil {
+				t.Fatalf("reading message: %s", err)
+			}
+
+			if attempts != 1 || !hidden {
+				t.Errorf("got %d attempts and hidden %t, want 1 attempt and hidden", attempts, hidden)
+			}
+		})
+	}
+}
+
+func TestPostgresRedelivered(t *testing.T) {
+	b, conn := newTestBackend(t)
+	publish(t, b, "one")
+
+	// A consumer that died with the message, its lease has run out
+	if _, err := conn.Exec("UPDATE queue_messages SET attempts = 1, locked_until = NOW() - INTERVAL '1 second'"); err != nil {
+		t.Fatalf("expiring lease: %s", err)
+	}
+
+	deliveries := consume(t, b, 1, func(d amqp.Delivery) { _ = d.Ack(false) })
+	if !deliveries[0].Redelivered {
+		t.Error("delivery after an expired lease not marked as redelivered")
+	}
+}
+
+func TestPostgresOneConsumerPerMessage(t *testing.T) {
+	const messages = 20
+
+	b, _ := newTestBackend(t)
+
+	var (
+		mutex sync.Mutex
+		got   []string
+		wg    sync.WaitGroup
+	)
+	wg.Add(messages)
+
+	handler := handle(func(d amqp.Delivery) {
+		mutex.Lock()
+		got = append(got, string(d.Body))
+		mutex.Unlock()
+
+		_ = d.Ack(false)
+		wg.Done()
+	})
+
+	for i := 0; i < 2; i++ {
+		c, err := b.NewConsumer(testContext(), Roles, 2, handler)
+		if err != nil {
+			t.Fatalf("NewConsumer: %s", err)
+		}
+		defer func() { _ = c.Shutdown(testContext()) }()
+	}
+
+	var want []string
+	for i := 0; i < messages; i++ {
+		want = append(want, fmt.Sprintf("%02d", i))
+	}
+	publish(t, b, want...)
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("not every message was delivered")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("deliveries: got %v, want each of %v once", got, want)
+	}
+}
+
+func TestPostgresShutdown(t *testing.T) {
+	b, _ := newTestBackend(t)
+
+	c, err := b.NewConsumer(testContext(), Roles, 2, handle(func(d amqp.Delivery) { _ = d.Ack(false) }))
+	if err != nil {
+		t.Fatalf("NewConsumer: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(testContext(), 5*time.Second)
+	defer cancel()
+
+	if err = c.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %s", err)
+	}
+}
@@ -8,14 +8,14 @@ import (
 	"go.uber.org/zap"
 )
 
-type Producer struct {
+type AMQPProducer struct {
 	conn       *amqp.Connection
 	channel    *amqp.Channel
 	exchange   string
 	routingKey string
 }
 
-func NewPublisher(ctx context.Context, amqpURI, exchange, exchangeType, routingKey string) (*Producer, error) {
+func NewPublisher(ctx context.Context, amqpURI, exchange, exchangeType, routingKey string) (*AMQPProducer, error) {
 	_, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
@@ -28,7 +28,7 @@ func NewPublisher(ctx context.Context, amqpURI, exchange, exchangeType, routingK
 
 	var err error
 
-	p := &Producer{
+	p := &AMQPProducer{
 		conn:       nil,
 		channel:    nil,
 		exchange:   exchange,
@@ -68,7 +68,7 @@ func NewPublisher(ctx context.Context, amqpURI, exchange, exchangeType, routingK
 	return p, nil
 }
 
-func (p Producer) Publish(ctx context.Context, body []byte) error {
+func (p AMQPProducer) Publish(ctx context.Context, body []byte) error {
 	_, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
@@ -102,11 +102,17 @@ func (p Producer) Publish(ctx context.Context, body []byte) error {
 	return nil
 }
 
-func (p Producer) Shutdown(ctx context.Context) {
+// Shutdown closes the channel before the connection so anything already published is flushed to the server first.
+func (p AMQPProducer) Shutdown(ctx context.Context) {
 	_, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
 
-	err := p.conn.Close()
+	err := p.channel.Close()
+	if err != nil {
+		sp.Error("Error closing channel", zap.Error(err))
+	}
+
+	err = p.conn.Close()
 	if err != nil {
 		sp.Error("Error closing connection", zap.Error(err))
 	}
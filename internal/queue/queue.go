@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"context"
+)
+
+// The queues chremoas uses
+const (
+	Members = "members"
+	Roles   = "roles"
+)
+
+// Publisher is the producing side of a queue. The AMQP Producer and the postgres backed publisher both satisfy it.
+type Publisher interface {
+	Publish(ctx context.Context, body []byte) error
+	Shutdown(ctx context.Context)
+}
+
+// Consumer is the consuming side of a queue. Messages are handed to a Handler as amqp.Delivery values regardless of
+// the backend so the handlers don't need to know where the message came from.
+type Consumer interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Backend creates publishers and consumers for a named queue. The name is used as the exchange, queue and routing key.
+type Backend interface {
+	NewPublisher(ctx context.Context, name string) (Publisher, error)
+	NewConsumer(ctx context.Context, name string, threads int, handler Handler) (Consumer, error)
+}
+
+type amqpBackend struct {
+	uri string
+}
+
+// NewAMQPBackend returns a Backend that talks to RabbitMQ.
+func NewAMQPBackend(uri string) Backend {
+	return &amqpBackend{uri: uri}
+}
+
+func (b amqpBackend) NewPublisher(ctx context.Context, name string) (Publisher, error) {
+	p, err := NewPublisher(ctx, b.uri, name, "direct", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (b amqpBackend) NewConsumer(ctx context.Context, name string, threads int, handler Handler) (Consumer, error) {
+	c, err := NewConsumer(ctx, b.uri, name, "direct", name, name, name, threads, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,292 @@
+package roles
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bhechinger/go-sets"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/filters"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"go.uber.org/zap"
+)
+
+// Diff compares who should have a role according to the filters with who actually has it in discord. With fix set a
+// sync is queued for every member that doesn't match.
+func Diff(ctx context.Context, sig bool, ticker string, fix bool, channelID, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("role_type", roleType[sig]),
+		zap.String("ticker", ticker),
+		zap.Bool("fix", fix),
+		zap.String("author", author),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if fix {
+		if err := perms.CanPerform(ctx, author, adminType[sig], deps); err != nil {
+			sp.Warn("user doesn't have permission to this command", zap.Error(err))
+			return common.SendError(&author, "User doesn't have permission to this command")
+		}
+	}
+
+	role, err := deps.Storage.GetRoleByType(ctx, sig, ticker)
+	if err != nil {
+		sp.Error("error getting role", zap.Error(err))
+		return common.SendErrorf(&author, "No such %s: %s", roleType[sig], ticker)
+	}
+
+	if !role.Sync {
+		return common.SendErrorf(&author, "%s `%s` isn't synced to discord", roleType[sig], ticker)
+	}
+
+	dRole, err := GetDiscordRole(ctx, role.Name, deps)
+	if err != nil {
+		sp.Warn("role not in discord", zap.Error(err))
+		return common.SendErrorf(&author, "%s `%s` hasn't been created in discord yet", roleType[sig], ticker)
+	}
+
+	members, err := GetRoleMembers(ctx, sig, ticker, deps)
+	if err != nil {
+		sp.Error("error getting role members", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting role members: %s", err)
+	}
+
+	expected := sets.NewStringSet()
+	for _, member := range members {
+		expected.Add(fmt.Sprintf("%d", member))
+	}
+
+	actual, err := discordRoleMembers(ctx, dRole.ID, deps)
+	if err != nil {
+		sp.Error("error getting guild members", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting guild members: %s", err)
+	}
+
+	roleFilters, err := deps.Storage.GetTickerFilters(ctx, sig, ticker)
+	if err != nil {
+		sp.Error("error getting role filters", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting role filters: %s", err)
+	}
+
+	var lines []string
+
+	missing := expected.Difference(actual)
+	for _, userID := range missing.ToSlice() {
+		lines = append(lines, fmt.Sprintf("<@%s> missing the role, they're in every filter", userID))
+	}
+
+	extra := actual.Difference(expected)
+	for _, userID := range extra.ToSlice() {
+		missingFilters, err := missingFilters(ctx, userID, roleFilters, deps)
+		if err != nil {
+			sp.Error("error getting user filters", zap.String("user_id", userID), zap.Error(err))
+			return common.SendErrorf(&author, "Error getting user filters: %s", err)
+		}
+
+		lines = append(lines, fmt.Sprintf("<@%s> has the role but isn't in: %s", userID, strings.Join(missingFilters, ", ")))
+	}
+
+	if len(lines) == 0 {
+		return common.SendSuccessf(nil, "Discord matches the filters for %s `%s` (%d members)", roleType[sig], ticker, expected.Len())
+	}
+
+	if fix {
+		for _, userID := range append(missing.ToSlice(), extra.ToSlice()...) {
+			filters.QueueGuildSync(ctx, deps.GuildID, userID, deps)
+		}
+
+		lines = append(lines, fmt.Sprintf("Queued a sync for %d member(s)", missing.Len()+extra.Len()))
+	} else {
+		lines = append(lines, "Run again with `--fix` to queue a sync for these members")
+	}
+
+	err = common.SendChunkedMessage(
+		ctx,
+		channelID,
+		fmt.Sprintf("%s %s: %d missing, %d extra", roleType[sig], ticker, missing.Len(), extra.Len()),
+		lines,
+		deps,
+	)
+	if err != nil {
+		sp.Error("Error sending chunked message", zap.Error(err))
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// CheckUser compares the roles chremoas manages that a user should have with what they have in discord. With fix set
+// a sync is queued for them.
+func CheckUser(ctx context.Context, user string, fix bool, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("user", user),
+		zap.Bool("fix", fix),
+		zap.String("author", author),
+	)
+
+	var messages []*discordgo.MessageSend
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if !common.IsDiscordUser(user) {
+		return common.SendError(&author, "argument must be a discord user")
+	}
+
+	userID := common.ExtractUserId(user)
+
+	if fix {
+		if err := perms.CanPerform(ctx, author, adminType[Role], deps); err != nil {
+			sp.Warn("user doesn't have permission to this command", zap.Error(err))
+			return common.SendError(&author, "User doesn't have permission to this command")
+		}
+	}
+
+	member, err := deps.Session.GuildMember(deps.GuildID, userID)
+	if err != nil {
+		sp.Error("error getting guild member", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting guild member: %s", err)
+	}
+
+	membership, err := common.GetMembership(ctx, userID, deps)
+	if err != nil {
+		sp.Error("error getting membership", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting membership: %s", err)
+	}
+
+	managed, err := common.ManagedRoles(ctx, deps)
+	if err != nil {
+		sp.Error("error getting managed roles", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting managed roles: %s", err)
+	}
+
+	syncedRoles, err := deps.Storage.GetRolesBySync(ctx, true)
+	if err != nil {
+		sp.Error("error getting synced roles", zap.Error(err))
+		return common.SendErrorf(&author, "Error getting synced roles: %s", err)
+	}
+
+	roleByID := make(map[string]payloads.Role)
+	for _, role := range syncedRoles {
+		roleByID[role.ID] = role
+	}
+
+	current := sets.NewStringSet()
+	current.FromSlice(member.Roles)
+
+	missing := membership.Intersection(managed).Difference(current)
+	extra := current.Intersection(managed).Difference(membership)
+
+	var lines []string
+
+	for _, roleID := range missing.ToSlice() {
+		lines = append(lines, fmt.Sprintf("Missing <@&%s>, they're in every filter", roleID))
+	}
+
+	for _, roleID := range extra.ToSlice() {
+		role := roleByID[roleID]
+
+		roleFilters, err := deps.Storage.GetTickerFilters(ctx, role.Sig, role.ShortName)
+		if err != nil {
+			sp.Error("error getting role filters", zap.Error(err))
+			return common.SendErrorf(&author, "Error getting role filters: %s", err)
+		}
+
+		missingFilters, err := missingFilters(ctx, userID, roleFilters, deps)
+		if err != nil {
+			sp.Error("error getting user filters", zap.Error(err))
+			return common.SendErrorf(&author, "Error getting user filters: %s", err)
+		}
+
+		lines = append(lines, fmt.Sprintf("Has <@&%s> but isn't in: %s", roleID, strings.Join(missingFilters, ", ")))
+	}
+
+	if len(lines) == 0 {
+		return common.SendSuccessf(nil, "%s's roles match their filters", common.GetUsername(userID, deps.Session))
+	}
+
+	if fix {
+		filters.QueueGuildSync(ctx, deps.GuildID, userID, deps)
+		lines = append(lines, "Queued a sync")
+	} else {
+		lines = append(lines, "Run again with `--fix` to queue a sync")
+	}
+
+	embed := common.NewEmbed()
+	embed.SetTitle(fmt.Sprintf("Sync check for %s", common.GetUsername(userID, deps.Session)))
+	embed.SetDescription(strings.Join(lines, "\n"))
+
+	return append(messages, &discordgo.MessageSend{Embed: embed.GetMessageEmbed()})
+}
+
+// discordRoleMembers pages through the guild and returns everyone who has the role.
+func discordRoleMembers(ctx context.Context, roleID string, deps common.Dependencies) (*sets.StringSet, error) {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	var after string
+
+	output := sets.NewStringSet()
+
+	for {
+		members, err := deps.Session.GuildMembers(deps.GuildID, after, 1000)
+		if err != nil {
+			sp.Error("error getting guild members", zap.Error(err))
+			return nil, err
+		}
+
+		for _, member := range members {
+			for _, r := range member.Roles {
+				if r == roleID {
+					output.Add(member.User.ID)
+					break
+				}
+			}
+		}
+
+		if len(members) < 1000 {
+			return output, nil
+		}
+
+		after = members[len(members)-1].User.ID
+	}
+}
+
+// missingFilters returns the names of the filters the user would need to be in to justify a role.
+func missingFilters(ctx context.Context, userID string, roleFilters []payloads.Filter, deps common.Dependencies) ([]string, error) {
+	userFilters, err := deps.Storage.GetUserFilters(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	in := sets.NewStringSet()
+	for _, filter := range userFilters {
+		in.Add(filter.Name)
+	}
+
+	var output []string
+	for _, filter := range roleFilters {
+		if !in.Contains(filter.Name) {
+			output = append(output, filter.Name)
+		}
+	}
+
+	if len(output) == 0 {
+		// They're in every filter so the role should have been added, not a filter problem
+		output = append(output, "(none, sync pending)")
+	}
+
+	return output, nil
+}
@@ -229,6 +229,37 @@ func Info(ctx context.Context, sig bool, ticker string, deps common.Dependencies
 	return append(messages, &discordgo.MessageSend{Embed: embed.GetMessageEmbed()})
 }
 
+// Doctor checks whether the bot can manage the synced roles in the guild and explains what to fix if it can't.
+func Doctor(ctx context.Context, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("author", author))
+
+	var messages []*discordgo.MessageSend
+
+	if err := perms.CanPerform(ctx, author, adminType[Role], deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	report, err := common.CheckHierarchy(ctx, deps)
+	if err != nil {
+		sp.Error("error checking role hierarchy", zap.Error(err))
+		return common.SendFatalf(&author, "Error checking role hierarchy: %s", err)
+	}
+
+	embed := common.NewEmbed()
+	if report.OK() {
+		embed.SetTitle("Role doctor: all good")
+	} else {
+		embed.SetTitle("Role doctor: problems found")
+	}
+	embed.SetDescription(report.String())
+
+	return append(messages, &discordgo.MessageSend{Embed: embed.GetMessageEmbed()})
+}
+
 func AuthedAdd(ctx context.Context, sig, joinable bool, ticker, name, chatType, author string, deps common.Dependencies) []*discordgo.MessageSend {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
@@ -283,54 +314,96 @@ func Add(ctx context.Context, sig, joinable bool, ticker, name, chatType string,
 		return common.SendErrorf(nil, "`%s` isn't a valid Role Type", chatType)
 	}
 
-	roleID, err := deps.Storage.InsertRole(ctx, name, ticker, chatType, sig, joinable)
-	if err != nil {
-		if errors.Is(err, storage.ErrRoleExists) {
-			return common.SendErrorf(nil, "Role already exists: %s", name)
-		}
+	var (
+		filterResponse []*discordgo.MessageSend
+		response       []*discordgo.MessageSend
+	)
 
-		sp.Error("Error inserting role", zap.Error(err))
-		return common.SendErrorf(nil, "Error inserting role: %s", name)
-	}
+	// The role, its filter and the queued discord role are created together or not at all
+	err := deps.WithTx(ctx, func(deps common.Dependencies) error {
+		roleID, err := deps.Storage.InsertRole(ctx, name, ticker, chatType, sig, joinable)
+		if err != nil {
+			if errors.Is(err, storage.ErrRoleExists) {
+				response = common.SendErrorf(nil, "Role already exists: %s", name)
+				return err
+			}
+
+			sp.Error("Error inserting role", zap.Error(err))
+			response = common.SendErrorf(nil, "Error inserting role: %s", name)
+			return err
+		}
 
-	sp.With(zap.Int("role_id", roleID))
+		sp.With(zap.Int("role_id", roleID))
+
+		// Filters are shared between guilds so the same role in another guild uses the filter that's already there.
+		// A failed insert would abort the transaction so look for it first.
+		filter, err := deps.Storage.GetFilter(ctx, ticker)
+		filterID := filter.ID
+
+		switch {
+		case errors.Is(err, storage.ErrNoFilter):
+			// We now need to create the default filter for this role
+			filterResponse, filterID = filters.Add(
+				ctx,
+				ticker,
+				fmt.Sprintf("Auto-created filter for %s %s", roleType[sig], ticker),
+				deps,
+			)
+			if filterID == -1 {
+				response = append(common.SendError(nil, "Error creating filter"), filterResponse...)
+				return errors.New("error creating filter")
+			}
+		case err != nil:
+			sp.Error("Error getting existing filter", zap.Error(err))
+			response = common.SendError(nil, "Error creating filter")
+			return err
+		}
 
-	role := payloads.Role{
-		Name:        name,
-		Managed:     false,
-		Mentionable: false,
-		Hoist:       false,
-		Color:       0,
-		Position:    0,
-		Permissions: 0,
-	}
+		sp.With(zap.Int("filter_id", filterID))
 
-	// We now need to create the default filter for this role
-	filterResponse, filterID := filters.Add(
-		ctx,
-		ticker,
-		fmt.Sprintf("Auto-created filter for %s %s", roleType[sig], ticker),
-		deps,
-	)
+		err = deps.Storage.InsertRoleFilter(ctx, roleID, filterID)
+		if err != nil {
+			if errors.Is(err, storage.ErrRoleFilterExists) {
+				response = common.SendError(nil, "Role filter already exists")
+				return err
+			}
+			sp.Error("Error inserting role filter", zap.Error(err))
+			response = common.SendError(nil, "Error inserting role filter")
+			return err
+		}
 
-	sp.With(zap.Int("filter_id", filterID))
+		role := payloads.Role{
+			Name:        name,
+			Managed:     false,
+			Mentionable: false,
+			Hoist:       false,
+			Color:       0,
+			Position:    0,
+			Permissions: 0,
+		}
 
-	err = deps.Storage.InsertRoleFilter(ctx, roleID, filterID)
+		return queueUpdate(ctx, role, payloads.Upsert, deps)
+	})
 	if err != nil {
-		if errors.Is(err, storage.ErrRoleFilterExists) {
-			return common.SendError(nil, "Role filter already exists")
+		if response != nil {
+			return response
 		}
-		sp.Error("Error inserting role filter", zap.Error(err))
-		return common.SendError(nil, "Error inserting role filter")
-	}
 
-	err = queueUpdate(ctx, role, payloads.Upsert, deps)
-	if err != nil {
 		sp.Error("error adding role", zap.Error(err))
 		return common.SendFatalf(nil, "error adding role for %s: %s", roleType[sig], err)
 	}
 
 	sp.Info("created role")
+	err = common.Audit(ctx, common.Change{
+		Action:     "create",
+		TargetType: roleType[sig],
+		Target:     ticker,
+		After:      map[string]interface{}{"name": name, "type": chatType, "joinable": joinable},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording %s creation: %s", roleType[sig], err)
+	}
+
 	messages := common.SendSuccessf(nil, "Created %s `%s`", roleType[sig], ticker)
 
 	embed := common.NewEmbed()
@@ -388,19 +461,32 @@ func Destroy(ctx context.Context, sig bool, ticker string, deps common.Dependenc
 
 	sp.With(zap.Int64("chat_id", role.ChatID))
 
-	err = deps.Storage.DeleteRole(ctx, ticker, sig)
-	if err != nil {
-		sp.Error("Error deleting role", zap.Error(err))
-		return common.SendError(nil, "Error deleting role")
-	}
+	// The role is only gone from the database if the discord role delete is queued, and the other way round
+	err = deps.WithTx(ctx, func(deps common.Dependencies) error {
+		err := deps.Storage.DeleteRole(ctx, ticker, sig)
+		if err != nil {
+			sp.Error("Error deleting role", zap.Error(err))
+			return fmt.Errorf("error deleting role: %w", err)
+		}
 
-	err = queueUpdate(ctx, payloads.Role{ID: fmt.Sprintf("%d", role.ChatID)}, payloads.Delete, deps)
+		return queueUpdate(ctx, payloads.Role{ID: fmt.Sprintf("%d", role.ChatID)}, payloads.Delete, deps)
+	})
 	if err != nil {
 		sp.Error("error deleting role", zap.Error(err))
 		return common.SendFatalf(nil, "error deleting role for %s: %s", roleType[sig], err)
 	}
 
 	sp.Info("deleted role")
+	err = common.Audit(ctx, common.Change{
+		Action:     "destroy",
+		TargetType: roleType[sig],
+		Target:     ticker,
+		Before:     role,
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording %s deletion: %s", roleType[sig], err)
+	}
+
 	messages := common.SendSuccessf(nil, "Destroyed %s `%s`", roleType[sig], ticker)
 
 	embed := common.NewEmbed()
@@ -471,6 +557,17 @@ func Update(ctx context.Context, sig bool, ticker string, values map[string]stri
 		return common.SendError(nil, "Error updating role")
 	}
 
+	err = common.Audit(ctx, common.Change{
+		Action:     "update",
+		TargetType: roleType[sig],
+		Target:     ticker,
+		Before:     roleData,
+		After:      values,
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording %s update: %s", roleType[sig], err)
+	}
+
 	role, err := GetChremoasRole(ctx, sig, ticker, deps)
 	if err != nil {
 		sp.Error("error fetching role", zap.Error(err))
@@ -685,7 +782,17 @@ func AddFilter(ctx context.Context, sig bool, name, ticker string, deps common.D
 	}
 
 	sp.Info("added filter")
-	return common.SendSuccessf(nil, "Added filter %s to role %s", filter, ticker)
+	err = common.Audit(ctx, common.Change{
+		Action:     "add_filter",
+		TargetType: roleType[sig],
+		Target:     ticker,
+		After:      map[string]string{"filter": name},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording role filter: %s", err)
+	}
+
+	return common.SendSuccessf(nil, "Added filter %s to role %s", name, ticker)
 }
 
 func AuthedRemoveFilter(ctx context.Context, sig bool, filter, ticker, author string, deps common.Dependencies) []*discordgo.MessageSend {
@@ -729,5 +836,15 @@ func RemoveFilter(ctx context.Context, sig bool, name, ticker string, deps commo
 	}
 
 	sp.Info("removed filter")
+	err = common.Audit(ctx, common.Change{
+		Action:     "remove_filter",
+		TargetType: roleType[sig],
+		Target:     ticker,
+		Before:     map[string]string{"filter": name},
+	}, deps)
+	if err != nil {
+		return common.SendErrorf(nil, "Error recording role filter removal: %s", err)
+	}
+
 	return common.SendSuccessf(nil, "Removed filter %s from role %s", name, ticker)
 }
@@ -0,0 +1,189 @@
+package roles_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/chremoas/chremoas-ng/internal/roles"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+)
+
+func TestAdd(t *testing.T) {
+	cases := []struct {
+		name     string
+		setup    func(t *testing.T, deps common.Dependencies)
+		chatType string
+		ticker   string
+		reply    string
+		// created is whether the role is there afterwards
+		created bool
+		// messages is how many role messages are queued, including any from setup
+		messages int
+	}{
+		{
+			name:   "no type",
+			ticker: "TEST",
+			reply:  "type is required",
+		},
+		{
+			name:     "no ticker",
+			chatType: "discord",
+			reply:    "short name is required",
+		},
+		{
+			name:     "bad type",
+			chatType: "bogus",
+			ticker:   "TEST",
+			reply:    "`bogus` isn't a valid Role Type",
+		},
+		{
+			name:     "new role",
+			chatType: "discord",
+			ticker:   "TEST",
+			reply:    "Created role `TEST`",
+			created:  true,
+			messages: 1,
+		},
+		{
+			name: "filter already there",
+			setup: func(t *testing.T, deps common.Dependencies) {
+				if _, err := deps.Storage.InsertFilter(commontest.Context(), "TEST", "Shared filter"); err != nil {
+					t.Fatalf("InsertFilter: %s", err)
+				}
+			},
+			chatType: "discord",
+			ticker:   "TEST",
+			reply:    "Created role `TEST`",
+			created:  true,
+			messages: 1,
+		},
+		{
+			name: "role already there",
+			setup: func(t *testing.T, deps common.Dependencies) {
+				roles.Add(commontest.Context(), roles.Role, false, "TEST", "Test Corp", "discord", deps)
+			},
+			chatType: "discord",
+			ticker:   "TEST",
+			reply:    "Role already exists: Test Corp",
+			created:  true,
+			messages: 1,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := commontest.Context()
+			deps := commontest.Dependencies(t)
+
+			if c.setup != nil {
+				c.setup(t, deps)
+			}
+
+			reply := commontest.Reply(roles.Add(ctx, roles.Role, false, c.ticker, "Test Corp", c.chatType, deps))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			role, err := deps.Storage.GetRoleByType(ctx, roles.Role, "TEST")
+			if c.created != (err == nil) {
+				t.Fatalf("GetRoleByType: got %+v, %v, want created %t", role, err, c.created)
+			}
+
+			messages := commontest.RoleMessages(t, deps)
+			if len(messages) != c.messages {
+				t.Fatalf("role messages: got %d, want %d", len(messages), c.messages)
+			}
+
+			if !c.created {
+				return
+			}
+
+			if role.Name != "Test Corp" {
+				t.Errorf("role name: got %q, want Test Corp", role.Name)
+			}
+
+			filter, err := deps.Storage.GetFilter(ctx, "TEST")
+			if err != nil {
+				t.Fatalf("GetFilter: %s", err)
+			}
+
+			roleFilters, err := deps.Storage.GetRoleFilters(ctx, roles.Role, "TEST")
+			if err != nil {
+				t.Fatalf("GetRoleFilters: %s", err)
+			}
+
+			if len(roleFilters) != 1 || roleFilters[0].Filter != int64(filter.ID) {
+				t.Errorf("role filters: got %+v, want filter %d", roleFilters, filter.ID)
+			}
+
+			if messages[0].Action != payloads.Upsert || messages[0].Role.Name != "Test Corp" ||
+				messages[0].GuildID != commontest.GuildID {
+				t.Errorf("role message: got %+v, want an upsert of Test Corp", messages[0])
+			}
+		})
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	cases := []struct {
+		name   string
+		ticker string
+		reply  string
+		// deleted is whether a delete is queued for the role
+		deleted bool
+	}{
+		{
+			name:  "no ticker",
+			reply: "short name is required",
+		},
+		{
+			name:   "no such role",
+			ticker: "NOPE",
+			reply:  "No such role",
+		},
+		{
+			name:    "role",
+			ticker:  "TEST",
+			reply:   "Destroyed role `TEST`",
+			deleted: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := commontest.Context()
+			deps := commontest.Dependencies(t)
+
+			commontest.AddRole(t, deps, "TEST", "Test Corp", "42")
+
+			reply := commontest.Reply(roles.Destroy(ctx, roles.Role, c.ticker, deps))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			_, err := deps.Storage.GetRole(ctx, "", "TEST", nil)
+			if c.deleted != errors.Is(err, storage.ErrNoRole) {
+				t.Errorf("GetRole: got %v, want deleted %t", err, c.deleted)
+			}
+
+			// The first message is the upsert from adding the role
+			messages := commontest.RoleMessages(t, deps)
+			if !c.deleted {
+				if len(messages) != 1 {
+					t.Errorf("role messages: got %+v, want only the upsert", messages)
+				}
+				return
+			}
+
+			if len(messages) != 2 || messages[1].Action != payloads.Delete || messages[1].Role.ID != "42" {
+				t.Errorf("role messages: got %+v, want a delete of 42", messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"go.uber.org/zap"
+)
+
+// RoleSyncer is the part of the esi poller that syncs discord roles with the database.
+type RoleSyncer interface {
+	PreviewRoles(ctx context.Context, guildID string) (*common.RolePlan, error)
+	ConfirmRoles(ctx context.Context, guildID, planID string) (int, int, error)
+}
+
+// SyncPreview shows what the next role sync would change in discord without changing anything, and the plan ID
+// !role sync confirm needs to apply it.
+func SyncPreview(ctx context.Context, syncer RoleSyncer, channelID, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("author", author))
+
+	if err := perms.CanPerform(ctx, author, adminType[Role], deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	plan, err := syncer.PreviewRoles(ctx, deps.GuildID)
+	if err != nil {
+		sp.Error("error previewing role sync", zap.Error(err))
+		return common.SendFatalf(&author, "Error previewing role sync: %s", err)
+	}
+
+	if plan.Empty() {
+		return common.SendSuccess(nil, "Discord roles already match, nothing to sync")
+	}
+
+	title := fmt.Sprintf("Role sync %s: %d delete, %d add, %d update", plan.ID(), len(plan.Delete), len(plan.Add),
+		len(plan.Update))
+	if plan.TooManyDeletes() {
+		title = fmt.Sprintf("%s (over the limit of %d deletes, needs !role sync confirm %s)", title,
+			common.SyncMaxDeletes(), plan.ID())
+	} else if common.SyncDryRun() {
+		title = fmt.Sprintf("%s (dry run, needs !role sync confirm %s)", title, plan.ID())
+	}
+
+	err = common.SendChunkedMessage(ctx, channelID, title, plan.Lines(), deps)
+	if err != nil {
+		sp.Error("Error sending chunked message", zap.Error(err))
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// SyncConfirm applies the role sync previewed as planID now, even if it's over the delete limit or a dry run. It's
+// refused if discord or the database changed since the preview.
+func SyncConfirm(ctx context.Context, syncer RoleSyncer, planID, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.String("author", author), zap.String("plan_id", planID))
+
+	if err := perms.CanPerform(ctx, author, adminType[Role], deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	sp.Info("role sync confirmed")
+
+	count, errorCount, err := syncer.ConfirmRoles(ctx, deps.GuildID, planID)
+	if errors.Is(err, common.ErrNoRolePlanPreview) {
+		return common.SendErrorf(&author, "No role sync preview `%s`, run !role sync preview and confirm the ID it shows", planID)
+	}
+	if errors.Is(err, common.ErrRolePlanChanged) {
+		return common.SendError(&author, "Roles changed since the preview, nothing was changed. Run !role sync preview again")
+	}
+	if err != nil {
+		sp.Error("error syncing roles", zap.Error(err))
+		return common.SendFatalf(&author, "Error syncing roles: %s", err)
+	}
+
+	if errorCount > 0 {
+		return common.SendErrorf(&author, "Queued %d role changes, %d failed", count, errorCount)
+	}
+
+	return common.SendSuccessf(&author, "Queued %d role changes", count)
+}
@@ -0,0 +1,75 @@
+package roles_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/roles"
+)
+
+// syncer is a RoleSyncer that returns err from ConfirmRoles and remembers the plan ID it was given.
+type syncer struct {
+	err       error
+	confirmed string
+}
+
+func (s *syncer) PreviewRoles(context.Context, string) (*common.RolePlan, error) {
+	return &common.RolePlan{}, nil
+}
+
+func (s *syncer) ConfirmRoles(_ context.Context, _, planID string) (int, int, error) {
+	if s.err != nil {
+		return -1, -1, s.err
+	}
+
+	s.confirmed = planID
+
+	return 2, 0, nil
+}
+
+func TestSyncConfirm(t *testing.T) {
+	cases := []struct {
+		name  string
+		err   error
+		reply string
+		// confirmed is the plan ID the syncer applied
+		confirmed string
+	}{
+		{
+			name:  "not previewed",
+			err:   common.ErrNoRolePlanPreview,
+			reply: "No role sync preview `abcd1234`, run !role sync preview",
+		},
+		{
+			name:  "changed since the preview",
+			err:   common.ErrRolePlanChanged,
+			reply: "Roles changed since the preview, nothing was changed",
+		},
+		{
+			name:      "previewed",
+			reply:     "Queued 2 role changes",
+			confirmed: "abcd1234",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			deps := commontest.Dependencies(t)
+			commontest.AddPermission(t, deps, "role_admins", "1")
+			s := &syncer{err: c.err}
+
+			reply := commontest.Reply(roles.SyncConfirm(commontest.Context(), s, "abcd1234", "1", deps))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			if s.confirmed != c.confirmed {
+				t.Errorf("confirmed: got %q, want %q", s.confirmed, c.confirmed)
+			}
+		})
+	}
+}
@@ -46,18 +46,26 @@ func New(ctx context.Context, member, sig, author string, deps common.Dependenci
 		sp.Error("error getting roles")
 		return nil, err
 	}
-	if len(roleList) == 0 {
+
+	var role *payloads.Role
+	for i := range roleList {
+		if roleList[i].ShortName == sig {
+			role = &roleList[i]
+			break
+		}
+	}
+	if role == nil {
 		sp.Error("no such sig")
 		return nil, fmt.Errorf("no such sig: `%s`", sig)
 	}
-	if !roleList[0].Sig {
+	if !role.Sig {
 		sp.Error("not a sig")
 		return nil, fmt.Errorf("not a sig: `%s`", sig)
 	}
 
 	return &Sig{
 		dependencies: deps,
-		role:         roleList[0],
+		role:         *role,
 		sig:          sig,
 		userID:       member,
 		author:       author,
@@ -72,7 +80,7 @@ func (s Sig) Add(ctx context.Context) []*discordgo.MessageSend {
 		sp.Error("User not authorized", zap.Error(err))
 		return common.SendError(&s.author, "User not authorized")
 	}
-	return filters.AddMember(ctx, s.userID, s.sig, s.dependencies)
+	return filters.AddMember(common.WithTargetType(ctx, "sig"), s.userID, s.sig, s.dependencies)
 }
 
 func (s Sig) Remove(ctx context.Context) []*discordgo.MessageSend {
@@ -83,7 +91,7 @@ func (s Sig) Remove(ctx context.Context) []*discordgo.MessageSend {
 		sp.Error("User not authorized", zap.Error(err))
 		return common.SendError(&s.author, "User not authorized")
 	}
-	return filters.RemoveMember(ctx, s.userID, s.sig, s.dependencies)
+	return filters.RemoveMember(common.WithTargetType(ctx, "sig"), s.userID, s.sig, s.dependencies)
 }
 
 func (s Sig) Join(ctx context.Context) []*discordgo.MessageSend {
@@ -94,7 +102,7 @@ func (s Sig) Join(ctx context.Context) []*discordgo.MessageSend {
 		return common.SendErrorf(&s.author, "'%s' is not a joinable SIG, talk to an admin", s.sig)
 	}
 
-	return filters.AddMember(ctx, s.userID, s.sig, s.dependencies)
+	return filters.AddMember(common.WithTargetType(ctx, "sig"), s.userID, s.sig, s.dependencies)
 }
 
 func (s Sig) Leave(ctx context.Context) []*discordgo.MessageSend {
@@ -105,5 +113,5 @@ func (s Sig) Leave(ctx context.Context) []*discordgo.MessageSend {
 		return common.SendErrorf(&s.author, "'%s' is not a joinable SIG, talk to an admin", s.sig)
 	}
 
-	return filters.RemoveMember(ctx, s.userID, s.sig, s.dependencies)
+	return filters.RemoveMember(common.WithTargetType(ctx, "sig"), s.userID, s.sig, s.dependencies)
 }
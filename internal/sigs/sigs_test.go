@@ -0,0 +1,183 @@
+package sigs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/common/commontest"
+	"github.com/chremoas/chremoas-ng/internal/roles"
+	"github.com/chremoas/chremoas-ng/internal/sigs"
+)
+
+// admin is in sig_admins
+const admin = "1"
+
+// newDependencies has a joinable sig OPEN and a sig CLOSED only admins can add members to.
+func newDependencies(t *testing.T) common.Dependencies {
+	t.Helper()
+
+	ctx := commontest.Context()
+	deps := commontest.Dependencies(t)
+	commontest.AddPermission(t, deps, "sig_admins", admin)
+
+	roles.Add(ctx, roles.Sig, false, "CLOSED", "Closed Sig", "discord", deps)
+	roles.Add(ctx, roles.Sig, true, "OPEN", "Open Sig", "discord", deps)
+
+	return deps
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name   string
+		member string
+		sig    string
+		err    string
+	}{
+		{name: "member ID", member: "123", sig: "OPEN"},
+		{name: "mention", member: "<@123>", sig: "OPEN"},
+		{name: "not a user", member: "bob", sig: "OPEN", err: "second argument must be a discord user"},
+		{name: "no such sig", member: "123", sig: "NOPE", err: "no such sig: `NOPE`"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			_, err := sigs.New(commontest.Context(), c.member, c.sig, "123", newDependencies(t))
+
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+
+			if got != c.err {
+				t.Errorf("got error %q, want %q", got, c.err)
+			}
+		})
+	}
+}
+
+func TestJoinAndAdd(t *testing.T) {
+	cases := []struct {
+		name   string
+		author string
+		sig    string
+		// add uses !sig add instead of !sig join
+		add   bool
+		reply string
+		// member is whether 123 is in the sig afterwards
+		member bool
+	}{
+		{
+			name:   "join",
+			author: "123",
+			sig:    "OPEN",
+			reply:  "Added <@123> to `OPEN`",
+			member: true,
+		},
+		{
+			name:   "join a sig that isn't joinable",
+			author: "123",
+			sig:    "CLOSED",
+			reply:  "'CLOSED' is not a joinable SIG",
+		},
+		{
+			name:   "added by an admin",
+			author: admin,
+			sig:    "CLOSED",
+			add:    true,
+			reply:  "Added <@123> to `CLOSED`",
+			member: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := commontest.Context()
+			deps := newDependencies(t)
+
+			sig, err := sigs.New(ctx, "123", c.sig, c.author, deps)
+			if err != nil {
+				t.Fatalf("New: %s", err)
+			}
+
+			join := sig.Join
+			if c.add {
+				join = sig.Add
+			}
+
+			reply := commontest.Reply(join(ctx))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			members := commontest.FilterMembers(t, deps, c.sig)
+			if c.member != (len(members) == 1 && members[0] == 123) {
+				t.Errorf("members of %s: got %v, want member %t", c.sig, members, c.member)
+			}
+		})
+	}
+}
+
+func TestLeaveAndRemove(t *testing.T) {
+	cases := []struct {
+		name   string
+		author string
+		sig    string
+		remove bool
+		reply  string
+		// member is whether 123 is still in the sig afterwards
+		member bool
+	}{
+		{
+			name:   "leave",
+			author: "123",
+			sig:    "OPEN",
+			reply:  "Removed 123 from `OPEN`",
+		},
+		{
+			name:   "leave a sig that isn't joinable",
+			author: "123",
+			sig:    "CLOSED",
+			reply:  "'CLOSED' is not a joinable SIG",
+			member: true,
+		},
+		{
+			name:   "removed by an admin",
+			author: admin,
+			sig:    "CLOSED",
+			remove: true,
+			reply:  "Removed 123 from `CLOSED`",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := commontest.Context()
+			deps := newDependencies(t)
+			commontest.AddFilterMember(t, deps, c.sig, "123")
+
+			sig, err := sigs.New(ctx, "123", c.sig, c.author, deps)
+			if err != nil {
+				t.Fatalf("New: %s", err)
+			}
+
+			leave := sig.Leave
+			if c.remove {
+				leave = sig.Remove
+			}
+
+			reply := commontest.Reply(leave(ctx))
+			if !strings.Contains(reply, c.reply) {
+				t.Errorf("reply: got %q, want %q", reply, c.reply)
+			}
+
+			members := commontest.FilterMembers(t, deps, c.sig)
+			if c.member != (len(members) == 1) {
+				t.Errorf("members of %s: got %v, want member %t", c.sig, members, c.member)
+			}
+		})
+	}
+}
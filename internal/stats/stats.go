@@ -0,0 +1,340 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/chremoas/chremoas-ng/internal/perms"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	serverAdmins = "server_admins"
+	// How many sigs Trends lists
+	topSigs = 10
+	// How far back trends and series look if they aren't told
+	DefaultTrendDays = 30
+	// How far back history and exports look if they aren't told
+	DefaultHistoryDays = 90
+	dayFormat          = "2006-01-02"
+)
+
+// Trends shows how many members each corporation, alliance and role has now against days ago, and the biggest sigs.
+func Trends(ctx context.Context, days int, channelID, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.Int("days", days),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	snapshots, err := deps.Storage.GetMembershipSnapshots(ctx, payloads.MembershipSnapshotQuery{
+		Since: daysAgo(days),
+	})
+	if err != nil {
+		sp.Error("Error getting membership snapshots", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting membership snapshots: %s", err)
+	}
+
+	if len(snapshots) == 0 {
+		return common.SendSuccess(nil, "No membership counts yet, they're taken by the poller's stats stage")
+	}
+
+	first, latest := firstAndLatest(snapshots)
+
+	var lines []string
+	for _, kind := range []string{storage.SnapshotCorporation, storage.SnapshotAlliance, storage.SnapshotRole} {
+		for _, snapshot := range byMembers(latest, kind) {
+			was := first[snapshotKey(snapshot)]
+			lines = append(lines, fmt.Sprintf("`%s` **%s**: %d (%+d since %s)", kind, snapshot.Name,
+				snapshot.Members, snapshot.Members-was.Members, was.Day.Format(dayFormat)))
+		}
+	}
+
+	sigs := byMembers(latest, storage.SnapshotSig)
+	if len(sigs) > topSigs {
+		sigs = sigs[:topSigs]
+	}
+
+	for i, snapshot := range sigs {
+		was := first[snapshotKey(snapshot)]
+		lines = append(lines, fmt.Sprintf("%d. sig **%s**: %d (%+d since %s)", i+1, snapshot.Name,
+			snapshot.Members, snapshot.Members-was.Members, was.Day.Format(dayFormat)))
+	}
+
+	err = common.SendChunkedMessage(ctx, channelID, fmt.Sprintf("Membership over the last %d days", days), lines, deps)
+	if err != nil {
+		sp.Error("Error sending chunked message", zap.Error(err))
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// Series shows the daily member counts of a corporation, alliance, sig or role.
+func Series(ctx context.Context, name string, days int, channelID, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("name", name),
+		zap.Int("days", days),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	snapshots, err := deps.Storage.GetMembershipSnapshots(ctx, payloads.MembershipSnapshotQuery{
+		Name:  name,
+		Since: daysAgo(days),
+	})
+	if err != nil {
+		sp.Error("Error getting membership snapshots", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting membership snapshots: %s", err)
+	}
+
+	if len(snapshots) == 0 {
+		return common.SendErrorf(nil, "No membership counts for `%s`", name)
+	}
+
+	// A corporation and a role can share a name
+	previous := make(map[string]int)
+	lines := make([]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		change := ""
+		if was, ok := previous[snapshot.Kind]; ok {
+			change = fmt.Sprintf(" (%+d)", snapshot.Members-was)
+		}
+		previous[snapshot.Kind] = snapshot.Members
+
+		lines[i] = fmt.Sprintf("**%s** `%s` %d%s", snapshot.Day.Format(dayFormat), snapshot.Kind, snapshot.Members,
+			change)
+	}
+
+	err = common.SendChunkedMessage(ctx, channelID, fmt.Sprintf("Members of %s", name), lines, deps)
+	if err != nil {
+		sp.Error("Error sending chunked message", zap.Error(err))
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// History shows when a user joined and left filters, and why.
+func History(ctx context.Context, userID string, days int, channelID, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("user_id", userID),
+		zap.Int("days", days),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	if !common.IsDiscordUser(userID) {
+		return common.SendError(nil, "second argument must be a discord user")
+	}
+	userID = common.ExtractUserId(userID)
+
+	events, err := deps.Storage.GetMembershipHistory(ctx, payloads.MembershipHistoryQuery{
+		UserID: userID,
+		Since:  daysAgo(days),
+	})
+	if err != nil {
+		sp.Error("Error getting membership history", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting membership history: %s", err)
+	}
+
+	if len(events) == 0 {
+		return common.SendSuccessf(nil, "No membership changes for <@%s> in the last %d days", userID, days)
+	}
+
+	lines := make([]string, len(events))
+	for i, event := range events {
+		lines[i] = fmt.Sprintf("**%s** %s `%s` by %s", event.CreatedAt.UTC().Format("2006-01-02 15:04 MST"),
+			event.Event, event.FilterName, cause(event))
+	}
+
+	err = common.SendChunkedMessage(ctx, channelID, "Membership history", lines, deps)
+	if err != nil {
+		sp.Error("Error sending chunked message", zap.Error(err))
+		return common.SendErrorf(nil, "Error sending chunked message: %s", err)
+	}
+
+	return nil
+}
+
+// Export sends the daily counts, or the membership history if what is "history", as a CSV file.
+func Export(ctx context.Context, what string, days int, author string, deps common.Dependencies) []*discordgo.MessageSend {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(
+		zap.String("what", what),
+		zap.Int("days", days),
+		zap.String("author", author),
+	)
+
+	if err := perms.CanPerform(ctx, author, serverAdmins, deps); err != nil {
+		sp.Warn("user doesn't have permission to this command", zap.Error(err))
+		return common.SendError(&author, "User doesn't have permission to this command")
+	}
+
+	var (
+		records [][]string
+		err     error
+	)
+
+	switch what {
+	case "snapshots":
+		records, err = snapshotRecords(ctx, days, deps)
+	case "history":
+		records, err = historyRecords(ctx, days, deps)
+	default:
+		return common.SendErrorf(nil, "Unknown export `%s`, expected snapshots or history", what)
+	}
+
+	if err != nil {
+		sp.Error("Error getting export", zap.Error(err))
+		return common.SendErrorf(nil, "Error getting %s: %s", what, err)
+	}
+
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	if err = w.WriteAll(records); err != nil {
+		sp.Error("Error writing csv", zap.Error(err))
+		return common.SendErrorf(nil, "Error writing csv: %s", err)
+	}
+
+	return []*discordgo.MessageSend{{
+		Content: fmt.Sprintf("Membership %s for the last %d days", what, days),
+		Files: []*discordgo.File{{
+			Name:        fmt.Sprintf("membership-%s-%s.csv", what, time.Now().UTC().Format(dayFormat)),
+			ContentType: "text/csv",
+			Reader:      &buffer,
+		}},
+	}}
+}
+
+func snapshotRecords(ctx context.Context, days int, deps common.Dependencies) ([][]string, error) {
+	snapshots, err := deps.Storage.GetMembershipSnapshots(ctx, payloads.MembershipSnapshotQuery{
+		Since: daysAgo(days),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records := [][]string{{"day", "kind", "name", "members"}}
+	for _, snapshot := range snapshots {
+		records = append(records, []string{snapshot.Day.Format(dayFormat), snapshot.Kind, snapshot.Name,
+			strconv.Itoa(snapshot.Members)})
+	}
+
+	return records, nil
+}
+
+func historyRecords(ctx context.Context, days int, deps common.Dependencies) ([][]string, error) {
+	events, err := deps.Storage.GetMembershipHistory(ctx, payloads.MembershipHistoryQuery{
+		Since: daysAgo(days),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records := [][]string{{"time", "user_id", "event", "filter", "cause", "actor_id", "correlation_id"}}
+	for _, event := range events {
+		records = append(records, []string{event.CreatedAt.UTC().Format(time.RFC3339), event.UserID, event.Event,
+			event.FilterName, event.Cause, event.ActorID, event.CorrelationID})
+	}
+
+	return records, nil
+}
+
+// ParseDays reads a number of days, e.g. 30 or 30d.
+func ParseDays(arg string) (int, bool) {
+	days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+	if err != nil || days <= 0 {
+		return 0, false
+	}
+
+	return days, true
+}
+
+func daysAgo(days int) time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()-days, 0, 0, 0, 0, time.UTC)
+}
+
+func cause(event payloads.MembershipEvent) string {
+	if event.ActorID != "" {
+		return fmt.Sprintf("<@%s>", event.ActorID)
+	}
+
+	return event.Cause
+}
+
+func snapshotKey(snapshot payloads.MembershipSnapshot) string {
+	return snapshot.Kind + "/" + snapshot.Name
+}
+
+// firstAndLatest picks each name's earliest count, and the counts from the latest day. Snapshots are oldest first.
+func firstAndLatest(snapshots []payloads.MembershipSnapshot) (map[string]payloads.MembershipSnapshot, []payloads.MembershipSnapshot) {
+	first := make(map[string]payloads.MembershipSnapshot)
+	for _, snapshot := range snapshots {
+		if _, ok := first[snapshotKey(snapshot)]; !ok {
+			first[snapshotKey(snapshot)] = snapshot
+		}
+	}
+
+	lastDay := snapshots[len(snapshots)-1].Day
+
+	var latest []payloads.MembershipSnapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Day.Equal(lastDay) {
+			latest = append(latest, snapshot)
+		}
+	}
+
+	return first, latest
+}
+
+// byMembers returns the snapshots of the kind, biggest first.
+func byMembers(snapshots []payloads.MembershipSnapshot, kind string) []payloads.MembershipSnapshot {
+	var matched []payloads.MembershipSnapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Kind == kind {
+			matched = append(matched, snapshot)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Members > matched[j].Members
+	})
+
+	return matched
+}
@@ -155,21 +155,11 @@ func (s Storage) UpsertAlliance(ctx context.Context, allianceID int32, name, tic
 		sp.Debug("UpsertAlliance(): sql query")
 	}
 
-	rows, err := insert.QueryContext(ctx)
+	_, err = insert.ExecContext(ctx)
 	if err != nil {
 		sp.Error("Error updating alliance", zap.Error(err))
 		return err
 	}
 
-	defer func() {
-		if rows == nil {
-			return
-		}
-
-		if err = rows.Close(); err != nil {
-			sp.Error("error closing row", zap.Error(err))
-		}
-	}()
-
 	return nil
 }
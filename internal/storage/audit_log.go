@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"go.uber.org/zap"
+)
+
+// InsertAuditEntry records an administrative change. The guild is the storage's.
+func (s Storage) InsertAuditEntry(ctx context.Context, entry payloads.AuditEntry) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	insert := s.DB.Insert("audit_log").
+		Columns("guild_id", "actor_type", "actor_id", "action", "target_type", "target", "member_id", "before",
+			"after", "correlation_id").
+		Values(s.GuildID, entry.ActorType, entry.ActorID, entry.Action, entry.TargetType, entry.Target,
+			entry.MemberID, jsonb(entry.Before), jsonb(entry.After), entry.CorrelationID)
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("InsertAuditEntry(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error inserting audit entry", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditLog returns the entries matching the query, newest first.
+func (s Storage) GetAuditLog(ctx context.Context, q payloads.AuditQuery) ([]payloads.AuditEntry, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("id", "guild_id", "actor_type", "actor_id", "action", "target_type", "target", "member_id",
+		"before", "after", "correlation_id", "created_at").
+		From("audit_log").
+		OrderBy("created_at DESC", "id DESC").
+		Limit(q.Limit)
+
+	if q.UserID != "" {
+		query = query.Where(sq.Or{sq.Eq{"actor_id": q.UserID}, sq.Eq{"member_id": q.UserID}})
+	}
+
+	if q.Target != "" {
+		query = query.Where(sq.Eq{"target": q.Target})
+	}
+
+	if !q.Since.IsZero() {
+		query = query.Where(sq.GtOrEq{"created_at": q.Since})
+	}
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetAuditLog(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting audit log", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	var entries []payloads.AuditEntry
+
+	for rows.Next() {
+		var entry payloads.AuditEntry
+
+		err = rows.Scan(&entry.ID, &entry.GuildID, &entry.ActorType, &entry.ActorID, &entry.Action, &entry.TargetType,
+			&entry.Target, &entry.MemberID, &entry.Before, &entry.After, &entry.CorrelationID, &entry.CreatedAt)
+		if err != nil {
+			sp.Error("error scanning audit entry", zap.Error(err))
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// jsonb passes JSON as text, lib/pq would send a []byte as bytea. Empty is NULL.
+func jsonb(value []byte) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+
+	return string(value)
+}
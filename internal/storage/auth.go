@@ -75,7 +75,7 @@ func (s Storage) DeleteAuthCodes(ctx context.Context, characterID int32) error {
 		sp.Debug("DeleteAuthCode(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting user's authentication codes from the db", zap.Error(err))
 		return fmt.Errorf("error deleting user's auth codes: %w", err)
@@ -107,7 +107,7 @@ func (s Storage) InsertAuthCode(ctx context.Context, characterID int32, authCode
 		sp.Debug("InsertAuthCode(): sql query")
 	}
 
-	_, err = insert.QueryContext(ctx)
+	_, err = insert.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error inserting authentication code", zap.Error(err))
 		return err
@@ -139,7 +139,7 @@ func (s Storage) UpdateAuthCode(ctx context.Context, authCode string) error {
 		sp.Debug("UpdateAuthCode(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error updating authentication code", zap.Error(err))
 		return err
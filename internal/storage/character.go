@@ -79,6 +79,45 @@ func (s Storage) GetCharacter(ctx context.Context, characterID int) (payloads.Ch
 	return character, nil
 }
 
+// GetMainCharacter returns the first character a discord user authed, nicknames are built from it.
+func (s Storage) GetMainCharacter(ctx context.Context, chatID string) (payloads.Character, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	query := s.DB.Select("characters.id", "characters.name", "characters.corporation_id").
+		From("characters").
+		Join("user_character_map ON user_character_map.character_id = characters.id").
+		Where(sq.Eq{"user_character_map.chat_id": chatID}).
+		OrderBy("characters.inserted_at", "characters.id").
+		Limit(1)
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return payloads.Character{}, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetMainCharacter(): sql query")
+	}
+
+	var character payloads.Character
+
+	err = query.Scan(&character.ID, &character.Name, &character.CorporationID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return payloads.Character{}, ErrNoCharacter
+		}
+
+		sp.Error("error getting main character", zap.Error(err))
+		return payloads.Character{}, err
+	}
+
+	return character, nil
+}
+
 func (s Storage) GetCharacters(ctx context.Context) ([]payloads.Character, error) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
@@ -172,21 +211,11 @@ func (s Storage) UpsertCharacter(ctx context.Context, characterID, corporationID
 		sp.Debug("UpsertCharacter(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("Error inserting character", zap.Error(err))
 	}
 
-	defer func() {
-		if rows == nil {
-			return
-		}
-
-		if err = rows.Close(); err != nil {
-			sp.Error("error closing row", zap.Error(err))
-		}
-	}()
-
 	return nil
 }
 
@@ -219,7 +248,7 @@ func (s Storage) DeleteCharacter(ctx context.Context, characterID int32) error {
 		sp.Debug("DeleteCharacter(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting user's character from the db", zap.Error(err))
 		return err
@@ -171,20 +171,65 @@ func (s Storage) UpsertCorporation(ctx context.Context, corporationID, allianceI
 		sp.Debug("UpsertCorporation(): sql query")
 	}
 
-	rows, err := insert.QueryContext(ctx)
+	_, err = insert.ExecContext(ctx)
 	if err != nil {
 		sp.Error("Error upserting corporation %d alliance: %v: %s", zap.Error(err))
 	}
 
-	defer func() {
-		if rows == nil {
-			return
-		}
+	return nil
+}
+
+// GetUserCorporations returns the corporations of every character a discord user has authed.
+func (s Storage) GetUserCorporations(ctx context.Context, chatID string) ([]payloads.Corporation, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("corporations.id", "corporations.name", "corporations.ticker", "corporations.alliance_id").
+		Distinct().
+		From("corporations").
+		Join("characters ON characters.corporation_id = corporations.id").
+		Join("user_character_map ON user_character_map.character_id = characters.id").
+		Where(sq.Eq{"user_character_map.chat_id": chatID})
 
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetUserCorporations(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting user corporations", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
 		if err = rows.Close(); err != nil {
-			sp.Error("error closing row", zap.Error(err))
+			sp.Error("error closing rows", zap.Error(err))
 		}
 	}()
 
-	return nil
+	var corporations []payloads.Corporation
+
+	for rows.Next() {
+		var corporation payloads.Corporation
+
+		err = rows.Scan(&corporation.ID, &corporation.Name, &corporation.Ticker, &corporation.AllianceID)
+		if err != nil {
+			sp.Error("error scanning corporation", zap.Error(err))
+			return nil, err
+		}
+
+		corporations = append(corporations, corporation)
+	}
+
+	return corporations, nil
 }
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"go.uber.org/zap"
+)
+
+var ErrNoCacheEntry = errors.New("no cache entry")
+
+// GetCacheEntry returns a cached ESI response and marks it as used, so it's evicted last.
+func (s Storage) GetCacheEntry(ctx context.Context, key string) ([]byte, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var value []byte
+
+	query := s.DB.Update("esi_cache").
+		Set("accessed_at", sq.Expr("NOW()")).
+		Where(sq.Eq{"key": key}).
+		Suffix("RETURNING \"value\"")
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetCacheEntry(): sql query")
+	}
+
+	err = query.QueryRowContext(ctx).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoCacheEntry
+		}
+
+		sp.Error("error getting cache entry", zap.Error(err))
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (s Storage) UpsertCacheEntry(ctx context.Context, key string, value []byte) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	insert := s.DB.Insert("esi_cache").
+		Columns("key", "value", "size").
+		Values(key, value, len(value)).
+		Suffix("ON CONFLICT (key) DO UPDATE SET value=?, size=?, inserted_at=NOW(), accessed_at=NOW()", value, len(value))
+
+	sqlStr, _, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.String("key", key),
+			zap.Int("size", len(value)),
+		)
+		sp.Debug("UpsertCacheEntry(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error upserting cache entry", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s Storage) DeleteCacheEntry(ctx context.Context, key string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("esi_cache").
+		Where(sq.Eq{"key": key})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("DeleteCacheEntry(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error deleting cache entry", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// EvictCache deletes entries not used within maxAge and then the least recently used entries until the cache is no
+// bigger than maxSize bytes. It returns how many entries were deleted.
+func (s Storage) EvictCache(ctx context.Context, maxSize int64, maxAge time.Duration) (int64, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("esi_cache").
+		Where(sq.Or{
+			sq.Lt{"accessed_at": time.Now().Add(-maxAge)},
+			sq.Expr("key IN (SELECT key FROM (SELECT key, SUM(size) OVER (ORDER BY accessed_at DESC, key) AS total FROM esi_cache) AS sizes WHERE total > ?)", maxSize),
+		})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return 0, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("EvictCache(): sql query")
+	}
+
+	result, err := query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error evicting cache entries", zap.Error(err))
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// FlushCache deletes every entry and returns how many there were.
+func (s Storage) FlushCache(ctx context.Context) (int64, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("esi_cache")
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return 0, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("FlushCache(): sql query")
+	}
+
+	result, err := query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error flushing cache", zap.Error(err))
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s Storage) GetCacheStats(ctx context.Context) (payloads.ESICacheStats, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stats payloads.ESICacheStats
+
+	query := s.DB.Select("COUNT(*)", "COALESCE(SUM(size), 0)", "MAX(accessed_at)").
+		From("esi_cache")
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return stats, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetCacheStats(): sql query")
+	}
+
+	err = query.QueryRowContext(ctx).Scan(&stats.Entries, &stats.Size, &stats.LastAccessed)
+	if err != nil {
+		sp.Error("error getting cache stats", zap.Error(err))
+		return stats, err
+	}
+
+	return stats, nil
+}
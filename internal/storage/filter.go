@@ -123,7 +123,8 @@ func (s Storage) GetTickerFilters(ctx context.Context, sig bool, ticker string)
 		Join("role_filters ON role_filters.filter = filters.id").
 		Join("roles ON roles.id = role_filters.role").
 		Where(sq.Eq{"roles.role_nick": ticker}).
-		Where(sq.Eq{"roles.sig": sig})
+		Where(sq.Eq{"roles.sig": sig}).
+		Where(sq.Eq{"roles.guild_id": s.GuildID})
 
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
@@ -166,6 +167,60 @@ func (s Storage) GetTickerFilters(ctx context.Context, sig bool, ticker string)
 	return filters, nil
 }
 
+// GetUserFilters lists the filters a user is a member of.
+func (s Storage) GetUserFilters(ctx context.Context, userID string) ([]payloads.Filter, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("filters.id", "filters.name", "filters.description").
+		From("filters").
+		Join("filter_membership ON filter_membership.filter = filters.id").
+		Where(sq.Eq{"filter_membership.user_id": userID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetUserFilters(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error fetching user filters", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			sp.Error("error closing database", zap.Error(err))
+		}
+	}()
+
+	var filters []payloads.Filter
+
+	for rows.Next() {
+		var filter payloads.Filter
+
+		err = rows.Scan(&filter.ID, &filter.Name, &filter.Description)
+		if err != nil {
+			sp.Error("error scanning filters", zap.Error(err))
+			return nil, err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
 func (s Storage) InsertFilter(ctx context.Context, name, description string) (int, error) {
 	ctx, sp := sl.OpenSpan(ctx)
 	defer sp.Close()
@@ -246,7 +301,7 @@ func (s Storage) DeleteFilter(ctx context.Context, name string) error {
 		sp.Debug("DeleteFilter(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting filter", zap.Error(err))
 		return err
@@ -288,7 +343,7 @@ func (s Storage) DeleteFilterByID(ctx context.Context, id int) error {
 		sp.Debug("DeleteFilterByID(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting filter", zap.Error(err))
 		return err
@@ -313,6 +368,9 @@ func (s Storage) DeleteFilterMembership(ctx context.Context, filterID int, userI
 		query = query.Where(sq.Eq{"user_id": userID})
 	}
 
+	// The users that left are recorded in the membership history
+	query = query.Suffix("RETURNING user_id")
+
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
 		sp.Error("error getting sql", zap.Error(err))
@@ -325,13 +383,30 @@ func (s Storage) DeleteFilterMembership(ctx context.Context, filterID int, userI
 		sp.Debug("DeleteFilterMembership(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	rows, err := query.QueryContext(ctx)
 	if err != nil {
 		sp.Error("error deleting filter membership", zap.Error(err))
 		return err
 	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
 
-	return nil
+	var userIDs []string
+
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			sp.Error("error scanning deleted filter_membership userID", zap.Error(err))
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return s.recordMembership(ctx, filterID, MembershipLeave, userIDs)
 }
 
 func (s Storage) ListFilterMembers(ctx context.Context, filter string) ([]int64, error) {
@@ -408,7 +483,7 @@ func (s Storage) AddFilterMembership(ctx context.Context, filterID int, userID s
 		sp.Debug("AddFilterMembership(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		// I don't love this, but I can't find a better way right now
 		if err.(*pq.Error).Code == "23505" {
@@ -418,14 +493,8 @@ func (s Storage) AddFilterMembership(ctx context.Context, filterID int, userID s
 		sp.Error("error inserting filter", zap.Error(err))
 		return err
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			sp.Error("error closing database", zap.Error(err))
-		}
-	}()
 
-	return nil
+	return s.recordMembership(ctx, filterID, MembershipJoin, []string{userID})
 }
 
 // GetRoleMembers I need to re-evaluate this query. I don't really remember why it needed to be so complex.
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoMemberState   = errors.New("no such member state")
+	ErrExemptionExists = errors.New("member is already exempt")
+	ErrNoExemption     = errors.New("member isn't exempt")
+)
+
+func (s Storage) GetMemberState(ctx context.Context, chatID string) (payloads.MemberState, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	query := s.DB.Select("guild_id", "chat_id", "joined_at", "last_authed", "reminded_at", "quarantined_at").
+		From("member_state").
+		Where(sq.Eq{"guild_id": s.GuildID}).
+		Where(sq.Eq{"chat_id": chatID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return payloads.MemberState{}, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetMemberState(): sql query")
+	}
+
+	var state payloads.MemberState
+
+	err = query.Scan(
+		&state.GuildID,
+		&state.ChatID,
+		&state.JoinedAt,
+		&state.LastAuthed,
+		&state.RemindedAt,
+		&state.QuarantinedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return payloads.MemberState{}, ErrNoMemberState
+		}
+
+		sp.Error("error getting member state", zap.Error(err))
+		return payloads.MemberState{}, err
+	}
+
+	return state, nil
+}
+
+// InsertMemberState starts tracking a member, members that are already tracked keep their state.
+func (s Storage) InsertMemberState(ctx context.Context, chatID string, joinedAt time.Time) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	insert := s.DB.Insert("member_state").
+		Columns("guild_id", "chat_id", "joined_at").
+		Values(s.GuildID, chatID, joinedAt).
+		Suffix("ON CONFLICT (guild_id, chat_id) DO NOTHING")
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("InsertMemberState(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error inserting member state", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// UpdateMemberAuthed records that the member has a character the member policy allows.
+func (s Storage) UpdateMemberAuthed(ctx context.Context, chatID string) error {
+	return s.updateMemberState(ctx, "UpdateMemberAuthed", chatID, "last_authed", time.Now())
+}
+
+func (s Storage) UpdateMemberReminded(ctx context.Context, chatID string) error {
+	return s.updateMemberState(ctx, "UpdateMemberReminded", chatID, "reminded_at", time.Now())
+}
+
+func (s Storage) UpdateMemberQuarantined(ctx context.Context, chatID string, quarantined bool) error {
+	if quarantined {
+		return s.updateMemberState(ctx, "UpdateMemberQuarantined", chatID, "quarantined_at", time.Now())
+	}
+
+	return s.updateMemberState(ctx, "UpdateMemberQuarantined", chatID, "quarantined_at", nil)
+}
+
+func (s Storage) updateMemberState(ctx context.Context, caller, chatID, column string, value interface{}) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Update("member_state").
+		Set(column, value).
+		Where(sq.Eq{"guild_id": s.GuildID}).
+		Where(sq.Eq{"chat_id": chatID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug(caller + "(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error updating member state", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s Storage) DeleteMemberState(ctx context.Context, chatID string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("member_state").
+		Where(sq.Eq{"guild_id": s.GuildID}).
+		Where(sq.Eq{"chat_id": chatID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("DeleteMemberState(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error deleting member state", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s Storage) GetMemberPolicyExemptions(ctx context.Context) ([]payloads.MemberPolicyExemption, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("chat_id", "reason", "inserted_at").
+		From("member_policy_exemptions").
+		OrderBy("inserted_at")
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetMemberPolicyExemptions(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting member policy exemptions", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	var exemptions []payloads.MemberPolicyExemption
+
+	for rows.Next() {
+		var exemption payloads.MemberPolicyExemption
+
+		err = rows.Scan(&exemption.ChatID, &exemption.Reason, &exemption.InsertedAt)
+		if err != nil {
+			sp.Error("error scanning member policy exemption", zap.Error(err))
+			return nil, err
+		}
+
+		exemptions = append(exemptions, exemption)
+	}
+
+	return exemptions, nil
+}
+
+func (s Storage) InsertMemberPolicyExemption(ctx context.Context, chatID, reason string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	insert := s.DB.Insert("member_policy_exemptions").
+		Columns("chat_id", "reason").
+		Values(chatID, reason)
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("InsertMemberPolicyExemption(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrExemptionExists
+		}
+
+		sp.Error("error inserting member policy exemption", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s Storage) DeleteMemberPolicyExemption(ctx context.Context, chatID string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("member_policy_exemptions").
+		Where(sq.Eq{"chat_id": chatID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("DeleteMemberPolicyExemption(): sql query")
+	}
+
+	result, err := query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error deleting member policy exemption", zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		sp.Error("error getting rows affected", zap.Error(err))
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoExemption
+	}
+
+	return nil
+}
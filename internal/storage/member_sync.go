@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"go.uber.org/zap"
+)
+
+// BumpMemberSync counts another sync queued for the member and returns the new count.
+func (s Storage) BumpMemberSync(ctx context.Context, chatID string) (int64, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	insert := s.DB.Insert("member_sync").
+		Columns("guild_id", "chat_id").
+		Values(s.GuildID, chatID).
+		Suffix("ON CONFLICT (guild_id, chat_id) DO UPDATE SET seq = member_sync.seq + 1 RETURNING seq")
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return 0, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("BumpMemberSync(): sql query")
+	}
+
+	var seq int64
+
+	err = insert.QueryRowContext(ctx).Scan(&seq)
+	if err != nil {
+		sp.Error("error bumping member sync", zap.Error(err))
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+// GetMemberSync returns how many syncs have been queued for the member, 0 if none have.
+func (s Storage) GetMemberSync(ctx context.Context, chatID string) (int64, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	query := s.DB.Select("seq").
+		From("member_sync").
+		Where(sq.Eq{"guild_id": s.GuildID}).
+		Where(sq.Eq{"chat_id": chatID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return 0, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetMemberSync(): sql query")
+	}
+
+	var seq int64
+
+	err = query.QueryRowContext(ctx).Scan(&seq)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+
+		sp.Error("error getting member sync", zap.Error(err))
+		return 0, err
+	}
+
+	return seq, nil
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"go.uber.org/zap"
+)
+
+// Membership history events
+const (
+	MembershipJoin  = "join"
+	MembershipLeave = "leave"
+)
+
+// Kinds of membership snapshot
+const (
+	SnapshotCorporation = "corporation"
+	SnapshotAlliance    = "alliance"
+	SnapshotSig         = "sig"
+	SnapshotRole        = "role"
+)
+
+type membershipCauseKey struct{}
+
+type membershipCause struct {
+	cause   string
+	actorID string
+}
+
+// WithMembershipCause records filter membership changes made with the context as caused by the actor, see
+// common.WithUser. Changes with no cause are recorded as made by the system.
+func WithMembershipCause(ctx context.Context, cause, actorID string) context.Context {
+	return context.WithValue(ctx, membershipCauseKey{}, membershipCause{cause: cause, actorID: actorID})
+}
+
+func membershipCauseFrom(ctx context.Context) membershipCause {
+	if c, ok := ctx.Value(membershipCauseKey{}).(membershipCause); ok {
+		return c
+	}
+
+	return membershipCause{cause: "system"}
+}
+
+// recordMembership adds an event to the membership history for each user. The history is kept by the filter's name
+// as the filter may not outlive it. Failing to record history is logged but doesn't fail the change, except in a
+// transaction where the failed insert has aborted it and the error is returned.
+func (s Storage) recordMembership(ctx context.Context, filterID int, event string, userIDs []string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cause := membershipCauseFrom(ctx)
+
+	sp.With(
+		zap.Int("filter_id", filterID),
+		zap.String("event", event),
+		zap.String("cause", cause.cause),
+		zap.String("actor_id", cause.actorID),
+	)
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	insert := s.DB.Insert("membership_history").
+		Columns("filter_name", "user_id", "event", "cause", "actor_id", "correlation_id")
+
+	for _, userID := range userIDs {
+		insert = insert.Values(sq.Expr("(SELECT name FROM filters WHERE id = ?)", filterID), userID, event,
+			cause.cause, cause.actorID, sp.GetCorrelationID())
+	}
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("recordMembership(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error recording membership history", zap.Error(err))
+		if s.tx != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetMembershipHistory returns the membership events matching the query, newest first.
+func (s Storage) GetMembershipHistory(ctx context.Context, q payloads.MembershipHistoryQuery) ([]payloads.MembershipEvent, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("id", "filter_name", "user_id", "event", "cause", "actor_id", "correlation_id",
+		"created_at").
+		From("membership_history").
+		OrderBy("created_at DESC", "id DESC")
+
+	if q.UserID != "" {
+		query = query.Where(sq.Eq{"user_id": q.UserID})
+	}
+
+	if !q.Since.IsZero() {
+		query = query.Where(sq.GtOrEq{"created_at": q.Since})
+	}
+
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetMembershipHistory(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting membership history", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	var events []payloads.MembershipEvent
+
+	for rows.Next() {
+		var event payloads.MembershipEvent
+
+		err = rows.Scan(&event.ID, &event.FilterName, &event.UserID, &event.Event, &event.Cause, &event.ActorID,
+			&event.CorrelationID, &event.CreatedAt)
+		if err != nil {
+			sp.Error("error scanning membership event", zap.Error(err))
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// SnapshotMembership counts the members of every corporation, alliance, sig and role in the guild and stores the
+// counts for the day, replacing any already taken that day. Corporations and alliances count the discord users with
+// an authed character in them, sigs and roles the users in all of their filters. It returns how many counts were
+// stored.
+func (s Storage) SnapshotMembership(ctx context.Context, day time.Time) (int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	sp.With(zap.Time("day", day))
+
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	// Every count is stored against the guild, the day and its kind
+	counts := func(kind string) sq.SelectBuilder {
+		return sq.Select().
+			Column("?::bigint", s.GuildID).
+			Column("?::date", day).
+			Column("?::varchar", kind)
+	}
+
+	corporations := counts(SnapshotCorporation).
+		Columns("corporations.ticker", "COUNT(DISTINCT user_character_map.chat_id)").
+		From("user_character_map").
+		Join("characters ON characters.id = user_character_map.character_id").
+		Join("corporations ON corporations.id = characters.corporation_id").
+		GroupBy("corporations.ticker")
+
+	alliances := counts(SnapshotAlliance).
+		Columns("alliances.ticker", "COUNT(DISTINCT user_character_map.chat_id)").
+		From("user_character_map").
+		Join("characters ON characters.id = user_character_map.character_id").
+		Join("corporations ON corporations.id = characters.corporation_id").
+		Join("alliances ON alliances.id = corporations.alliance_id").
+		GroupBy("alliances.ticker")
+
+	// A role's members are the users in every one of its filters, like GetRoleMembers
+	roles := func(kind string, sig bool) sq.SelectBuilder {
+		return counts(kind).
+			Columns("roles.role_nick", `(SELECT COUNT(*) FROM (
+				SELECT filter_membership.user_id FROM role_filters
+				JOIN filter_membership ON filter_membership.filter = role_filters.filter
+				WHERE role_filters.role = roles.id
+				GROUP BY filter_membership.user_id
+				HAVING COUNT(*) = (SELECT COUNT(*) FROM role_filters WHERE role_filters.role = roles.id)
+			) AS members)`).
+			From("roles").
+			Where(sq.Eq{"roles.guild_id": s.GuildID}).
+			Where(sq.Eq{"roles.sig": sig})
+	}
+
+	var count int
+
+	for _, query := range []sq.SelectBuilder{
+		corporations,
+		alliances,
+		roles(SnapshotSig, true),
+		roles(SnapshotRole, false),
+	} {
+		// The select keeps its ? placeholders, the insert numbers them along with its own
+		insert := s.DB.Insert("membership_snapshots").
+			Columns("guild_id", "day", "kind", "name", "members").
+			Select(query).
+			Suffix("ON CONFLICT (guild_id, day, kind, name) DO UPDATE SET members = EXCLUDED.members")
+
+		sqlStr, args, err := insert.ToSql()
+		if err != nil {
+			sp.Error("error getting sql", zap.Error(err))
+			return count, err
+		} else {
+			sp.With(
+				zap.String("query", sqlStr),
+				zap.Any("args", args),
+			)
+			sp.Debug("SnapshotMembership(): sql query")
+		}
+
+		result, err := insert.ExecContext(ctx)
+		if err != nil {
+			sp.Error("error storing membership snapshot", zap.Error(err))
+			return count, fmt.Errorf("error storing membership snapshot: %w", err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err == nil {
+			count += int(rows)
+		}
+	}
+
+	return count, nil
+}
+
+// GetMembershipSnapshots returns the guild's daily counts matching the query, oldest first.
+func (s Storage) GetMembershipSnapshots(ctx context.Context, q payloads.MembershipSnapshotQuery) ([]payloads.MembershipSnapshot, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("guild_id", "day", "kind", "name", "members").
+		From("membership_snapshots").
+		Where(sq.Eq{"guild_id": s.GuildID}).
+		OrderBy("day", "kind", "name")
+
+	if q.Kind != "" {
+		query = query.Where(sq.Eq{"kind": q.Kind})
+	}
+
+	if q.Name != "" {
+		query = query.Where(sq.Eq{"name": q.Name})
+	}
+
+	if !q.Since.IsZero() {
+		query = query.Where(sq.GtOrEq{"day": q.Since})
+	}
+
+	if !q.Until.IsZero() {
+		query = query.Where(sq.Lt{"day": q.Until})
+	}
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetMembershipSnapshots(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting membership snapshots", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	var snapshots []payloads.MembershipSnapshot
+
+	for rows.Next() {
+		var snapshot payloads.MembershipSnapshot
+
+		err = rows.Scan(&snapshot.GuildID, &snapshot.Day, &snapshot.Kind, &snapshot.Name, &snapshot.Members)
+		if err != nil {
+			sp.Error("error scanning membership snapshot", zap.Error(err))
+			return nil, err
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
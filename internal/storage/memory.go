@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+// Memory keeps everything in memory, it's a Store for tests that don't want Postgres. It behaves like Storage does:
+// it returns the same errors for missing and duplicate rows, cascades deletes and checks foreign keys the way the
+// schema does and records the membership history. Rows come back in the order they were inserted where Storage
+// doesn't order them. The contract tests in store_test.go run against both.
+//
+// Transactions run one at a time and anything outside a transaction waits for the running one to finish, as if every
+// transaction locked every row. A store outside the transaction mustn't be used from inside it, that would wait
+// forever.
+type Memory struct {
+	// GuildID scopes role and member state queries, roles belong to a guild while everything else is shared.
+	GuildID string
+
+	db *memoryDB
+	// inTx is set when the memory is in a transaction, onCommit is what to run once it commits
+	inTx     bool
+	onCommit *[]func()
+}
+
+type memoryDB struct {
+	sync.Mutex
+	// tx is held by the running transaction, or by a change outside one while it's made
+	tx sync.Mutex
+	// sequences hands out IDs by table. Like Postgres sequences they aren't rolled back.
+	sequences map[string]int64
+
+	memoryTables
+}
+
+// memoryTables are the rows of each table, rolling back a transaction puts back a copy taken when it started.
+type memoryTables struct {
+	alliances         []payloads.Alliance
+	corporations      []payloads.Corporation
+	characters        []payloads.Character
+	userCharacters    []memoryUserCharacter
+	authCodes         []payloads.AuthenticationCode
+	filters           []payloads.Filter
+	filterMembers     []memoryMember
+	roles             []memoryRole
+	roleFilters       []payloads.RoleFilter
+	permissions       []payloads.Permission
+	permissionMembers []memoryMember
+	memberState       []payloads.MemberState
+	exemptions        []payloads.MemberPolicyExemption
+	memberSyncs       []memoryMemberSync
+	nicknames         []memoryNickname
+	standings         []payloads.Standing
+	esiCache          []memoryCacheEntry
+	pollRuns          []payloads.PollRun
+	auditLog          []payloads.AuditEntry
+	membershipHistory []payloads.MembershipEvent
+	snapshots         []payloads.MembershipSnapshot
+	outbox            []payloads.OutboxMessage
+}
+
+type memoryUserCharacter struct {
+	chatID      string
+	characterID int32
+}
+
+// memoryMember is a filter_membership or permission_membership row, id is the filter's or permission's
+type memoryMember struct {
+	id     int
+	userID int64
+}
+
+type memoryRole struct {
+	id      int
+	guildID string
+	role    payloads.Role
+}
+
+type memoryMemberSync struct {
+	guildID string
+	chatID  string
+	seq     int64
+}
+
+type memoryNickname struct {
+	chatID   string
+	nickname string
+}
+
+type memoryCacheEntry struct {
+	key        string
+	value      []byte
+	insertedAt time.Time
+	accessedAt time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{db: &memoryDB{sequences: make(map[string]int64)}}
+}
+
+// ForGuild returns a copy of the memory whose role queries only see the given guild.
+func (m Memory) ForGuild(guildID string) Store {
+	m.GuildID = guildID
+	return &m
+}
+
+// WithTx runs fn with a copy of the memory in a transaction. The changes fn made are rolled back if it returns an
+// error.
+func (m Memory) WithTx(_ context.Context, fn func(tx Store) error) error {
+	if m.inTx {
+		return fn(&m)
+	}
+
+	var onCommit []func()
+
+	err := func() error {
+		m.db.tx.Lock()
+		defer m.db.tx.Unlock()
+
+		m.db.Lock()
+		saved := m.db.memoryTables.clone()
+		m.db.Unlock()
+
+		m.inTx = true
+		m.onCommit = &onCommit
+
+		err := fn(&m)
+		if err != nil {
+			m.db.Lock()
+			m.db.memoryTables = saved
+			m.db.Unlock()
+		}
+
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range onCommit {
+		fn()
+	}
+
+	return nil
+}
+
+// lock locks the tables. Outside a transaction it first waits for the running transaction, if any, so a rollback
+// never drops changes made outside it.
+func (m Memory) lock() func() {
+	if !m.inTx {
+		m.db.tx.Lock()
+	}
+	m.db.Lock()
+
+	return func() {
+		m.db.Unlock()
+		if !m.inTx {
+			m.db.tx.Unlock()
+		}
+	}
+}
+
+// InTx returns true if the memory is in a transaction.
+func (m Memory) InTx() bool {
+	return m.inTx
+}
+
+// OnCommit runs fn once the transaction commits, or straight away outside a transaction.
+func (m Memory) OnCommit(fn func()) {
+	if !m.inTx {
+		fn()
+		return
+	}
+
+	*m.onCommit = append(*m.onCommit, fn)
+}
+
+func (t memoryTables) clone() memoryTables {
+	return memoryTables{
+		alliances:         append([]payloads.Alliance(nil), t.alliances...),
+		corporations:      append([]payloads.Corporation(nil), t.corporations...),
+		characters:        append([]payloads.Character(nil), t.characters...),
+		userCharacters:    append([]memoryUserCharacter(nil), t.userCharacters...),
+		authCodes:         append([]payloads.AuthenticationCode(nil), t.authCodes...),
+		filters:           append([]payloads.Filter(nil), t.filters...),
+		filterMembers:     append([]memoryMember(nil), t.filterMembers...),
+		roles:             append([]memoryRole(nil), t.roles...),
+		roleFilters:       append([]payloads.RoleFilter(nil), t.roleFilters...),
+		permissions:       append([]payloads.Permission(nil), t.permissions...),
+		permissionMembers: append([]memoryMember(nil), t.permissionMembers...),
+		memberState:       append([]payloads.MemberState(nil), t.memberState...),
+		exemptions:        append([]payloads.MemberPolicyExemption(nil), t.exemptions...),
+		memberSyncs:       append([]memoryMemberSync(nil), t.memberSyncs...),
+		nicknames:         append([]memoryNickname(nil), t.nicknames...),
+		standings:         append([]payloads.Standing(nil), t.standings...),
+		esiCache:          append([]memoryCacheEntry(nil), t.esiCache...),
+		pollRuns:          append([]payloads.PollRun(nil), t.pollRuns...),
+		auditLog:          append([]payloads.AuditEntry(nil), t.auditLog...),
+		membershipHistory: append([]payloads.MembershipEvent(nil), t.membershipHistory...),
+		snapshots:         append([]payloads.MembershipSnapshot(nil), t.snapshots...),
+		outbox:            append([]payloads.OutboxMessage(nil), t.outbox...),
+	}
+}
+
+// nextID returns the table's next ID, like a BIGSERIAL column.
+func (db *memoryDB) nextID(table string) int64 {
+	db.sequences[table] += 1
+	return db.sequences[table]
+}
+
+// The errors below are the ones Postgres returns that Storage passes on as they are.
+
+func errUniqueViolation(constraint string) error {
+	return fmt.Errorf("duplicate key value violates unique constraint %q", constraint)
+}
+
+func errForeignKeyViolation(table, constraint string) error {
+	return fmt.Errorf("insert, update or delete on table %q violates foreign key constraint %q", table, constraint)
+}
+
+// parseBigint reads an ID that's kept in a BIGINT column.
+func parseBigint(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid input syntax for type bigint: %q", value)
+	}
+
+	return id, nil
+}
+
+// checkLength fails like a VARCHAR column would if the value doesn't fit.
+func checkLength(value string, length int) error {
+	if utf8.RuneCountInString(value) > length {
+		return fmt.Errorf("value too long for type character varying(%d)", length)
+	}
+
+	return nil
+}
+
+func memoryNow() time.Time {
+	return time.Now().UTC()
+}
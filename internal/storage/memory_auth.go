@@ -0,0 +1,512 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+func (m Memory) GetAllianceCount(_ context.Context, allianceID int32) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	if m.db.alliance(allianceID) == nil {
+		return 0, nil
+	}
+
+	return 1, nil
+}
+
+func (m Memory) GetAlliance(_ context.Context, allianceID int32) (payloads.Alliance, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	alliance := m.db.alliance(allianceID)
+	if alliance == nil {
+		return payloads.Alliance{}, ErrNoAlliance
+	}
+
+	return payloads.Alliance{ID: alliance.ID, Name: alliance.Name, Ticker: alliance.Ticker}, nil
+}
+
+func (m Memory) GetAlliances(_ context.Context) ([]payloads.Alliance, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var alliances []payloads.Alliance
+	for _, alliance := range m.db.alliances {
+		alliances = append(alliances, payloads.Alliance{ID: alliance.ID, Name: alliance.Name, Ticker: alliance.Ticker})
+	}
+
+	return alliances, nil
+}
+
+func (m Memory) UpsertAlliance(_ context.Context, allianceID int32, name, ticker string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	for _, alliance := range m.db.alliances {
+		if alliance.ID == allianceID {
+			continue
+		}
+
+		if alliance.Name == name {
+			return errUniqueViolation("alliances_name_key")
+		}
+
+		if alliance.Ticker == ticker {
+			return errUniqueViolation("alliances_ticker_key")
+		}
+	}
+
+	if alliance := m.db.alliance(allianceID); alliance != nil {
+		alliance.Name = name
+		alliance.Ticker = ticker
+		return nil
+	}
+
+	m.db.alliances = append(m.db.alliances, payloads.Alliance{ID: allianceID, Name: name, Ticker: ticker})
+
+	return nil
+}
+
+func (db *memoryDB) alliance(allianceID int32) *payloads.Alliance {
+	for i := range db.alliances {
+		if db.alliances[i].ID == allianceID {
+			return &db.alliances[i]
+		}
+	}
+
+	return nil
+}
+
+func (m Memory) GetCorporationCount(_ context.Context, corporationID int32) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	if m.db.corporation(corporationID) == nil {
+		return 0, nil
+	}
+
+	return 1, nil
+}
+
+func (m Memory) GetCorporation(_ context.Context, corporationID int32) (payloads.Corporation, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	corporation := m.db.corporation(corporationID)
+	if corporation == nil {
+		return payloads.Corporation{}, ErrNoCorporation
+	}
+
+	return payloads.Corporation{Ticker: corporation.Ticker, AllianceID: corporation.AllianceID}, nil
+}
+
+func (m Memory) GetCorporations(_ context.Context) ([]payloads.Corporation, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var corporations []payloads.Corporation
+	for _, corporation := range m.db.corporations {
+		corporations = append(corporations, payloads.Corporation{
+			ID:         corporation.ID,
+			Name:       corporation.Name,
+			Ticker:     corporation.Ticker,
+			AllianceID: corporation.AllianceID,
+		})
+	}
+
+	return corporations, nil
+}
+
+func (m Memory) UpsertCorporation(_ context.Context, corporationID, allianceID int32, name, ticker string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	var allianceNullableID sql.NullInt32
+	if allianceID != 0 {
+		allianceNullableID.Int32 = allianceID
+		allianceNullableID.Valid = true
+
+		if m.db.alliance(allianceID) == nil {
+			return errForeignKeyViolation("corporations", "corporations_alliance_id_fkey")
+		}
+	}
+
+	for _, corporation := range m.db.corporations {
+		if corporation.ID == corporationID {
+			continue
+		}
+
+		if corporation.Name == name {
+			return errUniqueViolation("corporations_name_key")
+		}
+
+		if corporation.Ticker == ticker {
+			return errUniqueViolation("corporations_ticker_key")
+		}
+	}
+
+	if corporation := m.db.corporation(corporationID); corporation != nil {
+		corporation.Name = name
+		corporation.Ticker = ticker
+		corporation.AllianceID = allianceNullableID
+		return nil
+	}
+
+	m.db.corporations = append(m.db.corporations, payloads.Corporation{
+		ID:         corporationID,
+		Name:       name,
+		Ticker:     ticker,
+		AllianceID: allianceNullableID,
+	})
+
+	return nil
+}
+
+// GetUserCorporations returns the corporations of every character a discord user has authed.
+func (m Memory) GetUserCorporations(_ context.Context, chatID string) ([]payloads.Corporation, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	seen := make(map[int32]bool)
+
+	var corporations []payloads.Corporation
+	for _, character := range m.db.userCharacterList(chatID) {
+		corporation := m.db.corporation(character.CorporationID)
+		if corporation == nil || seen[corporation.ID] {
+			continue
+		}
+		seen[corporation.ID] = true
+
+		corporations = append(corporations, payloads.Corporation{
+			ID:         corporation.ID,
+			Name:       corporation.Name,
+			Ticker:     corporation.Ticker,
+			AllianceID: corporation.AllianceID,
+		})
+	}
+
+	return corporations, nil
+}
+
+func (db *memoryDB) corporation(corporationID int32) *payloads.Corporation {
+	for i := range db.corporations {
+		if db.corporations[i].ID == corporationID {
+			return &db.corporations[i]
+		}
+	}
+
+	return nil
+}
+
+func (m Memory) GetCharacterCount(_ context.Context, characterID int32) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	if m.db.character(characterID) == nil {
+		return 0, nil
+	}
+
+	return 1, nil
+}
+
+func (m Memory) GetCharacter(_ context.Context, characterID int) (payloads.Character, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	character := m.db.character(int32(characterID))
+	if character == nil {
+		return payloads.Character{}, ErrNoCharacter
+	}
+
+	return payloads.Character{Name: character.Name, CorporationID: character.CorporationID}, nil
+}
+
+// GetMainCharacter returns the first character a discord user authed, nicknames are built from it.
+func (m Memory) GetMainCharacter(_ context.Context, chatID string) (payloads.Character, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	characters := m.db.userCharacterList(chatID)
+	if len(characters) == 0 {
+		return payloads.Character{}, ErrNoCharacter
+	}
+
+	sort.SliceStable(characters, func(i, j int) bool {
+		if !characters[i].InsertedAt.Equal(*characters[j].InsertedAt) {
+			return characters[i].InsertedAt.Before(*characters[j].InsertedAt)
+		}
+
+		return characters[i].ID < characters[j].ID
+	})
+
+	return payloads.Character{
+		ID:            characters[0].ID,
+		Name:          characters[0].Name,
+		CorporationID: characters[0].CorporationID,
+	}, nil
+}
+
+func (m Memory) GetCharacters(_ context.Context) ([]payloads.Character, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var characters []payloads.Character
+	for _, character := range m.db.characters {
+		characters = append(characters, payloads.Character{
+			ID:            character.ID,
+			Name:          character.Name,
+			CorporationID: character.CorporationID,
+			Token:         character.Token,
+		})
+	}
+
+	return characters, nil
+}
+
+func (m Memory) UpsertCharacter(_ context.Context, characterID, corporationID int32, name, token string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if m.db.corporation(corporationID) == nil {
+		return errForeignKeyViolation("characters", "characters_corporation_id_fkey")
+	}
+
+	for _, character := range m.db.characters {
+		if character.ID != characterID && character.Name == name {
+			return errUniqueViolation("characters_name_key")
+		}
+	}
+
+	if character := m.db.character(characterID); character != nil {
+		character.Name = name
+		character.CorporationID = corporationID
+		if token != "" {
+			character.Token = token
+		}
+
+		return nil
+	}
+
+	if token == "" {
+		return fmt.Errorf("null value in column \"token\" violates not-null constraint")
+	}
+
+	insertedAt := memoryNow()
+	m.db.characters = append(m.db.characters, payloads.Character{
+		ID:            characterID,
+		Name:          name,
+		CorporationID: corporationID,
+		Token:         token,
+		InsertedAt:    &insertedAt,
+	})
+
+	return nil
+}
+
+func (m Memory) DeleteCharacter(_ context.Context, characterID int32) error {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.deleteCharacter(characterID)
+}
+
+func (db *memoryDB) deleteCharacter(characterID int32) error {
+	// Delete auth codes
+	db.deleteAuthCodes(characterID)
+
+	for _, userCharacter := range db.userCharacters {
+		if userCharacter.characterID == characterID {
+			return errForeignKeyViolation("characters", "user_character_map_character_id_fkey")
+		}
+	}
+
+	characters := db.characters[:0]
+	for _, character := range db.characters {
+		if character.ID != characterID {
+			characters = append(characters, character)
+		}
+	}
+	db.characters = characters
+
+	return nil
+}
+
+func (db *memoryDB) character(characterID int32) *payloads.Character {
+	for i := range db.characters {
+		if db.characters[i].ID == characterID {
+			return &db.characters[i]
+		}
+	}
+
+	return nil
+}
+
+// userCharacterList returns copies of the characters a discord user has authed.
+func (db *memoryDB) userCharacterList(chatID string) []payloads.Character {
+	var characters []payloads.Character
+	for _, userCharacter := range db.userCharacters {
+		if userCharacter.chatID != chatID {
+			continue
+		}
+
+		if character := db.character(userCharacter.characterID); character != nil {
+			characters = append(characters, *character)
+		}
+	}
+
+	return characters
+}
+
+func (m Memory) GetDiscordUser(_ context.Context, characterID int32) (string, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	for _, userCharacter := range m.db.userCharacters {
+		if userCharacter.characterID == characterID {
+			return userCharacter.chatID, nil
+		}
+	}
+
+	return "", ErrNoDiscordUser
+}
+
+func (m Memory) GetDiscordCharacters(_ context.Context, discordID string) ([]payloads.Character, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.discordCharacters(discordID), nil
+}
+
+func (db *memoryDB) discordCharacters(discordID string) []payloads.Character {
+	var characters []payloads.Character
+	for _, userCharacter := range db.userCharacters {
+		if userCharacter.chatID == discordID {
+			characters = append(characters, payloads.Character{ID: userCharacter.characterID})
+		}
+	}
+
+	return characters
+}
+
+func (m Memory) InsertUserCharacterMap(_ context.Context, sender string, characterID int) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if m.db.character(int32(characterID)) == nil {
+		return errForeignKeyViolation("user_character_map", "user_character_map_character_id_fkey")
+	}
+
+	for _, userCharacter := range m.db.userCharacters {
+		if userCharacter.chatID == sender && userCharacter.characterID == int32(characterID) {
+			// Duplicate entry, which is fine, actually
+			return ErrUserMapped
+		}
+	}
+
+	m.db.userCharacters = append(m.db.userCharacters, memoryUserCharacter{
+		chatID:      sender,
+		characterID: int32(characterID),
+	})
+
+	return nil
+}
+
+func (m Memory) DeleteDiscordUser(_ context.Context, chatID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	userCharacters := m.db.userCharacters[:0]
+	for _, userCharacter := range m.db.userCharacters {
+		if userCharacter.chatID != chatID {
+			userCharacters = append(userCharacters, userCharacter)
+		}
+	}
+	m.db.userCharacters = userCharacters
+
+	// Clean up dependencies, like Storage this looks the characters up after the map is gone
+	characters := m.db.discordCharacters(chatID)
+	for c := range characters {
+		m.db.deleteAuthCodes(characters[c].ID)
+
+		err := m.db.deleteCharacter(characters[c].ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m Memory) GetAuthCode(_ context.Context, authCode string) (int, bool, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	for _, code := range m.db.authCodes {
+		if code.Code == authCode {
+			return int(code.CharacterID), code.Used, nil
+		}
+	}
+
+	return -1, false, ErrNoAuthCode
+}
+
+func (m Memory) DeleteAuthCodes(_ context.Context, characterID int32) error {
+	unlock := m.lock()
+	defer unlock()
+
+	m.db.deleteAuthCodes(characterID)
+
+	return nil
+}
+
+func (db *memoryDB) deleteAuthCodes(characterID int32) {
+	codes := db.authCodes[:0]
+	for _, code := range db.authCodes {
+		if code.CharacterID != characterID {
+			codes = append(codes, code)
+		}
+	}
+	db.authCodes = codes
+}
+
+func (m Memory) InsertAuthCode(_ context.Context, characterID int32, authCode string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if err := checkLength(authCode, 20); err != nil {
+		return err
+	}
+
+	if m.db.character(characterID) == nil {
+		return errForeignKeyViolation("authentication_codes", "authentication_codes_character_id_fkey")
+	}
+
+	for _, code := range m.db.authCodes {
+		if code.CharacterID == characterID && code.Code == authCode {
+			return errUniqueViolation("authentication_codes_pkey")
+		}
+	}
+
+	m.db.authCodes = append(m.db.authCodes, payloads.AuthenticationCode{CharacterID: characterID, Code: authCode})
+
+	return nil
+}
+
+func (m Memory) UpdateAuthCode(_ context.Context, authCode string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	for i := range m.db.authCodes {
+		if m.db.authCodes[i].Code == authCode {
+			m.db.authCodes[i].Used = true
+		}
+	}
+
+	return nil
+}
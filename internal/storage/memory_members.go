@@ -0,0 +1,478 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+func (m Memory) GetMemberState(_ context.Context, chatID string) (payloads.MemberState, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	state := m.db.memberStateOf(m.GuildID, chatID)
+	if state == nil {
+		return payloads.MemberState{}, ErrNoMemberState
+	}
+
+	return *state, nil
+}
+
+// InsertMemberState starts tracking a member, members that are already tracked keep their state.
+func (m Memory) InsertMemberState(_ context.Context, chatID string, joinedAt time.Time) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if m.db.memberStateOf(m.GuildID, chatID) != nil {
+		return nil
+	}
+
+	m.db.memberState = append(m.db.memberState, payloads.MemberState{
+		GuildID:  m.GuildID,
+		ChatID:   chatID,
+		JoinedAt: joinedAt,
+	})
+
+	return nil
+}
+
+// UpdateMemberAuthed records that the member has a character the member policy allows.
+func (m Memory) UpdateMemberAuthed(_ context.Context, chatID string) error {
+	return m.updateMemberState(chatID, func(state *payloads.MemberState) {
+		authed := memoryNow()
+		state.LastAuthed = &authed
+	})
+}
+
+func (m Memory) UpdateMemberReminded(_ context.Context, chatID string) error {
+	return m.updateMemberState(chatID, func(state *payloads.MemberState) {
+		reminded := memoryNow()
+		state.RemindedAt = &reminded
+	})
+}
+
+func (m Memory) UpdateMemberQuarantined(_ context.Context, chatID string, quarantined bool) error {
+	return m.updateMemberState(chatID, func(state *payloads.MemberState) {
+		if !quarantined {
+			state.QuarantinedAt = nil
+			return
+		}
+
+		quarantinedAt := memoryNow()
+		state.QuarantinedAt = &quarantinedAt
+	})
+}
+
+func (m Memory) updateMemberState(chatID string, update func(state *payloads.MemberState)) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if state := m.db.memberStateOf(m.GuildID, chatID); state != nil {
+		update(state)
+	}
+
+	return nil
+}
+
+func (m Memory) DeleteMemberState(_ context.Context, chatID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	states := m.db.memberState[:0]
+	for _, state := range m.db.memberState {
+		if state.GuildID != m.GuildID || state.ChatID != chatID {
+			states = append(states, state)
+		}
+	}
+	m.db.memberState = states
+
+	return nil
+}
+
+func (db *memoryDB) memberStateOf(guildID, chatID string) *payloads.MemberState {
+	for i := range db.memberState {
+		if db.memberState[i].GuildID == guildID && db.memberState[i].ChatID == chatID {
+			return &db.memberState[i]
+		}
+	}
+
+	return nil
+}
+
+func (m Memory) GetMemberPolicyExemptions(_ context.Context) ([]payloads.MemberPolicyExemption, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	// They're inserted in order, so they're already by inserted_at
+	return append([]payloads.MemberPolicyExemption(nil), m.db.exemptions...), nil
+}
+
+func (m Memory) InsertMemberPolicyExemption(_ context.Context, chatID, reason string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	for _, exemption := range m.db.exemptions {
+		if exemption.ChatID == chatID {
+			return ErrExemptionExists
+		}
+	}
+
+	insertedAt := memoryNow()
+	m.db.exemptions = append(m.db.exemptions, payloads.MemberPolicyExemption{
+		ChatID:     chatID,
+		Reason:     reason,
+		InsertedAt: &insertedAt,
+	})
+
+	return nil
+}
+
+func (m Memory) DeleteMemberPolicyExemption(_ context.Context, chatID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	for i, exemption := range m.db.exemptions {
+		if exemption.ChatID == chatID {
+			m.db.exemptions = append(m.db.exemptions[:i:i], m.db.exemptions[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNoExemption
+}
+
+// BumpMemberSync counts another sync queued for the member and returns the new count.
+func (m Memory) BumpMemberSync(_ context.Context, chatID string) (int64, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	if err := checkLength(chatID, 255); err != nil {
+		return 0, err
+	}
+
+	for i := range m.db.memberSyncs {
+		if m.db.memberSyncs[i].guildID == m.GuildID && m.db.memberSyncs[i].chatID == chatID {
+			m.db.memberSyncs[i].seq += 1
+			return m.db.memberSyncs[i].seq, nil
+		}
+	}
+
+	m.db.memberSyncs = append(m.db.memberSyncs, memoryMemberSync{guildID: m.GuildID, chatID: chatID, seq: 1})
+
+	return 1, nil
+}
+
+// GetMemberSync returns how many syncs have been queued for the member, 0 if none have.
+func (m Memory) GetMemberSync(_ context.Context, chatID string) (int64, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	for _, sync := range m.db.memberSyncs {
+		if sync.guildID == m.GuildID && sync.chatID == chatID {
+			return sync.seq, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (m Memory) GetNicknameOverride(_ context.Context, chatID string) (string, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	for _, override := range m.db.nicknames {
+		if override.chatID == chatID {
+			return override.nickname, nil
+		}
+	}
+
+	return "", ErrNoNicknameOverride
+}
+
+func (m Memory) UpsertNicknameOverride(_ context.Context, chatID, nickname string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if err := checkLength(nickname, 32); err != nil {
+		return err
+	}
+
+	for i := range m.db.nicknames {
+		if m.db.nicknames[i].chatID == chatID {
+			m.db.nicknames[i].nickname = nickname
+			return nil
+		}
+	}
+
+	m.db.nicknames = append(m.db.nicknames, memoryNickname{chatID: chatID, nickname: nickname})
+
+	return nil
+}
+
+func (m Memory) DeleteNicknameOverride(_ context.Context, chatID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	for i, override := range m.db.nicknames {
+		if override.chatID == chatID {
+			m.db.nicknames = append(m.db.nicknames[:i:i], m.db.nicknames[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNoNicknameOverride
+}
+
+// InsertAuditEntry records an administrative change. The guild is the memory's.
+func (m Memory) InsertAuditEntry(_ context.Context, entry payloads.AuditEntry) error {
+	unlock := m.lock()
+	defer unlock()
+
+	entry.ID = m.db.nextID("audit_log")
+	entry.GuildID = m.GuildID
+	entry.Before = jsonbCopy(entry.Before)
+	entry.After = jsonbCopy(entry.After)
+	entry.CreatedAt = memoryNow()
+
+	m.db.auditLog = append(m.db.auditLog, entry)
+
+	return nil
+}
+
+// GetAuditLog returns the entries matching the query, newest first.
+func (m Memory) GetAuditLog(_ context.Context, q payloads.AuditQuery) ([]payloads.AuditEntry, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var entries []payloads.AuditEntry
+	for i := len(m.db.auditLog) - 1; i >= 0; i-- {
+		entry := m.db.auditLog[i]
+
+		if q.UserID != "" && entry.ActorID != q.UserID && entry.MemberID != q.UserID {
+			continue
+		}
+
+		if q.Target != "" && entry.Target != q.Target {
+			continue
+		}
+
+		if !q.Since.IsZero() && entry.CreatedAt.Before(q.Since) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+
+	// Storage always sets the limit, so no limit returns nothing
+	if uint64(len(entries)) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+
+	return entries, nil
+}
+
+// jsonbCopy copies JSON going into a JSONB column. Empty is NULL.
+func jsonbCopy(value []byte) []byte {
+	if len(value) == 0 {
+		return nil
+	}
+
+	return append([]byte(nil), value...)
+}
+
+// GetMembershipHistory returns the membership events matching the query, newest first.
+func (m Memory) GetMembershipHistory(_ context.Context, q payloads.MembershipHistoryQuery) ([]payloads.MembershipEvent, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var events []payloads.MembershipEvent
+	for i := len(m.db.membershipHistory) - 1; i >= 0; i-- {
+		event := m.db.membershipHistory[i]
+
+		if q.UserID != "" && event.UserID != q.UserID {
+			continue
+		}
+
+		if !q.Since.IsZero() && event.CreatedAt.Before(q.Since) {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+
+	if q.Limit > 0 && uint64(len(events)) > q.Limit {
+		events = events[:q.Limit]
+	}
+
+	return events, nil
+}
+
+// SnapshotMembership counts the members of every corporation, alliance, sig and role in the guild and stores the
+// counts for the day, replacing any already taken that day. Corporations and alliances count the discord users with
+// an authed character in them, sigs and roles the users in all of their filters. It returns how many counts were
+// stored.
+func (m Memory) SnapshotMembership(_ context.Context, day time.Time) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	var counts []payloads.MembershipSnapshot
+
+	// Corporations and alliances are counted by ticker, in the order their first member authed
+	count := func(kind string, ticker func(character payloads.Character) (string, bool)) {
+		var tickers []string
+		users := make(map[string]map[string]bool)
+
+		for _, userCharacter := range m.db.userCharacters {
+			character := m.db.character(userCharacter.characterID)
+			if character == nil {
+				continue
+			}
+
+			name, ok := ticker(*character)
+			if !ok {
+				continue
+			}
+
+			if users[name] == nil {
+				users[name] = make(map[string]bool)
+				tickers = append(tickers, name)
+			}
+			users[name][userCharacter.chatID] = true
+		}
+
+		for _, name := range tickers {
+			counts = append(counts, payloads.MembershipSnapshot{Kind: kind, Name: name, Members: len(users[name])})
+		}
+	}
+
+	count(SnapshotCorporation, func(character payloads.Character) (string, bool) {
+		corporation := m.db.corporation(character.CorporationID)
+		if corporation == nil {
+			return "", false
+		}
+
+		return corporation.Ticker, true
+	})
+
+	count(SnapshotAlliance, func(character payloads.Character) (string, bool) {
+		corporation := m.db.corporation(character.CorporationID)
+		if corporation == nil || !corporation.AllianceID.Valid {
+			return "", false
+		}
+
+		alliance := m.db.alliance(corporation.AllianceID.Int32)
+		if alliance == nil {
+			return "", false
+		}
+
+		return alliance.Ticker, true
+	})
+
+	// A role's members are the users in every one of its filters, like GetRoleMembers
+	for _, kind := range []struct {
+		name string
+		sig  bool
+	}{{SnapshotSig, true}, {SnapshotRole, false}} {
+		for _, role := range m.db.roles {
+			if role.guildID != m.GuildID || role.role.Sig != kind.sig {
+				continue
+			}
+
+			// Each of the role's filters counts once per member, a user is a member if they're counted once for
+			// each of them
+			var filters int
+			joined := make(map[int64]int)
+
+			for _, roleFilter := range m.db.roleFilters {
+				if int(roleFilter.Role) != role.id {
+					continue
+				}
+				filters += 1
+
+				for _, member := range m.db.filterMembers {
+					if member.id == int(roleFilter.Filter) {
+						joined[member.userID] += 1
+					}
+				}
+			}
+
+			members := 0
+			for _, n := range joined {
+				if n == filters {
+					members += 1
+				}
+			}
+
+			counts = append(counts, payloads.MembershipSnapshot{
+				Kind:    kind.name,
+				Name:    role.role.ShortName,
+				Members: members,
+			})
+		}
+	}
+
+	for _, c := range counts {
+		c.GuildID = m.GuildID
+		c.Day = day
+
+		replaced := false
+		for i, snapshot := range m.db.snapshots {
+			if snapshot.GuildID == c.GuildID && snapshot.Day.Equal(c.Day) && snapshot.Kind == c.Kind &&
+				snapshot.Name == c.Name {
+				m.db.snapshots[i] = c
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			m.db.snapshots = append(m.db.snapshots, c)
+		}
+	}
+
+	return len(counts), nil
+}
+
+// GetMembershipSnapshots returns the guild's daily counts matching the query, oldest first.
+func (m Memory) GetMembershipSnapshots(_ context.Context, q payloads.MembershipSnapshotQuery) ([]payloads.MembershipSnapshot, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var snapshots []payloads.MembershipSnapshot
+	for _, snapshot := range m.db.snapshots {
+		if snapshot.GuildID != m.GuildID ||
+			(q.Kind != "" && snapshot.Kind != q.Kind) ||
+			(q.Name != "" && snapshot.Name != q.Name) ||
+			(!q.Since.IsZero() && snapshot.Day.Before(q.Since)) ||
+			(!q.Until.IsZero() && !snapshot.Day.Before(q.Until)) {
+			continue
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if !snapshots[i].Day.Equal(snapshots[j].Day) {
+			return snapshots[i].Day.Before(snapshots[j].Day)
+		}
+
+		if snapshots[i].Kind != snapshots[j].Kind {
+			return snapshots[i].Kind < snapshots[j].Kind
+		}
+
+		return snapshots[i].Name < snapshots[j].Name
+	})
+
+	return snapshots, nil
+}
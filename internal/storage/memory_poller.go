@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+// GetStandings returns every contact's standing keyed by contact ID.
+func (m Memory) GetStandings(_ context.Context) (map[int32]float32, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	standings := make(map[int32]float32)
+	for _, standing := range m.db.standings {
+		standings[standing.ContactID] = standing.Standing
+	}
+
+	return standings, nil
+}
+
+// ReplaceStandings swaps the stored contact list for a new one.
+func (m Memory) ReplaceStandings(_ context.Context, standings []payloads.Standing) error {
+	unlock := m.lock()
+	defer unlock()
+
+	// Like Storage the old list is gone even if the new one can't be stored
+	m.db.standings = nil
+
+	seen := make(map[int32]bool)
+	for _, standing := range standings {
+		if seen[standing.ContactID] {
+			return errUniqueViolation("standings_pkey")
+		}
+		seen[standing.ContactID] = true
+	}
+
+	m.db.standings = append(m.db.standings, standings...)
+
+	return nil
+}
+
+// GetCacheEntry returns a cached ESI response and marks it as used, so it's evicted last.
+func (m Memory) GetCacheEntry(_ context.Context, key string) ([]byte, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	for i := range m.db.esiCache {
+		if m.db.esiCache[i].key == key {
+			m.db.esiCache[i].accessedAt = memoryNow()
+			return append([]byte(nil), m.db.esiCache[i].value...), nil
+		}
+	}
+
+	return nil, ErrNoCacheEntry
+}
+
+func (m Memory) UpsertCacheEntry(_ context.Context, key string, value []byte) error {
+	unlock := m.lock()
+	defer unlock()
+
+	entry := memoryCacheEntry{
+		key:        key,
+		value:      append([]byte(nil), value...),
+		insertedAt: memoryNow(),
+		accessedAt: memoryNow(),
+	}
+
+	for i := range m.db.esiCache {
+		if m.db.esiCache[i].key == key {
+			m.db.esiCache[i] = entry
+			return nil
+		}
+	}
+
+	m.db.esiCache = append(m.db.esiCache, entry)
+
+	return nil
+}
+
+func (m Memory) DeleteCacheEntry(_ context.Context, key string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	m.db.deleteCacheEntries(func(entry memoryCacheEntry) bool {
+		return entry.key == key
+	})
+
+	return nil
+}
+
+// EvictCache deletes entries not used within maxAge and then the least recently used entries until the cache is no
+// bigger than maxSize bytes. It returns how many entries were deleted.
+func (m Memory) EvictCache(_ context.Context, maxSize int64, maxAge time.Duration) (int64, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	// Most recently used first, the running total of the entries' sizes picks the ones over maxSize
+	entries := append([]memoryCacheEntry(nil), m.db.esiCache...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].accessedAt.Equal(entries[j].accessedAt) {
+			return entries[i].accessedAt.After(entries[j].accessedAt)
+		}
+
+		return entries[i].key < entries[j].key
+	})
+
+	cutoff := memoryNow().Add(-maxAge)
+
+	var total int64
+	evict := make(map[string]bool)
+	for _, entry := range entries {
+		total += int64(len(entry.value))
+		if entry.accessedAt.Before(cutoff) || total > maxSize {
+			evict[entry.key] = true
+		}
+	}
+
+	return m.db.deleteCacheEntries(func(entry memoryCacheEntry) bool {
+		return evict[entry.key]
+	}), nil
+}
+
+// FlushCache deletes every entry and returns how many there were.
+func (m Memory) FlushCache(_ context.Context) (int64, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.deleteCacheEntries(func(memoryCacheEntry) bool {
+		return true
+	}), nil
+}
+
+func (m Memory) GetCacheStats(_ context.Context) (payloads.ESICacheStats, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var stats payloads.ESICacheStats
+	for _, entry := range m.db.esiCache {
+		stats.Entries += 1
+		stats.Size += int64(len(entry.value))
+
+		if stats.LastAccessed == nil || entry.accessedAt.After(*stats.LastAccessed) {
+			accessedAt := entry.accessedAt
+			stats.LastAccessed = &accessedAt
+		}
+	}
+
+	return stats, nil
+}
+
+// deleteCacheEntries deletes the entries matching and returns how many there were.
+func (db *memoryDB) deleteCacheEntries(match func(entry memoryCacheEntry) bool) int64 {
+	var deleted int64
+
+	entries := db.esiCache[:0]
+	for _, entry := range db.esiCache {
+		if match(entry) {
+			deleted += 1
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+	db.esiCache = entries
+
+	return deleted
+}
+
+// InsertPollRun records a finished poll and its stages and returns the run's ID.
+func (m Memory) InsertPollRun(_ context.Context, run payloads.PollRun) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	run.ID = int(m.db.nextID("poll_runs"))
+
+	stages := run.Stages
+	run.Stages = nil
+
+	// Like Storage the run is kept even if its stages can't be
+	m.db.pollRuns = append(m.db.pollRuns, run)
+	i := len(m.db.pollRuns) - 1
+
+	seen := make(map[string]bool)
+	for _, stage := range stages {
+		if seen[stage.Stage] {
+			return -1, errUniqueViolation("poll_run_stages_pkey")
+		}
+		seen[stage.Stage] = true
+	}
+
+	for _, stage := range stages {
+		// Errors are kept one per line
+		joined := strings.Join(stage.Errors, "\n")
+		stage.Errors = nil
+		if joined != "" {
+			stage.Errors = strings.Split(joined, "\n")
+		}
+
+		run.Stages = append(run.Stages, stage)
+	}
+	m.db.pollRuns[i].Stages = run.Stages
+
+	return run.ID, nil
+}
+
+// GetPollRuns returns the latest poll runs with their stages, newest first.
+func (m Memory) GetPollRuns(_ context.Context, limit uint64) ([]payloads.PollRun, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	runs := m.db.pollRunsByStart()
+	if uint64(len(runs)) > limit {
+		runs = runs[:limit]
+	}
+
+	for i := range runs {
+		stages := append([]payloads.PollStage(nil), runs[i].Stages...)
+		sort.SliceStable(stages, func(a, b int) bool {
+			return stages[a].StartedAt.Before(stages[b].StartedAt)
+		})
+
+		runs[i].Stages = stages
+	}
+
+	return runs, nil
+}
+
+// DeleteOldPollRuns keeps the latest runs and deletes the rest.
+func (m Memory) DeleteOldPollRuns(_ context.Context, keep uint64) error {
+	unlock := m.lock()
+	defer unlock()
+
+	runs := m.db.pollRunsByStart()
+	if uint64(len(runs)) > keep {
+		runs = runs[:keep]
+	}
+
+	kept := make(map[int]bool)
+	for _, run := range runs {
+		kept[run.ID] = true
+	}
+
+	pollRuns := m.db.pollRuns[:0]
+	for _, run := range m.db.pollRuns {
+		if kept[run.ID] {
+			pollRuns = append(pollRuns, run)
+		}
+	}
+	m.db.pollRuns = pollRuns
+
+	return nil
+}
+
+// pollRunsByStart returns a copy of the runs, newest first.
+func (db *memoryDB) pollRunsByStart() []payloads.PollRun {
+	runs := append([]payloads.PollRun(nil), db.pollRuns...)
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+
+	return runs
+}
+
+// OutboxPublisher returns a publisher that writes the queue's messages to the outbox with this memory.
+func (m Memory) OutboxPublisher(queue string) OutboxPublisher {
+	return OutboxPublisher{store: m, queue: queue}
+}
+
+func (m Memory) InsertOutboxMessage(_ context.Context, queue string, body []byte) error {
+	unlock := m.lock()
+	defer unlock()
+
+	m.db.outbox = append(m.db.outbox, payloads.OutboxMessage{
+		ID:        m.db.nextID("outbox"),
+		Queue:     queue,
+		Body:      append([]byte(nil), body...),
+		CreatedAt: memoryNow(),
+	})
+
+	return nil
+}
+
+// LockOutbox takes the outbox lock until the transaction ends. Transactions run one at a time so it's always free.
+func (m Memory) LockOutbox(_ context.Context) (bool, error) {
+	if !m.inTx {
+		return false, ErrNoTx
+	}
+
+	return true, nil
+}
+
+// GetOutboxMessages returns up to limit of the oldest messages in the outbox for the given queues.
+func (m Memory) GetOutboxMessages(_ context.Context, queues []string, limit uint64) ([]payloads.OutboxMessage, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	wanted := make(map[string]bool)
+	for _, queue := range queues {
+		wanted[queue] = true
+	}
+
+	var messages []payloads.OutboxMessage
+	for _, message := range m.db.outbox {
+		if wanted[message.Queue] {
+			messages = append(messages, message)
+		}
+	}
+
+	if uint64(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
+
+func (m Memory) DeleteOutboxMessages(_ context.Context, ids []int64) error {
+	unlock := m.lock()
+	defer unlock()
+
+	deleted := make(map[int64]bool)
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	messages := m.db.outbox[:0]
+	for _, message := range m.db.outbox {
+		if !deleted[message.ID] {
+			messages = append(messages, message)
+		}
+	}
+	m.db.outbox = messages
+
+	return nil
+}
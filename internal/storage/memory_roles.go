@@ -0,0 +1,1035 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+func (m Memory) GetFilter(_ context.Context, name string) (payloads.Filter, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.filterByName(name)
+}
+
+func (db *memoryDB) filterByName(name string) (payloads.Filter, error) {
+	for _, filter := range db.filters {
+		if filter.Name == name {
+			return filter, nil
+		}
+	}
+
+	return payloads.Filter{}, ErrNoFilter
+}
+
+func (db *memoryDB) filter(filterID int) *payloads.Filter {
+	for i := range db.filters {
+		if db.filters[i].ID == filterID {
+			return &db.filters[i]
+		}
+	}
+
+	return nil
+}
+
+func (m Memory) GetFilters(_ context.Context) ([]payloads.Filter, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var filters []payloads.Filter
+	for _, filter := range m.db.filters {
+		filters = append(filters, payloads.Filter{Name: filter.Name, Description: filter.Description})
+	}
+
+	return filters, nil
+}
+
+func (m Memory) GetTickerFilters(_ context.Context, sig bool, ticker string) ([]payloads.Filter, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var filters []payloads.Filter
+	for _, roleFilter := range m.db.roleFilters {
+		role := m.db.role(int(roleFilter.Role))
+		if role == nil || role.guildID != m.GuildID || role.role.Sig != sig || role.role.ShortName != ticker {
+			continue
+		}
+
+		if filter := m.db.filter(int(roleFilter.Filter)); filter != nil {
+			filters = append(filters, payloads.Filter{Name: filter.Name})
+		}
+	}
+
+	return filters, nil
+}
+
+// GetUserFilters lists the filters a user is a member of.
+func (m Memory) GetUserFilters(_ context.Context, userID string) ([]payloads.Filter, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []payloads.Filter
+	for _, member := range m.db.filterMembers {
+		if member.userID != id {
+			continue
+		}
+
+		if filter := m.db.filter(member.id); filter != nil {
+			filters = append(filters, *filter)
+		}
+	}
+
+	return filters, nil
+}
+
+func (m Memory) InsertFilter(_ context.Context, name, description string) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	if err := checkLength(name, 32); err != nil {
+		return -1, err
+	}
+
+	if err := checkLength(description, 256); err != nil {
+		return -1, err
+	}
+
+	if _, err := m.db.filterByName(name); err == nil {
+		return -1, ErrFilterExists
+	}
+
+	id := int(m.db.nextID("filters"))
+	m.db.filters = append(m.db.filters, payloads.Filter{ID: id, Name: name, Description: description})
+
+	return id, nil
+}
+
+func (m Memory) DeleteFilter(ctx context.Context, name string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	filter, err := m.db.filterByName(name)
+	if err != nil {
+		return err
+	}
+
+	return m.db.deleteFilter(ctx, filter.ID)
+}
+
+func (m Memory) DeleteFilterByID(ctx context.Context, id int) error {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.deleteFilter(ctx, id)
+}
+
+func (db *memoryDB) deleteFilter(ctx context.Context, filterID int) error {
+	err := db.deleteFilterMembership(ctx, filterID, "")
+	if err != nil {
+		return err
+	}
+
+	db.deleteRoleFilter(filterID)
+
+	filters := db.filters[:0]
+	for _, filter := range db.filters {
+		if filter.ID != filterID {
+			filters = append(filters, filter)
+		}
+	}
+	db.filters = filters
+
+	return nil
+}
+
+func (m Memory) DeleteFilterMembership(ctx context.Context, filterID int, userID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.deleteFilterMembership(ctx, filterID, userID)
+}
+
+func (db *memoryDB) deleteFilterMembership(ctx context.Context, filterID int, userID string) error {
+	var (
+		id  int64
+		err error
+	)
+
+	if userID != "" {
+		id, err = parseBigint(userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var userIDs []string
+
+	members := db.filterMembers[:0]
+	for _, member := range db.filterMembers {
+		if member.id == filterID && (userID == "" || member.userID == id) {
+			userIDs = append(userIDs, strconv.FormatInt(member.userID, 10))
+			continue
+		}
+
+		members = append(members, member)
+	}
+	db.filterMembers = members
+
+	// The users that left are recorded in the membership history
+	db.recordMembership(ctx, filterID, MembershipLeave, userIDs)
+
+	return nil
+}
+
+func (m Memory) ListFilterMembers(_ context.Context, filter string) ([]int64, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var userIDs []int64
+
+	f, err := m.db.filterByName(filter)
+	if err != nil {
+		return userIDs, nil
+	}
+
+	for _, member := range m.db.filterMembers {
+		if member.id == f.ID {
+			userIDs = append(userIDs, member.userID)
+		}
+	}
+
+	return userIDs, nil
+}
+
+func (m Memory) AddFilterMembership(ctx context.Context, filterID int, userID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(userID)
+	if err != nil {
+		return err
+	}
+
+	if m.db.filter(filterID) == nil {
+		return errForeignKeyViolation("filter_membership", "filter_membership_filter_fkey")
+	}
+
+	for _, member := range m.db.filterMembers {
+		if member.id == filterID && member.userID == id {
+			return ErrFilterMember
+		}
+	}
+
+	m.db.filterMembers = append(m.db.filterMembers, memoryMember{id: filterID, userID: id})
+
+	m.db.recordMembership(ctx, filterID, MembershipJoin, []string{strconv.FormatInt(id, 10)})
+
+	return nil
+}
+
+// GetRoleMembers returns the users that are in every one of the filters.
+func (m Memory) GetRoleMembers(_ context.Context, filterList []int64) ([]int64, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.roleMembers(filterList), nil
+}
+
+// roleMembers returns the users in every one of the filters, listed by when they first joined one of them.
+func (db *memoryDB) roleMembers(filterList []int64) []int64 {
+	inList := make(map[int]bool)
+	for _, filterID := range filterList {
+		inList[int(filterID)] = true
+	}
+
+	var users []int64
+
+	counts := make(map[int64]int)
+	for _, member := range db.filterMembers {
+		if !inList[member.id] {
+			continue
+		}
+
+		if counts[member.userID] == 0 {
+			users = append(users, member.userID)
+		}
+		counts[member.userID] += 1
+	}
+
+	var members []int64
+	for _, user := range users {
+		if counts[user] == len(filterList) {
+			members = append(members, user)
+		}
+	}
+
+	return members
+}
+
+// recordMembership adds an event to the membership history for each user, by the filter's name like Storage.
+func (db *memoryDB) recordMembership(ctx context.Context, filterID int, event string, userIDs []string) {
+	_, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	filter := db.filter(filterID)
+	if filter == nil || len(userIDs) == 0 {
+		return
+	}
+
+	cause := membershipCauseFrom(ctx)
+
+	for _, userID := range userIDs {
+		db.membershipHistory = append(db.membershipHistory, payloads.MembershipEvent{
+			ID:            db.nextID("membership_history"),
+			FilterName:    filter.Name,
+			UserID:        userID,
+			Event:         event,
+			Cause:         cause.cause,
+			ActorID:       cause.actorID,
+			CorrelationID: sp.GetCorrelationID(),
+			CreatedAt:     memoryNow(),
+		})
+	}
+}
+
+func (m Memory) GetRoleFilters(_ context.Context, sig bool, name string) ([]payloads.RoleFilter, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.roleFilterList(m.GuildID, sig, name), nil
+}
+
+// roleFilterList returns the role's filters, only the Filter is set like Storage.
+func (db *memoryDB) roleFilterList(guildID string, sig bool, name string) []payloads.RoleFilter {
+	var roleFilters []payloads.RoleFilter
+	for _, roleFilter := range db.roleFilters {
+		role := db.role(int(roleFilter.Role))
+		if role == nil || role.guildID != guildID || role.role.Sig != sig || role.role.ShortName != name {
+			continue
+		}
+
+		roleFilters = append(roleFilters, payloads.RoleFilter{Filter: roleFilter.Filter})
+	}
+
+	return roleFilters
+}
+
+func (m Memory) DeleteRoleFilter(_ context.Context, filterID int) error {
+	unlock := m.lock()
+	defer unlock()
+
+	m.db.deleteRoleFilter(filterID)
+
+	return nil
+}
+
+func (db *memoryDB) deleteRoleFilter(filterID int) {
+	roleFilters := db.roleFilters[:0]
+	for _, roleFilter := range db.roleFilters {
+		if int(roleFilter.Filter) != filterID {
+			roleFilters = append(roleFilters, roleFilter)
+		}
+	}
+	db.roleFilters = roleFilters
+}
+
+func (m Memory) InsertRoleFilter(_ context.Context, roleID, filterID int) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if m.db.role(roleID) == nil {
+		return errForeignKeyViolation("role_filters", "role_filters_role_fkey")
+	}
+
+	if m.db.filter(filterID) == nil {
+		return errForeignKeyViolation("role_filters", "role_filters_filter_fkey")
+	}
+
+	m.db.roleFilters = append(m.db.roleFilters, payloads.RoleFilter{
+		ID:     int(m.db.nextID("role_filters")),
+		Role:   int64(roleID),
+		Filter: int64(filterID),
+	})
+
+	return nil
+}
+
+func (m Memory) GetRoleCount(_ context.Context, sig bool, ticker string) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var count int
+	for _, role := range m.db.roles {
+		if role.guildID == m.GuildID && role.role.Sig == sig && role.role.ShortName == ticker {
+			count += 1
+		}
+	}
+
+	return count, nil
+}
+
+func (m Memory) GetRole(_ context.Context, name, ticker string, sig *bool) (payloads.Role, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	for _, role := range m.db.roles {
+		if role.guildID != m.GuildID ||
+			(name != "" && role.role.Name != name) ||
+			(ticker != "" && role.role.ShortName != ticker) ||
+			(sig != nil && role.role.Sig != *sig) {
+			continue
+		}
+
+		return payloads.Role{Sync: role.role.Sync, ChatID: role.role.ChatID, Manageable: role.role.Manageable}, nil
+	}
+
+	return payloads.Role{}, ErrNoRole
+}
+
+func (m Memory) GetRoleByChatID(_ context.Context, chatID string) (payloads.Role, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(chatID)
+	if err != nil {
+		return payloads.Role{}, err
+	}
+
+	for _, role := range m.db.roles {
+		if role.guildID == m.GuildID && role.role.ChatID == id {
+			return payloads.Role{Sync: role.role.Sync, ChatID: role.role.ChatID}, nil
+		}
+	}
+
+	// Storage returns this when there's no such role too
+	return payloads.Role{}, ErrRoleExists
+}
+
+func (m Memory) GetRoleByType(_ context.Context, sig bool, shortName string) (payloads.Role, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	roles := m.db.rolesByType(m.GuildID, sig, &shortName)
+
+	if len(roles) > 1 {
+		return payloads.Role{}, fmt.Errorf("more than one role returned when only one expected")
+	}
+
+	if len(roles) == 0 {
+		return payloads.Role{}, fmt.Errorf("no such role: %s", shortName)
+	}
+
+	return roles[0], nil
+}
+
+func (m Memory) GetRolesByType(_ context.Context, sig bool) ([]payloads.Role, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	return m.db.rolesByType(m.GuildID, sig, nil), nil
+}
+
+func (db *memoryDB) rolesByType(guildID string, sig bool, shortName *string) []payloads.Role {
+	var roles []payloads.Role
+	for _, role := range db.roles {
+		if role.guildID != guildID || role.role.Sig != sig || (shortName != nil && role.role.ShortName != *shortName) {
+			continue
+		}
+
+		roles = append(roles, payloads.Role{
+			Color:       role.role.Color,
+			Hoist:       role.role.Hoist,
+			Joinable:    role.role.Joinable,
+			Managed:     role.role.Managed,
+			Mentionable: role.role.Mentionable,
+			Name:        role.role.Name,
+			Permissions: role.role.Permissions,
+			Position:    role.role.Position,
+			ShortName:   role.role.ShortName,
+			Sig:         role.role.Sig,
+			Sync:        role.role.Sync,
+		})
+	}
+
+	return roles
+}
+
+func (m Memory) GetRolesBySync(_ context.Context, syncOnly bool) ([]payloads.Role, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var roles []payloads.Role
+	for _, role := range m.db.roles {
+		if role.guildID != m.GuildID || (syncOnly && !role.role.Sync) {
+			continue
+		}
+
+		// The discord ID comes back as the ID, like Storage
+		roles = append(roles, payloads.Role{
+			ID:          strconv.FormatInt(role.role.ChatID, 10),
+			Name:        role.role.Name,
+			Managed:     role.role.Managed,
+			Mentionable: role.role.Mentionable,
+			Hoist:       role.role.Hoist,
+			Color:       role.role.Color,
+			Position:    role.role.Position,
+			Permissions: role.role.Permissions,
+			Sig:         role.role.Sig,
+			ShortName:   role.role.ShortName,
+			Manageable:  role.role.Manageable,
+		})
+	}
+
+	return roles, nil
+}
+
+func (m Memory) UpdateRole(_ context.Context, chatID, name, id string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if chatID == "" && id == "" {
+		return fmt.Errorf("chatID or id need to be set")
+	}
+
+	var (
+		newChatID int64
+		newID     int64
+		err       error
+	)
+
+	if chatID != "" {
+		newChatID, err = parseBigint(chatID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if id != "" {
+		newID, err = parseBigint(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range m.db.roles {
+		role := &m.db.roles[i]
+		if role.guildID != m.GuildID || role.role.Name != name {
+			continue
+		}
+
+		if id != "" && int(newID) != role.id {
+			if m.db.role(int(newID)) != nil {
+				return errUniqueViolation("roles_pkey")
+			}
+
+			for _, roleFilter := range m.db.roleFilters {
+				if int(roleFilter.Role) == role.id {
+					return errForeignKeyViolation("roles", "role_filters_role_fkey")
+				}
+			}
+
+			role.id = int(newID)
+		}
+
+		if chatID != "" {
+			role.role.ChatID = newChatID
+		}
+	}
+
+	return nil
+}
+
+// UpdateRoleManageable records whether the bot is able to manage the role in discord.
+func (m Memory) UpdateRoleManageable(_ context.Context, chatID string, manageable bool) error {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(chatID)
+	if err != nil {
+		return err
+	}
+
+	for i := range m.db.roles {
+		if m.db.roles[i].guildID == m.GuildID && m.db.roles[i].role.ChatID == id {
+			m.db.roles[i].role.Manageable = manageable
+		}
+	}
+
+	return nil
+}
+
+// UpdateRoleValues sets the role's columns from values, keyed by column name like Storage.
+func (m Memory) UpdateRoleValues(_ context.Context, sig bool, name string, values map[string]string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if len(values) == 0 {
+		return errors.New("update statements must have at least one Set clause")
+	}
+
+	// Every value is checked before any role changes, Postgres checks them before it looks for the rows
+	var setters []func(role *payloads.Role)
+
+	for k, v := range values {
+		key := strings.ToLower(k)
+		if key == "color" {
+			if strings.HasPrefix(v, "#") {
+				i, _ := strconv.ParseInt(v[1:], 16, 64)
+				v = strconv.Itoa(int(i))
+			}
+		}
+
+		setter, err := roleColumnSetter(key, v)
+		if err != nil {
+			return err
+		}
+
+		setters = append(setters, setter)
+	}
+
+	for i := range m.db.roles {
+		role := &m.db.roles[i]
+		if role.guildID != m.GuildID || role.role.Name != name || role.role.Sig != sig {
+			continue
+		}
+
+		updated := role.role
+		for _, setter := range setters {
+			setter(&updated)
+		}
+
+		for j, other := range m.db.roles {
+			if j != i && other.guildID == role.guildID && other.role.Name == updated.Name &&
+				other.role.Sig == updated.Sig {
+				return errUniqueViolation("name_uindex")
+			}
+		}
+
+		role.role = updated
+	}
+
+	return nil
+}
+
+// roleColumnSetter returns a func that sets the roles column to the value, converted like Postgres would.
+func roleColumnSetter(column, value string) (func(role *payloads.Role), error) {
+	switch column {
+	case "name":
+		if err := checkLength(value, 256); err != nil {
+			return nil, err
+		}
+
+		return func(role *payloads.Role) { role.Name = value }, nil
+
+	case "role_nick":
+		if err := checkLength(value, 70); err != nil {
+			return nil, err
+		}
+
+		return func(role *payloads.Role) { role.ShortName = value }, nil
+
+	case "chat_type":
+		if err := checkLength(value, 32); err != nil {
+			return nil, err
+		}
+
+		return func(role *payloads.Role) { role.Type = value }, nil
+
+	case "color", "position":
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type integer: %q", value)
+		}
+
+		if column == "color" {
+			return func(role *payloads.Role) { role.Color = int(i) }, nil
+		}
+
+		return func(role *payloads.Role) { role.Position = int(i) }, nil
+
+	case "permissions", "chat_id":
+		i, err := parseBigint(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+
+		if column == "permissions" {
+			return func(role *payloads.Role) { role.Permissions = i }, nil
+		}
+
+		return func(role *payloads.Role) { role.ChatID = i }, nil
+	}
+
+	fields := map[string]func(role *payloads.Role) *bool{
+		"hoist":       func(role *payloads.Role) *bool { return &role.Hoist },
+		"joinable":    func(role *payloads.Role) *bool { return &role.Joinable },
+		"managed":     func(role *payloads.Role) *bool { return &role.Managed },
+		"mentionable": func(role *payloads.Role) *bool { return &role.Mentionable },
+		"sig":         func(role *payloads.Role) *bool { return &role.Sig },
+		"sync":        func(role *payloads.Role) *bool { return &role.Sync },
+		"manageable":  func(role *payloads.Role) *bool { return &role.Manageable },
+	}
+
+	field, ok := fields[column]
+	if !ok {
+		return nil, fmt.Errorf("column %q of relation \"roles\" does not exist", column)
+	}
+
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid input syntax for type boolean: %q", value)
+	}
+
+	return func(role *payloads.Role) { *field(role) = b }, nil
+}
+
+// GetMemberRoles returns the guild's roles or sigs the user is in every filter of, like getMemberRoles() does.
+func (m Memory) GetMemberRoles(_ context.Context, userID string, sig bool) ([]payloads.Role, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user %ss (%s): %s", RoleType[sig], userID, err)
+	}
+
+	var roles []payloads.Role
+	for _, role := range m.db.roles {
+		if role.guildID != m.GuildID || role.role.Sig != sig {
+			continue
+		}
+
+		var filters []int64
+		for _, roleFilter := range m.db.roleFilters {
+			if int(roleFilter.Role) == role.id {
+				filters = append(filters, roleFilter.Filter)
+			}
+		}
+
+		// A role with no filters has no members
+		if len(filters) == 0 {
+			continue
+		}
+
+		for _, member := range m.db.roleMembers(filters) {
+			if member == id {
+				roles = append(roles, payloads.Role{
+					ShortName: role.role.ShortName,
+					Name:      role.role.Name,
+					ChatID:    role.role.ChatID,
+				})
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// InsertRole creates a new role. sync is set to sig which causes sigs to be synced by default and roles to not be
+// synced by default.
+func (m Memory) InsertRole(_ context.Context, name, ticker, chatType string, sig, joinable bool) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	if err := checkLength(name, 256); err != nil {
+		return -1, err
+	}
+
+	if err := checkLength(ticker, 70); err != nil {
+		return -1, err
+	}
+
+	if err := checkLength(chatType, 32); err != nil {
+		return -1, err
+	}
+
+	for _, role := range m.db.roles {
+		if role.guildID == m.GuildID && role.role.Name == name && role.role.Sig == sig {
+			return -1, ErrRoleExists
+		}
+	}
+
+	id := int(m.db.nextID("roles"))
+
+	// The defaults are the roles table's
+	m.db.roles = append(m.db.roles, memoryRole{
+		id:      id,
+		guildID: m.GuildID,
+		role: payloads.Role{
+			Name:        name,
+			Managed:     true,
+			Mentionable: true,
+			Joinable:    joinable,
+			ShortName:   ticker,
+			Sig:         sig,
+			Sync:        sig,
+			Type:        chatType,
+			Manageable:  true,
+		},
+	})
+
+	return id, nil
+}
+
+func (m Memory) DeleteRole(ctx context.Context, ticker string, sig bool) error {
+	unlock := m.lock()
+	defer unlock()
+
+	// Like Storage this goes by the role filters' IDs, which GetRoleFilters doesn't set, so the filters are kept
+	roleFilters := m.db.roleFilterList(m.GuildID, sig, ticker)
+
+	for r := range roleFilters {
+		_ = m.db.deleteFilterMembership(ctx, roleFilters[r].ID, "")
+		_ = m.db.deleteFilter(ctx, roleFilters[r].ID)
+		m.db.deleteRoleFilter(roleFilters[r].ID)
+	}
+
+	var deleted []int
+
+	roles := m.db.roles[:0]
+	for _, role := range m.db.roles {
+		if role.guildID == m.GuildID && role.role.ShortName == ticker && role.role.Sig == sig {
+			deleted = append(deleted, role.id)
+			continue
+		}
+
+		roles = append(roles, role)
+	}
+	m.db.roles = roles
+
+	// role_filters go with their role
+	for _, roleID := range deleted {
+		roleFilters := m.db.roleFilters[:0]
+		for _, roleFilter := range m.db.roleFilters {
+			if int(roleFilter.Role) != roleID {
+				roleFilters = append(roleFilters, roleFilter)
+			}
+		}
+		m.db.roleFilters = roleFilters
+	}
+
+	return nil
+}
+
+func (db *memoryDB) role(roleID int) *memoryRole {
+	for i := range db.roles {
+		if db.roles[i].id == roleID {
+			return &db.roles[i]
+		}
+	}
+
+	return nil
+}
+
+func (m Memory) GetPermission(_ context.Context, name string) (payloads.Permission, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	permission := m.db.permissionByName(name)
+	if permission == nil {
+		return payloads.Permission{}, ErrNoPermission
+	}
+
+	return payloads.Permission{ID: permission.ID}, nil
+}
+
+func (m Memory) GetPermissions(_ context.Context) ([]payloads.Permission, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var permissions []payloads.Permission
+	for _, permission := range m.db.permissions {
+		permissions = append(permissions, payloads.Permission{Name: permission.Name, Description: permission.Description})
+	}
+
+	return permissions, nil
+}
+
+func (m Memory) InsertPermission(_ context.Context, name, description string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	if err := checkLength(name, 32); err != nil {
+		return err
+	}
+
+	if err := checkLength(description, 256); err != nil {
+		return err
+	}
+
+	if m.db.permissionByName(name) != nil {
+		return ErrPermissionExists
+	}
+
+	m.db.permissions = append(m.db.permissions, payloads.Permission{
+		ID:          int(m.db.nextID("permissions")),
+		Name:        name,
+		Description: description,
+	})
+
+	return nil
+}
+
+func (m Memory) DeletePermission(_ context.Context, name string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	permission := m.db.permissionByName(name)
+	if permission == nil {
+		return nil
+	}
+
+	// permission_membership doesn't cascade
+	for _, member := range m.db.permissionMembers {
+		if member.id == permission.ID {
+			return errForeignKeyViolation("permissions", "permission_membership_permission_fkey")
+		}
+	}
+
+	permissions := m.db.permissions[:0]
+	for _, p := range m.db.permissions {
+		if p.Name != name {
+			permissions = append(permissions, p)
+		}
+	}
+	m.db.permissions = permissions
+
+	return nil
+}
+
+func (m Memory) ListPermissionMembers(_ context.Context, name string) ([]int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	var userIDs []int
+
+	permission := m.db.permissionByName(name)
+	if permission == nil {
+		return userIDs, nil
+	}
+
+	for _, member := range m.db.permissionMembers {
+		if member.id == permission.ID {
+			userIDs = append(userIDs, int(member.userID))
+		}
+	}
+
+	return userIDs, nil
+}
+
+func (m Memory) InsertPermissionMembership(_ context.Context, permissionID int, userID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(userID)
+	if err != nil {
+		return err
+	}
+
+	if m.db.permission(permissionID) == nil {
+		return errForeignKeyViolation("permission_membership", "permission_membership_permission_fkey")
+	}
+
+	for _, member := range m.db.permissionMembers {
+		if member.id == permissionID && member.userID == id {
+			return ErrPermissionMember
+		}
+	}
+
+	m.db.permissionMembers = append(m.db.permissionMembers, memoryMember{id: permissionID, userID: id})
+
+	return nil
+}
+
+func (m Memory) DeletePermissionMembership(_ context.Context, permissionID int, userID string) error {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(userID)
+	if err != nil {
+		return err
+	}
+
+	members := m.db.permissionMembers[:0]
+	for _, member := range m.db.permissionMembers {
+		if member.id != permissionID || member.userID != id {
+			members = append(members, member)
+		}
+	}
+	m.db.permissionMembers = members
+
+	return nil
+}
+
+func (m Memory) GetUserPermissions(_ context.Context, userID string) ([]payloads.Permission, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []payloads.Permission
+	for _, member := range m.db.permissionMembers {
+		if member.userID != id {
+			continue
+		}
+
+		if permission := m.db.permission(member.id); permission != nil {
+			permissions = append(permissions, payloads.Permission{Name: permission.Name})
+		}
+	}
+
+	return permissions, nil
+}
+
+func (m Memory) GetPermissionCount(_ context.Context, authorID string, permissionID int) (int, error) {
+	unlock := m.lock()
+	defer unlock()
+
+	id, err := parseBigint(authorID)
+	if err != nil {
+		return -1, err
+	}
+
+	var count int
+	for _, member := range m.db.permissionMembers {
+		if member.id == permissionID && member.userID == id {
+			count += 1
+		}
+	}
+
+	return count, nil
+}
+
+func (db *memoryDB) permission(permissionID int) *payloads.Permission {
+	for i := range db.permissions {
+		if db.permissions[i].ID == permissionID {
+			return &db.permissions[i]
+		}
+	}
+
+	return nil
+}
+
+func (db *memoryDB) permissionByName(name string) *payloads.Permission {
+	for i := range db.permissions {
+		if db.permissions[i].Name == name {
+			return &db.permissions[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"go.uber.org/zap"
+)
+
+var ErrNoNicknameOverride = errors.New("no nickname override")
+
+func (s Storage) GetNicknameOverride(ctx context.Context, chatID string) (string, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	query := s.DB.Select("nickname").
+		From("nickname_overrides").
+		Where(sq.Eq{"chat_id": chatID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return "", err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetNicknameOverride(): sql query")
+	}
+
+	var nickname string
+
+	err = query.Scan(&nickname)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoNicknameOverride
+		}
+
+		sp.Error("error getting nickname override", zap.Error(err))
+		return "", err
+	}
+
+	return nickname, nil
+}
+
+func (s Storage) UpsertNicknameOverride(ctx context.Context, chatID, nickname string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	insert := s.DB.Insert("nickname_overrides").
+		Columns("chat_id", "nickname").
+		Values(chatID, nickname).
+		Suffix("ON CONFLICT (chat_id) DO UPDATE SET nickname=?", nickname)
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("UpsertNicknameOverride(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error upserting nickname override", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s Storage) DeleteNicknameOverride(ctx context.Context, chatID string) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("nickname_overrides").
+		Where(sq.Eq{"chat_id": chatID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("DeleteNicknameOverride(): sql query")
+	}
+
+	result, err := query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error deleting nickname override", zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		sp.Error("error getting rows affected", zap.Error(err))
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoNicknameOverride
+	}
+
+	return nil
+}
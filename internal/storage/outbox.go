@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"go.uber.org/zap"
+)
+
+// Only one relay publishes from the outbox at a time so messages go out in the order they were written
+const outboxLockKey = 0x6f757462
+
+var ErrNoTx = errors.New("not in a transaction")
+
+// OutboxPublisher writes messages to the outbox for the relay to publish, as part of the store's transaction if it
+// has one. It satisfies queue.Publisher.
+type OutboxPublisher struct {
+	store Store
+	queue string
+}
+
+// OutboxPublisher returns a publisher that writes the queue's messages to the outbox with this storage.
+func (s Storage) OutboxPublisher(queue string) OutboxPublisher {
+	return OutboxPublisher{store: s, queue: queue}
+}
+
+func (p OutboxPublisher) Publish(ctx context.Context, body []byte) error {
+	return p.store.InsertOutboxMessage(ctx, p.queue, body)
+}
+
+// Shutdown is a no-op, the relay publishes the messages.
+func (p OutboxPublisher) Shutdown(_ context.Context) {}
+
+func (s Storage) InsertOutboxMessage(ctx context.Context, queue string, body []byte) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sp.With(
+		zap.String("queue", queue),
+		zap.ByteString("payload", body),
+	)
+
+	insert := s.DB.Insert("outbox").
+		Columns("queue", "body").
+		Values(queue, body)
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("InsertOutboxMessage(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error inserting outbox message", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// LockOutbox takes the outbox lock until the transaction ends, false if another relay has it.
+func (s Storage) LockOutbox(ctx context.Context) (bool, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	if s.tx == nil {
+		return false, ErrNoTx
+	}
+
+	var locked bool
+
+	sp.Debug("LockOutbox(): sql query", zap.String("query", "SELECT pg_try_advisory_xact_lock($1)"))
+	err := s.tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxLockKey).Scan(&locked)
+	if err != nil {
+		sp.Error("error taking outbox lock", zap.Error(err))
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// GetOutboxMessages returns up to limit of the oldest messages in the outbox for the given queues.
+func (s Storage) GetOutboxMessages(ctx context.Context, queues []string, limit uint64) ([]payloads.OutboxMessage, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("id", "queue", "body", "created_at").
+		From("outbox").
+		Where(sq.Eq{"queue": queues}).
+		OrderBy("id").
+		Limit(limit)
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetOutboxMessages(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting outbox messages", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	var messages []payloads.OutboxMessage
+
+	for rows.Next() {
+		var message payloads.OutboxMessage
+
+		err = rows.Scan(&message.ID, &message.Queue, &message.Body, &message.CreatedAt)
+		if err != nil {
+			sp.Error("error scanning outbox message", zap.Error(err))
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+func (s Storage) DeleteOutboxMessages(ctx context.Context, ids []int64) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("outbox").
+		Where(sq.Eq{"id": ids})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("DeleteOutboxMessages(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error deleting outbox messages", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -118,7 +118,7 @@ func (s Storage) InsertPermission(ctx context.Context, name, description string)
 		sp.Debug("InsertPermission(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		// I don't love this, but I can't find a better way right now
 		if err.(*pq.Error).Code == "23505" {
@@ -129,12 +129,6 @@ func (s Storage) InsertPermission(ctx context.Context, name, description string)
 		sp.Error("error inserting permissions", zap.Error(err))
 		return err
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			sp.Error("error closing database", zap.Error(err))
-		}
-	}()
 
 	return nil
 }
@@ -158,17 +152,11 @@ func (s Storage) DeletePermission(ctx context.Context, name string) error {
 		sp.Debug("DeletePermission(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting permissions", zap.Error(err))
 		return err
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			sp.Error("error closing database", zap.Error(err))
-		}
-	}()
 
 	return nil
 }
@@ -248,7 +236,7 @@ func (s Storage) InsertPermissionMembership(ctx context.Context, permissionID in
 		sp.Debug("InsertPermissionMembership(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		// I don't love this, but I can't find a better way right now
 		if err.(*pq.Error).Code == "23505" {
@@ -258,12 +246,6 @@ func (s Storage) InsertPermissionMembership(ctx context.Context, permissionID in
 		sp.Error("error inserting permission", zap.Error(err))
 		return err
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			sp.Error("error closing database", zap.Error(err))
-		}
-	}()
 
 	return nil
 }
@@ -291,17 +273,11 @@ func (s Storage) DeletePermissionMembership(ctx context.Context, permissionID in
 		sp.Debug("DeletePermissionMembership(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting permission", zap.Error(err))
 		return err
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			sp.Error("error closing database", zap.Error(err))
-		}
-	}()
 
 	return nil
 }
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"go.uber.org/zap"
+)
+
+// InsertPollRun records a finished poll and its stages and returns the run's ID.
+func (s Storage) InsertPollRun(ctx context.Context, run payloads.PollRun) (int, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var id int
+
+	insert := s.DB.Insert("poll_runs").
+		Columns("trigger", "started_at", "finished_at").
+		Values(run.Trigger, run.StartedAt, run.FinishedAt).
+		Suffix("RETURNING \"id\"")
+
+	sqlStr, args, err := insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return -1, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("InsertPollRun(): sql query")
+	}
+
+	err = insert.QueryRowContext(ctx).Scan(&id)
+	if err != nil {
+		sp.Error("error inserting poll run", zap.Error(err))
+		return -1, err
+	}
+
+	if len(run.Stages) == 0 {
+		return id, nil
+	}
+
+	stages := s.DB.Insert("poll_run_stages").
+		Columns("run_id", "stage", "count", "error_count", "errors", "started_at", "finished_at")
+
+	for _, stage := range run.Stages {
+		stages = stages.Values(id, stage.Stage, stage.Count, stage.ErrorCount, strings.Join(stage.Errors, "\n"),
+			stage.StartedAt, stage.FinishedAt)
+	}
+
+	sqlStr, args, err = stages.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return -1, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("InsertPollRun(): sql query")
+	}
+
+	_, err = stages.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error inserting poll run stages", zap.Error(err))
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// GetPollRuns returns the latest poll runs with their stages, newest first.
+func (s Storage) GetPollRuns(ctx context.Context, limit uint64) ([]payloads.PollRun, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("id", "trigger", "started_at", "finished_at").
+		From("poll_runs").
+		OrderBy("started_at DESC").
+		Limit(limit)
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetPollRuns(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting poll runs", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	var (
+		runs     []payloads.PollRun
+		ids      []int
+		runIndex = make(map[int]int)
+	)
+
+	for rows.Next() {
+		var run payloads.PollRun
+
+		err = rows.Scan(&run.ID, &run.Trigger, &run.StartedAt, &run.FinishedAt)
+		if err != nil {
+			sp.Error("error scanning poll run", zap.Error(err))
+			return nil, err
+		}
+
+		runIndex[run.ID] = len(runs)
+		runs = append(runs, run)
+		ids = append(ids, run.ID)
+	}
+
+	if len(ids) == 0 {
+		return runs, nil
+	}
+
+	stages := s.DB.Select("run_id", "stage", "count", "error_count", "errors", "started_at", "finished_at").
+		From("poll_run_stages").
+		Where(sq.Eq{"run_id": ids}).
+		OrderBy("started_at")
+
+	sqlStr, args, err = stages.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetPollRuns(): sql query")
+	}
+
+	stageRows, err := stages.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting poll run stages", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = stageRows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	for stageRows.Next() {
+		var (
+			runID  int
+			errors string
+			stage  payloads.PollStage
+		)
+
+		err = stageRows.Scan(&runID, &stage.Stage, &stage.Count, &stage.ErrorCount, &errors, &stage.StartedAt, &stage.FinishedAt)
+		if err != nil {
+			sp.Error("error scanning poll run stage", zap.Error(err))
+			return nil, err
+		}
+
+		if errors != "" {
+			stage.Errors = strings.Split(errors, "\n")
+		}
+
+		i := runIndex[runID]
+		runs[i].Stages = append(runs[i].Stages, stage)
+	}
+
+	return runs, nil
+}
+
+// DeleteOldPollRuns keeps the latest runs and deletes the rest.
+func (s Storage) DeleteOldPollRuns(ctx context.Context, keep uint64) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("poll_runs").
+		Where(sq.Expr("id NOT IN (SELECT id FROM poll_runs ORDER BY started_at DESC LIMIT ?)", keep))
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("DeleteOldPollRuns(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error deleting old poll runs", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -24,7 +24,8 @@ func (s Storage) GetRoleFilters(ctx context.Context, sig bool, name string) ([]p
 		From("role_filters").
 		InnerJoin("roles ON role_filters.role = roles.id").
 		Where(sq.Eq{"sig": sig}).
-		Where(sq.Eq{"role_nick": name})
+		Where(sq.Eq{"role_nick": name}).
+		Where(sq.Eq{"guild_id": s.GuildID})
 
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
@@ -88,7 +89,7 @@ func (s Storage) DeleteRoleFilter(ctx context.Context, filterID int) error {
 		sp.Debug("DeleteRoleFilter(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting filter membership", zap.Error(err))
 		return err
@@ -120,7 +121,7 @@ func (s Storage) InsertRoleFilter(ctx context.Context, roleID, filterID int) err
 		sp.Debug("InsertRoleFilter(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		if err.(*pq.Error).Code == "23505" {
 			return ErrPermissionExists
@@ -130,12 +131,5 @@ func (s Storage) InsertRoleFilter(ctx context.Context, roleID, filterID int) err
 		return err
 	}
 
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			sp.Error("error closing database", zap.Error(err))
-		}
-	}()
-
 	return nil
 }
@@ -33,7 +33,8 @@ func (s Storage) GetRoleCount(ctx context.Context, sig bool, ticker string) (int
 	query := s.DB.Select("count(id)").
 		From("roles").
 		Where(sq.Eq{"role_nick": ticker}).
-		Where(sq.Eq{"sig": sig})
+		Where(sq.Eq{"sig": sig}).
+		Where(sq.Eq{"guild_id": s.GuildID})
 
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
@@ -64,8 +65,9 @@ func (s Storage) GetRole(ctx context.Context, name, ticker string, sig *bool) (p
 	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
 	defer sp.Close()
 
-	query := s.DB.Select("sync", "chat_id").
-		From("roles")
+	query := s.DB.Select("sync", "chat_id", "manageable").
+		From("roles").
+		Where(sq.Eq{"guild_id": s.GuildID})
 
 	if name != "" {
 		query = query.Where(sq.Eq{"name": name})
@@ -93,7 +95,7 @@ func (s Storage) GetRole(ctx context.Context, name, ticker string, sig *bool) (p
 
 	var role payloads.Role
 
-	err = query.Scan(&role.Sync, &role.ChatID)
+	err = query.Scan(&role.Sync, &role.ChatID, &role.Manageable)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return payloads.Role{}, ErrNoRole
@@ -112,7 +114,8 @@ func (s Storage) GetRoleByChatID(ctx context.Context, chatID string) (payloads.R
 
 	query := s.DB.Select("sync", "chat_id").
 		From("roles").
-		Where(sq.Eq{"chat_id": chatID})
+		Where(sq.Eq{"chat_id": chatID}).
+		Where(sq.Eq{"guild_id": s.GuildID})
 
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
@@ -195,6 +198,7 @@ func (s Storage) doGetRoles(ctx context.Context, sig bool, shortName *string) ([
 		"sync",
 	).
 		Where(sq.Eq{"sig": sig}).
+		Where(sq.Eq{"guild_id": s.GuildID}).
 		From("roles")
 
 	if shortName != nil {
@@ -263,8 +267,9 @@ func (s Storage) GetRolesBySync(ctx context.Context, syncOnly bool) ([]payloads.
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	query := s.DB.Select("chat_id", "name", "managed", "mentionable", "hoist", "color", "position", "permissions").
-		From("roles")
+	query := s.DB.Select("chat_id", "name", "managed", "mentionable", "hoist", "color", "position", "permissions", "sig", "role_nick", "manageable").
+		From("roles").
+		Where(sq.Eq{"guild_id": s.GuildID})
 
 	if syncOnly {
 		query = query.Where(sq.Eq{"sync": "true"})
@@ -310,6 +315,9 @@ func (s Storage) GetRolesBySync(ctx context.Context, syncOnly bool) ([]payloads.
 			&role.Color,
 			&role.Position,
 			&role.Permissions,
+			&role.Sig,
+			&role.ShortName,
+			&role.Manageable,
 		)
 		if err != nil {
 			sp.Error("error scanning role fields", zap.Error(err))
@@ -344,7 +352,8 @@ func (s Storage) UpdateRole(ctx context.Context, chatID, name, id string) error
 		query = query.Set("id", id)
 	}
 
-	query = query.Where(sq.Eq{"name": name})
+	query = query.Where(sq.Eq{"name": name}).
+		Where(sq.Eq{"guild_id": s.GuildID})
 
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
@@ -358,7 +367,7 @@ func (s Storage) UpdateRole(ctx context.Context, chatID, name, id string) error
 		sp.Debug("UpdateRole(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("Error updating role id in db", zap.Error(err))
 		return err
@@ -367,6 +376,40 @@ func (s Storage) UpdateRole(ctx context.Context, chatID, name, id string) error
 	return nil
 }
 
+// UpdateRoleManageable records whether the bot is able to manage the role in discord.
+func (s Storage) UpdateRoleManageable(ctx context.Context, chatID string, manageable bool) error {
+	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Update("roles").
+		Set("manageable", manageable).
+		Where(sq.Eq{"chat_id": chatID}).
+		Where(sq.Eq{"guild_id": s.GuildID})
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("UpdateRoleManageable(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("Error updating role manageable", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
 // UpdateRoleValues is just a quick fix until I find a better way to merge these two
 func (s Storage) UpdateRoleValues(ctx context.Context, sig bool, name string, values map[string]string) error {
 	ctx, sp := sl.OpenCorrelatedSpan(ctx, sl.NewID())
@@ -412,6 +455,7 @@ func (s Storage) UpdateRoleValues(ctx context.Context, sig bool, name string, va
 
 	_, err = query.Where(sq.Eq{"name": name}).
 		Where(sq.Eq{"sig": sig}).
+		Where(sq.Eq{"guild_id": s.GuildID}).
 		QueryContext(ctx)
 	if err != nil {
 		sp.Error("error adding role", zap.Error(err))
@@ -430,7 +474,7 @@ func (s Storage) GetMemberRoles(ctx context.Context, userID string, sig bool) ([
 
 	query := s.DB.Select("role_nick", "name", "chat_id").
 		From("").
-		Suffix("getMemberRoles(?, ?)", userID, strconv.FormatBool(sig))
+		Suffix("getMemberRoles(?, ?) WHERE guild_id = ?", userID, strconv.FormatBool(sig), s.GuildID)
 
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
@@ -487,9 +531,9 @@ func (s Storage) InsertRole(ctx context.Context, name, ticker, chatType string,
 	defer cancel()
 
 	query := s.DB.Insert("roles").
-		Columns("sig", "joinable", "name", "role_nick", "chat_type", "sync").
+		Columns("sig", "joinable", "name", "role_nick", "chat_type", "sync", "guild_id").
 		// a sig is sync-ed by default, so we overload the sig bool because it does the right thing here.
-		Values(sig, joinable, name, ticker, chatType, sig).
+		Values(sig, joinable, name, ticker, chatType, sig, s.GuildID).
 		Suffix("RETURNING \"id\"")
 
 	sqlStr, args, err := query.ToSql()
@@ -550,7 +594,8 @@ func (s Storage) DeleteRole(ctx context.Context, ticker string, sig bool) error
 
 	query := s.DB.Delete("roles").
 		Where(sq.Eq{"role_nick": ticker}).
-		Where(sq.Eq{"sig": sig})
+		Where(sq.Eq{"sig": sig}).
+		Where(sq.Eq{"guild_id": s.GuildID})
 
 	sqlStr, args, err := query.ToSql()
 	if err != nil {
@@ -564,17 +609,11 @@ func (s Storage) DeleteRole(ctx context.Context, ticker string, sig bool) error
 		sp.Debug("DeleteRoles(): sql query")
 	}
 
-	rows, err := query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting role", zap.Error(err))
 		return err
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			sp.Error("error closing database", zap.Error(err))
-		}
-	}()
 
 	return nil
 }
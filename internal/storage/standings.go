@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+	"go.uber.org/zap"
+)
+
+// GetStandings returns every contact's standing keyed by contact ID.
+func (s Storage) GetStandings(ctx context.Context) (map[int32]float32, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Select("contact_id", "standing").
+		From("standings")
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return nil, err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("GetStandings(): sql query")
+	}
+
+	rows, err := query.QueryContext(ctx)
+	if err != nil {
+		sp.Error("error getting standings", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			sp.Error("error closing rows", zap.Error(err))
+		}
+	}()
+
+	standings := make(map[int32]float32)
+
+	for rows.Next() {
+		var (
+			contactID int32
+			standing  float32
+		)
+
+		err = rows.Scan(&contactID, &standing)
+		if err != nil {
+			sp.Error("error scanning standing", zap.Error(err))
+			return nil, err
+		}
+
+		standings[contactID] = standing
+	}
+
+	return standings, nil
+}
+
+// ReplaceStandings swaps the stored contact list for a new one.
+func (s Storage) ReplaceStandings(ctx context.Context, standings []payloads.Standing) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := s.DB.Delete("standings")
+
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Any("args", args),
+		)
+		sp.Debug("ReplaceStandings(): sql query")
+	}
+
+	_, err = query.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error deleting standings", zap.Error(err))
+		return err
+	}
+
+	if len(standings) == 0 {
+		return nil
+	}
+
+	insert := s.DB.Insert("standings").
+		Columns("contact_id", "contact_type", "standing")
+
+	for _, standing := range standings {
+		insert = insert.Values(standing.ContactID, standing.ContactType, standing.Standing)
+	}
+
+	sqlStr, args, err = insert.ToSql()
+	if err != nil {
+		sp.Error("error getting sql", zap.Error(err))
+		return err
+	} else {
+		sp.With(
+			zap.String("query", sqlStr),
+			zap.Int("count", len(standings)),
+		)
+		sp.Debug("ReplaceStandings(): sql query")
+	}
+
+	_, err = insert.ExecContext(ctx)
+	if err != nil {
+		sp.Error("error inserting standings", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -1,11 +1,93 @@
 package storage
 
-import sq "github.com/Masterminds/squirrel"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	"go.uber.org/zap"
+)
 
 type Storage struct {
 	DB *sq.StatementBuilderType
+	// GuildID scopes role and member state queries, roles belong to a guild while everything else is shared.
+	GuildID string
+
+	// conn is the pool DB runs with, transactions are started on it
+	conn *sql.DB
+	// tx is set when DB runs in a transaction, onCommit is what to run once it commits
+	tx       *sql.Tx
+	onCommit *[]func()
+}
+
+func New(db *sq.StatementBuilderType, conn *sql.DB) *Storage {
+	return &Storage{DB: db, conn: conn}
+}
+
+// ForGuild returns a copy of the storage whose role queries only see the given guild.
+func (s Storage) ForGuild(guildID string) Store {
+	s.GuildID = guildID
+	return &s
+}
+
+// WithTx runs fn with a copy of the storage whose queries all run in one transaction. The transaction is committed
+// if fn returns nil and rolled back if it returns an error. Storage that's already in a transaction runs fn in it.
+func (s Storage) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	if s.tx != nil {
+		return fn(&s)
+	}
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		sp.Error("error starting transaction", zap.Error(err))
+		return err
+	}
+
+	db := s.DB.RunWith(tx)
+	s.DB = &db
+	s.tx = tx
+
+	var onCommit []func()
+	s.onCommit = &onCommit
+
+	err = fn(&s)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			sp.Error("error rolling back transaction", zap.Error(rollbackErr))
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		sp.Error("error committing transaction", zap.Error(err))
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	for _, fn := range onCommit {
+		fn()
+	}
+
+	return nil
 }
 
-func New(db *sq.StatementBuilderType) *Storage {
-	return &Storage{DB: db}
+// InTx returns true if the storage is in a transaction.
+func (s Storage) InTx() bool {
+	return s.tx != nil
+}
+
+// OnCommit runs fn once the transaction commits, or straight away outside a transaction.
+func (s Storage) OnCommit(fn func()) {
+	if s.tx == nil {
+		fn()
+		return
+	}
+
+	*s.onCommit = append(*s.onCommit, fn)
 }
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/chremoas/chremoas-ng/internal/payloads"
+)
+
+// Store is everything the bot keeps. Storage keeps it in Postgres and Memory keeps it in memory for tests.
+type Store interface {
+	// ForGuild returns a copy of the store whose role queries only see the given guild.
+	ForGuild(guildID string) Store
+	// WithTx runs fn with a copy of the store whose changes are kept if fn returns nil and dropped if it returns an
+	// error. A store that's already in a transaction runs fn in it.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+	// InTx returns true if the store is in a transaction.
+	InTx() bool
+	// OnCommit runs fn once the transaction commits, and never if it's rolled back. Outside a transaction fn runs
+	// straight away. It's for side effects outside the store, like posting to discord.
+	OnCommit(fn func())
+
+	// Alliances
+	GetAllianceCount(ctx context.Context, allianceID int32) (int, error)
+	GetAlliance(ctx context.Context, allianceID int32) (payloads.Alliance, error)
+	GetAlliances(ctx context.Context) ([]payloads.Alliance, error)
+	UpsertAlliance(ctx context.Context, allianceID int32, name, ticker string) error
+
+	// Corporations
+	GetCorporationCount(ctx context.Context, corporationID int32) (int, error)
+	GetCorporation(ctx context.Context, corporationID int32) (payloads.Corporation, error)
+	GetCorporations(ctx context.Context) ([]payloads.Corporation, error)
+	UpsertCorporation(ctx context.Context, corporationID, allianceID int32, name, ticker string) error
+	GetUserCorporations(ctx context.Context, chatID string) ([]payloads.Corporation, error)
+
+	// Characters
+	GetCharacterCount(ctx context.Context, characterID int32) (int, error)
+	GetCharacter(ctx context.Context, characterID int) (payloads.Character, error)
+	GetMainCharacter(ctx context.Context, chatID string) (payloads.Character, error)
+	GetCharacters(ctx context.Context) ([]payloads.Character, error)
+	UpsertCharacter(ctx context.Context, characterID, corporationID int32, name, token string) error
+	DeleteCharacter(ctx context.Context, characterID int32) error
+
+	// Discord users
+	GetDiscordUser(ctx context.Context, characterID int32) (string, error)
+	GetDiscordCharacters(ctx context.Context, discordID string) ([]payloads.Character, error)
+	InsertUserCharacterMap(ctx context.Context, sender string, characterID int) error
+	DeleteDiscordUser(ctx context.Context, chatID string) error
+
+	// Auth codes
+	GetAuthCode(ctx context.Context, authCode string) (int, bool, error)
+	DeleteAuthCodes(ctx context.Context, characterID int32) error
+	InsertAuthCode(ctx context.Context, characterID int32, authCode string) error
+	UpdateAuthCode(ctx context.Context, authCode string) error
+
+	// Filters
+	GetFilter(ctx context.Context, name string) (payloads.Filter, error)
+	GetFilters(ctx context.Context) ([]payloads.Filter, error)
+	GetTickerFilters(ctx context.Context, sig bool, ticker string) ([]payloads.Filter, error)
+	GetUserFilters(ctx context.Context, userID string) ([]payloads.Filter, error)
+	InsertFilter(ctx context.Context, name, description string) (int, error)
+	DeleteFilter(ctx context.Context, name string) error
+	DeleteFilterByID(ctx context.Context, id int) error
+	DeleteFilterMembership(ctx context.Context, filterID int, userID string) error
+	ListFilterMembers(ctx context.Context, filter string) ([]int64, error)
+	AddFilterMembership(ctx context.Context, filterID int, userID string) error
+	GetRoleMembers(ctx context.Context, filterList []int64) ([]int64, error)
+
+	// Roles and sigs
+	GetRoleCount(ctx context.Context, sig bool, ticker string) (int, error)
+	GetRole(ctx context.Context, name, ticker string, sig *bool) (payloads.Role, error)
+	GetRoleByChatID(ctx context.Context, chatID string) (payloads.Role, error)
+	GetRoleByType(ctx context.Context, sig bool, shortName string) (payloads.Role, error)
+	GetRolesByType(ctx context.Context, sig bool) ([]payloads.Role, error)
+	GetRolesBySync(ctx context.Context, syncOnly bool) ([]payloads.Role, error)
+	UpdateRole(ctx context.Context, chatID, name, id string) error
+	UpdateRoleManageable(ctx context.Context, chatID string, manageable bool) error
+	UpdateRoleValues(ctx context.Context, sig bool, name string, values map[string]string) error
+	GetMemberRoles(ctx context.Context, userID string, sig bool) ([]payloads.Role, error)
+	InsertRole(ctx context.Context, name, ticker, chatType string, sig, joinable bool) (int, error)
+	DeleteRole(ctx context.Context, ticker string, sig bool) error
+	GetRoleFilters(ctx context.Context, sig bool, name string) ([]payloads.RoleFilter, error)
+	DeleteRoleFilter(ctx context.Context, filterID int) error
+	InsertRoleFilter(ctx context.Context, roleID, filterID int) error
+
+	// Permissions
+	GetPermission(ctx context.Context, name string) (payloads.Permission, error)
+	GetPermissions(ctx context.Context) ([]payloads.Permission, error)
+	InsertPermission(ctx context.Context, name, description string) error
+	DeletePermission(ctx context.Context, name string) error
+	ListPermissionMembers(ctx context.Context, name string) ([]int, error)
+	InsertPermissionMembership(ctx context.Context, permissionID int, userID string) error
+	DeletePermissionMembership(ctx context.Context, permissionID int, userID string) error
+	GetUserPermissions(ctx context.Context, userID string) ([]payloads.Permission, error)
+	GetPermissionCount(ctx context.Context, authorID string, permissionID int) (int, error)
+
+	// Member policy
+	GetMemberState(ctx context.Context, chatID string) (payloads.MemberState, error)
+	InsertMemberState(ctx context.Context, chatID string, joinedAt time.Time) error
+	UpdateMemberAuthed(ctx context.Context, chatID string) error
+	UpdateMemberReminded(ctx context.Context, chatID string) error
+	UpdateMemberQuarantined(ctx context.Context, chatID string, quarantined bool) error
+	DeleteMemberState(ctx context.Context, chatID string) error
+	GetMemberPolicyExemptions(ctx context.Context) ([]payloads.MemberPolicyExemption, error)
+	InsertMemberPolicyExemption(ctx context.Context, chatID, reason string) error
+	DeleteMemberPolicyExemption(ctx context.Context, chatID string) error
+
+	// Member syncs
+	BumpMemberSync(ctx context.Context, chatID string) (int64, error)
+	GetMemberSync(ctx context.Context, chatID string) (int64, error)
+
+	// Nicknames
+	GetNicknameOverride(ctx context.Context, chatID string) (string, error)
+	UpsertNicknameOverride(ctx context.Context, chatID, nickname string) error
+	DeleteNicknameOverride(ctx context.Context, chatID string) error
+
+	// Standings
+	GetStandings(ctx context.Context) (map[int32]float32, error)
+	ReplaceStandings(ctx context.Context, standings []payloads.Standing) error
+
+	// ESI cache
+	GetCacheEntry(ctx context.Context, key string) ([]byte, error)
+	UpsertCacheEntry(ctx context.Context, key string, value []byte) error
+	DeleteCacheEntry(ctx context.Context, key string) error
+	EvictCache(ctx context.Context, maxSize int64, maxAge time.Duration) (int64, error)
+	FlushCache(ctx context.Context) (int64, error)
+	GetCacheStats(ctx context.Context) (payloads.ESICacheStats, error)
+
+	// Poll runs
+	InsertPollRun(ctx context.Context, run payloads.PollRun) (int, error)
+	GetPollRuns(ctx context.Context, limit uint64) ([]payloads.PollRun, error)
+	DeleteOldPollRuns(ctx context.Context, keep uint64) error
+
+	// Audit log
+	InsertAuditEntry(ctx context.Context, entry payloads.AuditEntry) error
+	GetAuditLog(ctx context.Context, q payloads.AuditQuery) ([]payloads.AuditEntry, error)
+
+	// Membership stats
+	GetMembershipHistory(ctx context.Context, q payloads.MembershipHistoryQuery) ([]payloads.MembershipEvent, error)
+	SnapshotMembership(ctx context.Context, day time.Time) (int, error)
+	GetMembershipSnapshots(ctx context.Context, q payloads.MembershipSnapshotQuery) ([]payloads.MembershipSnapshot, error)
+
+	// Outbox
+	OutboxPublisher(queue string) OutboxPublisher
+	InsertOutboxMessage(ctx context.Context, queue string, body []byte) error
+	LockOutbox(ctx context.Context) (bool, error)
+	GetOutboxMessages(ctx context.Context, queues []string, limit uint64) ([]payloads.OutboxMessage, error)
+	DeleteOutboxMessages(ctx context.Context, ids []int64) error
+}
+
+var (
+	_ Store = Storage{}
+	_ Store = (*Memory)(nil)
+)
@@ -0,0 +1,405 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+	sl "github.com/bhechinger/spiffylogger"
+	_ "github.com/lib/pq"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/chremoas/chremoas-ng/internal/database"
+	"github.com/chremoas/chremoas-ng/internal/storage"
+)
+
+// testDatabaseEnv names a postgres DSN to run the contract tests against. The database is wiped on every test.
+const testDatabaseEnv = "CHREMOAS_TEST_DATABASE"
+
+const (
+	testGuild  = "1000"
+	otherGuild = "2000"
+)
+
+func TestMemory(t *testing.T) {
+	testStore(t, func(t *testing.T) storage.Store {
+		return storage.NewMemory().ForGuild(testGuild)
+	})
+}
+
+func TestStorage(t *testing.T) {
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testDatabaseEnv)
+	}
+
+	testStore(t, func(t *testing.T) storage.Store {
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatalf("opening database: %s", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+
+		ctx := testContext()
+		if _, err = conn.ExecContext(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+			t.Fatalf("wiping database: %s", err)
+		}
+
+		migrator, err := database.NewMigrator(conn, os.DirFS("../../sql"))
+		if err != nil {
+			t.Fatalf("reading migrations: %s", err)
+		}
+
+		if _, err = migrator.Up(ctx); err != nil {
+			t.Fatalf("migrating database: %s", err)
+		}
+
+		db := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(sq.NewStmtCache(conn))
+
+		return storage.New(&db, conn).ForGuild(testGuild)
+	})
+}
+
+func testContext() context.Context {
+	return sl.NewCtxWithLogger(zapcore.FatalLevel)
+}
+
+// testStore runs the cases every Store has to pass against a fresh store from newStore.
+func testStore(t *testing.T, newStore func(t *testing.T) storage.Store) {
+	cases := []struct {
+		name string
+		test func(t *testing.T, s storage.Store)
+	}{
+		{"filters", testFilters},
+		{"roles", testRoles},
+		{"permissions", testPermissions},
+		{"transactions", testTransactions},
+		{"outbox", testOutbox},
+		{"member syncs", testMemberSyncs},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.test(t, newStore(t))
+		})
+	}
+}
+
+func check(t *testing.T, what string, err, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: got error %v, want %v", what, err, want)
+	}
+}
+
+func testFilters(t *testing.T, s storage.Store) {
+	ctx := testContext()
+
+	id, err := s.InsertFilter(ctx, "pilots", "All pilots")
+	check(t, "InsertFilter", err, nil)
+
+	_, err = s.InsertFilter(ctx, "pilots", "All pilots")
+	check(t, "InsertFilter again", err, storage.ErrFilterExists)
+
+	filter, err := s.GetFilter(ctx, "pilots")
+	check(t, "GetFilter", err, nil)
+	if filter.ID != id || filter.Description != "All pilots" {
+		t.Fatalf("GetFilter: got %+v, want id %d", filter, id)
+	}
+
+	_, err = s.GetFilter(ctx, "nobody")
+	check(t, "GetFilter missing", err, storage.ErrNoFilter)
+
+	check(t, "AddFilterMembership", s.AddFilterMembership(ctx, id, "123"), nil)
+	check(t, "AddFilterMembership again", s.AddFilterMembership(ctx, id, "123"), storage.ErrFilterMember)
+	check(t, "AddFilterMembership other", s.AddFilterMembership(ctx, id, "456"), nil)
+
+	members, err := s.ListFilterMembers(ctx, "pilots")
+	check(t, "ListFilterMembers", err, nil)
+	if !sameInts(members, []int64{123, 456}) {
+		t.Fatalf("ListFilterMembers: got %v, want [123 456]", members)
+	}
+
+	check(t, "DeleteFilterMembership", s.DeleteFilterMembership(ctx, id, "123"), nil)
+
+	members, err = s.ListFilterMembers(ctx, "pilots")
+	check(t, "ListFilterMembers after delete", err, nil)
+	if !sameInts(members, []int64{456}) {
+		t.Fatalf("ListFilterMembers after delete: got %v, want [456]", members)
+	}
+
+	check(t, "DeleteFilter", s.DeleteFilter(ctx, "pilots"), nil)
+
+	_, err = s.GetFilter(ctx, "pilots")
+	check(t, "GetFilter after delete", err, storage.ErrNoFilter)
+}
+
+func testRoles(t *testing.T, s storage.Store) {
+	ctx := testContext()
+	sig := false
+
+	roleID, err := s.InsertRole(ctx, "Test Corp", "TEST", "discord", sig, false)
+	check(t, "InsertRole", err, nil)
+
+	_, err = s.InsertRole(ctx, "Test Corp", "TEST", "discord", sig, false)
+	check(t, "InsertRole again", err, storage.ErrRoleExists)
+
+	role, err := s.GetRole(ctx, "", "TEST", &sig)
+	check(t, "GetRole", err, nil)
+	if !role.Manageable || role.Sync {
+		t.Fatalf("GetRole: got %+v, want manageable and not synced", role)
+	}
+
+	role, err = s.GetRoleByType(ctx, sig, "TEST")
+	check(t, "GetRoleByType", err, nil)
+	if role.Name != "Test Corp" || role.ShortName != "TEST" || role.Sig {
+		t.Fatalf("GetRoleByType: got %+v", role)
+	}
+
+	_, err = s.ForGuild(otherGuild).GetRole(ctx, "", "TEST", &sig)
+	check(t, "GetRole in another guild", err, storage.ErrNoRole)
+
+	filterID, err := s.InsertFilter(ctx, "TEST", "Test Corp members")
+	check(t, "InsertFilter", err, nil)
+	check(t, "InsertRoleFilter", s.InsertRoleFilter(ctx, roleID, filterID), nil)
+	check(t, "AddFilterMembership", s.AddFilterMembership(ctx, filterID, "123"), nil)
+
+	roles, err := s.GetMemberRoles(ctx, "123", sig)
+	check(t, "GetMemberRoles", err, nil)
+	if len(roles) != 1 || roles[0].ShortName != "TEST" {
+		t.Fatalf("GetMemberRoles: got %+v, want TEST", roles)
+	}
+
+	roles, err = s.GetMemberRoles(ctx, "456", sig)
+	check(t, "GetMemberRoles non-member", err, nil)
+	if len(roles) != 0 {
+		t.Fatalf("GetMemberRoles non-member: got %+v, want none", roles)
+	}
+
+	check(t, "DeleteRole", s.DeleteRole(ctx, "TEST", sig), nil)
+
+	_, err = s.GetRole(ctx, "", "TEST", &sig)
+	check(t, "GetRole after delete", err, storage.ErrNoRole)
+}
+
+func testPermissions(t *testing.T, s storage.Store) {
+	ctx := testContext()
+
+	check(t, "InsertPermission", s.InsertPermission(ctx, "role_admins", "Role admins"), nil)
+	check(t, "InsertPermission again", s.InsertPermission(ctx, "role_admins", "Role admins"),
+		storage.ErrPermissionExists)
+
+	perm, err := s.GetPermission(ctx, "role_admins")
+	check(t, "GetPermission", err, nil)
+
+	_, err = s.GetPermission(ctx, "nobody")
+	check(t, "GetPermission missing", err, storage.ErrNoPermission)
+
+	check(t, "InsertPermissionMembership", s.InsertPermissionMembership(ctx, perm.ID, "123"), nil)
+	check(t, "InsertPermissionMembership again", s.InsertPermissionMembership(ctx, perm.ID, "123"),
+		storage.ErrPermissionMember)
+
+	members, err := s.ListPermissionMembers(ctx, "role_admins")
+	check(t, "ListPermissionMembers", err, nil)
+	if !reflect.DeepEqual(members, []int{123}) {
+		t.Fatalf("ListPermissionMembers: got %v, want [123]", members)
+	}
+
+	for _, c := range []struct {
+		user string
+		want int
+	}{
+		{"123", 1},
+		{"456", 0},
+	} {
+		count, err := s.GetPermissionCount(ctx, c.user, perm.ID)
+		check(t, "GetPermissionCount", err, nil)
+		if count != c.want {
+			t.Fatalf("GetPermissionCount(%s): got %d, want %d", c.user, count, c.want)
+		}
+	}
+
+	check(t, "DeletePermissionMembership", s.DeletePermissionMembership(ctx, perm.ID, "123"), nil)
+
+	count, err := s.GetPermissionCount(ctx, "123", perm.ID)
+	check(t, "GetPermissionCount after delete", err, nil)
+	if count != 0 {
+		t.Fatalf("GetPermissionCount after delete: got %d, want 0", count)
+	}
+}
+
+func testTransactions(t *testing.T, s storage.Store) {
+	ctx := testContext()
+	errRollback := errors.New("rollback")
+
+	if s.InTx() {
+		t.Fatal("InTx: store starts in a transaction")
+	}
+
+	committed := false
+	err := s.WithTx(ctx, func(tx storage.Store) error {
+		if !tx.InTx() {
+			t.Error("InTx: false in a transaction")
+		}
+
+		tx.OnCommit(func() { committed = true })
+		if committed {
+			t.Error("OnCommit: ran before commit")
+		}
+
+		_, err := tx.InsertFilter(ctx, "kept", "")
+		return err
+	})
+	check(t, "WithTx commit", err, nil)
+
+	if !committed {
+		t.Fatal("OnCommit: didn't run after commit")
+	}
+
+	_, err = s.GetFilter(ctx, "kept")
+	check(t, "GetFilter after commit", err, nil)
+
+	rolledBack := false
+	outside := make(chan error, 1)
+	err = s.WithTx(ctx, func(tx storage.Store) error {
+		tx.OnCommit(func() { rolledBack = true })
+
+		if _, err := tx.InsertFilter(ctx, "dropped", ""); err != nil {
+			return err
+		}
+
+		// A write outside the transaction has to survive it rolling back
+		go func() {
+			_, err := s.InsertFilter(ctx, "outside", "")
+			outside <- err
+		}()
+
+		return errRollback
+	})
+	check(t, "WithTx rollback", err, errRollback)
+	check(t, "InsertFilter outside", <-outside, nil)
+
+	if rolledBack {
+		t.Fatal("OnCommit: ran after rollback")
+	}
+
+	_, err = s.GetFilter(ctx, "dropped")
+	check(t, "GetFilter after rollback", err, storage.ErrNoFilter)
+
+	_, err = s.GetFilter(ctx, "outside")
+	check(t, "GetFilter outside", err, nil)
+
+	ran := false
+	s.OnCommit(func() { ran = true })
+	if !ran {
+		t.Fatal("OnCommit: didn't run straight away outside a transaction")
+	}
+}
+
+func testOutbox(t *testing.T, s storage.Store) {
+	ctx := testContext()
+
+	_, err := s.LockOutbox(ctx)
+	check(t, "LockOutbox outside a transaction", err, storage.ErrNoTx)
+
+	check(t, "InsertOutboxMessage", s.InsertOutboxMessage(ctx, "members", []byte("one")), nil)
+	check(t, "InsertOutboxMessage", s.InsertOutboxMessage(ctx, "other", []byte("two")), nil)
+	check(t, "Publish", s.OutboxPublisher("members").Publish(ctx, []byte("three")), nil)
+
+	err = s.WithTx(ctx, func(tx storage.Store) error {
+		locked, err := tx.LockOutbox(ctx)
+		check(t, "LockOutbox", err, nil)
+		if !locked {
+			t.Error("LockOutbox: not locked")
+		}
+
+		messages, err := tx.GetOutboxMessages(ctx, []string{"members"}, 10)
+		check(t, "GetOutboxMessages", err, nil)
+
+		var (
+			bodies []string
+			ids    []int64
+		)
+		for _, message := range messages {
+			if message.Queue != "members" {
+				t.Errorf("GetOutboxMessages: got a message for %s", message.Queue)
+			}
+			bodies = append(bodies, string(message.Body))
+			ids = append(ids, message.ID)
+		}
+
+		if !reflect.DeepEqual(bodies, []string{"one", "three"}) {
+			t.Errorf("GetOutboxMessages: got %v, want [one three]", bodies)
+		}
+
+		return tx.DeleteOutboxMessages(ctx, ids)
+	})
+	check(t, "WithTx", err, nil)
+
+	err = s.WithTx(ctx, func(tx storage.Store) error {
+		messages, err := tx.GetOutboxMessages(ctx, []string{"members", "other"}, 10)
+		if err != nil {
+			return err
+		}
+
+		if len(messages) != 1 || string(messages[0].Body) != "two" {
+			t.Errorf("GetOutboxMessages after delete: got %+v, want only two", messages)
+		}
+
+		return nil
+	})
+	check(t, "WithTx", err, nil)
+}
+
+func testMemberSyncs(t *testing.T, s storage.Store) {
+	ctx := testContext()
+
+	seq, err := s.GetMemberSync(ctx, "123")
+	check(t, "GetMemberSync", err, nil)
+	if seq != 0 {
+		t.Fatalf("GetMemberSync: got %d before any bump, want 0", seq)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		seq, err = s.BumpMemberSync(ctx, "123")
+		check(t, "BumpMemberSync", err, nil)
+		if seq != want {
+			t.Fatalf("BumpMemberSync: got %d, want %d", seq, want)
+		}
+	}
+
+	seq, err = s.GetMemberSync(ctx, "123")
+	check(t, "GetMemberSync", err, nil)
+	if seq != 2 {
+		t.Fatalf("GetMemberSync: got %d, want 2", seq)
+	}
+
+	seq, err = s.ForGuild(otherGuild).GetMemberSync(ctx, "123")
+	check(t, "GetMemberSync in another guild", err, nil)
+	if seq != 0 {
+		t.Fatalf("GetMemberSync in another guild: got %d, want 0", seq)
+	}
+}
+
+func sameInts(got, want []int64) bool {
+	seen := make(map[int64]int)
+	for _, i := range got {
+		seen[i]++
+	}
+	for _, i := range want {
+		seen[i]--
+	}
+	for _, n := range seen {
+		if n != 0 {
+			return false
+		}
+	}
+
+	return len(got) == len(want)
+}
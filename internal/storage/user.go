@@ -121,7 +121,7 @@ func (s Storage) InsertUserCharacterMap(ctx context.Context, sender string, char
 		sp.Debug("InsertUserCharacterMap(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		if err.(*pq.Error).Code == "23505" {
 			// Duplicate entry, which is fine, actually
@@ -160,7 +160,7 @@ func (s Storage) DeleteDiscordUser(ctx context.Context, chatID string) error {
 		sp.Debug("DeleteDiscordUser(): sql query")
 	}
 
-	_, err = query.QueryContext(ctx)
+	_, err = query.ExecContext(ctx)
 	if err != nil {
 		sp.Error("error deleting role", zap.Error(err))
 		return err
@@ -20,9 +20,12 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/bwmarrin/disgord/x/mux"
 	"github.com/chremoas/chremoas-ng/internal/common"
+	discordEvents "github.com/chremoas/chremoas-ng/internal/discord/events"
 	discordMembers "github.com/chremoas/chremoas-ng/internal/discord/members"
 	discordRoles "github.com/chremoas/chremoas-ng/internal/discord/roles"
 	esiPoller "github.com/chremoas/chremoas-ng/internal/esi-poller"
+	"github.com/chremoas/chremoas-ng/internal/esicache"
+	"github.com/chremoas/chremoas-ng/internal/leader"
 	"github.com/chremoas/chremoas-ng/internal/storage"
 	"github.com/gregjones/httpcache"
 	_ "github.com/lib/pq"
@@ -35,6 +38,7 @@ import (
 	"github.com/chremoas/chremoas-ng/internal/commands"
 	"github.com/chremoas/chremoas-ng/internal/config"
 	"github.com/chremoas/chremoas-ng/internal/database"
+	"github.com/chremoas/chremoas-ng/internal/outbox"
 	"github.com/chremoas/chremoas-ng/internal/queue"
 )
 
@@ -61,6 +65,7 @@ func main() {
 
 	flag.String("configFile", "chremoas.yaml", "configuration file name")
 	flag.String("loglevel", "INFO", "Log Level")
+	flag.String("mode", "all", "components to run, all or a comma separated list of bot, web, poller, members-worker and roles-worker (bot and poller go together)")
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
 	err = viper.BindPFlags(pflag.CommandLine)
@@ -92,6 +97,19 @@ func main() {
 		return
 	}
 
+	// migrate is the only command, without one we run the components
+	if args := pflag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("FATAL ERROR: unknown command %s, %s", args[0], migrateUsage)
+		}
+
+		if err = runMigrate(ctx, args[1:]); err != nil {
+			log.Fatalf("FATAL ERROR: migrate: %s", err)
+		}
+
+		return
+	}
+
 	// put guildID somewhere useful
 	dependencies.GuildID = viper.GetString("bot.discordServerId")
 
@@ -113,19 +131,40 @@ func main() {
 		}
 	}()
 
+	// =========================================================================
+	// Work out which components this process runs
+
+	mode, err := config.ParseMode(viper.GetString("mode"))
+	if err != nil {
+		sp.Error("Error parsing mode", zap.Error(err))
+		return
+	}
+
+	sp.Info("Running components", zap.String("mode", mode.String()))
+
 	// =========================================================================
 	// Setup DB connection
 
-	db, err := database.New(ctx)
+	db, sqlDB, err := database.New(ctx, schema())
 	if err != nil {
 		sp.Error("error opening connection to PostgreSQL", zap.Error(err))
 		return
 	}
 
-	dependencies.Storage = storage.New(db)
+	dependencies.Storage = storage.New(db, sqlDB).ForGuild(dependencies.GuildID)
+
+	// Everything started from here on adds a step to stop it. They run in
+	// reverse order with one deadline when main returns, see shutdownSteps.
+	var shutdown shutdownSteps
+	defer shutdown.run(ctx)
+
+	shutdown.add("database", func(_ context.Context) error {
+		return sqlDB.Close()
+	})
 
 	// =========================================================================
-	// Start the discord session
+	// Setup the discord session. Every component uses it to talk to Discord's
+	// REST API, only the bot opens the gateway.
 
 	dependencies.Session, err = discordgo.New("Bot " + viper.GetString("bot.token"))
 	if err != nil {
@@ -133,91 +172,249 @@ func main() {
 		return
 	}
 
-	defer func() {
-		err := dependencies.Session.Close()
-		if err != nil {
-			sp.Error("Error closing discord connection", zap.Error(err))
-		}
-	}()
-
 	// Let's use a caching http client
 	dependencies.Session.Client = httpcache.NewMemoryCacheTransport().Client()
 
 	dependencies.Session.Identify.Intents = discordgo.IntentsAll
 
-	Router := mux.New()
-	Router.Prefix = "!"
-
-	// Register the mux OnMessageCreate handler that listens for and processes
-	// all messages received.
-	dependencies.Session.AddHandler(Router.OnMessageCreate)
-
-	// Register the build-in help command.
-	_, err = Router.Route("help", "Display this message.", Router.Help)
+	// Only the bot opens the gateway, which is what fills in the session's
+	// state, so everything else needs the bot's ID from the REST API.
+	botUser, err := dependencies.Session.User("@me")
 	if err != nil {
-		panic("Can't load help router something is very, very wrong")
+		sp.Error("Error getting the bot's user", zap.Error(err))
+		return
 	}
+	dependencies.BotID = botUser.ID
 
 	// =========================================================================
-	// Setup RabbitMQ
+	// Setup the queue backend
 	// =========================================================================
-	queueURI := fmt.Sprintf("amqp://%s:%s@%s:%d/%s",
-		viper.GetString("queue.username"),
-		viper.GetString("queue.password"),
-		viper.GetString("queue.host"),
-		viper.GetInt("queue.port"),
-		viper.GetString("namespace"),
-	)
+	var queueBackend queue.Backend
+
+	switch viper.GetString("queue.backend") {
+	case "postgres":
+		sp.Info("Using postgres queue backend")
+		queueBackend = queue.NewPostgresBackend(db)
+
+	case "", "amqp":
+		queueURI := fmt.Sprintf("amqp://%s:%s@%s:%d/%s",
+			viper.GetString("queue.username"),
+			viper.GetString("queue.password"),
+			viper.GetString("queue.host"),
+			viper.GetInt("queue.port"),
+			viper.GetString("namespace"),
+		)
+
+		sp.Info("Using amqp queue backend")
+		queueBackend = queue.NewAMQPBackend(queueURI)
+
+	default:
+		sp.Error("Unknown queue backend", zap.String("backend", viper.GetString("queue.backend")))
+		return
+	}
 
-	// Consumers
-	// Member consumer
-	members := discordMembers.New(ctx, dependencies)
-	membersConsumer, err := queue.NewConsumer(ctx, queueURI, "members", "direct", "members",
-		"members", "members", 8, members.HandleMessage)
+	// Producers, the consumers publish too so these are set up first and shut down last
+	// Members producer
+	membersProducer, err := queueBackend.NewPublisher(ctx, queue.Members)
+	if err != nil {
+		sp.Error("Error setting up members producer", zap.Error(err))
+		return
+	}
+	shutdown.add("members producer", func(ctx context.Context) error {
+		membersProducer.Shutdown(ctx)
+		return nil
+	})
+
+	// Roles producer
+	rolesProducer, err := queueBackend.NewPublisher(ctx, queue.Roles)
 	if err != nil {
-		sp.Error("Error setting up members consumer", zap.Error(err))
+		sp.Error("Error setting up roles producer", zap.Error(err))
+		return
 	}
-	defer func() {
-		err := membersConsumer.Shutdown(ctx)
+	shutdown.add("roles producer", func(ctx context.Context) error {
+		rolesProducer.Shutdown(ctx)
+		return nil
+	})
+
+	// Everything publishes through the outbox so messages go out in the order
+	// they were written and only once the change they're for commits, see
+	// common.WithTx. Every process relays the outbox to the real producers.
+	dependencies.MembersProducer = dependencies.Storage.OutboxPublisher(queue.Members)
+	dependencies.RolesProducer = dependencies.Storage.OutboxPublisher(queue.Roles)
+
+	relay := outbox.New(dependencies.Storage, map[string]queue.Publisher{
+		queue.Members: membersProducer,
+		queue.Roles:   rolesProducer,
+	})
+	relay.Start(ctx)
+	shutdown.add("outbox relay", relay.Stop)
+
+	// Consumers
+	// Member consumer
+	if mode.Has(config.MembersWorker) {
+		members := discordMembers.New(ctx, dependencies)
+		membersConsumer, err := queueBackend.NewConsumer(ctx, queue.Members, 8, members.HandleMessage)
 		if err != nil {
-			sp.Error("error shutting down members consumer", zap.Error(err))
+			sp.Error("Error setting up members consumer", zap.Error(err))
+			return
 		}
-	}()
+		shutdown.add("members consumer", membersConsumer.Shutdown)
+	}
 
 	// Role consumer
-	roles := discordRoles.New(ctx, dependencies)
-	rolesConsumer, err := queue.NewConsumer(ctx, queueURI, "roles", "direct", "roles",
-		"roles", "roles", 8, roles.HandleMessage)
-	if err != nil {
-		sp.Error("Error setting up members consumer", zap.Error(err))
+	if mode.Has(config.RolesWorker) {
+		roles := discordRoles.New(ctx, dependencies)
+		rolesConsumer, err := queueBackend.NewConsumer(ctx, queue.Roles, 8, roles.HandleMessage)
+		if err != nil {
+			sp.Error("Error setting up roles consumer", zap.Error(err))
+			return
+		}
+		shutdown.add("roles consumer", rolesConsumer.Shutdown)
 	}
-	defer func() {
-		err := rolesConsumer.Shutdown(ctx)
+
+	// =========================================================================
+	// Setup the ESI cache, shared by the poller and auth-web
+	var esiCache *esicache.Cache
+	if mode.Has(config.Bot, config.Web, config.Poller) {
+		esiCache = esicache.New(ctx, dependencies)
+	}
+
+	// =========================================================================
+	// Setup the ESI Poller. The bot uses it for commands and gateway events,
+	// stopping it cancels polls they started.
+	var esi esiPoller.AuthEsiPoller
+	if mode.Has(config.Bot, config.Poller) {
+		userAgent := "chremoas-ng Ramdar Chinken on TweetFleet Slack https://github.com/chremoas/chremoas-ng"
+		esi = esiPoller.New(ctx, userAgent, esiCache, dependencies)
+		shutdown.add("esi poller", func(ctx context.Context) error {
+			esi.Stop(ctx)
+			return nil
+		})
+	}
+
+	// =========================================================================
+	// Start the ESI Poller thread on whichever replica is elected leader. The
+	// bot only handles commands and gateway events on the leader too.
+	if mode.Has(config.Poller) {
+		elector := leader.New(sqlDB, func(ctx context.Context) {
+			esi.Start(ctx)
+			checkHierarchy(ctx, dependencies)
+		}, esi.Stop)
+		dependencies.Leader = elector
+		elector.Start(ctx)
+		shutdown.add("leader election", func(ctx context.Context) error {
+			elector.Stop(ctx)
+			return nil
+		})
+	}
+
+	// Make a channel to listen for errors coming from the listener. Use a
+	// buffered channel so the goroutine can exit if we don't collect this error.
+	// Without the web component nothing is sent on it.
+	serverErrors := make(chan error, 1)
+
+	// =========================================================================
+	// Start auth-web Service
+	if mode.Has(config.Web) {
+		sp.Info("main: Initializing auth-web support")
+
+		authWeb, err := web.New(ctx, esiCache, dependencies)
 		if err != nil {
-			sp.Error("error shutting down roles consumer", zap.Error(err))
+			sp.Error("Error starting authWeb", zap.Error(err))
+			return
 		}
-	}()
 
-	// Producers
-	// Members producer
-	dependencies.MembersProducer, err = queue.NewPublisher(ctx, queueURI, "members", "direct",
-		"members")
-	if err != nil {
-		sp.Error("Error setting up members producer", zap.Error(err))
+		webUI := &http.Server{
+			Addr: fmt.Sprintf("%s:%d",
+				viper.GetString("net.host"),
+				viper.GetInt("net.webPort")),
+			Handler:      authWeb.Auth(ctx),
+			ReadTimeout:  time.Second * 5,
+			WriteTimeout: time.Second * 5,
+		}
+
+		// Start the service listening for requests.
+		go func() {
+			sp.Info("main: auth-web listening", zap.String("webUI.Addr", webUI.Addr))
+			serverErrors <- webUI.ListenAndServe()
+		}()
+
+		// Asking listener to shutdown and shed load.
+		shutdown.add("auth-web", func(ctx context.Context) error {
+			err := webUI.Shutdown(ctx)
+			if err != nil {
+				sp.Error("could not stop server gracefully", zap.Error(err))
+				return webUI.Close()
+			}
+
+			return nil
+		})
 	}
-	defer dependencies.MembersProducer.Shutdown(ctx)
 
-	// Roles producer
-	dependencies.RolesProducer, err = queue.NewPublisher(ctx, queueURI, "roles", "direct",
-		"roles")
+	// =========================================================================
+	// Start the bot, last so everything it uses is already up
+	if mode.Has(config.Bot) {
+		stopBot, err := startBot(ctx, esi, esiCache, dependencies)
+		if err != nil {
+			sp.Error("Error starting bot", zap.Error(err))
+			return
+		}
+		shutdown.add("bot", stopBot)
+	}
+
+	// Make a channel to listen for an interrupt or terminate signal from the OS.
+	// Use a buffered channel because the signal package requires it.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+
+	// =========================================================================
+	// Main loop
+
+	sp.Info(`Now running. Press CTRL-C to exit.`)
+	// Blocking main and waiting for shutdown.
+	select {
+	case err = <-serverErrors:
+		sp.Error("server error", zap.Error(err))
+
+	case sig := <-signals:
+		sp.Info("main: Start shutdown", zap.Any("signal", sig))
+	}
+
+	// Exit Normally, the shutdown steps run as main returns.
+}
+
+// startBot registers the commands and gateway event handlers and opens the
+// gateway. The returned func stops handling new commands and events, closes
+// the gateway and waits for the handlers still running.
+func startBot(ctx context.Context, esi esiPoller.AuthEsiPoller, esiCache *esicache.Cache, dependencies common.Dependencies) (func(ctx context.Context) error, error) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	gate := &handlerGate{leader: dependencies.Leader}
+
+	Router := mux.New()
+	Router.Prefix = "!"
+
+	// Register the mux OnMessageCreate handler that listens for and processes
+	// all messages received.
+	dependencies.Session.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		if !gate.enter() {
+			return
+		}
+		defer gate.leave()
+
+		Router.OnMessageCreate(s, m)
+	})
+
+	// Register the build-in help command.
+	_, err := Router.Route("help", "Display this message.", Router.Help)
 	if err != nil {
-		sp.Error("Error setting up roles producer", zap.Error(err))
+		panic("Can't load help router something is very, very wrong")
 	}
-	defer dependencies.RolesProducer.Shutdown(ctx)
 
 	// =========================================================================
 	// Setup commands
-	c := commands.New(ctx, dependencies)
+	c := commands.New(ctx, esi, esiCache, dependencies)
 
 	commandList := []struct {
 		command string
@@ -231,6 +428,13 @@ func main() {
 		{"filter", "Manages Filters", c.Filter},
 		{"perms", "Manages Permissions", c.Perms},
 		{"auth", "Manages Permissions", c.Auth},
+		{"sync", "Checks member role sync", c.Sync},
+		{"nick", "Manages nicknames", c.Nick},
+		{"exempt", "Manages member policy exemptions", c.Exempt},
+		{"esi", "Manages the ESI cache", c.ESI},
+		{"poller", "Shows and runs the ESI poller", c.Poller},
+		{"audit", "Shows the audit log", c.Audit},
+		{"stats", "Shows membership trends and history", c.Stats},
 		{"version", "Returns Chremoas version", c.Version},
 	}
 
@@ -241,81 +445,83 @@ func main() {
 		}
 	}
 
-	// Open a websocket connection to Discord
-	err = dependencies.Session.Open()
-	if err != nil {
-		sp.Error("error opening connection to Discord", zap.Error(err))
-		return
-	}
-
 	// =========================================================================
-	// Start auth-web Service
+	// Gateway event handlers
+	events := discordEvents.New(ctx, esi, dependencies)
+	dependencies.Session.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+		if !gate.enter() {
+			return
+		}
+		defer gate.leave()
 
-	sp.Info("main: Initializing auth-web support")
+		events.GuildMemberAdd(s, m)
+	})
+	dependencies.Session.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+		if !gate.enter() {
+			return
+		}
+		defer gate.leave()
 
-	// Make a channel to listen for an interrupt or terminate signal from the OS.
-	// Use a buffered channel because the signal package requires it.
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+		events.GuildMemberRemove(s, m)
+	})
+	dependencies.Session.AddHandler(func(s *discordgo.Session, r *discordgo.GuildRoleUpdate) {
+		if !gate.enter() {
+			return
+		}
+		defer gate.leave()
 
-	authWeb, err := web.New(ctx, dependencies)
-	if err != nil {
-		sp.Error("Error starting authWeb", zap.Error(err))
-		return
-	}
+		events.GuildRoleUpdate(s, r)
+	})
+	dependencies.Session.AddHandler(func(s *discordgo.Session, r *discordgo.GuildRoleDelete) {
+		if !gate.enter() {
+			return
+		}
+		defer gate.leave()
+
+		events.GuildRoleDelete(s, r)
+	})
 
-	webUI := http.Server{
-		Addr: fmt.Sprintf("%s:%d",
-			viper.GetString("net.host"),
-			viper.GetInt("net.webPort")),
-		Handler:      authWeb.Auth(ctx),
-		ReadTimeout:  time.Second * 5,
-		WriteTimeout: time.Second * 5,
+	// Open a websocket connection to Discord
+	err = dependencies.Session.Open()
+	if err != nil {
+		sp.Error("error opening connection to Discord", zap.Error(err))
+		return nil, err
 	}
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	return func(ctx context.Context) error {
+		_, sp := sl.OpenSpan(ctx)
+		defer sp.Close()
 
-	// Start the service listening for requests.
-	go func() {
-		sp.Info("main: auth-web listening", zap.String("webUI.Addr", webUI.Addr))
-		serverErrors <- webUI.ListenAndServe()
-	}()
+		// Turn new commands away and let the running ones finish before the gateway goes
+		err := gate.close(ctx)
+		events.Stop()
 
-	// =========================================================================
-	// Start the ESI Poller thread.
-	userAgent := "chremoas-ng Ramdar Chinken on TweetFleet Slack https://github.com/chremoas/chremoas-ng"
-	esi := esiPoller.New(ctx, userAgent, dependencies)
-	esi.Start(ctx)
-	defer esi.Stop(ctx)
-
-	// =========================================================================
-	// Main loop
+		if cErr := dependencies.Session.Close(); cErr != nil {
+			sp.Error("Error closing discord connection", zap.Error(cErr))
+			return cErr
+		}
 
-	sp.Info(`Now running. Press CTRL-C to exit.`)
-	// Blocking main and waiting for shutdown.
-	select {
-	case err = <-serverErrors:
-		sp.Error("server error", zap.Error(err))
-		return
+		return err
+	}, nil
+}
 
-	case sig := <-shutdown:
-		sp.Info("main: Start shutdown", zap.Any("signal", sig))
+// checkHierarchy makes sure the bot can actually manage the roles it's going
+// to sync. It's run by the leader when it's elected so it's only alerted on
+// once.
+func checkHierarchy(ctx context.Context, dependencies common.Dependencies) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
 
-		// Give outstanding requests a deadline for completion.
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-		defer cancel()
+	for _, guild := range common.Guilds() {
+		report, err := common.CheckHierarchy(ctx, dependencies.ForGuild(guild.ID))
+		if err != nil {
+			sp.Error("Error checking role hierarchy", zap.String("guild_id", guild.ID), zap.Error(err))
+			continue
+		}
 
-		// Asking listener to shutdown and shed load.
-		if err = webUI.Shutdown(ctx); err != nil {
-			err = webUI.Close()
-			if err != nil {
-				sp.Error("Error stopping API", zap.Error(err))
-			}
-			sp.Error("could not stop server gracefully", zap.Error(err))
+		if !report.OK() {
+			sp.Warn("Bot can't manage all synced roles", zap.String("report", report.String()))
+			common.Alert(ctx, report.String(), dependencies)
 		}
 	}
-
-	// Exit Normally.
 }
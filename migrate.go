@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	"github.com/chremoas/chremoas-ng/internal/database"
+)
+
+// The schema migrations are built in so the binary can bring its database up to date
+//
+//go:embed sql/*.sql
+var migrations embed.FS
+
+func schema() fs.FS {
+	sql, err := fs.Sub(migrations, "sql")
+	if err != nil {
+		// Only fails if the directory name is invalid
+		panic(err)
+	}
+
+	return sql
+}
+
+const migrateUsage = "usage: chremoas migrate up|down [count|all]|status"
+
+// runMigrate is the migrate command. up applies the pending migrations, down rolls back the last one or count of
+// them, or all of them, and status shows where the schema is.
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	ldb, err := database.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+
+	migrator, err := database.NewMigrator(ldb.DB, schema())
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Applied %d migrations\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				steps = 0
+			} else if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return errors.New(migrateUsage)
+			}
+		}
+
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Rolled back %d migrations\n", rolledBack)
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Database is at version %d, this binary is at version %d\n", status.Version, status.Latest)
+		if status.Dirty {
+			fmt.Println("The schema is dirty, a migration failed part way and needs fixing by hand")
+		}
+
+		for _, m := range status.Pending {
+			fmt.Printf("Pending: %03d_%s\n", m.Version, m.Name)
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	sl "github.com/bhechinger/spiffylogger"
+	"github.com/chremoas/chremoas-ng/internal/common"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Used when bot.shutdownTimeout isn't set
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownStep stops one component. It should give up and return once ctx is done.
+type shutdownStep struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// shutdownSteps run in the reverse of the order they were added, so components stop in the reverse of the order
+// they started.
+type shutdownSteps []shutdownStep
+
+func (s *shutdownSteps) add(name string, stop func(ctx context.Context) error) {
+	*s = append(*s, shutdownStep{name: name, stop: stop})
+}
+
+// run runs every step with one deadline for the lot, bot.shutdownTimeout. Steps still run once the deadline has
+// passed so connections get closed, but they give up on waiting straight away and are logged as abandoned.
+func (s shutdownSteps) run(ctx context.Context) {
+	ctx, sp := sl.OpenSpan(ctx)
+	defer sp.Close()
+
+	timeout := viper.GetDuration("bot.shutdownTimeout")
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sp.Info("Shutting down", zap.Duration("timeout", timeout))
+
+	var abandoned []string
+	for i := len(s) - 1; i >= 0; i-- {
+		step := s[i]
+		start := time.Now()
+
+		err := step.stop(ctx)
+
+		switch {
+		case ctx.Err() != nil:
+			abandoned = append(abandoned, step.name)
+			sp.Warn("Shutdown deadline passed", zap.String("step", step.name), zap.Error(err))
+		case err != nil:
+			sp.Error("Error shutting down", zap.String("step", step.name), zap.Error(err))
+		default:
+			sp.Info("Stopped", zap.String("step", step.name), zap.Duration("took", time.Since(start)))
+		}
+	}
+
+	if len(abandoned) > 0 {
+		sp.Warn("Shutdown abandoned work", zap.Strings("steps", abandoned))
+		return
+	}
+
+	sp.Info("Shutdown complete")
+}
+
+// handlerGate lets commands and gateway events through only on the leader, so each is handled once however many
+// replicas are connected. It stops them being handled once shutdown starts and lets shutdown wait for the ones
+// already running.
+type handlerGate struct {
+	leader common.Leader
+
+	mutex  sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// enter is false if this replica isn't the leader or the gate is closed, otherwise leave must be called when the
+// handler returns.
+func (g *handlerGate) enter() bool {
+	if !g.leader.IsLeader() {
+		return false
+	}
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if g.closed {
+		return false
+	}
+
+	g.wg.Add(1)
+
+	return true
+}
+
+func (g *handlerGate) leave() {
+	g.wg.Done()
+}
+
+// close turns away new handlers and waits for the running ones until ctx is done.
+func (g *handlerGate) close(ctx context.Context) error {
+	g.mutex.Lock()
+	g.closed = true
+	g.mutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,11 @@
+CREATE TABLE queue_messages
+(
+    id           BIGSERIAL PRIMARY KEY NOT NULL,
+    queue        VARCHAR(64)           NOT NULL,
+    body         BYTEA                 NOT NULL,
+    attempts     INT                   NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    inserted_at  TIMESTAMP             NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX queue_messages_queue_index ON queue_messages (queue, id);
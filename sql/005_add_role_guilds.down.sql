@@ -0,0 +1,5 @@
+DROP INDEX name_uindex;
+CREATE UNIQUE INDEX name_uindex ON roles (name, sig);
+
+ALTER TABLE roles
+    DROP COLUMN guild_id;
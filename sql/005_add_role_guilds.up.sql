@@ -0,0 +1,7 @@
+-- Roles belong to a guild, filters and auth are shared between guilds.
+-- Existing roles are moved to bot.discordServerId on startup.
+ALTER TABLE roles
+    ADD COLUMN guild_id BIGINT NOT NULL DEFAULT 0;
+
+DROP INDEX name_uindex;
+CREATE UNIQUE INDEX name_uindex ON roles (name, sig, guild_id);
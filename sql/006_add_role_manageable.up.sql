@@ -0,0 +1,3 @@
+-- Set to false when the bot can't manage the role in discord, usually because it's above the bot's highest role
+ALTER TABLE roles
+    ADD COLUMN manageable BOOL NOT NULL DEFAULT TRUE;
@@ -0,0 +1 @@
+DROP TABLE nickname_overrides;
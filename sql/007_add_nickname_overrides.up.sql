@@ -0,0 +1,6 @@
+-- Nicknames set by an admin that replace the one built from bot.nickname.template
+CREATE TABLE nickname_overrides
+(
+    chat_id  VARCHAR(255) PRIMARY KEY,
+    nickname VARCHAR(32) NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE member_policy_exemptions;
+DROP TABLE member_state;
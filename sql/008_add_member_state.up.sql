@@ -0,0 +1,20 @@
+-- Tracks members per guild for the member policy. last_authed is the last time a poll saw the member with a
+-- character in an allowed corp or alliance.
+CREATE TABLE member_state
+(
+    guild_id       BIGINT       NOT NULL,
+    chat_id        VARCHAR(255) NOT NULL,
+    joined_at      TIMESTAMP    NOT NULL DEFAULT NOW(),
+    last_authed    TIMESTAMP,
+    reminded_at    TIMESTAMP,
+    quarantined_at TIMESTAMP,
+    PRIMARY KEY (guild_id, chat_id)
+);
+
+-- Members the member policy never touches
+CREATE TABLE member_policy_exemptions
+(
+    chat_id     VARCHAR(255) PRIMARY KEY,
+    reason      VARCHAR(255) NOT NULL DEFAULT '',
+    inserted_at TIMESTAMP    NOT NULL DEFAULT NOW()
+);
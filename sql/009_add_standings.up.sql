@@ -0,0 +1,8 @@
+-- Contacts from bot.accessPolicy.standings, refreshed every poll
+CREATE TABLE standings
+(
+    contact_id   INTEGER PRIMARY KEY,
+    contact_type VARCHAR(20) NOT NULL,
+    standing     REAL        NOT NULL,
+    updated_at   TIMESTAMP   NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1 @@
+DROP TABLE esi_cache;
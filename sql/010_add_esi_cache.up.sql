@@ -0,0 +1,11 @@
+-- ESI responses shared by the poller and auth web, so restarts don't download everything again
+CREATE TABLE esi_cache
+(
+    key         TEXT PRIMARY KEY,
+    value       BYTEA     NOT NULL,
+    size        INTEGER   NOT NULL,
+    inserted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    accessed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX esi_cache_accessed_at ON esi_cache (accessed_at);
@@ -0,0 +1,2 @@
+DROP TABLE poll_run_stages;
+DROP TABLE poll_runs;
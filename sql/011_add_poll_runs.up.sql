@@ -0,0 +1,21 @@
+-- Every ESI poller run and how each stage went
+CREATE TABLE poll_runs
+(
+    id          SERIAL PRIMARY KEY,
+    trigger     VARCHAR(20) NOT NULL,
+    started_at  TIMESTAMP   NOT NULL,
+    finished_at TIMESTAMP   NOT NULL
+);
+
+CREATE TABLE poll_run_stages
+(
+    run_id      INTEGER     NOT NULL REFERENCES poll_runs (id) ON DELETE CASCADE,
+    stage       VARCHAR(20) NOT NULL,
+    count       INTEGER     NOT NULL,
+    error_count INTEGER     NOT NULL,
+    -- The first few errors, one per line
+    errors      TEXT        NOT NULL DEFAULT '',
+    started_at  TIMESTAMP   NOT NULL,
+    finished_at TIMESTAMP   NOT NULL,
+    PRIMARY KEY (run_id, stage)
+);
@@ -0,0 +1,24 @@
+-- Every administrative change, who made it and what it looked like before and after
+CREATE TABLE audit_log
+(
+    id             BIGSERIAL PRIMARY KEY,
+    guild_id       BIGINT      NOT NULL DEFAULT 0,
+    -- user, poller, web or system
+    actor_type     VARCHAR(20) NOT NULL,
+    -- The discord user for user actors
+    actor_id       VARCHAR(64) NOT NULL DEFAULT '',
+    action         VARCHAR(64) NOT NULL,
+    target_type    VARCHAR(20) NOT NULL,
+    target         TEXT        NOT NULL,
+    -- The discord user the change was made to, if any
+    member_id      VARCHAR(64) NOT NULL DEFAULT '',
+    before         JSONB,
+    after          JSONB,
+    correlation_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at     TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_log_created_at_index ON audit_log (created_at);
+CREATE INDEX audit_log_actor_id_index ON audit_log (actor_id);
+CREATE INDEX audit_log_member_id_index ON audit_log (member_id);
+CREATE INDEX audit_log_target_index ON audit_log (target);
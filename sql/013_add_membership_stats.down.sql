@@ -0,0 +1,2 @@
+DROP TABLE membership_snapshots;
+DROP TABLE membership_history;
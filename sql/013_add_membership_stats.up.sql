@@ -0,0 +1,30 @@
+-- Every time a user joined or left a filter and why
+CREATE TABLE membership_history
+(
+    id             BIGSERIAL PRIMARY KEY,
+    -- The filter may be gone by now so the name is kept rather than a reference
+    filter_name    VARCHAR(32) NOT NULL,
+    user_id        BIGINT      NOT NULL,
+    -- join or leave
+    event          VARCHAR(10) NOT NULL,
+    -- Who made the change, user, poller, web or system, and which user if it was one
+    cause          VARCHAR(20) NOT NULL,
+    actor_id       VARCHAR(64) NOT NULL DEFAULT '',
+    correlation_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at     TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX membership_history_user_id_index ON membership_history (user_id, created_at);
+CREATE INDEX membership_history_created_at_index ON membership_history (created_at);
+
+-- How many members each corporation, alliance, sig and role had each day
+CREATE TABLE membership_snapshots
+(
+    guild_id BIGINT      NOT NULL,
+    day      DATE        NOT NULL,
+    -- corporation, alliance, sig or role
+    kind     VARCHAR(20) NOT NULL,
+    name     VARCHAR(256) NOT NULL,
+    members  INTEGER     NOT NULL,
+    PRIMARY KEY (guild_id, day, kind, name)
+);
@@ -0,0 +1,9 @@
+-- Queue messages waiting to be published. They're written in the same transaction as the change they're for and
+-- published by the outbox relay once it commits.
+CREATE TABLE outbox
+(
+    id         BIGSERIAL PRIMARY KEY,
+    queue      VARCHAR(64) NOT NULL,
+    body       BYTEA       NOT NULL,
+    created_at TIMESTAMP   NOT NULL DEFAULT NOW()
+);
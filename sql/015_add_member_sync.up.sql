@@ -0,0 +1,9 @@
+-- Counts the syncs queued for each member. The count is bumped in the same transaction as the change the sync is
+-- for, so a reconcile that reads it has already seen every change queued up to that count.
+CREATE TABLE member_sync
+(
+    guild_id BIGINT       NOT NULL,
+    chat_id  VARCHAR(255) NOT NULL,
+    seq      BIGINT       NOT NULL DEFAULT 1,
+    PRIMARY KEY (guild_id, chat_id)
+);